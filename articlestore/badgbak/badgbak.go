@@ -32,6 +32,8 @@ type Backend struct{
 }
 var _ articlestore.Storage = &Backend{}
 
+func MakeBackend(db *badger.DB) *Backend { return &Backend{db} }
+
 // Simply stores this.
 func (b *Backend) StoreWriteMessage(id, msg []byte, expire uint64) error {
 	tx := b.db.NewTransaction(true)
@@ -0,0 +1,51 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package badgbak_test
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore/badgbak"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/storetest"
+import "github.com/dgraph-io/badger"
+import "testing"
+import "io/ioutil"
+import "os"
+
+func TestConformance(t *testing.T) {
+	s := &storetest.ArticleSuite{New:func(t *testing.T) (articlestore.Storage,func()) {
+		dir,err := ioutil.TempDir("","badgbak")
+		if err!=nil { t.Fatal(err) }
+		opts := badger.DefaultOptions
+		opts.Dir = dir
+		opts.ValueDir = dir
+		db,err := badger.Open(opts)
+		if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+		return badgbak.MakeBackend(db),func() {
+			db.Close()
+			os.RemoveAll(dir)
+		}
+	}}
+	s.Run(t)
+	s.OverNetwire().Run(t)
+	s.OverGnetwire().Run(t)
+}
@@ -35,9 +35,17 @@ func Initialize(session *gocql.Session) {
 		bucket blob,
 		keep boolean,
 		exp bigint,
+		shards list<blob>,
+		ecdata int,
+		bodylen bigint,
 		PRIMARY KEY(messageid,recid)
 	)
 	`).Exec()
+	
+	/* Tables, that have been created before the erasure coding columns. */
+	session.Query(`ALTER TABLE article_locs ADD shards list<blob>`).Exec()
+	session.Query(`ALTER TABLE article_locs ADD ecdata int`).Exec()
+	session.Query(`ALTER TABLE article_locs ADD bodylen bigint`).Exec()
 }
 
 
@@ -0,0 +1,290 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package chybrid
+
+import "github.com/gocql/gocql"
+import "github.com/klauspost/reedsolomon"
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netmodel"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/selerr"
+
+import "sync"
+import "time"
+
+/*
+Reed-Solomon parameters.
+
+The body of an article is split into Data data shards and Parity parity shards,
+which are stored in distinct buckets (and nodes, if possible). The body can be
+restored from any Data of them. The header and xover are stored in the buckets
+of the first Parity+1 shards, so they survive the loss of Parity shards as well.
+*/
+type Erasure struct{
+	Data    int
+	Parity  int
+	
+	// Smaller bodies are stored in one bucket, as usual.
+	MinSize int
+}
+
+/*
+Optionally implemented by a BucketSched. Returns n distinct buckets.
+*/
+type MultiSched interface{
+	NextBuckets(n int) ([][]byte,bool)
+}
+
+var encoders sync.Map
+
+/* Encoders are safe for concurrent use, so they are shared. */
+func encoder(k, m int) (reedsolomon.Encoder, error) {
+	key := [2]int{k,m}
+	if e,ok := encoders.Load(key); ok { return e.(reedsolomon.Encoder),nil }
+	e,err := reedsolomon.New(k,m)
+	if err!=nil { return nil,err }
+	encoders.Store(key,e)
+	return e,nil
+}
+
+func shardKey(id []byte, i int) bufferex.Binary {
+	buf := bufferex.AllocBinary(len(id)+2)
+	b := buf.Bytes()
+	copy(b,id)
+	b[len(id)] = 's'
+	b[len(id)+1] = byte(i)
+	return buf
+}
+
+/*
+Writes a value with an expiration time, or without one, if the bucket does not
+support expiration.
+*/
+func put(srv netmodel.Server, bkt, key, value []byte, expire uint64) (expiring bool, err error) {
+	if srv.WriterEx!=nil {
+		err = srv.WriterEx.BucketPutExpire(bkt,key,value,expire)
+		if _,ok := err.(selerr.TNotImplemented); !ok { return true,err }
+	}
+	if srv.Writer!=nil { return false,srv.Writer.BucketPut(bkt,key,value) }
+	return false,articlestore.VEFail
+}
+
+func (s *StoreWriter) nextBuckets(n int) ([][]byte,bool) {
+	if ms,ok := s.Sched.(MultiSched); ok { return ms.NextBuckets(n) }
+	r := make([][]byte,0,n)
+	seen := make(map[string]bool)
+	for i := 0; i<n*4 && len(r)<n; i++ {
+		b,ok := s.Sched.NextBucket()
+		if !ok { break }
+		if seen[string(b)] { continue }
+		seen[string(b)] = true
+		r = append(r,b)
+	}
+	return r,len(r)==n
+}
+
+/* Splits a body into k data and m parity shards of equal size. */
+func encode(enc reedsolomon.Encoder, body []byte, k, m int) ([][]byte,error) {
+	size := (len(body)+k-1)/k
+	buf := make([]byte,(k+m)*size)
+	copy(buf,body)
+	shards := make([][]byte,k+m)
+	for i := range shards { shards[i] = buf[i*size:(i+1)*size] }
+	err := enc.Encode(shards)
+	return shards,err
+}
+
+func (s *StoreWriter) writeShards(id, xover, head, body []byte, expire uint64) (err error) {
+	e := s.Erasure
+	enc,err := encoder(e.Data,e.Parity)
+	if err!=nil { return }
+	shards,err := encode(enc,body,e.Data,e.Parity)
+	if err!=nil { return }
+	bkts,ok := s.nextBuckets(len(shards))
+	if !ok { return articlestore.VEFail }
+	
+	var nxover []byte
+	if s.UseFastOver { nxover,xover = xover,nxover }
+	
+	rid := gocql.TimeUUID()
+	
+	err = s.Session.Query(`
+	INSERT INTO article_locs
+	 (messageid,recid,bucket ,shards,ecdata,bodylen          ,exp   ) VALUES
+	 (?        ,?    ,?      ,?     ,?     ,?                ,?     )
+	`,id       ,rid  ,bkts[0],bkts  ,e.Data,int64(len(body)) ,expire).Exec()
+	if err!=nil { return }
+	
+	type result struct{
+		expiring bool
+		err error
+	}
+	results := make(chan result,len(shards))
+	for i := range shards {
+		go func(i int) {
+			var r result
+			defer func() { results <- r }()
+			srv,ok := s.Flook.FastLookup(bkts[i])
+			if !ok { r.err = articlestore.VEFail; return }
+			if i<=e.Parity {
+				idb,bts := extend(id)
+				defer idb.Free()
+				*bts = 'h'
+				r.expiring,r.err = put(srv,bkts[i],idb.Bytes(),head,expire)
+				if r.err!=nil { return }
+				*bts = 'x'
+				if len(xover)>0 { _,r.err = put(srv,bkts[i],idb.Bytes(),xover,expire) }
+				if r.err!=nil { return }
+			}
+			key := shardKey(id,i)
+			defer key.Free()
+			r.expiring,r.err = put(srv,bkts[i],key.Bytes(),shards[i],expire)
+		}(i)
+	}
+	expiring := true
+	for range shards {
+		r := <- results
+		if r.err!=nil && err==nil { err = r.err }
+		expiring = expiring && r.expiring
+	}
+	if err!=nil { return }
+	
+	secs := int64(time.Until(time.Unix(int64(expire),0))/time.Second)+1
+	
+	if expiring {
+		/*
+		All shards expire on their own, so the record can expire completely.
+		*/
+		err = s.Session.Query(`
+		INSERT INTO article_locs
+		 (messageid,recid,avail,xover ,bucket ,shards,ecdata,bodylen         ,keep,exp   ) VALUES
+		 (?        ,?    ,true ,?     ,?      ,?     ,?     ,?               ,true,?     ) USING TTL ?
+		`,id       ,rid        ,nxover,bkts[0],bkts  ,e.Data,int64(len(body))     ,expire           ,secs).Exec()
+		return
+	}
+	err = s.Session.Query(`
+	INSERT INTO article_locs
+	 (messageid,recid,xover ,avail,keep) VALUES
+	 (?        ,?    ,?     ,true ,true) USING TTL ?
+	`,id       ,rid  ,nxover                      ,secs).Exec()
+	return
+}
+
+/* Returns the value from the first bucket, that has it. */
+func (s *StoreReader) getAny(bkts [][]byte, key []byte) (bin bufferex.Binary, err error) {
+	err = bucketstore.ENotFound
+	for _,bkt := range bkts {
+		bin,err = s.Bucket.BucketGet(bkt,key)
+		if err==nil { return }
+	}
+	return
+}
+
+/*
+Restores the body from its shards. The data shards are fetched first, parity
+shards are only fetched, if data shards are missing.
+*/
+func (s *StoreReader) getBody(id []byte, bkts [][]byte, k int, size int64) (result bufferex.Binary, err error) {
+	n := len(bkts)
+	if k<1 || k>n || size<0 { return result,articlestore.VEFail }
+	enc,err := encoder(k,n-k)
+	if err!=nil { return }
+	ssize := int((size+int64(k)-1)/int64(k))
+	shards := make([][]byte,n)
+	errs := make([]error,n)
+	fetch := func(from, to int) {
+		var wg sync.WaitGroup
+		for i := from; i<to; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := shardKey(id,i)
+				defer key.Free()
+				bin,err := s.Bucket.BucketGet(bkts[i],key.Bytes())
+				if err!=nil { errs[i] = err; return }
+				defer bin.Free()
+				if len(bin.Bytes())!=ssize { errs[i] = articlestore.VEFail; return }
+				shards[i] = append(make([]byte,0,ssize),bin.Bytes()...)
+			}(i)
+		}
+		wg.Wait()
+	}
+	have,next := 0,0
+	for have<k && next<n {
+		to := next+k-have
+		if to>n { to = n }
+		fetch(next,to)
+		next = to
+		have = 0
+		for _,sh := range shards { if sh!=nil { have++ } }
+	}
+	if have<k {
+		err = bucketstore.ENotFound
+		for _,e := range errs { if e!=nil && e!=bucketstore.ENotFound { err = e } }
+		return
+	}
+	for _,sh := range shards[:k] {
+		if sh!=nil { continue }
+		err = enc.ReconstructData(shards)
+		if err!=nil { return }
+		break
+	}
+	result = bufferex.AllocBinary(int(size))
+	b := result.Bytes()
+	for _,sh := range shards[:k] { b = b[copy(b,sh):] }
+	return
+}
+
+func (s *StoreReader) readShards(id []byte, overb bufferex.Binary, bkts [][]byte, k int, size int64, over, head, body bool) (result bufferex.Binary, err error) {
+	idb,bts := extend(id)
+	defer idb.Free()
+	var headb,bodyb bufferex.Binary
+	
+	/* The header and xover are stored in the buckets of the first n-k+1 shards. */
+	copies := bkts
+	if k>=1 && k<=len(bkts) { copies = bkts[:len(bkts)-k+1] }
+	
+	if head {
+		*bts = 'h'
+		headb,err = s.getAny(copies,idb.Bytes())
+		if err!=nil { return }
+		defer headb.Free()
+	}
+	if !over {
+		overb = bufferex.Binary{}
+	} else if len(overb.Bytes())==0 {
+		*bts = 'x'
+		overb,err = s.getAny(copies,idb.Bytes())
+		if err!=nil { return }
+		defer overb.Free()
+	}
+	if body {
+		bodyb,err = s.getBody(id,bkts,k,size)
+		if err!=nil { return }
+		defer bodyb.Free()
+	}
+	result,err = articlestore.PackMessage(overb.Bytes(),headb.Bytes(),bodyb.Bytes())
+	return
+}
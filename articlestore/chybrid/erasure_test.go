@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package chybrid
+
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "bytes"
+import "errors"
+import "fmt"
+import "sync"
+import "testing"
+
+/* An in-memory BucketR, that counts the reads. */
+type shardStore struct{
+	mu   sync.Mutex
+	m    map[string][]byte
+	errs map[string]error
+	gets int
+}
+func newShardStore() *shardStore {
+	return &shardStore{m:make(map[string][]byte),errs:make(map[string]error)}
+}
+func (s *shardStore) BucketGet(bucket, key []byte) (bufferex.Binary, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	s.gets++
+	k := string(bucket)+"/"+string(key)
+	if err := s.errs[string(bucket)]; err!=nil { return bufferex.Binary{},err }
+	v,ok := s.m[k]
+	if !ok { return bufferex.Binary{},bucketstore.ENotFound }
+	return bufferex.NewBinary(v),nil
+}
+func (s *shardStore) put(bucket, key, value []byte) {
+	s.m[string(bucket)+"/"+string(key)] = append([]byte(nil),value...)
+}
+func (s *shardStore) shard(bkts [][]byte, id []byte, i int) string {
+	key := shardKey(id,i)
+	defer key.Free()
+	return string(bkts[i])+"/"+string(key.Bytes())
+}
+
+func testBody(n int) []byte {
+	b := make([]byte,n)
+	for i := range b { b[i] = byte(i*7+i/251) }
+	return b
+}
+
+/* Stores the shards of body, like writeShards does. */
+func storeShards(t *testing.T, k, m int, id, body []byte) (*shardStore,[][]byte) {
+	enc,err := encoder(k,m)
+	if err!=nil { t.Fatal(err) }
+	shards,err := encode(enc,body,k,m)
+	if err!=nil { t.Fatal(err) }
+	s := newShardStore()
+	var bkts [][]byte
+	for i,sh := range shards {
+		bkt := []byte(fmt.Sprintf("bucket%d",i))
+		bkts = append(bkts,bkt)
+		key := shardKey(id,i)
+		s.put(bkt,key.Bytes(),sh)
+		key.Free()
+	}
+	return s,bkts
+}
+
+func TestEncode(t *testing.T) {
+	enc,err := encoder(4,2)
+	if err!=nil { t.Fatal(err) }
+	if e2,_ := encoder(4,2); e2!=enc { t.Error("the encoder is not shared") }
+	body := testBody(1001)
+	shards,err := encode(enc,body,4,2)
+	if err!=nil { t.Fatal(err) }
+	if len(shards)!=6 { t.Fatalf("%d shards, expected 6",len(shards)) }
+	for i,sh := range shards {
+		if len(sh)!=251 { t.Errorf("shard %d has %d bytes, expected 251",i,len(sh)) }
+	}
+	data := bytes.Join(shards[:4],nil)
+	if !bytes.Equal(data[:len(body)],body) || bytes.Count(data[len(body):],[]byte{0})!=len(data)-len(body) {
+		t.Error("the data shards don't hold the body")
+	}
+	if ok,err := enc.Verify(shards); !ok || err!=nil { t.Errorf("Verify -> %v %v",ok,err) }
+}
+
+func TestGetBody(t *testing.T) {
+	const k,m = 4,2
+	id := []byte("<erasure@test>")
+	body := testBody(1001)
+	cases := []struct{
+		name    string
+		missing []int // shards, that are lost
+		corrupt []int // shards, that are truncated
+		gets    int   // expected number of reads
+		fails   bool
+	}{
+		{"complete",nil,nil,k,false},
+		{"one data shard",[]int{1},nil,k+1,false},
+		{"two data shards",[]int{0,3},nil,k+2,false},
+		{"data and parity",[]int{2,4},nil,k+2,false},
+		{"parity only",[]int{4,5},nil,k,false},
+		{"too many",[]int{0,1,5},nil,k+2,true},
+		{"corrupt data shard",nil,[]int{2},k+1,false},
+		{"corrupt and missing",[]int{0},[]int{3},k+2,false},
+		{"too many corrupt",[]int{1},[]int{2,4},k+2,true},
+	}
+	for _,c := range cases {
+		s,bkts := storeShards(t,k,m,id,body)
+		for _,i := range c.missing { delete(s.m,s.shard(bkts,id,i)) }
+		for _,i := range c.corrupt {
+			sk := s.shard(bkts,id,i)
+			s.m[sk] = s.m[sk][1:]
+		}
+		r := &StoreReader{Bucket:s}
+		got,err := r.getBody(id,bkts,k,int64(len(body)))
+		if c.fails {
+			if err==nil { t.Errorf("%s: restored the body",c.name) }
+		} else if err!=nil {
+			t.Errorf("%s: %v",c.name,err)
+		} else if !bytes.Equal(got.Bytes(),body) {
+			t.Errorf("%s: the body was not restored",c.name)
+		}
+		if s.gets!=c.gets { t.Errorf("%s: %d reads, expected %d",c.name,s.gets,c.gets) }
+	}
+}
+
+func TestGetBodyErrors(t *testing.T) {
+	const k,m = 3,2
+	id := []byte("<errors@test>")
+	body := testBody(300)
+	s,bkts := storeShards(t,k,m,id,body)
+	r := &StoreReader{Bucket:s}
+	
+	/* A failing bucket is like a missing shard. */
+	errDown := errors.New("bucket down")
+	s.errs[string(bkts[0])] = errDown
+	s.errs[string(bkts[2])] = errDown
+	got,err := r.getBody(id,bkts,k,int64(len(body)))
+	if err!=nil || !bytes.Equal(got.Bytes(),body) { t.Fatalf("with two buckets down: %v",err) }
+	
+	/* If too many are gone, a bucket error is reported rather than ENotFound. */
+	delete(s.m,s.shard(bkts,id,3))
+	if _,err = r.getBody(id,bkts,k,int64(len(body))); err!=errDown { t.Errorf("got %v, expected %v",err,errDown) }
+	
+	s.errs = make(map[string]error)
+	delete(s.m,s.shard(bkts,id,0))
+	delete(s.m,s.shard(bkts,id,1))
+	if _,err = r.getBody(id,bkts,k,int64(len(body))); err!=bucketstore.ENotFound { t.Errorf("got %v, expected ENotFound",err) }
+	
+	/* Invalid records. */
+	for _,p := range []struct{ k int; size int64 }{{0,300},{6,300},{3,-1}} {
+		if _,err = r.getBody(id,bkts,p.k,p.size); err!=articlestore.VEFail { t.Errorf("getBody(k=%d,size=%d) -> %v, expected VEFail",p.k,p.size,err) }
+	}
+}
+
+/* The header is found in any of the first n-k+1 buckets. */
+func TestReadShardsHeader(t *testing.T) {
+	const k,m = 3,2
+	id := []byte("<head@test>")
+	body := testBody(500)
+	s,bkts := storeShards(t,k,m,id,body)
+	idb,bts := extend(id)
+	*bts = 'h'
+	for _,bkt := range bkts[:m+1] { s.put(bkt,idb.Bytes(),[]byte("Subject: test")) }
+	idb.Free()
+	r := &StoreReader{Bucket:s}
+	
+	s.errs[string(bkts[0])] = errors.New("bucket down")
+	s.errs[string(bkts[1])] = errors.New("bucket down")
+	res,err := r.readShards(id,bufferex.Binary{},bkts,k,int64(len(body)),false,true,true)
+	if err!=nil { t.Fatal(err) }
+	expect,err := articlestore.PackMessage(nil,[]byte("Subject: test"),body)
+	if err!=nil { t.Fatal(err) }
+	if !bytes.Equal(res.Bytes(),expect.Bytes()) { t.Error("unexpected message") }
+	
+	/* The last k-1 buckets don't hold the header. */
+	s.errs[string(bkts[2])] = errors.New("bucket down")
+	if _,err = r.readShards(id,bufferex.Binary{},bkts,k,int64(len(body)),false,true,false); err==nil {
+		t.Error("found a header outside of the first n-k+1 buckets")
+	}
+}
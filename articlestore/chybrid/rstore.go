@@ -43,11 +43,17 @@ func (s *StoreReader) StoreReadMessage(id []byte, over, head, body bool) (result
 	idb,bts := extend(id)
 	defer idb.Free()
 	var bkt []byte
+	var shards [][]byte
+	var ecdata int
+	var bodylen int64
 	var overb,headb,bodyb bufferex.Binary
 	q := s.Session.Query(`
 	SELECT
 		xover,
-		bucket
+		bucket,
+		shards,
+		ecdata,
+		bodylen
 	FROM article_locs
 	WHERE messageid = ? AND avail = true
 	LIMIT 1 ALLOW FILTERING
@@ -56,11 +62,14 @@ func (s *StoreReader) StoreReadMessage(id []byte, over, head, body bool) (result
 	
 	{
 		var xov []byte
-		err = q.Scan(&xov,&bkt)
+		err = q.Scan(&xov,&bkt,&shards,&ecdata,&bodylen)
 		if err!=nil { return }
 		overb = bufferex.NewBinaryInplace(xov)
 	}
 	
+	/* Erasure coded (see Erasure). */
+	if len(shards)>0 { return s.readShards(id,overb,shards,ecdata,bodylen,over,head,body) }
+	
 	if head {
 		*bts = 'h'
 		headb,err = s.Bucket.BucketGet(bkt,idb.Bytes())
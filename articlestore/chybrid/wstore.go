@@ -43,9 +43,16 @@ type StoreWriter struct {
 	Flook FastLookup
 	Session *gocql.Session
 	UseFastOver bool
+	
+	/* If set, bodies are erasure coded, see Erasure. */
+	Erasure *Erasure
 }
 
 func (s *StoreWriter) StoreWriteMessage(id, msg []byte, expire uint64) (err error) {
+	if e := s.Erasure; e!=nil {
+		xover,head,body := articlestore.UnpackMessage(msg)
+		if len(body)>0 && len(body)>=e.MinSize { return s.writeShards(id,xover,head,body,expire) }
+	}
 	bkt,ok := s.Sched.NextBucket(); if !ok { return articlestore.VEFail }
 	srv,ok := s.Flook.FastLookup(bkt); if !ok { return articlestore.VEFail }
 	xover,head,body := articlestore.UnpackMessage(msg)
@@ -70,9 +77,9 @@ func (s *StoreWriter) StoreWriteMessage(id, msg []byte, expire uint64) (err erro
 		/*
 		If the bucket sits in a remote node, srv.WriterEx is set with an
 		implementation of bucketstore.BucketWEx (which is a *kvrpc.Client),
-		which's BucketPutExpire() method will always fail. We catch that
-		error case by checking the returned error for being a
-		selerr.TNotImplemented instance.
+		which forwards BucketPutExpire() to that node. If the bucket there
+		has no BucketWEx, the call fails with selerr.TNotImplemented. We
+		catch that error case and fall back to srv.Writer.
 		
 		We write the header first, since it is non-optional like xover
 		and we use the first performed write for our check, so it must
@@ -0,0 +1,52 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package graph_test
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore/graph"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/memstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/storetest"
+import "testing"
+
+/* Two rings. Writes go to the first one, that succeeds. Reads try the rings by priority. */
+func newRingSet(t *testing.T) (articlestore.Storage,func()) {
+	cfg := &graph.CfgConfig{Rings:[]graph.CfgRing{
+		{Ring:"a",Type:"JUMP",Seed:1,Num:2,Prio:2,Shard:[]string{"a1","a2","a3"}},
+		{Ring:"b",Type:"STEP",Seed:2,Num:2,Prio:1,Shard:[]string{"b1","b2"}},
+	}}
+	rs := new(graph.RingSet)
+	stt := make(graph.StorageMM)
+	rs.Configure(cfg,stt)
+	for _,ring := range stt {
+		for _,st := range ring { st.Set(new(memstore.Storage),1) }
+	}
+	return rs,nil
+}
+
+func TestRingSet(t *testing.T) {
+	s := &storetest.ArticleSuite{New:newRingSet}
+	s.Run(t)
+	s.OverNetwire().Run(t)
+	s.OverGnetwire().Run(t)
+}
@@ -0,0 +1,48 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package minifier_test
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore/minifier"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/memstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/storetest"
+import "testing"
+import "fmt"
+
+func TestRWrapper(t *testing.T) {
+	for _,safe := range []bool{false,true} {
+		safe := safe
+		t.Run(fmt.Sprint("Safe=",safe),func(t *testing.T) {
+			s := &storetest.ArticleSuite{New:func(t *testing.T) (articlestore.Storage,func()) {
+				st := new(memstore.Storage)
+				return struct{
+					*minifier.RWrapper
+					articlestore.StorageW
+				}{&minifier.RWrapper{StorageR:st,Safe:safe},st},nil
+			}}
+			s.Run(t)
+			s.OverNetwire().Run(t)
+		})
+	}
+}
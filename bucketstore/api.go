@@ -44,3 +44,27 @@ type BucketWEx interface {
 	BucketPutExpire(bucket,key,value []byte,expiresAt uint64) error
 }
 
+
+/*
+Describes a range of keys to be scanned.
+
+The scan yields the keys, that have the prefix Prefix, that are greater than or
+equal to Start and (if End is not empty) less than End, in ascending order.
+If Limit is positive, at most Limit keys are yielded per call. If Values is false,
+only the keys are delivered.
+*/
+type ScanRange struct {
+	Prefix, Start, End []byte
+	Limit int
+	Values bool
+}
+
+type BucketScanner interface {
+	/*
+	Scans the range r. The slices passed to targ are only valid during the call.
+	
+	If the scan was cut short by r.Limit, the returned cursor is non-nil. To resume
+	the scan, pass the cursor as r.Start in the next call.
+	*/
+	BucketScan(bucket []byte, r *ScanRange, targ func(key, value []byte)) (cursor []byte, err error)
+}
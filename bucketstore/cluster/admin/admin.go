@@ -0,0 +1,347 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+Runtime administration of the bucket cluster, over HTTP with JSON bodies.
+
+	GET  /members           The members and their metadata.
+	GET  /buckets           The buckets and the nodes, serving them.
+	GET  /health            The health of all buckets.
+	GET  /netkv             The offered and removed NetKV stores.
+	POST /buckets/add       {"path":...}: opens a dkv bucket and serves it.
+	POST /buckets/delete    {"name":...,"close":bool}: stops serving a bucket.
+	                        With close, a dkv bucket is closed and a named
+	                        bucket of a dkv.Multi is dropped.
+	POST /netkv/offer       {"provider":...,"loc":...,"bucket":...,"meta":base64}
+	POST /netkv/remove      {"loc":...,"bucket":...}
+	POST /health/override   {"name":...,"writable":bool or null to clear}
+
+Overrides are gossiped to all nodes, including the ones, serving the bucket.
+
+If a token is set, requests must carry the header "Authorization: Bearer <token>".
+Listen refuses to serve on a non-loopback address without a token.
+*/
+package admin
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/bucketmap"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/healthmap"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv/health"
+import "github.com/maxymania/fastnntp-polyglot-labs/guido"
+import "encoding/json"
+import "net/http"
+import "net"
+import "errors"
+import "sort"
+import "sync"
+import "os"
+
+var (
+	EUnauthorized = errors.New("unauthorized")
+	ENoSuchBucket = errors.New("no such bucket")
+	ENotADirectory = errors.New("not a directory")
+	EBadAddress = errors.New("invalid listen address")
+	ENoToken = errors.New("a token is required on non-loopback addresses")
+)
+
+type Member struct{
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+	Loc  string `json:"loc"`
+	Port int    `json:"rpc"`
+	Self bool   `json:"self"`
+}
+
+type BucketNodes struct{
+	Bucket string   `json:"bucket"`
+	Nodes  []string `json:"nodes"`
+	Local  bool     `json:"local"`
+}
+
+type Health struct{
+	Name     string `json:"name"`
+	Writable bool   `json:"writable"`
+	Free     uint64 `json:"free"`
+	Total    uint64 `json:"total"`
+	IOErrors uint64 `json:"ioerrors"`
+	Draining bool   `json:"draining"`
+	Override *bool  `json:"override,omitempty"`
+}
+
+/* A NetKV store. The metadata is not shown, as it may contain passwords. */
+type NetKv struct{
+	Provider string `json:"provider"`
+	Loc      string `json:"loc"`
+	Bucket   string `json:"bucket"`
+	Removed  bool   `json:"removed"`
+	Session  bool   `json:"session"`
+}
+
+type AddRequest struct{
+	Path string `json:"path"`
+}
+type AddResponse struct{
+	Name string `json:"name"`
+}
+type DeleteRequest struct{
+	Name  string `json:"name"`
+	Close bool   `json:"close"`
+}
+type OfferRequest struct{
+	Provider string `json:"provider"`
+	Loc      string `json:"loc"`
+	Bucket   string `json:"bucket"`
+	Meta     []byte `json:"meta"`
+}
+type OverrideRequest struct{
+	Name     string `json:"name"`
+	Writable *bool  `json:"writable"`
+}
+
+type errorResponse struct{
+	Error string `json:"error"`
+}
+
+type Server struct{
+	D     *cluster.Deleg
+	Token string
+	
+	// Creates the HealthChecker for a bucket, added at runtime.
+	// If nil, the defaults of package health are used.
+	Checker func(path string, bucket []byte) *health.HealthChecker
+	
+	mu       sync.Mutex
+	checkers map[string]*health.HealthChecker
+	mux      *http.ServeMux
+}
+
+func New(d *cluster.Deleg, token string) *Server {
+	s := &Server{D:d,Token:token,checkers:make(map[string]*health.HealthChecker)}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/members",s.get(s.members))
+	s.mux.HandleFunc("/buckets",s.get(s.buckets))
+	s.mux.HandleFunc("/health",s.get(s.health))
+	s.mux.HandleFunc("/netkv",s.get(s.netkv))
+	s.mux.HandleFunc("/buckets/add",s.post(func() interface{} { return new(AddRequest) },s.add))
+	s.mux.HandleFunc("/buckets/delete",s.post(func() interface{} { return new(DeleteRequest) },s.delete))
+	s.mux.HandleFunc("/netkv/offer",s.post(func() interface{} { return new(OfferRequest) },s.offer))
+	s.mux.HandleFunc("/netkv/remove",s.post(func() interface{} { return new(OfferRequest) },s.remove))
+	s.mux.HandleFunc("/health/override",s.post(func() interface{} { return new(OverrideRequest) },s.override))
+	return s
+}
+
+/*
+Listens for the admin service. An empty addr means 127.0.0.1. Without a token,
+only loopback addresses are accepted, as everyone, who can connect, could add
+and delete buckets.
+*/
+func Listen(addr string, port int, token string) (net.Listener,error) {
+	if addr=="" { addr = "127.0.0.1" }
+	ip := net.ParseIP(addr)
+	if ip==nil { return nil,EBadAddress }
+	if token=="" && !ip.IsLoopback() { return nil,ENoToken }
+	l,err := net.ListenTCP("tcp",&net.TCPAddr{IP:ip,Port:port})
+	if err!=nil { return nil,err }
+	return l,nil
+}
+
+func reply(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Token!="" && r.Header.Get("Authorization")!="Bearer "+s.Token {
+		reply(w,http.StatusUnauthorized,errorResponse{EUnauthorized.Error()})
+		return
+	}
+	s.mux.ServeHTTP(w,r)
+}
+
+func (s *Server) get(f func() interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method!="GET" {
+			reply(w,http.StatusMethodNotAllowed,errorResponse{"use GET"})
+			return
+		}
+		reply(w,http.StatusOK,f())
+	}
+}
+func (s *Server) post(req func() interface{}, f func(req interface{}) (interface{},error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method!="POST" {
+			reply(w,http.StatusMethodNotAllowed,errorResponse{"use POST"})
+			return
+		}
+		v := req()
+		err := json.NewDecoder(r.Body).Decode(v)
+		if err!=nil {
+			reply(w,http.StatusBadRequest,errorResponse{err.Error()})
+			return
+		}
+		resp,err := f(v)
+		if err!=nil {
+			reply(w,http.StatusConflict,errorResponse{err.Error()})
+			return
+		}
+		if resp==nil { resp = struct{}{} }
+		reply(w,http.StatusOK,resp)
+	}
+}
+
+func (s *Server) members() interface{} {
+	d := s.D
+	l := []Member{}
+	if d.ML==nil { return l }
+	for _,n := range d.ML.Members() {
+		m := Member{Name:n.Name,IP:n.Addr.String(),Self:n.Name==d.Self}
+		if md := d.GetOne(n.Name); md!=nil {
+			m.Loc,m.Port = md.Loc,md.Port
+		} else if m.Self && d.Meta!=nil {
+			m.Loc,m.Port = d.Meta.Loc,d.Meta.Port
+		}
+		l = append(l,m)
+	}
+	sort.Slice(l,func(i,j int) bool { return l[i].Name<l[j].Name })
+	return l
+}
+
+func (s *Server) buckets() interface{} {
+	d := s.D
+	l := []BucketNodes{}
+	for _,b := range d.NM.GetBucketList() {
+		if b=="" { continue }
+		nodes := d.NM.Nodes(b)
+		sort.Strings(nodes)
+		l = append(l,BucketNodes{Bucket:b,Nodes:nodes,Local:d.BM.Contains([]byte(b))})
+	}
+	sort.Slice(l,func(i,j int) bool { return l[i].Bucket<l[j].Bucket })
+	return l
+}
+
+func (s *Server) health() interface{} {
+	l := []Health{}
+	for _,h := range s.D.HM.GetAll() {
+		e := Health{string(h.Name),h.Writable,h.Free,h.Total,h.IOErrors,h.Draining,nil}
+		if w,ok := healthmap.Override(h.Name); ok { e.Override = &w }
+		l = append(l,e)
+	}
+	sort.Slice(l,func(i,j int) bool { return l[i].Name<l[j].Name })
+	return l
+}
+
+func (s *Server) netkv() interface{} {
+	l := []NetKv{}
+	for _,n := range s.D.NetKvStores() {
+		l = append(l,NetKv{
+			Provider:n.Prov,
+			Loc:n.Loc,
+			Bucket:string(n.Bucket),
+			Removed:n.Op==cluster.NetRem,
+			Session:s.D.NKV.Get(n.Bucket)!=nil,
+		})
+	}
+	sort.Slice(l,func(i,j int) bool { return l[i].Bucket<l[j].Bucket })
+	return l
+}
+
+/* Opens a dkv bucket, like the configured buckets are opened. */
+func (s *Server) add(v interface{}) (interface{},error) {
+	req := v.(*AddRequest)
+	st,err := os.Stat(req.Path)
+	if err!=nil { return nil,err }
+	if !st.IsDir() { return nil,ENotADirectory }
+	u,err := guido.GetUID(req.Path)
+	if err!=nil { return nil,err }
+	name := []byte(u.String())
+	if s.D.BM.Contains(name) { return &AddResponse{string(name)},nil }
+	db,err := dkv.OpenQuick(req.Path)
+	if err!=nil { return nil,err }
+	s.D.AddBucket(name,bucketmap.Bucket{Reader:db,Writer:db,WriterEx:db})
+	var hc *health.HealthChecker
+	if s.Checker!=nil {
+		hc = s.Checker(req.Path,name)
+	} else {
+		hc = &health.HealthChecker{Path:req.Path,Bucket:name}
+	}
+	hc.Start()
+	s.mu.Lock()
+	s.checkers[string(name)] = hc
+	s.mu.Unlock()
+	return &AddResponse{string(name)},nil
+}
+
+func (s *Server) delete(v interface{}) (interface{},error) {
+	req := v.(*DeleteRequest)
+	name := []byte(req.Name)
+	bkt,ok := s.D.BM.Obtain(name)
+	if !ok { return nil,ENoSuchBucket }
+	s.D.DeleteBucket(name)
+	s.mu.Lock()
+	if hc := s.checkers[req.Name]; hc!=nil {
+		hc.Stop()
+		delete(s.checkers,req.Name)
+	}
+	s.mu.Unlock()
+	if req.Close {
+		switch db := bkt.Reader.(type) {
+		case dkv.Bucket: return nil,db.DB.Close()
+		case *dkv.Bucket: return nil,db.DB.Close()
+		case *dkv.Named: return nil,db.Drop()
+		}
+	}
+	return nil,nil
+}
+
+func (s *Server) offer(v interface{}) (interface{},error) {
+	req := v.(*OfferRequest)
+	if req.Bucket=="" { return nil,ENoSuchBucket }
+	s.D.OfferNetKvStore(&cluster.NetKvStore{
+		Op:cluster.NetAdd,
+		Prov:req.Provider,
+		Loc:req.Loc,
+		Bucket:[]byte(req.Bucket),
+		Meta:req.Meta,
+	})
+	return nil,nil
+}
+func (s *Server) remove(v interface{}) (interface{},error) {
+	req := v.(*OfferRequest)
+	if req.Bucket=="" { return nil,ENoSuchBucket }
+	s.D.OfferNetKvStore(&cluster.NetKvStore{
+		Op:cluster.NetRem,
+		Loc:req.Loc,
+		Bucket:[]byte(req.Bucket),
+	})
+	return nil,nil
+}
+
+func (s *Server) override(v interface{}) (interface{},error) {
+	req := v.(*OverrideRequest)
+	o := &cluster.HealthOverride{Bucket:[]byte(req.Name),Clear:req.Writable==nil}
+	if !o.Clear { o.Writable = *req.Writable }
+	s.D.OverrideHealth(o)
+	return nil,nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package admin
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/bucketmap"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv/health"
+import "testing"
+import "io/ioutil"
+import "os"
+import "time"
+
+func TestListen(t *testing.T) {
+	l,err := Listen("",0,"")
+	if err!=nil { t.Fatal(err) }
+	defer l.Close()
+	if a := l.Addr().String(); a[:10]!="127.0.0.1:" {
+		t.Errorf("listening on %s, expected 127.0.0.1",a)
+	}
+	
+	if _,err = Listen("0.0.0.0",0,""); err!=ENoToken {
+		t.Errorf("Listen(0.0.0.0) without token: %v, expected %v",err,ENoToken)
+	}
+	if _,err = Listen("localhost",0,"secret"); err!=EBadAddress {
+		t.Errorf("Listen(localhost): %v, expected %v",err,EBadAddress)
+	}
+	
+	l2,err := Listen("0.0.0.0",0,"secret")
+	if err!=nil { t.Fatal(err) }
+	l2.Close()
+}
+
+func newServer() *Server {
+	d := &cluster.Deleg{Self:"self"}
+	d.Init()
+	return New(d,"")
+}
+
+func TestAddDelete(t *testing.T) {
+	dir,err := ioutil.TempDir("","admin")
+	if err!=nil { t.Fatal(err) }
+	defer os.RemoveAll(dir)
+	s := newServer()
+	var hc *health.HealthChecker
+	s.Checker = func(path string, bucket []byte) *health.HealthChecker {
+		hc = &health.HealthChecker{Path:path,Bucket:bucket,MinFree:1,Interval:time.Millisecond}
+		return hc
+	}
+	resp,err := s.add(&AddRequest{Path:dir})
+	if err!=nil { t.Fatal(err) }
+	name := resp.(*AddResponse).Name
+	if hc==nil || string(hc.Bucket)!=name { t.Fatalf("the configured Checker was not used") }
+	if !s.D.BM.Contains([]byte(name)) { t.Errorf("bucket %q not served",name) }
+	
+	/* Let the checker run, while it is stopped. */
+	time.Sleep(10*time.Millisecond)
+	if _,err = s.delete(&DeleteRequest{Name:name,Close:true}); err!=nil { t.Fatal(err) }
+	if s.D.BM.Contains([]byte(name)) { t.Errorf("bucket %q still served",name) }
+	if len(s.checkers)!=0 { t.Errorf("checker not removed") }
+	if _,err = s.delete(&DeleteRequest{Name:name}); err!=ENoSuchBucket {
+		t.Errorf("deleting twice -> %v, expected %v",err,ENoSuchBucket)
+	}
+}
+
+func TestDeleteNamed(t *testing.T) {
+	dir,err := ioutil.TempDir("","admin")
+	if err!=nil { t.Fatal(err) }
+	defer os.RemoveAll(dir)
+	m,err := dkv.OpenMulti(dir)
+	if err!=nil { t.Fatal(err) }
+	defer m.DB.Close()
+	if err = m.Create([]byte("a")); err!=nil { t.Fatal(err) }
+	db := m.Bucket([]byte("a"))
+	if err = db.BucketPut(nil,[]byte("k"),[]byte("v")); err!=nil { t.Fatal(err) }
+	
+	s := newServer()
+	s.D.AddBucket([]byte("uid.a"),bucketmap.Bucket{Reader:db,Writer:db,WriterEx:db})
+	if _,err = s.delete(&DeleteRequest{Name:"uid.a",Close:true}); err!=nil { t.Fatal(err) }
+	if len(m.Buckets())!=0 { t.Errorf("named bucket not dropped: %q",m.Buckets()) }
+	if _,err = m.BucketGet([]byte("a"),[]byte("k")); err==nil { t.Errorf("the data of the dropped bucket is still there") }
+}
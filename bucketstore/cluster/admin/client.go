@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package admin
+
+import "encoding/json"
+import "net/http"
+import "bytes"
+import "errors"
+
+/* A client of the admin service. */
+type Client struct{
+	URL   string // like "http://127.0.0.1:63283"
+	Token string
+	HTTP  *http.Client
+}
+
+func (c *Client) do(method, path string, req, resp interface{}) error {
+	var body bytes.Buffer
+	if req!=nil {
+		err := json.NewEncoder(&body).Encode(req)
+		if err!=nil { return err }
+	}
+	hr,err := http.NewRequest(method,c.URL+path,&body)
+	if err!=nil { return err }
+	if c.Token!="" { hr.Header.Set("Authorization","Bearer "+c.Token) }
+	if req!=nil { hr.Header.Set("Content-Type","application/json") }
+	hc := c.HTTP
+	if hc==nil { hc = http.DefaultClient }
+	r,err := hc.Do(hr)
+	if err!=nil { return err }
+	defer r.Body.Close()
+	if r.StatusCode!=http.StatusOK {
+		var e errorResponse
+		if json.NewDecoder(r.Body).Decode(&e)!=nil || e.Error=="" { e.Error = r.Status }
+		return errors.New(e.Error)
+	}
+	if resp==nil { return nil }
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+func (c *Client) Members() (l []Member, err error) {
+	err = c.do("GET","/members",nil,&l)
+	return
+}
+func (c *Client) Buckets() (l []BucketNodes, err error) {
+	err = c.do("GET","/buckets",nil,&l)
+	return
+}
+func (c *Client) Health() (l []Health, err error) {
+	err = c.do("GET","/health",nil,&l)
+	return
+}
+func (c *Client) NetKv() (l []NetKv, err error) {
+	err = c.do("GET","/netkv",nil,&l)
+	return
+}
+
+/* Opens the dkv bucket at path (on the server) and returns its name. */
+func (c *Client) AddBucket(path string) (string,error) {
+	var resp AddResponse
+	err := c.do("POST","/buckets/add",&AddRequest{path},&resp)
+	return resp.Name,err
+}
+func (c *Client) DeleteBucket(name string, close bool) error {
+	return c.do("POST","/buckets/delete",&DeleteRequest{name,close},nil)
+}
+func (c *Client) OfferNetKv(provider, loc, bucket string, meta []byte) error {
+	return c.do("POST","/netkv/offer",&OfferRequest{provider,loc,bucket,meta},nil)
+}
+func (c *Client) RemoveNetKv(loc, bucket string) error {
+	return c.do("POST","/netkv/remove",&OfferRequest{Loc:loc,Bucket:bucket},nil)
+}
+
+/* Overrides the writable state of a bucket; nil clears the override. */
+func (c *Client) OverrideHealth(name string, writable *bool) error {
+	return c.do("POST","/health/override",&OverrideRequest{name,writable},nil)
+}
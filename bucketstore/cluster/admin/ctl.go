@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package admin
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netkv/netdir"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netkv/netresp"
+import "encoding/base64"
+import "text/tabwriter"
+import "strings"
+import "errors"
+import "flag"
+import "fmt"
+import "io"
+
+const usage = `usage: clusteradm [-url URL] [-token TOKEN] COMMAND [ARGS]
+
+commands:
+	members
+	buckets
+	health
+	netkv
+	add PATH                      open the dkv bucket at PATH (on the server)
+	delete [-close] NAME          stop serving the bucket NAME
+	offer [-loc LOC] PROVIDER BUCKET META
+	remove [-loc LOC] BUCKET
+	override NAME true|false|clear
+
+META is base64, or for the built-in providers:
+	dir:/path/to/directory
+	resp:host:port[/prefix]
+`
+
+var EUsage = errors.New("usage")
+
+/* Generates the metadata for the built-in providers. */
+func parseMeta(provider, meta string) ([]byte,error) {
+	switch {
+	case provider==netdir.PROVIDER && strings.HasPrefix(meta,"dir:"):
+		return netdir.Generate(meta[4:],false),nil
+	case provider==netresp.PROVIDER && strings.HasPrefix(meta,"resp:"):
+		o := netresp.Options{Addr:meta[5:]}
+		if i := strings.IndexByte(o.Addr,'/'); i>=0 { o.Addr,o.Prefix = o.Addr[:i],o.Addr[i+1:] }
+		return netresp.Generate(o),nil
+	}
+	return base64.StdEncoding.DecodeString(meta)
+}
+
+/*
+Runs the command line client with the given arguments (without the program
+name), writing the output to w.
+*/
+func Main(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("clusteradm",flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.Usage = func() { fmt.Fprint(w,usage) }
+	c := new(Client)
+	fs.StringVar(&c.URL,"url","http://127.0.0.1:63283","admin service")
+	fs.StringVar(&c.Token,"token","","access token")
+	if err := fs.Parse(args); err!=nil { return err }
+	args = fs.Args()
+	if len(args)==0 { fs.Usage(); return EUsage }
+	
+	sub := flag.NewFlagSet(args[0],flag.ContinueOnError)
+	sub.SetOutput(w)
+	closeDB := sub.Bool("close",false,"close the database")
+	loc := sub.String("loc","","location, empty for all")
+	if err := sub.Parse(args[1:]); err!=nil { return err }
+	rest := sub.Args()
+	need := func(n int) error {
+		if len(rest)!=n { fs.Usage(); return EUsage }
+		return nil
+	}
+	
+	tw := tabwriter.NewWriter(w,0,8,2,' ',0)
+	defer tw.Flush()
+	switch args[0] {
+	case "members":
+		l,err := c.Members()
+		if err!=nil { return err }
+		fmt.Fprintln(tw,"NAME\tIP\tLOC\tRPC\t")
+		for _,m := range l {
+			name := m.Name
+			if m.Self { name += " *" }
+			fmt.Fprintf(tw,"%s\t%s\t%s\t%d\t\n",name,m.IP,m.Loc,m.Port)
+		}
+	case "buckets":
+		l,err := c.Buckets()
+		if err!=nil { return err }
+		fmt.Fprintln(tw,"BUCKET\tLOCAL\tNODES\t")
+		for _,b := range l {
+			fmt.Fprintf(tw,"%s\t%v\t%s\t\n",b.Bucket,b.Local,strings.Join(b.Nodes,","))
+		}
+	case "health":
+		l,err := c.Health()
+		if err!=nil { return err }
+		fmt.Fprintln(tw,"BUCKET\tWRITABLE\tFREE\tTOTAL\tIOERRORS\tDRAINING\tOVERRIDE\t")
+		for _,h := range l {
+			ov := "-"
+			if h.Override!=nil { ov = fmt.Sprint(*h.Override) }
+			fmt.Fprintf(tw,"%s\t%v\t%d\t%d\t%d\t%v\t%s\t\n",h.Name,h.Writable,h.Free,h.Total,h.IOErrors,h.Draining,ov)
+		}
+	case "netkv":
+		l,err := c.NetKv()
+		if err!=nil { return err }
+		fmt.Fprintln(tw,"BUCKET\tPROVIDER\tLOC\tREMOVED\tSESSION\t")
+		for _,n := range l {
+			fmt.Fprintf(tw,"%s\t%s\t%s\t%v\t%v\t\n",n.Bucket,n.Provider,n.Loc,n.Removed,n.Session)
+		}
+	case "add":
+		if err := need(1); err!=nil { return err }
+		name,err := c.AddBucket(rest[0])
+		if err!=nil { return err }
+		fmt.Fprintln(tw,name)
+	case "delete":
+		if err := need(1); err!=nil { return err }
+		return c.DeleteBucket(rest[0],*closeDB)
+	case "offer":
+		if err := need(3); err!=nil { return err }
+		meta,err := parseMeta(rest[0],rest[2])
+		if err!=nil { return err }
+		return c.OfferNetKv(rest[0],*loc,rest[1],meta)
+	case "remove":
+		if err := need(1); err!=nil { return err }
+		return c.RemoveNetKv(*loc,rest[0])
+	case "override":
+		if err := need(2); err!=nil { return err }
+		var wr *bool
+		switch rest[1] {
+		case "true","false":
+			b := rest[1]=="true"
+			wr = &b
+		case "clear":
+		default: fs.Usage(); return EUsage
+		}
+		return c.OverrideHealth(rest[0],wr)
+	default:
+		fs.Usage()
+		return EUsage
+	}
+	return nil
+}
@@ -26,7 +26,10 @@ Bucket-Scheduler.
 package bucketsched
 
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/healthmap"
 import "math/rand"
+import "sync/atomic"
+import "sort"
 import "time"
 import "sync"
 
@@ -91,27 +94,146 @@ func (s *bucketList) update(lists [][]string) {
 	}
 }
 
+/*
+Chooses the bucket for new articles.
+
+Buckets are chosen at random, weighted by their free capacity, as reported
+by the healthmap. Buckets in the same location as this node (served by this
+node, by a node with the same Loc, or by a local NetKV store) are preferred
+by the factor LocalBias. Buckets with less than MinFree bytes left are never
+chosen.
+
+The weights are recomputed every second, the writable state is always checked
+before a bucket is returned.
+*/
 type BucketScheduler struct {
 	_willChange struct{}
 	bucketList
 	D *cluster.Deleg
+	
+	// Weight of buckets in the same location. Defaults to 1 (no preference).
+	LocalBias float64
+	
+	// Buckets with less free space are avoided.
+	MinFree uint64
+	
+	// The assumed free space of buckets, that do not report their capacity.
+	// Defaults to the average of the buckets, that do.
+	UnknownFree uint64
+	
+	weights atomic.Value // *wlist
 }
-func (s *BucketScheduler) NextBucket() ([]byte, bool) {
-	bkts := s.list
-	l := len(bkts)
-	switch l {
-	case 0: return nil,false
-	case 1:
-		if !s.D.HM.GetWritable(bkts[0],true) { return nil,false }
-		return bkts[0],true
-	}
-	i := rand.Intn(l)
-	if s.D.HM.GetWritable(bkts[i],true) { return bkts[i],true }
+
+type wlist struct{
+	list [][]byte
+	cum []float64
+}
+
+func (w *wlist) weight(i int) float64 {
+	if i==0 { return w.cum[0] }
+	return w.cum[i]-w.cum[i-1]
+}
+
+/*
+Returns a bucket at random, given the cumulated weights. Buckets with the
+weight 0 are never returned.
+*/
+func (w *wlist) pick(ok func([]byte) bool) ([]byte, bool) {
+	l := len(w.list)
+	if l==0 { return nil,false }
+	total := w.cum[l-1]
+	if total<=0 { return nil,false }
+	x := rand.Float64()*total
+	i := sort.SearchFloat64s(w.cum,x)
+	if i>=l { i = l-1 }
+	if w.weight(i)>0 && ok(w.list[i]) { return w.list[i],true }
 	for j := (i+1)%l ; i!=j ; j = (j+1)%l {
-		if s.D.HM.GetWritable(bkts[j],true) { return bkts[j],true }
+		if w.weight(j)>0 && ok(w.list[j]) { return w.list[j],true }
 	}
 	return nil,false
 }
+
+func (s *BucketScheduler) local(bucket []byte) bool {
+	d := s.D
+	if d.BM.Contains(bucket) { return true }
+	if has,_ := d.NKV.IsNet(bucket); has { return true }
+	if d.Meta==nil { return false }
+	for _,node := range d.NM.NodesB(bucket) {
+		if m := d.GetOne(node); m!=nil && m.Loc==d.Meta.Loc { return true }
+	}
+	return false
+}
+
+func (s *BucketScheduler) reweight() {
+	bkts := s.list
+	w := &wlist{list:make([][]byte,len(bkts)),cum:make([]float64,len(bkts))}
+	copy(w.list,bkts)
+	hs := make([]healthmap.Health,len(bkts))
+	var known,sum uint64
+	for i,b := range bkts {
+		hs[i],_ = s.D.HM.Get(b)
+		if hs[i].Total!=0 { known++; sum += hs[i].Free }
+	}
+	unknown := s.UnknownFree
+	if unknown==0 && known>0 { unknown = sum/known }
+	if unknown==0 { unknown = 1 }
+	
+	bias := s.LocalBias
+	if bias<=0 { bias = 1 }
+	
+	var cum float64
+	for i,b := range w.list {
+		free := hs[i].Free
+		if hs[i].Total==0 { free = unknown }
+		weight := float64(free)
+		if free<s.MinFree { weight = 0 }
+		if bias!=1 && s.local(b) { weight *= bias }
+		cum += weight
+		w.cum[i] = cum
+	}
+	s.weights.Store(w)
+}
+
+func (s *BucketScheduler) writable(bucket []byte) bool {
+	return s.D.HM.GetWritable(bucket,true)
+}
+
+func (s *BucketScheduler) NextBucket() ([]byte, bool) {
+	w,_ := s.weights.Load().(*wlist)
+	if w==nil { return nil,false }
+	return w.pick(s.writable)
+}
+/*
+Chooses n distinct buckets, e.g. for the shards of an erasure coded article.
+The buckets are chosen from distinct nodes, as long as there are enough of
+them. Returns false, if there are less than n writable buckets.
+*/
+func (s *BucketScheduler) NextBuckets(n int) ([][]byte, bool) {
+	w,_ := s.weights.Load().(*wlist)
+	if w==nil { return nil,false }
+	r := make([][]byte,0,n)
+	chosen := make(map[string]bool)
+	nodes := make(map[string]bool)
+	fresh := func(b []byte) bool {
+		return !chosen[string(b)] && s.writable(b)
+	}
+	distinct := func(b []byte) bool {
+		if !fresh(b) { return false }
+		for _,node := range s.D.NM.NodesB(b) {
+			if nodes[node] { return false }
+		}
+		return true
+	}
+	for len(r)<n {
+		b,ok := w.pick(distinct)
+		if !ok { b,ok = w.pick(fresh) }
+		if !ok { return nil,false }
+		chosen[string(b)] = true
+		for _,node := range s.D.NM.NodesB(b) { nodes[node] = true }
+		r = append(r,b)
+	}
+	return r,true
+}
 func (s *BucketScheduler) Start() {
 	go s.perform()
 }
@@ -124,6 +246,6 @@ func (s *BucketScheduler) perform() {
 		bkts1 := s.D.NM.GetBucketList()
 		bkts2 := s.D.NKV.GetBucketList()
 		s.update([][]string{bkts1,bkts2})
+		s.reweight()
 	}
 }
-
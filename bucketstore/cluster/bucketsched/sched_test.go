@@ -0,0 +1,54 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package bucketsched
+
+import "testing"
+
+func yes([]byte) bool { return true }
+
+func TestPickZeroWeight(t *testing.T) {
+	w := &wlist{
+		list: [][]byte{[]byte("a"),[]byte("b"),[]byte("c"),[]byte("d")},
+		cum: []float64{0,5,5,8},
+	}
+	notB := func(b []byte) bool { return string(b)!="b" }
+	for i := 0; i<1000; i++ {
+		b,ok := w.pick(yes)
+		if !ok || (string(b)!="b" && string(b)!="d") { t.Fatalf("pick returned %q,%v",b,ok) }
+		b,ok = w.pick(notB)
+		if !ok || string(b)!="d" { t.Fatalf("pick (without b) returned %q,%v",b,ok) }
+	}
+	if b,ok := w.pick(func(b []byte) bool { return string(b)=="a" || string(b)=="c" }); ok {
+		t.Fatalf("pick returned %q, which has the weight 0",b)
+	}
+}
+
+func TestPickAllZero(t *testing.T) {
+	w := &wlist{
+		list: [][]byte{[]byte("a"),[]byte("b")},
+		cum: []float64{0,0},
+	}
+	if b,ok := w.pick(yes); ok { t.Fatalf("pick returned %q, expected none",b) }
+	if _,ok := (&wlist{}).pick(yes); ok { t.Fatal("pick on an empty list returned a bucket") }
+}
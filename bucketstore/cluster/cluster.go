@@ -35,6 +35,7 @@ import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/healthmap"
 import "github.com/vmihailenco/msgpack"
 import "github.com/byte-mug/golibs/msgpackx"
 import "github.com/hashicorp/memberlist"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/cluster/mlsec"
 import "sort"
 
 const (
@@ -48,6 +49,24 @@ const (
 	
 	// &HealthEvent{}
 	PutHealth
+	
+	// &Alias{}
+	PutAlias
+	
+	// &HealthEvent{}, carrying the whole healthmap.Health.
+	//
+	// Nodes running older versions do not know PutHealthEx. They stop decoding
+	// a message at the first unknown command. Therefore a PutHealth is sent
+	// along with every PutHealthEx, and the PutHealthEx commands come last.
+	PutHealthEx
+	
+	// &HealthOverride{}
+	PutOverride
+	
+	// The stamp of the following NetAdd or NetRem.
+	//
+	// Nodes running older versions skip it, as the stamp never equals a command.
+	NetStamp
 )
 
 type HealthEvent healthmap.Health
@@ -59,16 +78,61 @@ func (c HealthEvent) Invalidates(b memberlist.Broadcast) bool {
 	return false
 }
 func (c HealthEvent) Message() []byte {
-	b,_ := msgpackx.Marshal(PutHealth,c.Name,c.Writable)
+	b,_ := msgpackx.Marshal(PutHealth,c.Name,c.Writable,PutHealthEx,c.Name,c.Writable,c.Free,c.Total,c.IOErrors,c.Draining)
 	return b
 }
 func (c HealthEvent) Finished() {}
 
+/*
+Overrides the writable state of Bucket (see healthmap.SetOverride), or clears
+the override, if Clear is set. Every node records it, but only the nodes,
+that serve the bucket, publish the overridden health.
+*/
+type HealthOverride struct{
+	Bucket []byte
+	Clear, Writable bool
+}
+func (c *HealthOverride) Invalidates(b memberlist.Broadcast) bool {
+	switch o := b.(type) {
+	case *HealthOverride: return string(c.Bucket)==string(o.Bucket)
+	}
+	return false
+}
+func (c *HealthOverride) Message() []byte {
+	b,_ := msgpackx.Marshal(PutOverride,c.Bucket,c.Clear,c.Writable)
+	return b
+}
+func (c *HealthOverride) Finished() {}
+
+/*
+Asks Node to serve Bucket from its local bucket Local.
+*/
+type Alias struct{
+	Node string
+	Bucket, Local []byte
+}
+func (c *Alias) Invalidates(b memberlist.Broadcast) bool {
+	switch o := b.(type) {
+	case *Alias: return c.Node==o.Node && string(c.Bucket)==string(o.Bucket)
+	}
+	return false
+}
+func (c *Alias) Message() []byte {
+	b,_ := msgpackx.Marshal(PutAlias,c.Node,c.Bucket,c.Local)
+	return b
+}
+func (c *Alias) Finished() {}
+
 type NetKvStore struct{
 	// NetAdd,NetRem
 	Op     uint
 	Prov,Loc string
 	Bucket,Meta []byte
+	
+	// The time, the store was offered or removed, set once by the node, that
+	// did it (see OfferNetKvStore). Receivers keep it, so that all nodes agree
+	// on, which entry of a bucket is the newest. Zero, if sent by older nodes.
+	Stamp  int64
 }
 func (c *NetKvStore) Invalidates(b memberlist.Broadcast) bool {
 	switch o := b.(type) {
@@ -80,7 +144,7 @@ func (c *NetKvStore) Invalidates(b memberlist.Broadcast) bool {
 	return false
 }
 func (c *NetKvStore) Message() []byte {
-	b,_ := msgpackx.Marshal(c.Op,c.Loc,c.Prov,c.Bucket,c.Meta)
+	b,_ := msgpackx.Marshal(NetStamp,c.Stamp,c.Op,c.Loc,c.Prov,c.Bucket,c.Meta)
 	return b
 }
 func (c *NetKvStore) Finished() {}
@@ -107,13 +171,17 @@ func (c *Command) Finished() {}
 
 const Magic uint = 0xcafedead
 
+/*
+Measures the distance between two locations (see Metadata.Loc).
+Nearer locations have smaller distances.
+*/
 type DistanceMeter interface{
 	Distance(l,r string) int
 }
 type simplistic struct{}
 func (simplistic) Distance(l,r string) int {
-	if l==r { return 1 }
-	return 0
+	if l==r { return 0 }
+	return 1
 }
 
 type nkvm map[string]*NetKvStore
@@ -130,7 +198,6 @@ type NodeMetadata struct{
 	Name string
 	IP   net.IP
 	Metadata
-	distance int
 }
 func (m *NodeMetadata) String() string {
 	return fmt.Sprintf("%q/%v%v",m.Name,m.IP,&m.Metadata)
@@ -154,6 +221,9 @@ type Deleg struct{
 	
 	Dist DistanceMeter
 	
+	// Gossip encryption and admission control. If nil, every node is admitted.
+	Sec *mlsec.Security
+	
 	nkvL    sync.RWMutex
 	nkvAdd nkvm
 	nkvRem nkvm
@@ -161,8 +231,13 @@ type Deleg struct{
 	othersL sync.RWMutex
 	othersM mdmap
 	
+	stateL  sync.Mutex
+	version uint64
+	known   versionMap
+	
 	leaveListener []func(string)
 	joinListener []func(string)
+	updateListener []func(string)
 }
 func (d *Deleg) AddLeaveListener(f func(string)){
 	d.leaveListener = append(d.leaveListener,f)
@@ -170,11 +245,35 @@ func (d *Deleg) AddLeaveListener(f func(string)){
 func (d *Deleg) AddJoinListener(f func(string)){
 	d.joinListener = append(d.joinListener,f)
 }
+/* The listener is called, after the metadata of a node has changed. */
+func (d *Deleg) AddUpdateListener(f func(string)){
+	d.updateListener = append(d.updateListener,f)
+}
+type byDistance struct{
+	s []*NodeMetadata
+	d []int
+}
+func (b byDistance) Len() int { return len(b.s) }
+func (b byDistance) Less(i,j int) bool { return b.d[i]<b.d[j] }
+func (b byDistance) Swap(i,j int) {
+	b.s[i],b.s[j] = b.s[j],b.s[i]
+	b.d[i],b.d[j] = b.d[j],b.d[i]
+}
+
+/* Sorts the nodes by their distance, nearest first. */
 func (d *Deleg) SortNodesDistance(s []*NodeMetadata) {
 	dist := d.Dist
 	if dist==nil { dist = simplistic{} }
-	for i := range s { s[i].distance = dist.Distance(s[i].Loc,d.Meta.Loc) }
-	sort.Slice(s,func(i,j int) bool { return s[i].distance < s[j].distance })
+	nd,_ := dist.(NodeDistanceMeter)
+	b := byDistance{s,make([]int,len(s))}
+	for i := range s {
+		if nd!=nil {
+			b.d[i] = nd.NodeDistance(d.Meta,s[i])
+		} else {
+			b.d[i] = dist.Distance(s[i].Loc,d.loc())
+		}
+	}
+	sort.Stable(b)
 }
 func (d *Deleg) numNodes() int {
 	if ml := d.ML; ml!=nil {
@@ -192,6 +291,8 @@ func (d *Deleg) Init() {
 	d.nkvAdd = make(nkvm)
 	d.nkvRem = make(nkvm)
 	d.othersM = make(mdmap)
+	d.known = make(versionMap)
+	d.version = uint64(stamp())
 }
 func (d *Deleg) NodeMeta(limit int) []byte {
 	m := d.Meta
@@ -211,6 +312,7 @@ func (d *Deleg) getNetKvStoreEncoded(buf *bytes.Buffer) {
 }
 func (d *Deleg) handleNetKvStore(n *NetKvStore) {
 	if !(n.Loc=="" || n.Loc==d.loc()) { return } // If out of loc, ignore.
+	if n.Loc!="" { defer d.bump() }
 	
 	switch n.Op {
 	case NetAdd:
@@ -236,6 +338,7 @@ func (d *Deleg) NotifyMsg(msg []byte) {
 	var op uint
 	var node string
 	var buck []byte
+	var nstamp int64
 	dec := msgpack.NewDecoder(bytes.NewReader(msg))
 	for{
 		if dec.Decode(&op)!=nil { return }
@@ -251,20 +354,41 @@ func (d *Deleg) NotifyMsg(msg []byte) {
 				c := new(NetKvStore)
 				c.Op = op
 				if dec.DecodeMulti(&c.Loc,&c.Prov,&c.Bucket,&c.Meta)!=nil { return }
+				c.Stamp,nstamp = nstamp,0
+				/* A late broadcast must not undo a newer change. */
+				if c.Stamp!=0 && !d.newerNetKv(c) { continue }
 				d.handleNetKvStore(c)
 			}
+		case NetStamp:
+			if dec.Decode(&nstamp)!=nil { return }
 		case PutHealth:
 			{
 				c := new(HealthEvent)
 				if dec.DecodeMulti(&c.Name,&c.Writable)!=nil { return }
 				d.HM.SetWritable(c.Name,c.Writable)
 			}
+		case PutHealthEx:
+			{
+				c := new(HealthEvent)
+				if dec.DecodeMulti(&c.Name,&c.Writable,&c.Free,&c.Total,&c.IOErrors,&c.Draining)!=nil { return }
+				d.HM.Set(healthmap.Health(*c))
+			}
+		case PutAlias:
+			{
+				c := new(Alias)
+				if dec.DecodeMulti(&c.Node,&c.Bucket,&c.Local)!=nil { return }
+				d.handleAlias(c)
+			}
+		case PutOverride:
+			{
+				c := new(HealthOverride)
+				if dec.DecodeMulti(&c.Bucket,&c.Clear,&c.Writable)!=nil { return }
+				d.handleOverride(c)
+			}
 		}
 	}
 }
 func (d *Deleg) GetBroadcasts(overhead, limit int) [][]byte { return d.TLQ.GetBroadcasts(overhead,limit) }
-func (  *Deleg) LocalState(join bool) []byte { return nil }
-func (  *Deleg) MergeRemoteState(buf []byte, join bool) { }
 func (d *Deleg) onNode(n *memberlist.Node) {
 	var u uint
 	m := new(NodeMetadata)
@@ -285,10 +409,14 @@ func (d *Deleg) NotifyJoin(n *memberlist.Node) {
 		for _,e := range d.NKV.GetLocalBuckets() {
 			enc.EncodeMulti(CmdAdd,d.Self,[]byte(e))
 		}
-		for _,e := range d.HM.GetAll() {
+		all := d.HM.GetAll()
+		for _,e := range all {
 			enc.EncodeMulti(PutHealth,e.Name,e.Writable)
 		}
 		d.getNetKvStoreEncoded(&buf)
+		for _,e := range all {
+			enc.EncodeMulti(PutHealthEx,e.Name,e.Writable,e.Free,e.Total,e.IOErrors,e.Draining)
+		}
 		if buf.Len()>0 {
 			go ml.SendReliable(n, buf.Bytes())
 		}
@@ -298,10 +426,14 @@ func (d *Deleg) NotifyJoin(n *memberlist.Node) {
 }
 func (d *Deleg) NotifyLeave(n *memberlist.Node) {
 	d.NM.DropNode(n.Name)
+	d.stateL.Lock()
+	delete(d.known,n.Name)
+	d.stateL.Unlock()
 	for _,f := range d.leaveListener { f(n.Name) }
 }
 func (d *Deleg) NotifyUpdate(n *memberlist.Node) {
 	d.onNode(n)
+	for _,f := range d.updateListener { f(n.Name) }
 }
 func (d *Deleg) NotifyAlive(peer *memberlist.Node) error {
 	return d.NotifyMerge([]*memberlist.Node{peer})
@@ -313,6 +445,8 @@ func (d *Deleg) NotifyMerge(peers []*memberlist.Node) error {
 		err := msgpackx.Unmarshal(peer.Meta,&u,&m)
 		if err!=nil { return err }
 		if u!=Magic { return fmt.Errorf("wrong magic number: got %x expected %x",u,Magic)}
+		err = d.Sec.Admit(peer)
+		if err!=nil { return fmt.Errorf("node %q (%v): %v",peer.Name,peer.Addr,err) }
 	}
 	return nil
 }
@@ -335,6 +469,7 @@ func (d *Deleg) GetOne(node string) *NodeMetadata {
 func (d *Deleg) AddBucket(name []byte,buck bucketmap.Bucket) {
 	d.NM.Set(d.Self,string(name))
 	d.BM.Add(name,buck)
+	d.bump()
 	d.TLQ.QueueBroadcast(&Command{CmdAdd,d.Self,name})
 }
 
@@ -342,16 +477,71 @@ func (d *Deleg) AddBucket(name []byte,buck bucketmap.Bucket) {
 func (d *Deleg) DeleteBucket(name []byte) {
 	d.NM.Drop(d.Self,string(name))
 	d.BM.Remove(name)
+	d.bump()
 	d.TLQ.QueueBroadcast(&Command{CmdSub,d.Self,name})
 }
+func (d *Deleg) handleAlias(a *Alias) {
+	if a.Node!=d.Self { return }
+	if d.BM.Contains(a.Bucket) { return }
+	bkt,ok := d.BM.Obtain(a.Local)
+	if !ok { return }
+	d.AddBucket(a.Bucket,bkt)
+}
+
+/*
+Asks the given node to serve the bucket name from its local bucket local,
+which then announces it using CmdAdd. This is used to move a bucket to
+another node.
+
+After calling, the 'name' and 'local' arrays must no be modified.
+*/
+func (d *Deleg) AliasBucket(node string,name,local []byte) {
+	a := &Alias{node,name,local}
+	d.handleAlias(a)
+	d.TLQ.QueueBroadcast(a)
+}
+
+/* Returns the NetKvStore records, that have been offered (NetAdd) or removed (NetRem). */
+func (d *Deleg) NetKvStores() (l []NetKvStore) {
+	d.nkvL.RLock(); defer d.nkvL.RUnlock()
+	for _,v := range d.nkvAdd { l = append(l,*v) }
+	for _,v := range d.nkvRem { l = append(l,*v) }
+	return
+}
+
 // After calling, the *NetKvStore data structure and all buffers used by it must not be used.
 func (d *Deleg) OfferNetKvStore(n *NetKvStore) {
+	n.Stamp = stamp()
 	d.handleNetKvStore(n)
 	d.TLQ.QueueBroadcast(n)
 }
+func (d *Deleg) handleOverride(o *HealthOverride) {
+	if o.Clear {
+		healthmap.ClearOverride(o.Bucket)
+		return
+	}
+	healthmap.SetOverride(o.Bucket,o.Writable)
+	if !d.BM.Contains(o.Bucket) { return }
+	/* Publish it now, not only with the next check. */
+	h,_ := d.HM.Get(o.Bucket)
+	h.Name = o.Bucket
+	healthmap.IssueHealth(h)
+}
+
+/*
+Overrides the writable state of a bucket on all nodes. A cleared override
+takes effect with the next check of the bucket's health checker.
+
+After calling, the *HealthOverride data structure and all buffers used by it must not be used.
+*/
+func (d *Deleg) OverrideHealth(o *HealthOverride) {
+	d.handleOverride(o)
+	d.TLQ.QueueBroadcast(o)
+}
 // ----
 func (d *Deleg) IssueHealth(h healthmap.Health) {
-	d.HM.SetWritable(h.Name,h.Writable)
+	d.HM.Set(h)
+	d.bump()
 	d.TLQ.QueueBroadcast(HealthEvent(h))
 }
 var _ healthmap.HealthReceiver = (*Deleg)(nil)
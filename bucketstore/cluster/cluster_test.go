@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package cluster
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/healthmap"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/bucketmap"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netkv"
+import "github.com/vmihailenco/msgpack"
+import "testing"
+import "bytes"
+import "time"
+
+/* Decodes a message the way nodes did before PutHealthEx was introduced. */
+func legacyHealth(msg []byte) map[string]bool {
+	var op uint
+	var node string
+	var buck []byte
+	m := make(map[string]bool)
+	dec := msgpack.NewDecoder(bytes.NewReader(msg))
+	for {
+		if dec.Decode(&op)!=nil { return m }
+		switch op {
+		case CmdAdd,CmdSub:
+			if dec.DecodeMulti(&node,&buck)!=nil { return m }
+		case PutHealth:
+			var w bool
+			if dec.DecodeMulti(&buck,&w)!=nil { return m }
+			m[string(buck)] = w
+		}
+	}
+}
+
+func TestHealthEventCompat(t *testing.T) {
+	h := healthmap.Health{Name:[]byte("b1"),Writable:true,Free:100,Total:200,IOErrors:3}
+	msg := HealthEvent(h).Message()
+	
+	if w,ok := legacyHealth(msg)["b1"]; !ok || !w {
+		t.Errorf("an old node does not see the health of b1")
+	}
+	
+	d := new(Deleg)
+	d.Init()
+	d.NotifyMsg(msg)
+	g,ok := d.HM.Get([]byte("b1"))
+	if !ok || !g.Writable || g.Free!=100 || g.Total!=200 || g.IOErrors!=3 {
+		t.Errorf("got %+v, expected %+v",g,h)
+	}
+}
+
+func TestHealthOverride(t *testing.T) {
+	name := []byte("b2")
+	owner := new(Deleg)
+	owner.Init()
+	owner.BM.Add(name,bucketmap.Bucket{})
+	owner.HM.Set(healthmap.Health{Name:name,Writable:true,Free:100,Total:200})
+	healthmap.AddHealthReceiver(owner)
+	defer healthmap.RemoveHealthReceiver(owner)
+	defer healthmap.ClearOverride(name)
+	
+	other := new(Deleg)
+	other.Init()
+	other.OverrideHealth(&HealthOverride{Bucket:name,Writable:false})
+	
+	msgs := other.TLQ.GetBroadcasts(0,1<<16)
+	if len(msgs)!=1 {
+		t.Fatalf("%d broadcasts, expected 1",len(msgs))
+	}
+	owner.NotifyMsg(msgs[0])
+	
+	if w,ok := healthmap.Override(name); !ok || w {
+		t.Errorf("Override() -> %v %v, expected false true",w,ok)
+	}
+	g,_ := owner.HM.Get(name)
+	if g.Writable || g.Free!=100 {
+		t.Errorf("the owner publishes %+v, expected a read-only bucket",g)
+	}
+	
+	owner.NotifyMsg((&HealthOverride{Bucket:name,Clear:true}).Message())
+	if _,ok := healthmap.Override(name); ok {
+		t.Errorf("the override has not been cleared")
+	}
+}
+
+func init() {
+	netkv.Provider(func(bucket, meta []byte) (*netkv.Session,error) {
+		return new(netkv.Session),nil
+	}).RegisterAs("test")
+}
+
+func newDeleg(name string) *Deleg {
+	d := &Deleg{Self:name}
+	d.Init()
+	return d
+}
+
+func broadcasts(d *Deleg) [][]byte {
+	return d.TLQ.GetBroadcasts(0,1<<16)
+}
+
+func expectRemoved(t *testing.T, d *Deleg, bucket []byte) {
+	if d.NKV.Get(bucket)!=nil {
+		t.Errorf("%s: the removed store is served again",d.Self)
+	}
+	for _,n := range d.NetKvStores() {
+		if string(n.Bucket)==string(bucket) && n.Op!=NetRem {
+			t.Errorf("%s: got op %d, expected NetRem",d.Self,n.Op)
+		}
+	}
+}
+
+/* A store is offered on one node and removed later on another one. */
+func TestNetKvStamps(t *testing.T) {
+	bucket := []byte("nkv")
+	a,b := newDeleg("a"),newDeleg("b")
+	
+	a.OfferNetKvStore(&NetKvStore{Op:NetAdd,Prov:"test",Bucket:bucket})
+	added := broadcasts(a)
+	stateAdd := a.LocalState(false)
+	for _,m := range added { b.NotifyMsg(m) }
+	if b.NKV.Get(bucket)==nil { t.Fatal("the offer did not reach b") }
+	
+	time.Sleep(time.Millisecond)
+	b.OfferNetKvStore(&NetKvStore{Op:NetRem,Bucket:bucket})
+	removed := broadcasts(b)
+	stateRem := b.LocalState(false)
+	
+	/* The receiver keeps the stamp of the origin. */
+	b.NotifyMsg(added[len(added)-1])
+	expectRemoved(t,b,bucket)
+	
+	x,y := newDeleg("x"),newDeleg("y")
+	x.MergeRemoteState(stateAdd,false)
+	x.MergeRemoteState(stateRem,false)
+	expectRemoved(t,x,bucket)
+	y.MergeRemoteState(stateRem,false)
+	y.MergeRemoteState(stateAdd,false)
+	expectRemoved(t,y,bucket)
+	
+	/* A late broadcast of the offer. */
+	z := newDeleg("z")
+	for _,m := range removed { z.NotifyMsg(m) }
+	for _,m := range added { z.NotifyMsg(m) }
+	expectRemoved(t,z,bucket)
+	
+	/* The states of the nodes, that have seen both, agree. */
+	a.MergeRemoteState(y.LocalState(false),false)
+	expectRemoved(t,a,bucket)
+}
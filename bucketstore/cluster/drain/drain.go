@@ -0,0 +1,349 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+Drains a bucket, that is served by this node, into a bucket of another node.
+
+The drain is performed in phases:
+
+	ReadOnly  The bucket is marked as draining in the healthmap, so it is no
+	          longer chosen for writes (see healthmap.SetDraining), and its
+	          writers are replaced, so that writes on this node fail.
+	Copy      All keys (with their expiration) are copied to the target
+	          bucket over kvrpc. The cursor is saved after every page.
+	Flip      The target node is asked to serve the bucket from the target
+	          bucket (cluster.Deleg.AliasBucket), which it announces with CmdAdd.
+	          Once that is visible, this node drops the bucket (CmdSub).
+	Close     The local dkv database is closed. If the bucket is one of many
+	          inside a dkv.Multi, only the bucket is dropped.
+
+The state is saved to a file after every step, so that an interrupted drain can
+be continued with Resume. The state file is JSON, so the progress can be watched
+from outside; within the process, use Drain.Progress.
+
+The draining mark and the replaced writers are kept in memory only. Run sets
+them again, whenever the drain is not Done, so that a resumed drain keeps the
+bucket read-only after a restart.
+
+Puts and deletes, that reach this node after the writers have been replaced,
+fail with EDraining, so that the caller can retry them on another bucket.
+Run waits PinGrace after replacing the writers, so that writes, that are
+still in flight, complete before keys are copied.
+
+The target bucket must exist on the target node. After the drain, remove the
+drained bucket from the configuration of this node, and add the alias to the
+configuration of the target node, or else it is lost on restart.
+*/
+package drain
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/healthmap"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/kvrpc"
+import "encoding/json"
+import "io/ioutil"
+import "errors"
+import "time"
+import "sync"
+import "net"
+import "fmt"
+import "os"
+
+type Phase uint
+const (
+	ReadOnly Phase = iota
+	Copy
+	Flip
+	Close
+	Done
+)
+func (p Phase) String() string {
+	switch p {
+	case ReadOnly: return "ReadOnly"
+	case Copy: return "Copy"
+	case Flip: return "Flip"
+	case Close: return "Close"
+	case Done: return "Done"
+	}
+	return fmt.Sprint("Phase(",uint(p),")")
+}
+
+var ENoHost = errors.New("drain: no such host")
+var ENotLocal = errors.New("drain: bucket is not served by this node")
+var ENoTarget = errors.New("drain: target node does not serve the target bucket")
+var EFlipTimeout = errors.New("drain: target node did not take over the bucket")
+var EExists = errors.New("drain: state file exists, use Resume")
+var EDraining = errors.New("drain: bucket is read-only, as it is being drained")
+
+/* The number of keys copied per page. */
+const PageSize = 256
+
+/* How long to wait for the target node to take over the bucket. */
+var FlipTimeout = time.Minute
+
+/*
+How long to wait after marking the bucket as draining, before keys are
+copied, so that other nodes learn about it and writes in flight complete.
+*/
+var PinGrace = 5*time.Second
+
+type State struct{
+	Bucket string
+	Node   string
+	Target string
+	
+	Phase  Phase
+	Cursor []byte
+	Keys   uint64
+	Bytes  uint64
+	
+	Started time.Time
+	Updated time.Time
+	Error   string
+}
+
+type Drain struct{
+	D    *cluster.Deleg
+	Path string
+	
+	mu sync.Mutex
+	st State
+	
+	cli *kvrpc.Client
+}
+
+/*
+Creates a new drain of bucket into the bucket target of node.
+The state is stored in the file path, which must not exist.
+*/
+func Start(d *cluster.Deleg, path string, bucket []byte, node string, target []byte) (*Drain,error) {
+	if _,err := os.Stat(path); err==nil { return nil,EExists }
+	if !d.BM.Contains(bucket) { return nil,ENotLocal }
+	dr := &Drain{D:d,Path:path}
+	dr.st = State{
+		Bucket: string(bucket),
+		Node:   node,
+		Target: string(target),
+		Phase:  ReadOnly,
+		Started: time.Now(),
+	}
+	return dr,dr.save()
+}
+
+/* Loads an interrupted drain from its state file. */
+func Resume(d *cluster.Deleg, path string) (*Drain,error) {
+	data,err := ioutil.ReadFile(path)
+	if err!=nil { return nil,err }
+	dr := &Drain{D:d,Path:path}
+	err = json.Unmarshal(data,&dr.st)
+	if err!=nil { return nil,err }
+	return dr,nil
+}
+
+/* Returns a snapshot of the state. */
+func (dr *Drain) Progress() State {
+	dr.mu.Lock(); defer dr.mu.Unlock()
+	st := dr.st
+	st.Cursor = append([]byte(nil),st.Cursor...)
+	return st
+}
+
+func (dr *Drain) save() error {
+	dr.mu.Lock()
+	dr.st.Updated = time.Now()
+	data,err := json.MarshalIndent(&dr.st,"","\t")
+	dr.mu.Unlock()
+	if err!=nil { return err }
+	tmp := dr.Path+".tmp"
+	err = ioutil.WriteFile(tmp,data,0644)
+	if err!=nil { return err }
+	return os.Rename(tmp,dr.Path)
+}
+func (dr *Drain) update(f func(st *State)) error {
+	dr.mu.Lock()
+	f(&dr.st)
+	dr.mu.Unlock()
+	return dr.save()
+}
+func (dr *Drain) fail(err error) error {
+	dr.update(func(st *State) { st.Error = err.Error() })
+	return err
+}
+
+/*
+Runs the remaining phases. If an error is returned, the drain can be continued
+later by calling Run again, or by Resume after a restart.
+*/
+func (dr *Drain) Run() error {
+	dr.update(func(st *State) { st.Error = "" })
+	
+	/* The draining mark does not survive a restart, so set it again. */
+	if p := dr.Progress().Phase; p<Done {
+		dr.pin()
+		if p<=Copy { time.Sleep(PinGrace) }
+	}
+	for {
+		var err error
+		switch dr.Progress().Phase {
+		case ReadOnly: err = dr.readOnly()
+		case Copy:     err = dr.copy()
+		case Flip:     err = dr.flip()
+		case Close:    err = dr.close()
+		default: return nil
+		}
+		if err!=nil { return dr.fail(err) }
+	}
+}
+
+func (dr *Drain) bucket() []byte { return []byte(dr.st.Bucket) }
+
+/* Rejects all writes to a bucket, that is being drained. */
+type rejecter struct{}
+func (rejecter) BucketPut(bucket, key, value []byte) error { return EDraining }
+func (rejecter) BucketDelete(bucket, key []byte) error { return EDraining }
+func (rejecter) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) error { return EDraining }
+
+/*
+Marks the bucket as draining and announces it, so that it is not chosen for
+writes, and makes the writes on this node fail.
+*/
+func (dr *Drain) pin() {
+	name := dr.bucket()
+	if bkt,ok := dr.D.BM.Obtain(name); ok {
+		bkt.Writer,bkt.WriterEx = rejecter{},rejecter{}
+		dr.D.BM.Add(name,bkt)
+	}
+	healthmap.SetDraining(name,true)
+	h,_ := dr.D.HM.Get(name)
+	h.Name = name
+	healthmap.IssueHealth(h)
+}
+
+/* The bucket has been pinned by Run. */
+func (dr *Drain) readOnly() error {
+	return dr.update(func(st *State) { st.Phase = Copy })
+}
+
+func (dr *Drain) dial(name string) (net.Conn, error) {
+	m := dr.D.GetOne(name)
+	if m==nil { return nil,ENoHost }
+	return net.DialTCP("tcp",nil,&net.TCPAddr{IP:m.IP,Port:m.Port})
+}
+func (dr *Drain) client() *kvrpc.Client {
+	if dr.cli==nil {
+		dr.cli = new(kvrpc.Client)
+		dr.cli.Init()
+		dr.cli.Cli.Addr = dr.st.Node
+		dr.cli.Cli.Dial = dr.dial
+	}
+	return dr.cli
+}
+/* A local bucket, that can be drained: a dkv.Bucket or a dkv.Named. */
+type source interface{
+	ScanExpire(r *bucketstore.ScanRange, targ func(key, value []byte, expiresAt uint64)) ([]byte, error)
+}
+
+func (dr *Drain) source() (source,error) {
+	bkt,ok := dr.D.BM.Obtain(dr.bucket())
+	if !ok { return nil,ENotLocal }
+	switch db := bkt.Reader.(type) {
+	case dkv.Bucket: return db,nil
+	case *dkv.Bucket: return *db,nil
+	case *dkv.Named: return db,nil
+	}
+	return nil,fmt.Errorf("drain: bucket %q is neither a dkv.Bucket nor a dkv.Named",dr.st.Bucket)
+}
+func (dr *Drain) holds(node, bucket string) bool {
+	for _,n := range dr.D.NM.Nodes(bucket) {
+		if n==node { return true }
+	}
+	return false
+}
+
+func (dr *Drain) copy() error {
+	st := dr.Progress()
+	if !dr.holds(st.Node,st.Target) { return ENoTarget }
+	src,err := dr.source()
+	if err!=nil { return err }
+	cli := dr.client()
+	target := []byte(st.Target)
+	
+	rng := &bucketstore.ScanRange{Start:st.Cursor,Limit:PageSize,Values:true}
+	ops := make([]kvrpc.Op,0,PageSize)
+	for {
+		ops = ops[:0]
+		var size uint64
+		cursor,err := src.ScanExpire(rng,func(key, value []byte, expiresAt uint64) {
+			ops = append(ops,kvrpc.Op{
+				Op:kvrpc.OpPut,
+				Bucket:target,
+				Key:append([]byte(nil),key...),
+				Value:append([]byte(nil),value...),
+				ExpiresAt:expiresAt,
+			})
+			size += uint64(len(value))
+		})
+		if err!=nil { return err }
+		err = cli.BucketBatch(ops)
+		if err!=nil { return err }
+		for i := range ops {
+			if ops[i].Err!=nil { return fmt.Errorf("drain: copying %q: %v",ops[i].Key,ops[i].Err) }
+		}
+		err = dr.update(func(st *State) {
+			st.Cursor = cursor
+			st.Keys += uint64(len(ops))
+			st.Bytes += size
+			if cursor==nil { st.Phase = Flip }
+		})
+		if err!=nil || cursor==nil { return err }
+		rng.Start = cursor
+	}
+}
+
+func (dr *Drain) flip() error {
+	st := dr.Progress()
+	name := dr.bucket()
+	if dr.D.BM.Contains(name) {
+		dr.D.AliasBucket(st.Node,name,[]byte(st.Target))
+		deadline := time.Now().Add(FlipTimeout)
+		for !dr.holds(st.Node,st.Bucket) {
+			if time.Now().After(deadline) { return EFlipTimeout }
+			time.Sleep(time.Second)
+		}
+	}
+	return dr.update(func(st *State) { st.Phase = Close })
+}
+
+func (dr *Drain) close() error {
+	name := dr.bucket()
+	if src,err := dr.source(); err==nil {
+		dr.D.DeleteBucket(name)
+		switch db := src.(type) {
+		case dkv.Bucket: err = db.DB.Close()
+		case *dkv.Named: err = db.Drop()
+		}
+		if err!=nil { return err }
+	}
+	return dr.update(func(st *State) { st.Phase = Done })
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package drain
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/bucketmap"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/healthmap"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/kvrpc"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/memstore"
+import "github.com/byte-mug/golibs/msgpackx"
+import "github.com/hashicorp/memberlist"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+import "net"
+import "fmt"
+import "os"
+
+func TestDrain(t *testing.T) {
+	dir,err := ioutil.TempDir("","drain")
+	if err!=nil { t.Fatal(err) }
+	defer os.RemoveAll(dir)
+	db,err := dkv.OpenQuick(filepath.Join(dir,"db"))
+	if err!=nil { t.Fatal(err) }
+	
+	name,tname := []byte("src"),[]byte("tgt")
+	defer healthmap.SetDraining(name,false)
+	d := &cluster.Deleg{Self:"self"}
+	d.Init()
+	d.AddBucket(name,bucketmap.Bucket{Reader:db,Writer:db,WriterEx:db})
+	
+	/* The target node. */
+	target := new(memstore.Bucket)
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { t.Fatal(err) }
+	defer ln.Close()
+	go kvrpc.NewServer(target).Serve(ln)
+	meta,_ := msgpackx.Marshal(cluster.Magic,&cluster.Metadata{Port:ln.Addr().(*net.TCPAddr).Port})
+	d.NotifyUpdate(&memberlist.Node{Name:"t",Addr:net.IPv4(127,0,0,1),Meta:meta})
+	d.NM.Set("t",string(tname))
+	/* The target node takes over at once. */
+	d.NM.Set("t",string(name))
+	
+	const n = PageSize+10
+	for i := 0; i<n; i++ {
+		err = db.BucketPutExpire(name,[]byte(fmt.Sprintf("k%04d",i)),[]byte(fmt.Sprint("v",i)),1<<40)
+		if err!=nil { t.Fatal(err) }
+	}
+	
+	PinGrace = 0
+	dr,err := Start(d,filepath.Join(dir,"drain.json"),name,"t",tname)
+	if err!=nil { t.Fatal(err) }
+	
+	/* Writes on this node fail, once the bucket is pinned. */
+	dr.pin()
+	bkt,_ := d.BM.Obtain(name)
+	if err = bkt.Writer.BucketPut(name,[]byte("late"),[]byte("v")); err!=EDraining {
+		t.Errorf("BucketPut while draining -> %v, expected EDraining",err)
+	}
+	if err = bkt.Writer.BucketDelete(name,[]byte("k0000")); err!=EDraining {
+		t.Errorf("BucketDelete while draining -> %v, expected EDraining",err)
+	}
+	if err = bkt.WriterEx.BucketPutExpire(name,[]byte("late"),[]byte("v"),1<<40); err!=EDraining {
+		t.Errorf("BucketPutExpire while draining -> %v, expected EDraining",err)
+	}
+	if v,err := bkt.Reader.BucketGet(name,[]byte("k0001")); err!=nil || string(v.Bytes())!="v1" {
+		t.Errorf("BucketGet while draining -> %q %v",v.Bytes(),err)
+	}
+	
+	if err = dr.Run(); err!=nil { t.Fatal(err) }
+	st := dr.Progress()
+	if st.Phase!=Done || st.Keys!=n {
+		t.Errorf("state %+v, expected %d keys and Done",st,n)
+	}
+	if d.BM.Contains(name) { t.Errorf("the drained bucket is still served") }
+	for i := 0; i<n; i++ {
+		v,err := target.BucketGet(tname,[]byte(fmt.Sprintf("k%04d",i)))
+		if err!=nil || string(v.Bytes())!=fmt.Sprint("v",i) {
+			t.Errorf("key %d on the target: %q %v",i,v.Bytes(),err)
+		}
+	}
+	if _,err = target.BucketGet(tname,[]byte("late")); err==nil {
+		t.Errorf("the rejected write reached the target")
+	}
+}
@@ -25,12 +25,18 @@ package runner
 
 import (
 	"github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster"
+	"github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster/admin"
+	"github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv/health"
 	"github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/kvrpc"
 	"github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/selector"
+	"github.com/maxymania/fastnntp-polyglot-labs2/utils/cluster/mlsec"
 	"github.com/hashicorp/memberlist"
 	"github.com/lytics/confl"
+	"net/http"
 	"net"
+	"fmt"
 	"io"
+	"time"
 )
 
 type Bind struct{
@@ -49,16 +55,93 @@ Config data structure. To be parsed with confl.
 		port 7946
 	}
 	name its_node_name
-	loc USA
+	loc eu/fra/rack1
 	rpc {
 		port 63282
 	}
+	# How many nodes must acknowledge a write to a bucket, that is
+	# served from more than one node: all, majority, one or a number.
+	quorum majority
+	# Gossip encryption: base64 encoded AES keys (16, 24 or 32 bytes).
+	# The first key is used for encryption, all are accepted.
+	keys [
+		"cg8StVXbQJ0gPvMd9o7yrg=="
+	]
+	# Nodes, that may join: node names or CIDRs. Empty: all nodes.
+	allow [
+		node1
+		10.1.0.0/16
+	]
+	# How to measure the distance between locations (loc):
+	# "simple" (same loc or not) or "hierarchical" (region/zone/rack).
+	# rttweight adds the estimated round trip time (in ms, times rttweight)
+	# to the distance; 1000 equals one level of the hierarchy.
+	topology {
+		type hierarchical
+		sep /
+		rttweight 10
+	}
+	# Admin service (HTTP), see bucketstore/cluster/admin.
+	# addr defaults to 127.0.0.1. Other addresses require a token.
+	admin {
+		addr 127.0.0.1
+		port 63283
+		token secret
+	}
+	# Health thresholds of the local buckets, including the ones added
+	# by the admin service. A bucket is no longer writable, if less than
+	# minfree bytes or less than minfreeratio of its filesystem are
+	# available. Interval is in seconds. If probe is set, a file is
+	# written on every check, to detect I/O errors.
+	health {
+		minfree 268435456
+		minfreeratio 0.001
+		interval 30
+		probe true
+	}
 
 */
+type Topology struct{
+	Type string
+	Sep string
+	RttWeight int
+}
+
+type Admin struct{
+	Addr string
+	Port int
+	Token string
+}
+
+type Health struct{
+	MinFree uint64
+	MinFreeRatio float64
+	Interval int
+	Probe bool
+}
+
+/* Returns a HealthChecker for the bucket, stored at path. */
+func (h *Health) Checker(path string, bkt []byte) *health.HealthChecker {
+	return &health.HealthChecker{
+		Path:path,
+		Bucket:bkt,
+		MinFree:h.MinFree,
+		MinFreeRatio:h.MinFreeRatio,
+		Interval:time.Duration(h.Interval)*time.Second,
+		Probe:h.Probe,
+	}
+}
+
 type Configuration struct{
 	Bind, Advertise Bind
 	Name,Loc string
 	Rpc Bind
+	Quorum string
+	Keys []string
+	Allow []string
+	Topology Topology
+	Admin Admin
+	Health Health
 }
 func (bcfg *Configuration) LoadBytes(b []byte) error {
 	return confl.Unmarshal(b,bcfg)
@@ -86,19 +169,54 @@ func (bcfg *Configuration) NCluster() (*cluster.Deleg,error) {
 	if bcfg.Rpc.Port!=0  { clst.Meta.Port = bcfg.Rpc.Port }
 	
 	clst.Init()
+	sec,e := mlsec.Configure(cfg,bcfg.Keys,bcfg.Allow)
+	if e!=nil { return nil,e }
+	clst.Sec = sec
 	cfg.Delegate = clst
 	cfg.Events = clst
 	cfg.Merge = clst
 	cfg.Alive = clst
+	switch bcfg.Topology.Type {
+	case "","simple":
+	case "hierarchical":
+		h := &cluster.Hierarchical{Sep:bcfg.Topology.Sep,RTTWeight:bcfg.Topology.RttWeight}
+		if h.RTTWeight>0 {
+			h.RTT = new(cluster.RTTMeter)
+			cfg.Ping = h.RTT
+			clst.AddLeaveListener(h.RTT.Forget)
+		}
+		clst.Dist = h
+	default:
+		return nil,fmt.Errorf("unknown topology type %q",bcfg.Topology.Type)
+	}
+	
+	var al net.Listener
+	if bcfg.Admin.Port!=0 {
+		var e error
+		al,e = admin.Listen(bcfg.Admin.Addr,bcfg.Admin.Port,bcfg.Admin.Token)
+		if e!=nil { return nil,e }
+	}
 	
 	l,e := net.ListenTCP("tcp", &net.TCPAddr{IP:net.ParseIP(addr),Port:clst.Meta.Port})
-	if e!=nil { return nil,e }
+	if e!=nil {
+		if al!=nil { al.Close() }
+		return nil,e
+	}
 	
 	go kvrpc.NewServer(&selector.Selector{&clst.BM,&clst.NKV}).Serve(l)
 	
 	clst.ML,e = memberlist.Create(cfg)
 	
-	if e!=nil { return nil,e }
+	if e!=nil {
+		if al!=nil { al.Close() }
+		return nil,e
+	}
+	
+	if al!=nil {
+		srv := admin.New(clst,bcfg.Admin.Token)
+		srv.Checker = bcfg.Health.Checker
+		go http.Serve(al,srv)
+	}
 	return clst,nil
 }
 
@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package cluster
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/healthmap"
+import "github.com/vmihailenco/msgpack"
+import "time"
+
+/*
+Full state exchange (push/pull) between nodes.
+
+Broadcasts are best-effort, so a lost CmdAdd, CmdSub or PutHealth leaves the
+maps of some nodes diverged. Therefore, memberlist periodically exchanges the
+full state between two nodes, using LocalState and MergeRemoteState.
+
+The state consists of one section per node, holding the buckets served by it
+and their health. Only a node itself changes its section, and it increments
+the version of the section on every change. A section is merged, if its
+version is newer than the one known. The NetKvStore sets are merged by their
+stamps, the newer entry of a bucket wins. The stamps are set by the node, that
+offered or removed the store, so every node compares the same values.
+*/
+const stateFormat uint = 1
+
+type nodeState struct{
+	Node    string             `msgpack:"node"`
+	Version uint64             `msgpack:"version"`
+	Buckets []string           `msgpack:"buckets"`
+	Health  []healthmap.Health `msgpack:"health"`
+}
+
+type clusterState struct{
+	Format uint          `msgpack:"format"`
+	Nodes  []nodeState   `msgpack:"nodes"`
+	NetKv  []*NetKvStore `msgpack:"netkv"`
+}
+
+type versionMap map[string]*nodeState
+
+/* Marks the section of this node as changed. */
+func (d *Deleg) bump() {
+	d.stateL.Lock(); defer d.stateL.Unlock()
+	d.version++
+}
+
+func (d *Deleg) alive() map[string]bool {
+	ml := d.ML
+	if ml==nil { return nil }
+	m := make(map[string]bool)
+	for _,n := range ml.Members() { m[n.Name] = true }
+	return m
+}
+
+func (d *Deleg) selfState() nodeState {
+	d.stateL.Lock()
+	ns := nodeState{Node:d.Self,Version:d.version}
+	d.stateL.Unlock()
+	ns.Buckets = append(d.BM.ListupRaw(),d.NKV.GetLocalBuckets()...)
+	for _,b := range ns.Buckets {
+		if h,ok := d.HM.Get([]byte(b)); ok { ns.Health = append(ns.Health,h) }
+	}
+	return ns
+}
+
+func (d *Deleg) LocalState(join bool) []byte {
+	st := clusterState{Format:stateFormat}
+	st.Nodes = append(st.Nodes,d.selfState())
+	alive := d.alive()
+	d.stateL.Lock()
+	for node,ns := range d.known {
+		if alive!=nil && !alive[node] { continue }
+		st.Nodes = append(st.Nodes,*ns)
+	}
+	d.stateL.Unlock()
+	
+	d.nkvL.RLock()
+	for _,v := range d.nkvAdd { st.NetKv = append(st.NetKv,v) }
+	for _,v := range d.nkvRem { st.NetKv = append(st.NetKv,v) }
+	data,_ := msgpack.Marshal(&st)
+	d.nkvL.RUnlock()
+	return data
+}
+
+func (d *Deleg) MergeRemoteState(buf []byte, join bool) {
+	var st clusterState
+	if msgpack.Unmarshal(buf,&st)!=nil { return }
+	if st.Format!=stateFormat { return }
+	alive := d.alive()
+	for i := range st.Nodes {
+		ns := &st.Nodes[i]
+		if ns.Node==d.Self { continue }
+		if alive!=nil && !alive[ns.Node] { continue }
+		d.mergeNode(ns)
+	}
+	for _,n := range st.NetKv {
+		if n==nil || !d.newerNetKv(n) { continue }
+		d.handleNetKvStore(n)
+	}
+}
+
+func (d *Deleg) mergeNode(ns *nodeState) {
+	d.stateL.Lock()
+	if o := d.known[ns.Node]; o!=nil && o.Version>=ns.Version {
+		d.stateL.Unlock()
+		return
+	}
+	d.known[ns.Node] = ns
+	d.stateL.Unlock()
+	
+	d.NM.Replace(ns.Node,ns.Buckets)
+	for _,h := range ns.Health { d.HM.Set(h) }
+}
+
+func (d *Deleg) newerNetKv(n *NetKvStore) bool {
+	d.nkvL.RLock(); defer d.nkvL.RUnlock()
+	o := d.nkvAdd[string(n.Bucket)]
+	if o==nil { o = d.nkvRem[string(n.Bucket)] }
+	return o==nil || o.Stamp<n.Stamp
+}
+
+func stamp() int64 { return time.Now().UnixNano() }
@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package cluster
+
+import "github.com/hashicorp/memberlist"
+import "github.com/hashicorp/serf/coordinate"
+import "github.com/vmihailenco/msgpack"
+import "strings"
+import "sync"
+import "time"
+
+/*
+A DistanceMeter, that also takes the node itself into account, not only its
+location. SortNodesDistance prefers this over Distance, if implemented.
+*/
+type NodeDistanceMeter interface{
+	DistanceMeter
+	NodeDistance(self *Metadata, n *NodeMetadata) int
+}
+
+/*
+Estimates the round trip times to the other nodes, using network coordinates
+(Vivaldi, as implemented by serf/coordinate). The coordinates are exchanged
+within the probes of memberlist. Install it as memberlist.Config.Ping.
+
+Unlike the raw RTT of the last probes, the distance of the coordinates is
+stable and also works for nodes, that have been probed rarely.
+*/
+type RTTMeter struct{
+	mu     sync.RWMutex
+	cli    *coordinate.Client
+	coords map[string]*coordinate.Coordinate
+}
+
+/* The version byte, that prefixes the coordinate in the ack payload. */
+const pingVersion = 1
+
+func (r *RTTMeter) client() *coordinate.Client {
+	r.mu.Lock(); defer r.mu.Unlock()
+	if r.cli==nil {
+		r.cli,_ = coordinate.NewClient(coordinate.DefaultConfig())
+		r.coords = make(map[string]*coordinate.Coordinate)
+	}
+	return r.cli
+}
+func (r *RTTMeter) AckPayload() []byte {
+	data,err := msgpack.Marshal(r.client().GetCoordinate())
+	if err!=nil { return nil }
+	return append([]byte{pingVersion},data...)
+}
+func (r *RTTMeter) NotifyPingComplete(other *memberlist.Node, rtt time.Duration, payload []byte) {
+	/* Nodes, that do not send coordinates, are not measured. */
+	if len(payload)<2 || payload[0]!=pingVersion { return }
+	coord := new(coordinate.Coordinate)
+	if msgpack.Unmarshal(payload[1:],coord)!=nil { return }
+	cli := r.client()
+	if _,err := cli.Update(other.Name,coord,rtt); err!=nil { return }
+	r.mu.Lock(); defer r.mu.Unlock()
+	r.coords[other.Name] = coord
+}
+
+/* Returns the estimated round trip time to node. */
+func (r *RTTMeter) RTT(node string) (time.Duration,bool) {
+	r.mu.RLock()
+	coord,ok := r.coords[node]
+	cli := r.cli
+	r.mu.RUnlock()
+	if !ok { return 0,false }
+	return cli.DistanceTo(coord),true
+}
+func (r *RTTMeter) Forget(node string) {
+	cli := r.client()
+	cli.ForgetNode(node)
+	r.mu.Lock(); defer r.mu.Unlock()
+	delete(r.coords,node)
+}
+
+var _ memberlist.PingDelegate = (*RTTMeter)(nil)
+
+const levelDistance = 1000
+
+/*
+A topology-aware DistanceMeter. Locations are hierarchical paths, like
+"region/zone/rack", so that
+
+	eu/fra/r1 -> eu/fra/r1   0
+	eu/fra/r1 -> eu/fra/r2   1000
+	eu/fra/r1 -> eu/ams/r1   2000
+	eu/fra/r1 -> us/nyc/r1   3000
+
+If RTT is set, the estimated round trip time (in milliseconds, multiplied by
+RTTWeight) is added to the distance of the node. With a RTTWeight below 1000,
+the RTT only orders nodes within the same level; higher weights allow the RTT
+to override the topology.
+*/
+type Hierarchical struct{
+	// The separator of the levels. Defaults to "/".
+	Sep string
+	
+	RTT *RTTMeter
+	RTTWeight int
+}
+func (h *Hierarchical) sep() string {
+	if h.Sep=="" { return "/" }
+	return h.Sep
+}
+func (h *Hierarchical) levels(loc string) []string {
+	if loc=="" { return nil }
+	return strings.Split(loc,h.sep())
+}
+func (h *Hierarchical) Distance(l,r string) int {
+	if l==r { return 0 }
+	ll,rl := h.levels(l),h.levels(r)
+	m := len(ll)
+	if len(rl)>m { m = len(rl) }
+	c := 0
+	for c<len(ll) && c<len(rl) && ll[c]==rl[c] { c++ }
+	return (m-c)*levelDistance
+}
+func (h *Hierarchical) NodeDistance(self *Metadata, n *NodeMetadata) int {
+	loc := ""
+	if self!=nil { loc = self.Loc }
+	d := h.Distance(n.Loc,loc)
+	if h.RTT!=nil && h.RTTWeight>0 {
+		if rtt,ok := h.RTT.RTT(n.Name); ok {
+			d += int(rtt/time.Millisecond)*h.RTTWeight
+		}
+	}
+	return d
+}
+
+var _ NodeDistanceMeter = (*Hierarchical)(nil)
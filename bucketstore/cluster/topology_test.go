@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package cluster
+
+import "github.com/hashicorp/memberlist"
+import "testing"
+import "time"
+
+func TestRTTMeter(t *testing.T) {
+	names := []string{"a","b","c"}
+	rtt := map[[2]int]time.Duration{
+		{0,1}: 10*time.Millisecond,
+		{0,2}: 50*time.Millisecond,
+		{1,2}: 45*time.Millisecond,
+	}
+	meters := make([]*RTTMeter,len(names))
+	for i := range meters { meters[i] = new(RTTMeter) }
+	
+	/* i pings j, j acks with its coordinate. */
+	ping := func(i, j int) {
+		d,ok := rtt[[2]int{i,j}]
+		if !ok { d = rtt[[2]int{j,i}] }
+		meters[i].NotifyPingComplete(&memberlist.Node{Name:names[j]},d,meters[j].AckPayload())
+	}
+	for round := 0; round<200; round++ {
+		for i := range names {
+			for j := range names {
+				if i!=j { ping(i,j) }
+			}
+		}
+	}
+	
+	ab,ok1 := meters[0].RTT("b")
+	ac,ok2 := meters[0].RTT("c")
+	if !ok1 || !ok2 { t.Fatalf("no estimate: %v %v",ok1,ok2) }
+	if ab>=ac { t.Errorf("RTT(a,b)=%v >= RTT(a,c)=%v",ab,ac) }
+	if ab<5*time.Millisecond || ab>20*time.Millisecond { t.Errorf("RTT(a,b)=%v, expected about 10ms",ab) }
+	
+	meters[0].Forget("b")
+	if _,ok := meters[0].RTT("b"); ok { t.Errorf("RTT(b) is known after Forget") }
+	
+	/* Nodes without coordinates are ignored. */
+	meters[0].NotifyPingComplete(&memberlist.Node{Name:"old"},time.Millisecond,nil)
+	if _,ok := meters[0].RTT("old"); ok { t.Errorf("RTT(old) is known") }
+}
+
+func TestHierarchical(t *testing.T) {
+	h := new(Hierarchical)
+	for _,c := range []struct{ l,r string; d int }{
+		{"eu/fra/r1","eu/fra/r1",0},
+		{"eu/fra/r1","eu/fra/r2",1000},
+		{"eu/fra/r1","eu/ams/r1",2000},
+		{"eu/fra/r1","us/nyc/r1",3000},
+		{"eu","eu/fra",1000},
+	} {
+		if d := h.Distance(c.l,c.r); d!=c.d { t.Errorf("Distance(%q,%q) = %d, expected %d",c.l,c.r,d,c.d) }
+	}
+}
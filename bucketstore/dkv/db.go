@@ -28,6 +28,7 @@ package dkv
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
 import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
 import "github.com/dgraph-io/badger"
+import "bytes"
 
 func bptr(bs []byte) (bp *byte) {
 	if cap(bs)==0 { return nil }
@@ -56,8 +57,8 @@ type Bucket struct{
 	DB *badger.DB
 }
 
-func (b Bucket) BucketGet(bucket,key []byte) (buf bufferex.Binary,err error) {
-	tx := b.DB.NewTransaction(false)
+func get(db *badger.DB, key []byte) (buf bufferex.Binary,err error) {
+	tx := db.NewTransaction(false)
 	defer tx.Discard()
 	i,e := tx.Get(key)
 	if e!=nil { err = converte(e); return }
@@ -73,28 +74,77 @@ func (b Bucket) BucketGet(bucket,key []byte) (buf bufferex.Binary,err error) {
 	}
 	return bc,nil
 }
-func (b Bucket) BucketPut(bucket,key,value []byte) error {
-	tx := b.DB.NewTransaction(true)
+func put(db *badger.DB, key,value []byte,expiresAt uint64) error {
+	tx := db.NewTransaction(true)
 	defer tx.Discard()
-	err := tx.Set(key,value)
+	err := tx.SetEntry(&badger.Entry{Key:key,Value:value,ExpiresAt:expiresAt})
 	if err!=nil { return err }
 	return tx.Commit()
 }
-func (b Bucket) BucketPutExpire(bucket,key,value []byte,expiresAt uint64) error {
-	tx := b.DB.NewTransaction(true)
+func del(db *badger.DB, key []byte) error {
+	tx := db.NewTransaction(true)
 	defer tx.Discard()
-	err := tx.SetEntry(&badger.Entry{Key:key,Value:value,ExpiresAt:expiresAt})
+	err := tx.Delete(key)
 	if err!=nil { return err }
 	return tx.Commit()
 }
+
+func (b Bucket) BucketGet(bucket,key []byte) (buf bufferex.Binary,err error) {
+	return get(b.DB,key)
+}
+func (b Bucket) BucketPut(bucket,key,value []byte) error {
+	return put(b.DB,key,value,0)
+}
+func (b Bucket) BucketPutExpire(bucket,key,value []byte,expiresAt uint64) error {
+	return put(b.DB,key,value,expiresAt)
+}
 func (b Bucket) BucketDelete(bucket,key []byte) error {
-	tx := b.DB.NewTransaction(true)
+	return del(b.DB,key)
+}
+func (b Bucket) BucketScan(bucket []byte, r *bucketstore.ScanRange, targ func(key, value []byte)) (cursor []byte, err error) {
+	return b.ScanExpire(r,func(key, value []byte, expiresAt uint64) { targ(key,value) })
+}
+
+/*
+Like BucketScan, but also reports the expiration time of every key (0 means never).
+*/
+func (b Bucket) ScanExpire(r *bucketstore.ScanRange, targ func(key, value []byte, expiresAt uint64)) (cursor []byte, err error) {
+	return scanDB(b.DB,nil,r,targ)
+}
+
+/* Scans the keys within the namespace ns, which is stripped from the keys. */
+func scanDB(db *badger.DB, ns []byte, r *bucketstore.ScanRange, targ func(key, value []byte, expiresAt uint64)) (cursor []byte, err error) {
+	tx := db.NewTransaction(false)
 	defer tx.Discard()
-	err := tx.Delete(key)
-	if err!=nil { return err }
-	return tx.Commit()
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = r.Values
+	iter := tx.NewIterator(opts)
+	defer iter.Close()
+	
+	prefix := join(ns,r.Prefix)
+	start := join(ns,r.Start)
+	if bytes.Compare(start,prefix)<0 { start = prefix }
+	var end []byte
+	if len(r.End)>0 { end = join(ns,r.End) }
+	
+	var buf []byte
+	n := 0
+	for iter.Seek(start); iter.ValidForPrefix(prefix); iter.Next() {
+		i := iter.Item()
+		key := i.Key()
+		if end!=nil && bytes.Compare(key,end)>=0 { break }
+		if r.Limit>0 && n>=r.Limit { return i.KeyCopy(nil)[len(ns):],nil }
+		if r.Values {
+			buf,err = i.ValueCopy(buf[:0])
+			if err!=nil { return }
+		}
+		targ(key[len(ns):],buf,i.ExpiresAt())
+		n++
+	}
+	return
 }
 
 var _ bucketstore.Bucket = (*Bucket)(nil)
 var _ bucketstore.BucketWEx = (*Bucket)(nil)
+var _ bucketstore.BucketScanner = (*Bucket)(nil)
 
@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package dkv
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/storetest"
+import "testing"
+import "io/ioutil"
+import "os"
+import "sync"
+import "sync/atomic"
+import "runtime"
+
+func tempDir(t *testing.T) string {
+	dir,err := ioutil.TempDir("","dkv")
+	if err!=nil { t.Fatal(err) }
+	return dir
+}
+
+func TestBucket(t *testing.T) {
+	s := &storetest.BucketSuite{New:func(t *testing.T) (bucketstore.Bucket,func()) {
+		dir := tempDir(t)
+		b,err := OpenQuick(dir)
+		if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+		return b,func() {
+			b.DB.Close()
+			os.RemoveAll(dir)
+		}
+	}}
+	s.Run(t)
+	s.OverKvrpc().Run(t)
+}
+
+func openMulti(t *testing.T, name []byte) (*Multi,func()) {
+	dir := tempDir(t)
+	m,err := OpenMulti(dir)
+	if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+	done := func() {
+		m.DB.Close()
+		os.RemoveAll(dir)
+	}
+	err = m.Create(name)
+	if err!=nil { done(); t.Fatal(err) }
+	return m,done
+}
+
+func TestMulti(t *testing.T) {
+	s := &storetest.BucketSuite{
+		New:func(t *testing.T) (bucketstore.Bucket,func()) {
+			return openMulti(t,[]byte("multi"))
+		},
+		Name:[]byte("multi"),
+	}
+	s.Run(t)
+	s.OverKvrpc().Run(t)
+}
+
+func TestNamed(t *testing.T) {
+	s := &storetest.BucketSuite{New:func(t *testing.T) (bucketstore.Bucket,func()) {
+		m,done := openMulti(t,[]byte("named"))
+		return m.Bucket([]byte("named")),done
+	}}
+	s.Run(t)
+	s.OverKvrpc().Run(t)
+}
+
+func TestRecreate(t *testing.T) {
+	name := []byte("drop")
+	m,done := openMulti(t,name)
+	defer done()
+	for i := 0; i<10; i++ {
+		if err := m.BucketPut(name,[]byte{byte(i)},[]byte("value")); err!=nil { t.Fatal(err) }
+	}
+	if err := m.Drop(name); err!=nil { t.Fatal(err) }
+	if err := m.Drop(name); err!=ENoSuchBucket { t.Fatalf("second Drop: got %v, want %v",err,ENoSuchBucket) }
+	if err := m.BucketPut(name,[]byte("k"),nil); err!=ENoSuchBucket { t.Fatalf("Put after Drop: got %v, want %v",err,ENoSuchBucket) }
+	if err := m.Create(name); err!=nil { t.Fatal(err) }
+	u,err := m.Usage(name)
+	if err!=nil { t.Fatal(err) }
+	if u.Keys!=0 { t.Fatalf("re-created bucket has %d keys",u.Keys) }
+}
+
+/* Writes, that race with Drop, must either fail or be dropped. */
+func TestDropRace(t *testing.T) {
+	name := []byte("race")
+	m,done := openMulti(t,name)
+	defer done()
+	for round := 0; round<20; round++ {
+		var wg sync.WaitGroup
+		var written int32
+		for w := 0; w<8; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					err := m.BucketPut(name,[]byte{byte(w),byte(i>>8),byte(i)},[]byte("value"))
+					if err==ENoSuchBucket { return }
+					if err!=nil { t.Error(err); return }
+					atomic.AddInt32(&written,1)
+				}
+			}(w)
+		}
+		for atomic.LoadInt32(&written)<100 { runtime.Gosched() }
+		if err := m.Drop(name); err!=nil { t.Fatal(err) }
+		wg.Wait()
+		
+		/* Create removes leftovers, so look at the keys before. */
+		n := 0
+		_,err := scanDB(m.DB,namespace(name),new(bucketstore.ScanRange),func(key, value []byte, expiresAt uint64) { n++ })
+		if err!=nil { t.Fatal(err) }
+		if n!=0 { t.Fatalf("round %d: %d keys survived the Drop",round,n) }
+		if err := m.Create(name); err!=nil { t.Fatal(err) }
+	}
+}
@@ -25,28 +25,80 @@ package health
 
 import "github.com/ricochet2200/go-disk-usage/du"
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/healthmap"
+import "io/ioutil"
+import "path/filepath"
+import "sync"
 import "time"
 
-// Minimum is 256 MB
-const minimumAvailable = 1<<28
+const (
+	DefaultMinFree = 1<<28 // 256 MB
+	DefaultMinFreeRatio = 1.0/1024
+	DefaultInterval = time.Second*30
+)
 
+/*
+Periodically issues the health of a bucket, stored on the filesystem at Path.
+
+The bucket is reported as not writable, if less than MinFree bytes or less
+than MinFreeRatio of the filesystem are available, or if the probe file
+could not be written since the last check. Zero values select the defaults.
+*/
 type HealthChecker struct {
 	Path string
 	Bucket []byte
-	Stop bool
+	
+	MinFree      uint64
+	MinFreeRatio float64
+	Interval     time.Duration
+	
+	// If set, a small file is written to Path on every check, to detect I/O errors.
+	Probe bool
+	
+	stop  chan struct{}
+	sonce sync.Once
+}
+func (h *HealthChecker) probe() bool {
+	if !h.Probe { return true }
+	name := filepath.Join(h.Path,".health-probe")
+	err := ioutil.WriteFile(name,[]byte(time.Now().String()),0600)
+	if err==nil { _,err = ioutil.ReadFile(name) }
+	if err!=nil {
+		healthmap.AddIOErrors(h.Bucket,1)
+		return false
+	}
+	return true
+}
+func (h *HealthChecker) check() {
+	minFree := h.MinFree
+	if minFree==0 { minFree = DefaultMinFree }
+	ratio := h.MinFreeRatio
+	if ratio==0 { ratio = DefaultMinFreeRatio }
+	
+	usage := du.NewDiskUsage(h.Path)
+	free,total := usage.Available(),usage.Size()
+	bad := free<minFree || float64(free)<float64(total)*ratio
+	if !h.probe() { bad = true }
+	
+	healthmap.IssueHealth(healthmap.Health{Name:h.Bucket,Writable:!bad,Free:free,Total:total})
 }
 func (h *HealthChecker) perform() {
-	t := time.Tick(time.Second*30)
+	iv := h.Interval
+	if iv<=0 { iv = DefaultInterval }
+	t := time.NewTicker(iv)
+	defer t.Stop()
+	h.check()
 	for {
-		<-t
-		usage := du.NewDiskUsage(h.Path)
-		minsiz := usage.Size()>>10
-		bad := usage.Available()<minimumAvailable || usage.Available()<minsiz
-		
-		healthmap.IssueHealth(healthmap.Health{h.Bucket,!bad})
+		select {
+		case <-t.C: h.check()
+		case <-h.stop: return
+		}
 	}
 }
 func (h *HealthChecker) Start() {
+	h.stop = make(chan struct{})
 	go h.perform()
 }
-
+/* Stops a started HealthChecker. It may be called more than once. */
+func (h *HealthChecker) Stop() {
+	h.sonce.Do(func() { close(h.stop) })
+}
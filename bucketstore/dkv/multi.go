@@ -0,0 +1,312 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package dkv
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "github.com/dgraph-io/badger"
+import "errors"
+import "sync"
+
+var ENoSuchBucket = errors.New("dkv: no such bucket")
+var EBadName = errors.New("dkv: bucket name must be 1 to 255 bytes long")
+
+/*
+Key layout of a Multi database:
+
+	0x00 name             Catalog entry of the bucket name.
+	0x01 len(name) name   Prefix of the keys in the bucket name.
+*/
+const (
+	pCatalog = 0x00
+	pData = 0x01
+)
+
+func join(a, b []byte) []byte {
+	if len(a)==0 { return b }
+	return append(append(make([]byte,0,len(a)+len(b)),a...),b...)
+}
+func namespace(name []byte) []byte {
+	return append([]byte{pData,byte(len(name))},name...)
+}
+
+/* The space used by a bucket. */
+type Usage struct{
+	Keys  uint64
+	Bytes uint64
+}
+
+/*
+Hosts many named buckets in one badger database, using key prefixes.
+
+A Multi implements the bucketstore interfaces itself, using the bucket argument
+to select the bucket. Alternatively, Bucket returns a handle of a single bucket,
+that ignores the bucket argument, just like dkv.Bucket does.
+*/
+type Multi struct{
+	DB *badger.DB
+	
+	mu sync.RWMutex
+	names map[string]*bucketLock
+}
+
+/*
+Every access to a bucket holds its read lock, Drop holds the write lock, so that
+no write can land in a bucket, after Drop has deleted its keys.
+*/
+type bucketLock struct{
+	sync.RWMutex
+	dropped bool
+}
+
+func OpenMulti(path string) (*Multi,error) {
+	b,err := OpenQuick(path)
+	if err!=nil { return nil,err }
+	m,err := NewMulti(b.DB)
+	if err!=nil { b.DB.Close(); return nil,err }
+	return m,nil
+}
+func NewMulti(db *badger.DB) (*Multi,error) {
+	m := &Multi{DB:db,names:make(map[string]*bucketLock)}
+	err := db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := tx.NewIterator(opts)
+		defer iter.Close()
+		cat := []byte{pCatalog}
+		for iter.Seek(cat); iter.ValidForPrefix(cat); iter.Next() {
+			m.names[string(iter.Item().Key()[1:])] = new(bucketLock)
+		}
+		return nil
+	})
+	if err!=nil { return nil,err }
+	return m,nil
+}
+
+func (m *Multi) lookup(name []byte) *bucketLock {
+	m.mu.RLock(); defer m.mu.RUnlock()
+	return m.names[string(name)]
+}
+
+/* Read-locks the bucket name. Returns nil, if it does not exist. */
+func (m *Multi) acquire(name []byte) *bucketLock {
+	l := m.lookup(name)
+	if l==nil { return nil }
+	l.RLock()
+	if l.dropped { l.RUnlock(); return nil }
+	return l
+}
+func (m *Multi) has(name []byte) bool {
+	l := m.acquire(name)
+	if l==nil { return false }
+	l.RUnlock()
+	return true
+}
+
+/* Lists the buckets. */
+func (m *Multi) Buckets() (names [][]byte) {
+	m.mu.RLock(); defer m.mu.RUnlock()
+	for n := range m.names { names = append(names,[]byte(n)) }
+	return
+}
+
+/*
+Creates a bucket. Creating an existing bucket is not an error.
+Leftovers of an interrupted Drop are removed first.
+*/
+func (m *Multi) Create(name []byte) error {
+	if len(name)==0 || len(name)>255 { return EBadName }
+	if m.has(name) { return nil }
+	err := m.deleteRange(namespace(name))
+	if err!=nil { return err }
+	err = put(m.DB,join([]byte{pCatalog},name),nil,0)
+	if err!=nil { return err }
+	m.mu.Lock(); defer m.mu.Unlock()
+	if m.names[string(name)]==nil { m.names[string(name)] = new(bucketLock) }
+	return nil
+}
+
+/*
+Drops a bucket and all its keys. If the Drop is interrupted, the bucket still
+exists, and the Drop must be repeated.
+
+Drop waits for the accesses to the bucket in progress, and blocks new ones, until
+it is done.
+*/
+func (m *Multi) Drop(name []byte) error {
+	l := m.lookup(name)
+	if l==nil { return ENoSuchBucket }
+	l.Lock(); defer l.Unlock()
+	if l.dropped { return ENoSuchBucket }
+	err := m.deleteRange(namespace(name))
+	if err!=nil { return err }
+	err = del(m.DB,join([]byte{pCatalog},name))
+	if err!=nil { return err }
+	l.dropped = true
+	m.mu.Lock(); defer m.mu.Unlock()
+	if m.names[string(name)]==l { delete(m.names,string(name)) }
+	return nil
+}
+
+/* Deletes all keys with the given prefix, using as many transactions as needed. */
+func (m *Multi) deleteRange(prefix []byte) error {
+	const batch = 1024
+	for {
+		var keys [][]byte
+		err := m.DB.View(func(tx *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			iter := tx.NewIterator(opts)
+			defer iter.Close()
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix) && len(keys)<batch; iter.Next() {
+				keys = append(keys,iter.Item().KeyCopy(nil))
+			}
+			return nil
+		})
+		if err!=nil { return err }
+		if len(keys)==0 { return nil }
+		
+		tx := m.DB.NewTransaction(true)
+		for _,k := range keys {
+			err = tx.Delete(k)
+			if err==badger.ErrTxnTooBig {
+				err = tx.Commit()
+				if err!=nil { return err }
+				tx = m.DB.NewTransaction(true)
+				err = tx.Delete(k)
+			}
+			if err!=nil { tx.Discard(); return err }
+		}
+		err = tx.Commit()
+		if err!=nil { return err }
+	}
+}
+
+/* Reports the number of keys and the (estimated) size of a bucket. */
+func (m *Multi) Usage(name []byte) (u Usage, err error) {
+	l := m.acquire(name)
+	if l==nil { err = ENoSuchBucket; return }
+	defer l.RUnlock()
+	prefix := namespace(name)
+	err = m.DB.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := tx.NewIterator(opts)
+		defer iter.Close()
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			u.Keys++
+			u.Bytes += uint64(iter.Item().EstimatedSize())
+		}
+		return nil
+	})
+	return
+}
+
+/* Reports the usage of all buckets. */
+func (m *Multi) Sizes() (map[string]Usage,error) {
+	sizes := make(map[string]Usage)
+	for _,name := range m.Buckets() {
+		u,err := m.Usage(name)
+		if err==ENoSuchBucket { continue }
+		if err!=nil { return nil,err }
+		sizes[string(name)] = u
+	}
+	return sizes,nil
+}
+
+func (m *Multi) BucketGet(bucket,key []byte) (bufferex.Binary,error) {
+	l := m.acquire(bucket)
+	if l==nil { return bufferex.Binary{},bucketstore.ENotFound }
+	defer l.RUnlock()
+	return get(m.DB,join(namespace(bucket),key))
+}
+func (m *Multi) BucketPut(bucket,key,value []byte) error {
+	l := m.acquire(bucket)
+	if l==nil { return ENoSuchBucket }
+	defer l.RUnlock()
+	return put(m.DB,join(namespace(bucket),key),value,0)
+}
+func (m *Multi) BucketPutExpire(bucket,key,value []byte,expiresAt uint64) error {
+	l := m.acquire(bucket)
+	if l==nil { return ENoSuchBucket }
+	defer l.RUnlock()
+	return put(m.DB,join(namespace(bucket),key),value,expiresAt)
+}
+func (m *Multi) BucketDelete(bucket,key []byte) error {
+	l := m.acquire(bucket)
+	if l==nil { return ENoSuchBucket }
+	defer l.RUnlock()
+	return del(m.DB,join(namespace(bucket),key))
+}
+func (m *Multi) BucketScan(bucket []byte, r *bucketstore.ScanRange, targ func(key, value []byte)) ([]byte, error) {
+	l := m.acquire(bucket)
+	if l==nil { return nil,ENoSuchBucket }
+	defer l.RUnlock()
+	return scanDB(m.DB,namespace(bucket),r,func(key, value []byte, expiresAt uint64) { targ(key,value) })
+}
+
+var _ bucketstore.Bucket = (*Multi)(nil)
+var _ bucketstore.BucketWEx = (*Multi)(nil)
+var _ bucketstore.BucketScanner = (*Multi)(nil)
+
+/* Returns a handle of the bucket name. The bucket must have been created. */
+func (m *Multi) Bucket(name []byte) *Named {
+	return &Named{m,append([]byte(nil),name...)}
+}
+
+/* A single bucket of a Multi. The bucket argument is ignored. */
+type Named struct{
+	M    *Multi
+	Name []byte
+}
+
+func (n *Named) BucketGet(bucket,key []byte) (bufferex.Binary,error) {
+	return n.M.BucketGet(n.Name,key)
+}
+func (n *Named) BucketPut(bucket,key,value []byte) error {
+	return n.M.BucketPut(n.Name,key,value)
+}
+func (n *Named) BucketPutExpire(bucket,key,value []byte,expiresAt uint64) error {
+	return n.M.BucketPutExpire(n.Name,key,value,expiresAt)
+}
+func (n *Named) BucketDelete(bucket,key []byte) error {
+	return n.M.BucketDelete(n.Name,key)
+}
+func (n *Named) BucketScan(bucket []byte, r *bucketstore.ScanRange, targ func(key, value []byte)) ([]byte, error) {
+	return n.M.BucketScan(n.Name,r,targ)
+}
+
+/* Like Bucket.ScanExpire. */
+func (n *Named) ScanExpire(r *bucketstore.ScanRange, targ func(key, value []byte, expiresAt uint64)) ([]byte, error) {
+	l := n.M.acquire(n.Name)
+	if l==nil { return nil,ENoSuchBucket }
+	defer l.RUnlock()
+	return scanDB(n.M.DB,namespace(n.Name),r,targ)
+}
+func (n *Named) Usage() (Usage,error) { return n.M.Usage(n.Name) }
+func (n *Named) Drop() error { return n.M.Drop(n.Name) }
+
+var _ bucketstore.Bucket = (*Named)(nil)
+var _ bucketstore.BucketWEx = (*Named)(nil)
+var _ bucketstore.BucketScanner = (*Named)(nil)
@@ -0,0 +1,312 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+Expiry emulation for buckets, that lack BucketPutExpire.
+
+The expiration times are recorded in a side index (a local badger database),
+kept in time order. A background sweeper deletes the expired keys from the
+buckets, and BucketGet hides keys, that are already expired.
+
+Writes through a Bucket and the sweeper are serialized per key, so that the
+sweeper never deletes a value, that has been written after the key was found
+to be expired.
+
+The index only knows about the writes, that went through it. If several nodes
+write to the same bucket, each of them sweeps its own writes.
+
+Index layout:
+
+	't' expiresAt len(name) name key -> (empty)
+	'k' len(name) name key           -> expiresAt
+*/
+package expiry
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv"
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "github.com/dgraph-io/badger"
+import "encoding/binary"
+import "hash/fnv"
+import "errors"
+import "bytes"
+import "time"
+import "sync"
+
+var EBadName = errors.New("expiry: bucket name must be at most 255 bytes long")
+
+const (
+	pTime = 't'
+	pKey = 'k'
+)
+
+/* The number of keys, the sweeper deletes per transaction. */
+const sweepBatch = 256
+
+/* The number of locks, that serialize the writes to a key with the sweeper. */
+const lockStripes = 64
+
+type Index struct{
+	DB *badger.DB
+	
+	// The interval of the sweeper. Defaults to one minute.
+	Interval time.Duration
+	
+	mu      sync.RWMutex
+	buckets map[string]bucketstore.BucketW
+	
+	locks [lockStripes]sync.Mutex
+	
+	stop chan struct{}
+	once sync.Once
+}
+
+/* Opens (or creates) the side index at path and starts the sweeper. */
+func Open(path string) (*Index,error) {
+	b,err := dkv.OpenQuick(path)
+	if err!=nil { return nil,err }
+	idx := New(b.DB)
+	idx.Start()
+	return idx,nil
+}
+func New(db *badger.DB) *Index {
+	return &Index{DB:db,buckets:make(map[string]bucketstore.BucketW),stop:make(chan struct{})}
+}
+
+func ns(name []byte) []byte {
+	return append([]byte{byte(len(name))},name...)
+}
+func kKey(ns, key []byte) []byte {
+	return append(append(append(make([]byte,0,1+len(ns)+len(key)),pKey),ns...),key...)
+}
+func tKey(exp uint64, ns, key []byte) []byte {
+	k := make([]byte,9,9+len(ns)+len(key))
+	k[0] = pTime
+	binary.BigEndian.PutUint64(k[1:],exp)
+	return append(append(k,ns...),key...)
+}
+
+/*
+Wraps the bucket b, registering it under name. Expired keys of the bucket are
+deleted by the sweeper, as long as it is registered. The sweeper passes name
+as the bucket argument to BucketDelete.
+*/
+func (idx *Index) Wrap(name []byte, b bucketstore.Bucket) (*Bucket,error) {
+	if len(name)>255 { return nil,EBadName }
+	idx.mu.Lock(); defer idx.mu.Unlock()
+	idx.buckets[string(name)] = b
+	return &Bucket{B:b,idx:idx,ns:ns(name)},nil
+}
+
+/* Locks the key. */
+func (idx *Index) lock(ns, key []byte) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write(ns)
+	h.Write(key)
+	m := &idx.locks[h.Sum32()%lockStripes]
+	m.Lock()
+	return m
+}
+
+/* Unregisters a bucket. Its expired keys are kept in the index. */
+func (idx *Index) Unwrap(name []byte) {
+	idx.mu.Lock(); defer idx.mu.Unlock()
+	delete(idx.buckets,string(name))
+}
+
+func (idx *Index) lookup(tx *badger.Txn, ns, key []byte) (uint64,bool,error) {
+	item,err := tx.Get(kKey(ns,key))
+	if err==badger.ErrKeyNotFound { return 0,false,nil }
+	if err!=nil { return 0,false,err }
+	v,err := item.ValueCopy(nil)
+	if err!=nil || len(v)!=8 { return 0,false,err }
+	return binary.BigEndian.Uint64(v),true,nil
+}
+
+/* Records (or, if exp is 0, removes) the expiration time of a key. */
+func (idx *Index) set(ns, key []byte, exp uint64) error {
+	return idx.DB.Update(func(tx *badger.Txn) error {
+		old,ok,err := idx.lookup(tx,ns,key)
+		if err!=nil { return err }
+		if ok {
+			if old==exp { return nil }
+			err = tx.Delete(tKey(old,ns,key))
+			if err!=nil { return err }
+		}
+		if exp==0 {
+			if !ok { return nil }
+			return tx.Delete(kKey(ns,key))
+		}
+		var v [8]byte
+		binary.BigEndian.PutUint64(v[:],exp)
+		err = tx.Set(kKey(ns,key),v[:])
+		if err!=nil { return err }
+		return tx.Set(tKey(exp,ns,key),nil)
+	})
+}
+
+func (idx *Index) expiresAt(ns, key []byte) (exp uint64, ok bool, err error) {
+	err = idx.DB.View(func(tx *badger.Txn) (e error) {
+		exp,ok,e = idx.lookup(tx,ns,key)
+		return
+	})
+	return
+}
+
+type expired struct{
+	tkey []byte
+	exp  uint64
+	name []byte
+	key  []byte
+}
+
+/* Collects up to sweepBatch expired entries, that come after the entry after (if not nil). */
+func (idx *Index) due(now uint64, after []byte) (list []expired, err error) {
+	err = idx.DB.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := tx.NewIterator(opts)
+		defer iter.Close()
+		prefix := []byte{pTime}
+		start := prefix
+		if after!=nil { start = after }
+		for iter.Seek(start); iter.ValidForPrefix(prefix) && len(list)<sweepBatch; iter.Next() {
+			k := iter.Item().KeyCopy(nil)
+			if bytes.Equal(k,after) { continue }
+			if len(k)<10 || len(k)<10+int(k[9]) { continue }
+			e := expired{tkey:k,exp:binary.BigEndian.Uint64(k[1:])}
+			if e.exp>now { break }
+			e.name = k[10:10+int(k[9])]
+			e.key = k[10+int(k[9]):]
+			list = append(list,e)
+		}
+		return nil
+	})
+	return
+}
+
+/*
+Deletes the expired keys from the registered buckets and from the index.
+Returns the number of deleted keys.
+
+Keys, that can not be deleted now (their bucket is not registered, or the
+delete fails), are left in the index and are tried again by the next call.
+*/
+func (idx *Index) Sweep() (n int, err error) {
+	now := uint64(time.Now().Unix())
+	var after []byte
+	for {
+		var list []expired
+		list,err = idx.due(now,after)
+		if err!=nil || len(list)==0 { return }
+		for _,e := range list {
+			/* Resume behind the entries, that are left in the index. */
+			after = e.tkey
+			idx.mu.RLock()
+			b := idx.buckets[string(e.name)]
+			idx.mu.RUnlock()
+			if b==nil { continue }
+			var ok bool
+			ok,err = idx.sweep(b,e)
+			if err!=nil { return }
+			if ok { n++ }
+		}
+	}
+}
+
+/* Deletes the expired key e from b and from the index. */
+func (idx *Index) sweep(b bucketstore.BucketW, e expired) (bool,error) {
+	ens := ns(e.name)
+	m := idx.lock(ens,e.key); defer m.Unlock()
+	
+	/* The key may have been written again, since it was collected. */
+	exp,ok,err := idx.expiresAt(ens,e.key)
+	if err!=nil { return false,err }
+	if !ok || exp!=e.exp { return false,nil }
+	
+	err = b.BucketDelete(e.name,e.key)
+	if err!=nil && err!=bucketstore.ENotFound { return false,nil }
+	return true,idx.set(ens,e.key,0)
+}
+
+func (idx *Index) sweeper() {
+	iv := idx.Interval
+	if iv<=0 { iv = time.Minute }
+	t := time.NewTicker(iv)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C: idx.Sweep()
+		case <-idx.stop: return
+		}
+	}
+}
+func (idx *Index) Start() {
+	go idx.sweeper()
+}
+
+/* Stops the sweeper and closes the index. */
+func (idx *Index) Close() error {
+	idx.once.Do(func() { close(idx.stop) })
+	return idx.DB.Close()
+}
+
+/* A bucket with emulated expiry. */
+type Bucket struct{
+	B   bucketstore.Bucket
+	idx *Index
+	ns  []byte
+}
+
+func (b *Bucket) BucketGet(bucket, key []byte) (bufferex.Binary, error) {
+	exp,ok,err := b.idx.expiresAt(b.ns,key)
+	if err!=nil { return bufferex.Binary{},err }
+	if ok && exp<=uint64(time.Now().Unix()) { return bufferex.Binary{},bucketstore.ENotFound }
+	return b.B.BucketGet(bucket,key)
+}
+func (b *Bucket) BucketPut(bucket, key, value []byte) error {
+	m := b.idx.lock(b.ns,key); defer m.Unlock()
+	err := b.idx.set(b.ns,key,0)
+	if err!=nil { return err }
+	return b.B.BucketPut(bucket,key,value)
+}
+/*
+The index is written first: if the write to the bucket fails, the sweeper
+just deletes a key, that does not exist.
+*/
+func (b *Bucket) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) error {
+	m := b.idx.lock(b.ns,key); defer m.Unlock()
+	err := b.idx.set(b.ns,key,expiresAt)
+	if err!=nil { return err }
+	return b.B.BucketPut(bucket,key,value)
+}
+func (b *Bucket) BucketDelete(bucket, key []byte) error {
+	m := b.idx.lock(b.ns,key); defer m.Unlock()
+	err := b.B.BucketDelete(bucket,key)
+	if err!=nil && err!=bucketstore.ENotFound { return err }
+	if e := b.idx.set(b.ns,key,0); e!=nil { return e }
+	return err
+}
+
+var _ bucketstore.Bucket = (*Bucket)(nil)
+var _ bucketstore.BucketWEx = (*Bucket)(nil)
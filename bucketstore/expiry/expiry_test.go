@@ -0,0 +1,137 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package expiry
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/memstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/storetest"
+import "testing"
+import "io/ioutil"
+import "time"
+import "fmt"
+import "os"
+
+/* Hides BucketPutExpire of the memstore.Bucket. */
+type plain struct{
+	bucketstore.Bucket
+}
+
+func tempIndex(t *testing.T) (*Index,func()) {
+	dir,err := ioutil.TempDir("","expiry")
+	if err!=nil { t.Fatal(err) }
+	b,err := dkv.OpenQuick(dir)
+	if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+	return New(b.DB),func() {
+		b.DB.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func exists(b bucketstore.BucketR, name, key string) bool {
+	bin,err := b.BucketGet([]byte(name),[]byte(key))
+	bin.Free()
+	return err==nil
+}
+
+func TestConformance(t *testing.T) {
+	s := &storetest.BucketSuite{New:func(t *testing.T) (bucketstore.Bucket,func()) {
+		idx,done := tempIndex(t)
+		b,err := idx.Wrap([]byte("storetest"),plain{new(memstore.Bucket)})
+		if err!=nil { done(); t.Fatal(err) }
+		return b,done
+	}}
+	s.Run(t)
+}
+
+func TestSweep(t *testing.T) {
+	idx,done := tempIndex(t)
+	defer done()
+	mem := new(memstore.Bucket)
+	b,_ := idx.Wrap([]byte("x"),plain{mem})
+	now := uint64(time.Now().Unix())
+	b.BucketPutExpire([]byte("x"),[]byte("a"),[]byte("1"),now-1)
+	b.BucketPutExpire([]byte("x"),[]byte("b"),[]byte("2"),now+100)
+	b.BucketPutExpire([]byte("x"),[]byte("c"),[]byte("3"),now-5)
+	b.BucketPut([]byte("x"),[]byte("c"),[]byte("3"))
+	
+	n,err := idx.Sweep()
+	if err!=nil || n!=1 { t.Fatalf("Sweep() -> %d %v, expected 1",n,err) }
+	if exists(mem,"x","a") { t.Errorf("the expired key a has not been deleted") }
+	if !exists(mem,"x","b") { t.Errorf("the live key b has been deleted") }
+	if !exists(mem,"x","c") { t.Errorf("the rewritten key c has been deleted") }
+}
+
+/* Entries, that can not be swept, must not hide the entries behind them. */
+func TestSweepBehindSkipped(t *testing.T) {
+	idx,done := tempIndex(t)
+	defer done()
+	now := uint64(time.Now().Unix())
+	gone,_ := idx.Wrap([]byte("gone"),plain{new(memstore.Bucket)})
+	for i := 0; i<sweepBatch*2; i++ {
+		gone.BucketPutExpire([]byte("gone"),[]byte(fmt.Sprint(i)),[]byte("x"),now-100)
+	}
+	idx.Unwrap([]byte("gone"))
+	
+	mem := new(memstore.Bucket)
+	b,_ := idx.Wrap([]byte("live"),plain{mem})
+	for i := 0; i<5; i++ {
+		b.BucketPutExpire([]byte("live"),[]byte(fmt.Sprint(i)),[]byte("x"),now-1)
+	}
+	n,err := idx.Sweep()
+	if err!=nil || n!=5 { t.Fatalf("Sweep() -> %d %v, expected 5",n,err) }
+	for i := 0; i<5; i++ {
+		if exists(mem,"live",fmt.Sprint(i)) { t.Errorf("key %d has not been deleted",i) }
+	}
+	
+	/* The entries of the unregistered bucket are kept. */
+	list,_ := idx.due(now,nil)
+	if len(list)!=sweepBatch { t.Errorf("%d entries are left, expected %d",len(list),sweepBatch) }
+}
+
+/* A key, that is written after it was found to be expired, must survive. */
+func TestSweepRewritten(t *testing.T) {
+	idx,done := tempIndex(t)
+	defer done()
+	now := uint64(time.Now().Unix())
+	mem := new(memstore.Bucket)
+	b,_ := idx.Wrap([]byte("x"),plain{mem})
+	b.BucketPutExpire([]byte("x"),[]byte("p"),[]byte("old"),now-1)
+	b.BucketPutExpire([]byte("x"),[]byte("e"),[]byte("old"),now-1)
+	list,err := idx.due(now,nil)
+	if err!=nil || len(list)!=2 { t.Fatalf("due() -> %d entries %v",len(list),err) }
+	
+	/* Written between collecting and deleting. */
+	b.BucketPut([]byte("x"),[]byte("p"),[]byte("new"))
+	b.BucketPutExpire([]byte("x"),[]byte("e"),[]byte("new"),now+100)
+	for _,e := range list {
+		ok,err := idx.sweep(mem,e)
+		if err!=nil || ok { t.Errorf("sweep(%q) -> %v %v, expected nothing to be deleted",e.key,ok,err) }
+	}
+	for _,k := range []string{"p","e"} {
+		bin,err := b.BucketGet([]byte("x"),[]byte(k))
+		if err!=nil || string(bin.Bytes())!="new" { t.Errorf("BucketGet(%q) -> %q %v",k,bin.Bytes(),err) }
+		bin.Free()
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package expiry
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netkv"
+import "io"
+
+type rw struct{
+	bucketstore.BucketR
+	bucketstore.BucketW
+}
+
+type unwrapper struct{
+	idx   *Index
+	name  []byte
+	inner io.Closer
+}
+func (u *unwrapper) Close() error {
+	u.idx.Unwrap(u.name)
+	if u.inner==nil { return nil }
+	return u.inner.Close()
+}
+
+/*
+Emulates WriterEx for a netkv session. To be used as netkv.NetKVMap.EmulateExpiry.
+*/
+func (idx *Index) Emulate(bucket []byte, sess *netkv.Session) error {
+	name := append([]byte(nil),bucket...)
+	b,err := idx.Wrap(name,rw{sess.Reader,sess.Writer})
+	if err!=nil { return err }
+	sess.Reader = b
+	sess.Writer = b
+	sess.WriterEx = b
+	sess.Closer = &unwrapper{idx,name,sess.Closer}
+	return nil
+}
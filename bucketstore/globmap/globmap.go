@@ -126,3 +126,15 @@ func (n *NodeMap) DropBucket(bucket string) {
 	delete(n.b2n,bucket)
 }
 
+/* Replaces the buckets of node with the given list. */
+func (n *NodeMap) Replace(node string,buckets []string) {
+	n.access.Lock(); defer n.access.Unlock()
+	for _,bucket := range n.n2b[node] {
+		if !find(bucket,buckets) { remi(bucket,node,n.b2n) }
+	}
+	delete(n.n2b,node)
+	for _,bucket := range buckets {
+		addi(node,bucket,n.n2b)
+		addi(bucket,node,n.b2n)
+	}
+}
@@ -30,9 +30,24 @@ func cloneb(b []byte) []byte{
 	copy(s,b)
 	return s
 }
+/*
+The health of a bucket. Free and Total are zero, if the capacity is unknown.
+IOErrors is the number of I/O errors, that have been reported for the bucket
+since the process has started (see AddIOErrors).
+*/
 type Health struct{
 	Name     []byte
 	Writable bool
+	Free     uint64
+	Total    uint64
+	IOErrors uint64
+	Draining bool
+}
+
+/* The fraction of the capacity, that is free, or -1 if unknown. */
+func (h *Health) FreeRatio() float64 {
+	if h.Total==0 { return -1 }
+	return float64(h.Free)/float64(h.Total)
 }
 
 type HealthReceiver interface{
@@ -57,9 +72,66 @@ func RemoveHealthReceiver(hr HealthReceiver) {
 	}
 }
 func IssueHealth(h Health) {
+	h.Draining = IsDraining(h.Name)
+	if h.Draining { h.Writable = false }
+	if w,ok := Override(h.Name); ok && !h.Draining { h.Writable = w }
+	if n := IOErrors(h.Name); n>h.IOErrors { h.IOErrors = n }
 	for _,hr := range healthRecvs { if hr!=nil { hr.IssueHealth(h) } }
 }
 
+var drainingLock sync.RWMutex
+var draining = make(map[string]bool)
+
+/*
+Marks a bucket as draining (or not). A draining bucket is never reported
+as writable by IssueHealth, whatever the health checker says.
+*/
+func SetDraining(name []byte, d bool) {
+	drainingLock.Lock(); defer drainingLock.Unlock()
+	if d {
+		draining[string(name)] = true
+	} else {
+		delete(draining,string(name))
+	}
+}
+func IsDraining(name []byte) bool {
+	drainingLock.RLock(); defer drainingLock.RUnlock()
+	return draining[string(name)]
+}
+
+var overridesLock sync.RWMutex
+var overrides = make(map[string]bool)
+
+/*
+Overrides the writable state of a bucket, reported by its health checker.
+Draining buckets are never writable, regardless of the override.
+*/
+func SetOverride(name []byte, writable bool) {
+	overridesLock.Lock(); defer overridesLock.Unlock()
+	overrides[string(name)] = writable
+}
+func ClearOverride(name []byte) {
+	overridesLock.Lock(); defer overridesLock.Unlock()
+	delete(overrides,string(name))
+}
+func Override(name []byte) (writable, ok bool) {
+	overridesLock.RLock(); defer overridesLock.RUnlock()
+	writable,ok = overrides[string(name)]
+	return
+}
+
+var ioErrorsLock sync.Mutex
+var ioErrors = make(map[string]uint64)
+
+/* Counts I/O errors of a bucket. They are included in the next IssueHealth. */
+func AddIOErrors(name []byte, n uint64) {
+	ioErrorsLock.Lock(); defer ioErrorsLock.Unlock()
+	ioErrors[string(name)] += n
+}
+func IOErrors(name []byte) uint64 {
+	ioErrorsLock.Lock(); defer ioErrorsLock.Unlock()
+	return ioErrors[string(name)]
+}
 
 type HealthMap struct {
 	hmap map[string]*Health
@@ -74,9 +146,20 @@ func (n *HealthMap) SetWritable(name []byte,writable bool) {
 	if h!=nil {
 		h.Writable = writable
 	} else {
-		n.hmap[string(name)] = &Health{cloneb(name),writable}
+		n.hmap[string(name)] = &Health{Name:cloneb(name),Writable:writable}
 	}
 }
+func (n *HealthMap) Set(h Health) {
+	h.Name = cloneb(h.Name)
+	n.access.Lock(); defer n.access.Unlock()
+	n.hmap[string(h.Name)] = &h
+}
+func (n *HealthMap) Get(name []byte) (Health,bool) {
+	n.access.RLock(); defer n.access.RUnlock()
+	h := n.hmap[string(name)]
+	if h!=nil { return *h,true }
+	return Health{},false
+}
 func (n *HealthMap) GetWritable(name []byte,def bool) bool {
 	n.access.RLock(); defer n.access.RUnlock()
 	h := n.hmap[string(name)]
@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package kvrpc
+
+import "errors"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/selerr"
+
+/* Result codes of protocol version 2. */
+const (
+	cOk uint = iota
+	cNotFound
+	cFail
+	cUnsupported
+	cNoTransfer
+	cOther
+)
+
+var EUnsupported = errors.New("EUnsupported")
+var ENoTransfer = errors.New("ENoTransfer")
+var EProtocol = errors.New("EProtocol")
+
+/* The server has reached its concurrency limit. */
+var EBusy = errors.New("EBusy")
+
+/* The maximum number of operations within one frame. */
+const MaxBatch = 1<<12
+
+/*
+An error, that has been returned by the remote bucket, and that has no
+result code of its own.
+*/
+type RemoteError string
+func (r RemoteError) Error() string { return string(r) }
+
+/*
+Restores the selerr errors of the remote node, so that callers can check for
+them (see articlestore/chybrid).
+*/
+func remoteError(msg string) error {
+	switch msg {
+	case selerr.ENotImplemented.Error(): return selerr.ENotImplemented
+	case selerr.ENoSuchBucket.Error(): return selerr.ENoSuchBucket
+	}
+	return RemoteError(msg)
+}
+
+func e2c(e error) (uint,string) {
+	switch e {
+	case nil: return cOk,""
+	case bucketstore.ENotFound: return cNotFound,""
+	case bucketstore.EFail: return cFail,""
+	case EUnsupported: return cUnsupported,""
+	case ENoTransfer: return cNoTransfer,""
+	}
+	return cOther,e.Error()
+}
+func c2e(c uint, msg string) error {
+	switch c {
+	case cOk: return nil
+	case cNotFound: return bucketstore.ENotFound
+	case cFail: return bucketstore.EFail
+	case cUnsupported: return EUnsupported
+	case cNoTransfer: return ENoTransfer
+	}
+	return remoteError(msg)
+}
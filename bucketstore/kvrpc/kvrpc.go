@@ -24,7 +24,8 @@ SOFTWARE.
 package kvrpc
 
 import "time"
-import "errors"
+import "sync"
+import "sync/atomic"
 import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
 import "github.com/valyala/fastrpc"
@@ -47,15 +48,89 @@ func s2e(e string) error {
 	case "": return nil
 	case "EFail": return bucketstore.EFail
 	case "ENotFound": return bucketstore.ENotFound
+	case "EBusy": return EBusy
 	}
-	return errors.New(e)
+	return remoteError(e)
 }
 
 func doerr (r *reqCtx) {
 	r.err = "EFail"
 }
 
+/* Executes an item of a batch. Gets return no more than room bytes inline. */
+func execute(b GBucket, tr *transfers, it *item, room int) {
+	var e error
+	switch it.op {
+	case uGet:
+		it.bin,e = b.BucketGet(it.bucket,it.key)
+		it.out = it.bin.Bytes()
+		it.total = uint64(len(it.out))
+		if room>ChunkSize { room = ChunkSize }
+		if room<0 { room = 0 }
+		if len(it.out)>room {
+			/* The remainder is fetched using uGetChunk. */
+			it.id = tr.add(&transfer{bin:it.bin})
+			it.bin = bufferex.Binary{}
+			it.out = it.out[:room]
+		}
+	case uPut: e = b.BucketPut(it.bucket,it.key,it.value)
+	case uDelete: e = b.BucketDelete(it.bucket,it.key)
+	case uPutExpire: e = b.BucketPutExpire(it.bucket,it.key,it.value,it.expiresAt)
+	default: e = EUnsupported
+	}
+	it.code,it.msg = e2c(e)
+}
+func getChunk(tr *transfers, rr *reqCtx, it *item) {
+	x := tr.get(rr.id)
+	if x==nil { it.code = cNoTransfer; return }
+	data := x.bin.Bytes()
+	if rr.offset>=uint64(len(data)) { it.code = cFail; return }
+	end := rr.offset+ChunkSize
+	if end>=uint64(len(data)) {
+		end = uint64(len(data))
+		/* Last chunk: the value is released after the response is written. */
+		tr.remove(rr.id)
+		it.bin = x.bin
+	}
+	it.out = data[rr.offset:end]
+	it.total = uint64(len(data))
+	it.id = rr.id
+}
+func putChunk(b GBucket, tr *transfers, rr *reqCtx, it *item) {
+	var x *transfer
+	id := rr.id
+	if id==0 {
+		x = new(transfer)
+		id = tr.add(x)
+	} else if x = tr.get(id); x==nil {
+		it.code = cNoTransfer
+		return
+	}
+	if uint64(len(x.buf))!=rr.offset {
+		tr.remove(id)
+		it.code = cFail
+		return
+	}
+	x.buf = append(x.buf,rr.value...)
+	it.id = id
+	it.total = uint64(len(x.buf))
+	if !rr.final { return }
+	tr.remove(id)
+	var e error
+	if rr.expiresAt==0 {
+		e = b.BucketPut(rr.bucket,rr.key,x.buf)
+	} else {
+		e = b.BucketPutExpire(rr.bucket,rr.key,x.buf,rr.expiresAt)
+	}
+	it.code,it.msg = e2c(e)
+}
+
+/*
+Creates the handler. The handler speaks both protocol versions, the version
+is negotiated by the client (see Client.Version).
+*/
 func Makehandler(b GBucket) func(ctx fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+	tr := newTransfers()
 	return func(ctx fastrpc.HandlerCtx) fastrpc.HandlerCtx {
 		rr := ctx.(*reqCtx)
 		switch rr.op {
@@ -66,6 +141,27 @@ func Makehandler(b GBucket) func(ctx fastrpc.HandlerCtx) fastrpc.HandlerCtx {
 		case uPut: rr.err = e2s(b.BucketPut(rr.bucket,rr.key,rr.value))
 		case uDelete: rr.err = e2s(b.BucketDelete(rr.bucket,rr.key))
 		case uPutExpire: rr.err = e2s(b.BucketPutExpire(rr.bucket,rr.key,rr.value,rr.expiresAt))
+		case uHello:
+			/* The client offers its highest version in expiresAt. */
+			v := rr.expiresAt
+			if v==0 || v>uint64(VersionLatest) { v = uint64(VersionLatest) }
+			rr.bin = bufferex.AllocBinary(1)
+			rr.bin.Bytes()[0] = byte(v)
+			rr.err = ""
+		case uBatch:
+			/* The response frame is capped like the request frame. */
+			room := frameBytes
+			for i := range rr.items {
+				execute(b,tr,&rr.items[i],room)
+				room -= len(rr.items[i].out)
+			}
+		case uGetChunk:
+			rr.items = growItems(rr.items,1)
+			getChunk(tr,rr,&rr.items[0])
+		case uPutChunk:
+			rr.items = growItems(rr.items,1)
+			putChunk(b,tr,rr,&rr.items[0])
+		case uScan: scan(b,rr)
 		default: doerr(rr)
 		}
 		return ctx
@@ -78,81 +174,279 @@ func NewServer(b GBucket) *fastrpc.Server {
 	}
 }
 
+/* ------------------------------------------------------------ */
+
+const (
+	OpGet = uGet
+	OpPut = uPut
+	OpDelete = uDelete
+)
+
+/*
+One operation of a batch. A put with a non-zero ExpiresAt is a put with expiry.
+The results are stored in Result (for gets) and Err.
+*/
+type Op struct{
+	Op uint
+	Bucket, Key, Value []byte
+	ExpiresAt uint64
+	
+	Result bufferex.Binary
+	Err error
+}
+func (p *Op) wire() uint {
+	if p.Op==OpPut && p.ExpiresAt!=0 { return uPutExpire }
+	return p.Op
+}
+func (p *Op) chunked() bool {
+	return p.Op==OpPut && len(p.Value)>ChunkSize
+}
+
+type negotiated struct{
+	addr string
+	version uint
+}
+
+func deadline() time.Time { return time.Now().Add(time.Second*5) }
 
 type Client struct{
 	Cli fastrpc.Client
+	
+	/*
+	The highest protocol version to use. 0 means VersionLatest.
+	If set to Version1, no negotiation takes place.
+	*/
+	MaxVersion uint
+	
+	nmu sync.Mutex
+	neg atomic.Value
 }
 func (c *Client) Init() {
 	c.Cli.NewResponse = nresp
 }
-func (c *Client) BucketGet(bucket, key []byte) (bufferex.Binary, error) {
+func (c *Client) lookup() uint {
+	n,_ := c.neg.Load().(*negotiated)
+	if n!=nil && n.addr==c.Cli.Addr { return n.version }
+	return 0
+}
+func (c *Client) renegotiate() {
+	c.neg.Store((*negotiated)(nil))
+}
+
+/*
+Returns the protocol version, that has been negotiated with the server.
+The negotiation takes place on the first call, and after transport errors,
+so that a client picks up the version of a server, that has been upgraded or
+downgraded in the meantime.
+*/
+func (c *Client) Version() (uint,error) {
+	max := c.MaxVersion
+	if max==0 || max>VersionLatest { max = VersionLatest }
+	if max<=Version1 { return Version1,nil }
+	if v := c.lookup(); v!=0 { return v,nil }
+	
+	c.nmu.Lock(); defer c.nmu.Unlock()
+	if v := c.lookup(); v!=0 { return v,nil }
+	
 	o := reqs.Get().(*req)
 	defer o.free()
 	i := resps.Get().(*resp)
 	defer i.free()
 	
-	o.op = uGet
-	o.bucket = append(o.bucket,bucket...)
-	o.key = append(o.key,key...)
-	o.value = o.value[:0]
-	o.expiresAt = 0
+	o.single(uHello,nil,nil,nil,uint64(max))
+	i.mode = mV1
 	
-	err := c.Cli.DoDeadline(o,i,time.Now().Add(time.Second*5))
-	if err!=nil { return bufferex.Binary{},err }
+	addr := c.Cli.Addr
+	err := c.Cli.DoDeadline(o,i,deadline())
+	if err!=nil { return 0,err }
 	
-	return i.pullBin(),s2e(i.err)
+	var v uint
+	arr := i.bin.Bytes()
+	switch {
+	case i.err=="" && len(arr)==1:
+		v = uint(arr[0])
+		if v<Version1 { v = Version1 }
+		if v>max { v = max }
+	case i.err=="EFail":
+		/* A version 1 server answers with "EFail". */
+		v = Version1
+	case i.err=="":
+		return 0,EProtocol
+	default:
+		/* Eg. EBusy. Nothing is learned about the server, so nothing is cached. */
+		return 0,s2e(i.err)
+	}
+	c.neg.Store(&negotiated{addr,v})
+	return v,nil
 }
-func (c *Client) BucketPut(bucket, key, value []byte) error {
+
+func (c *Client) call1(p *Op) {
 	o := reqs.Get().(*req)
 	defer o.free()
 	i := resps.Get().(*resp)
 	defer i.free()
 	
-	o.op = uPut
-	o.bucket = append(o.bucket,bucket...)
-	o.key = append(o.key,key...)
-	o.value = append(o.value,value...)
-	o.expiresAt = 0
+	o.single(p.wire(),p.Bucket,p.Key,p.Value,p.ExpiresAt)
+	i.mode = mV1
 	
-	err := c.Cli.DoDeadline(o,i,time.Now().Add(time.Second*5))
-	if err!=nil { return err }
+	err := c.Cli.DoDeadline(o,i,deadline())
+	if err!=nil { c.renegotiate(); p.Err = err; return }
 	
-	return s2e(i.err)
+	p.Result,p.Err = i.pullBin(),s2e(i.err)
 }
-func (c *Client) BucketDelete(bucket, key []byte) error {
+func (c *Client) call2(o *req, i *resp) error {
+	i.mode = mV2
+	err := c.Cli.DoDeadline(o,i,deadline())
+	if err!=nil { c.renegotiate() }
+	return err
+}
+func (c *Client) getChunk(id, offset uint64, dst []byte) (int,error) {
 	o := reqs.Get().(*req)
 	defer o.free()
 	i := resps.Get().(*resp)
 	defer i.free()
 	
-	o.op = uDelete
-	o.bucket = append(o.bucket,bucket...)
-	o.key = append(o.key,key...)
-	o.value = o.value[:0]
-	o.expiresAt = 0
+	o.op = uGetChunk
+	o.id = id
+	o.offset = offset
 	
-	err := c.Cli.DoDeadline(o,i,time.Now().Add(time.Second*5))
-	if err!=nil { return err }
-	
-	return s2e(i.err)
+	err := c.call2(o,i)
+	if err!=nil { return 0,err }
+	if len(i.items)!=1 { return 0,EProtocol }
+	it := &i.items[0]
+	err = c2e(it.code,it.msg)
+	if err!=nil { return 0,err }
+	n := copy(dst,it.bin.Bytes())
+	if n==0 { return 0,EProtocol }
+	return n,nil
 }
-func (c *Client) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) error {
+func (c *Client) pullValue(it *item) (bufferex.Binary,error) {
+	first := it.bin.Bytes()
+	if it.total==uint64(len(first)) {
+		bin := it.bin
+		it.bin = bufferex.Binary{}
+		return bin,nil
+	}
+	if it.total<uint64(len(first)) { return bufferex.Binary{},EProtocol }
+	bin := bufferex.AllocBinary(int(it.total))
+	dst := bin.Bytes()
+	off := copy(dst,first)
+	for off<len(dst) {
+		n,err := c.getChunk(it.id,uint64(off),dst[off:])
+		if err!=nil { bin.Free(); return bufferex.Binary{},err }
+		off += n
+	}
+	return bin,nil
+}
+func (c *Client) putChunk(id uint64, offset int, p *Op, chunk []byte, final bool) (uint64,error) {
 	o := reqs.Get().(*req)
 	defer o.free()
 	i := resps.Get().(*resp)
 	defer i.free()
 	
-	o.op = uPut
-	o.bucket = append(o.bucket,bucket...)
-	o.key = append(o.key,key...)
-	o.value = append(o.value,value...)
-	o.expiresAt = expiresAt
+	o.single(uPutChunk,p.Bucket,p.Key,nil,p.ExpiresAt)
+	o.id = id
+	o.offset = uint64(offset)
+	o.chunk = chunk
+	o.final = final
 	
-	err := c.Cli.DoDeadline(o,i,time.Now().Add(time.Second*5))
-	if err!=nil { return err }
+	err := c.call2(o,i)
+	if err!=nil { return 0,err }
+	if len(i.items)!=1 { return 0,EProtocol }
+	it := &i.items[0]
+	return it.id,c2e(it.code,it.msg)
+}
+func (c *Client) putChunked(p *Op) {
+	var id uint64
+	for off := 0; off<len(p.Value); off += ChunkSize {
+		end := off+ChunkSize
+		if end>len(p.Value) { end = len(p.Value) }
+		id,p.Err = c.putChunk(id,off,p,p.Value[off:end],end==len(p.Value))
+		if p.Err!=nil { return }
+	}
+}
+
+/*
+Frames are cut, once their values exceed this size. The server answers gets
+with no more than this size in total, the remainder is fetched using uGetChunk.
+*/
+const frameBytes = ChunkSize*4
+
+func (c *Client) batch2(ops []Op) error {
+	o := reqs.Get().(*req)
+	defer o.free()
+	i := resps.Get().(*resp)
+	defer i.free()
 	
-	return s2e(i.err)
+	o.op = uBatch
+	size := 0
+	n := 0
+	for k := range ops {
+		p := &ops[k]
+		n = k+1
+		if p.chunked() { continue }
+		o.items = append(o.items,item{op:p.wire(),bucket:p.Bucket,key:p.Key,value:p.Value,expiresAt:p.ExpiresAt})
+		size += len(p.Value)
+		if len(o.items)>=MaxBatch || size>=frameBytes { break }
+	}
+	if len(o.items)>0 {
+		err := c.call2(o,i)
+		if err!=nil { return err }
+		j := 0
+		for k := range ops[:n] {
+			p := &ops[k]
+			if p.chunked() { continue }
+			/* A busy server answers with a single failure. */
+			if j>=len(i.items) { p.Err = bucketstore.EFail; continue }
+			it := &i.items[j]
+			j++
+			p.Err = c2e(it.code,it.msg)
+			if p.Op==OpGet && p.Err==nil { p.Result,p.Err = c.pullValue(it) }
+		}
+	}
+	for k := range ops[:n] {
+		if ops[k].chunked() { c.putChunked(&ops[k]) }
+	}
+	if n<len(ops) { return c.batch2(ops[n:]) }
+	return nil
 }
 
-var _ GBucket = (*Client)(nil)
+/*
+Performs multiple operations. With protocol version 2, they are sent in as few
+frames as possible, otherwise one by one. The results of the individual
+operations are stored in the Op structs. An error is returned, if the version
+negotiation or the transfer of a frame failed.
+*/
+func (c *Client) BucketBatch(ops []Op) error {
+	v,err := c.Version()
+	if err!=nil { return err }
+	if v<Version2 {
+		for k := range ops { c.call1(&ops[k]) }
+		return nil
+	}
+	return c.batch2(ops)
+}
+func (c *Client) one(p *Op) (bufferex.Binary, error) {
+	ops := [1]Op{*p}
+	err := c.BucketBatch(ops[:])
+	if err!=nil { return bufferex.Binary{},err }
+	return ops[0].Result,ops[0].Err
+}
 
+func (c *Client) BucketGet(bucket, key []byte) (bufferex.Binary, error) {
+	return c.one(&Op{Op:OpGet,Bucket:bucket,Key:key})
+}
+func (c *Client) BucketPut(bucket, key, value []byte) error {
+	_,err := c.one(&Op{Op:OpPut,Bucket:bucket,Key:key,Value:value})
+	return err
+}
+func (c *Client) BucketDelete(bucket, key []byte) error {
+	_,err := c.one(&Op{Op:OpDelete,Bucket:bucket,Key:key})
+	return err
+}
+func (c *Client) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) error {
+	_,err := c.one(&Op{Op:OpPut,Bucket:bucket,Key:key,Value:value,ExpiresAt:expiresAt})
+	return err
+}
+
+var _ GBucket = (*Client)(nil)
@@ -0,0 +1,188 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package kvrpc
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/selerr"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/memstore"
+import "github.com/valyala/fastrpc"
+import "testing"
+import "net"
+import "fmt"
+import "bytes"
+import "sync/atomic"
+
+/* Serves h on a loopback port and returns a client connected to it. */
+func loopback(t *testing.T, h func(ctx fastrpc.HandlerCtx) fastrpc.HandlerCtx) (*Client,func()) {
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { t.Fatal(err) }
+	go (&fastrpc.Server{NewHandlerCtx:NewHandler,Handler:h}).Serve(ln)
+	c := new(Client)
+	c.Cli.Addr = ln.Addr().String()
+	c.Init()
+	return c,func() { ln.Close() }
+}
+
+func TestVersionLatest(t *testing.T) {
+	c,stop := loopback(t,Makehandler(new(memstore.Bucket)))
+	defer stop()
+	v,err := c.Version()
+	if err!=nil || v!=VersionLatest { t.Fatalf("Version() -> %d %v, expected %d",v,err,VersionLatest) }
+}
+
+func TestVersion1(t *testing.T) {
+	v2 := Makehandler(new(memstore.Bucket))
+	c,stop := loopback(t,func(ctx fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+		/* A version 1 server does not know uHello. */
+		if rr := ctx.(*reqCtx); rr.op==uHello { doerr(rr); return ctx }
+		return v2(ctx)
+	})
+	defer stop()
+	v,err := c.Version()
+	if err!=nil || v!=Version1 { t.Fatalf("Version() -> %d %v, expected %d",v,err,Version1) }
+	if c.lookup()!=Version1 { t.Errorf("Version1 has not been cached") }
+	
+	err = c.BucketPut([]byte("b"),[]byte("k"),[]byte("v"))
+	if err!=nil { t.Errorf("BucketPut over version 1: %v",err) }
+}
+
+/* A busy server must not be mistaken for a version 1 server. */
+func TestVersionBusy(t *testing.T) {
+	busy := int32(1)
+	v2 := Makehandler(new(memstore.Bucket))
+	c,stop := loopback(t,func(ctx fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+		if atomic.LoadInt32(&busy)!=0 { ctx.ConcurrencyLimitError(1); return ctx }
+		return v2(ctx)
+	})
+	defer stop()
+	v,err := c.Version()
+	if err!=EBusy { t.Fatalf("Version() -> %d %v, expected EBusy",v,err) }
+	if c.lookup()!=0 { t.Fatalf("Version %d has been cached",c.lookup()) }
+	
+	atomic.StoreInt32(&busy,0)
+	v,err = c.Version()
+	if err!=nil || v!=VersionLatest { t.Fatalf("Version() -> %d %v, expected %d",v,err,VersionLatest) }
+}
+
+/* Pages are cut by size, not only by the number of keys. */
+func TestScanPageBytes(t *testing.T) {
+	b := new(memstore.Bucket)
+	bucket := []byte("b")
+	value := make([]byte,ScanPageBytes/3)
+	for i := 0; i<10; i++ {
+		value[0] = byte(i)
+		b.BucketPut(bucket,[]byte(fmt.Sprintf("k%02d",i)),value)
+	}
+	
+	rr := new(reqCtx)
+	rr.bucket = bucket
+	rr.rng.Values = true
+	scan(b,rr)
+	if n := len(rr.page.ofs)/2; n==0 || n>=10 || !rr.page.more {
+		t.Fatalf("first page holds %d keys (more=%v), expected it to be cut",n,rr.page.more)
+	}
+	if len(rr.page.buf) > ScanPageBytes+len(value)+8 {
+		t.Errorf("first page holds %d bytes",len(rr.page.buf))
+	}
+	
+	c,stop := loopback(t,Makehandler(b))
+	defer stop()
+	i := 0
+	cursor,err := c.BucketScan(bucket,&bucketstore.ScanRange{Values:true},func(key, v []byte) {
+		if string(key)!=fmt.Sprintf("k%02d",i) || len(v)!=len(value) || v[0]!=byte(i) {
+			t.Errorf("key %d: got %q with %d bytes",i,key,len(v))
+		}
+		i++
+	})
+	if err!=nil || cursor!=nil { t.Fatalf("BucketScan -> %q %v",cursor,err) }
+	if i!=10 { t.Errorf("BucketScan yielded %d keys, expected 10",i) }
+	
+	/* Keys only. */
+	i = 0
+	c.BucketScan(bucket,&bucketstore.ScanRange{Prefix:[]byte("k0")},func(key, v []byte) {
+		if !bytes.HasPrefix(key,[]byte("k0")) { t.Errorf("BucketScan yielded %q",key) }
+		i++
+	})
+	if i!=10 { t.Errorf("BucketScan yielded %d keys, expected 10",i) }
+}
+
+/* Many gets in a batch must not add up to a huge response frame. */
+func TestBatchResponseBytes(t *testing.T) {
+	b := new(memstore.Bucket)
+	bucket := []byte("b")
+	value := make([]byte,ChunkSize*3/4)
+	const n = 16
+	for i := 0; i<n; i++ {
+		value[0] = byte(i)
+		b.BucketPut(bucket,[]byte(fmt.Sprintf("k%02d",i)),value)
+	}
+	
+	rr := new(reqCtx)
+	rr.op = uBatch
+	for i := 0; i<n; i++ {
+		rr.items = append(rr.items,item{op:uGet,bucket:bucket,key:[]byte(fmt.Sprintf("k%02d",i))})
+	}
+	Makehandler(b)(rr)
+	size := 0
+	for i := range rr.items { size += len(rr.items[i].out) }
+	if size>frameBytes {
+		t.Errorf("the response holds %d bytes, expected no more than %d",size,frameBytes)
+	}
+	
+	c,stop := loopback(t,Makehandler(b))
+	defer stop()
+	ops := make([]Op,n)
+	for i := range ops {
+		ops[i] = Op{Op:OpGet,Bucket:bucket,Key:[]byte(fmt.Sprintf("k%02d",i))}
+	}
+	if err := c.BucketBatch(ops); err!=nil { t.Fatal(err) }
+	for i := range ops {
+		v := ops[i].Result.Bytes()
+		if ops[i].Err!=nil || len(v)!=len(value) || v[0]!=byte(i) {
+			t.Errorf("get %d: %d bytes, %v",i,len(v),ops[i].Err)
+		}
+		ops[i].Result.Free()
+	}
+}
+
+/* A bucket without expiry support. */
+type noExpiry struct{
+	*memstore.Bucket
+}
+func (noExpiry) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) error {
+	return selerr.ENotImplemented
+}
+
+/* The remote selerr.TNotImplemented is reported as such (see articlestore/chybrid). */
+func TestNotImplemented(t *testing.T) {
+	c,stop := loopback(t,Makehandler(noExpiry{new(memstore.Bucket)}))
+	defer stop()
+	err := c.BucketPutExpire([]byte("b"),[]byte("k"),[]byte("v"),1<<40)
+	if _,ok := err.(selerr.TNotImplemented); !ok {
+		t.Errorf("BucketPutExpire -> %v, expected selerr.TNotImplemented",err)
+	}
+	if err = c.BucketPut([]byte("b"),[]byte("k"),[]byte("v")); err!=nil {
+		t.Errorf("BucketPut -> %v",err)
+	}
+}
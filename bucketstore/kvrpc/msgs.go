@@ -29,6 +29,7 @@ import "bufio"
 import "github.com/vmihailenco/msgpack"
 import "github.com/valyala/fasthttp"
 import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
 import "sync"
 
 
@@ -41,6 +42,7 @@ type cwriter struct{
 	writer
 }
 
+/* Protocol version 1. */
 const (
 	uGet uint = iota
 	uPut
@@ -48,6 +50,35 @@ const (
 	uPutExpire
 )
 
+/*
+Protocol version 2.
+
+uHello is sent in the layout of a version 1 request, so that a version 1
+server will simply answer it with "EFail".
+*/
+const (
+	uHello uint = 16+iota
+	uBatch
+	uGetChunk
+	uPutChunk
+	uScan
+)
+
+const (
+	Version1 uint = 1
+	Version2 uint = 2
+	
+	/* The highest version supported by this implementation. */
+	VersionLatest = Version2
+)
+
+/*
+Values larger than this are transferred in multiple frames, so that a single
+large value does not block the connection, nor requires a buffer of its size
+for each frame.
+*/
+const ChunkSize = 1<<20
+
 type encer struct{
 	wri cwriter
 	enc *msgpack.Encoder
@@ -72,14 +103,100 @@ func (d *decer) read(br *bufio.Reader) *msgpack.Decoder {
 	return d.dec
 }
 
+/* A single operation within a version 2 frame. */
+type item struct{
+	op uint
+	bucket, key, value []byte
+	expiresAt uint64
+	
+	// result
+	code uint
+	msg string
+	total uint64
+	id uint64
+	out []byte
+	bin bufferex.Binary
+}
+func (i *item) reset() {
+	i.bin.Free()
+	i.bin = bufferex.Binary{}
+	i.out = nil
+	i.code = cOk
+	i.msg = ""
+	i.total = 0
+	i.id = 0
+}
+func (i *item) writeRequest(enc *msgpack.Encoder) error {
+	return enc.EncodeMulti(i.op,i.bucket,i.key,i.value,i.expiresAt)
+}
+func (i *item) readRequest(dec *msgpack.Decoder) error {
+	return dec.DecodeMulti(&i.op,&i.bucket,&i.key,&i.value,&i.expiresAt)
+}
+func (i *item) writeResponse(enc *msgpack.Encoder, bw *bufio.Writer) error {
+	err := enc.EncodeMulti(i.code,i.msg,i.total,i.id,uint(len(i.out)))
+	if err!=nil { return err }
+	_,err = bw.Write(i.out)
+	return err
+}
+func (i *item) readResponse(dec *msgpack.Decoder, br *bufio.Reader) error {
+	var ui uint
+	err := dec.DecodeMulti(&i.code,&i.msg,&i.total,&i.id,&ui)
+	if err!=nil { return err }
+	if ui>ChunkSize { return EProtocol }
+	i.bin = bufferex.AllocBinary(int(ui))
+	_,err = io.ReadFull(br,i.bin.Bytes())
+	return err
+}
+
+func growItems(items []item, n int) []item {
+	if cap(items)<n { items = append(items[:cap(items)],make([]item,n-cap(items))...) }
+	return items[:n]
+}
+
+/*
+The client side request. The fields bucket, key and value are owned by the
+request, while items and chunk refer to the buffers of the caller.
+*/
 type req struct{
 	op uint
 	bucket, key, value []byte
 	expiresAt uint64
+	
+	// version 2
+	items []item
+	id, offset uint64
+	chunk []byte
+	final bool
+	scan *bucketstore.ScanRange
+	
 	enc encer
 }
+func (r *req) single(op uint, bucket, key, value []byte, expiresAt uint64) {
+	r.op = op
+	r.bucket = append(r.bucket[:0],bucket...)
+	r.key = append(r.key[:0],key...)
+	r.value = append(r.value[:0],value...)
+	r.expiresAt = expiresAt
+}
 func (r *req) WriteRequest(bw *bufio.Writer) error {
-	return r.enc.write(bw).EncodeMulti(r.op,r.bucket,r.key,r.value,r.expiresAt)
+	enc := r.enc.write(bw)
+	switch r.op {
+	case uBatch:
+		err := enc.EncodeMulti(r.op,uint(len(r.items)))
+		if err!=nil { return err }
+		for i := range r.items {
+			err = r.items[i].writeRequest(enc)
+			if err!=nil { return err }
+		}
+		return nil
+	case uGetChunk:
+		return enc.EncodeMulti(r.op,r.id,r.offset)
+	case uPutChunk:
+		return enc.EncodeMulti(r.op,r.id,r.offset,r.bucket,r.key,r.chunk,r.expiresAt,r.final)
+	case uScan:
+		return writeScan(enc,r.op,r.bucket,r.scan)
+	}
+	return enc.EncodeMulti(r.op,r.bucket,r.key,r.value,r.expiresAt)
 }
 
 type reqCtx struct{
@@ -88,20 +205,68 @@ type reqCtx struct{
 	bucket, key, value []byte
 	expiresAt uint64
 	
+	// req, version 2
+	items []item
+	id, offset uint64
+	final bool
+	rng bucketstore.ScanRange
+	
 	// resp
 	err string
 	bin bufferex.Binary
+	page scanPage
 	
 	dec decer
 	enc encer
 }
 
-func (r *reqCtx) ConcurrencyLimitError(concurrency int) { r.err = "EFail" }
+func (r *reqCtx) v2() bool { return r.op>uHello }
+
+func (r *reqCtx) ConcurrencyLimitError(concurrency int) {
+	if r.op==uScan {
+		r.page.reset()
+		r.page.code = cFail
+	} else if r.v2() {
+		r.items = growItems(r.items,1)
+		r.items[0].reset()
+		r.items[0].code = cFail
+	} else if r.op==uHello {
+		/* Must not be mistaken for the "EFail" of a version 1 server. */
+		r.err = "EBusy"
+	} else {
+		r.err = "EFail"
+	}
+}
 func (r *reqCtx) Init(conn net.Conn, logger fasthttp.Logger) {}
 func (r *reqCtx) ReadRequest(br *bufio.Reader) error {
-	return r.dec.read(br).DecodeMulti(&r.op,&r.bucket,&r.key,&r.value,&r.expiresAt)
+	dec := r.dec.read(br)
+	err := dec.DecodeMulti(&r.op)
+	if err!=nil { return err }
+	switch r.op {
+	case uBatch:
+		var n uint
+		err = dec.DecodeMulti(&n)
+		if err!=nil { return err }
+		if n>MaxBatch { return EProtocol }
+		r.items = growItems(r.items,int(n))
+		for i := range r.items {
+			err = r.items[i].readRequest(dec)
+			if err!=nil { return err }
+		}
+		return nil
+	case uGetChunk:
+		return dec.DecodeMulti(&r.id,&r.offset)
+	case uPutChunk:
+		return dec.DecodeMulti(&r.id,&r.offset,&r.bucket,&r.key,&r.value,&r.expiresAt,&r.final)
+	case uScan:
+		return readScan(dec,&r.bucket,&r.rng)
+	}
+	if r.op>uHello { return EProtocol }
+	return dec.DecodeMulti(&r.bucket,&r.key,&r.value,&r.expiresAt)
 }
 func (r *reqCtx) WriteResponse(bw *bufio.Writer) error {
+	if r.op==uScan { return r.page.write(r.enc.write(bw),bw) }
+	if r.v2() { return r.writeResponse2(bw) }
 	arr := r.bin.Bytes()
 	err := r.enc.write(bw).EncodeMulti(r.err,uint(len(arr)))
 	if err!=nil { return err }
@@ -110,15 +275,52 @@ func (r *reqCtx) WriteResponse(bw *bufio.Writer) error {
 	r.bin = bufferex.Binary{}
 	return err
 }
+func (r *reqCtx) writeResponse2(bw *bufio.Writer) (err error) {
+	enc := r.enc.write(bw)
+	defer func() {
+		for i := range r.items { r.items[i].reset() }
+	}()
+	err = enc.EncodeMulti(uint(len(r.items)))
+	if err!=nil { return }
+	for i := range r.items {
+		err = r.items[i].writeResponse(enc,bw)
+		if err!=nil { return }
+	}
+	return
+}
+
+const (
+	mV1 uint = iota
+	mV2
+	mScan
+)
 
 type resp struct{
+	mode uint
+	items []item
+	page scanPage
+	
 	err string
 	bin bufferex.Binary
 	dec decer
 }
 func (r *resp) ReadResponse(br *bufio.Reader) error {
+	dec := r.dec.read(br)
+	if r.mode==mScan { return r.page.read(dec,br) }
+	if r.mode==mV2 {
+		var n uint
+		err := dec.DecodeMulti(&n)
+		if err!=nil { return err }
+		if n>MaxBatch { return EProtocol }
+		r.items = growItems(r.items,int(n))
+		for i := range r.items {
+			err = r.items[i].readResponse(dec,br)
+			if err!=nil { return err }
+		}
+		return nil
+	}
 	var ui uint
-	err := r.dec.read(br).DecodeMulti(&r.err,&ui)
+	err := dec.DecodeMulti(&r.err,&ui)
 	if err!=nil { return err }
 	r.bin = bufferex.AllocBinary(int(ui))
 	_,err = io.ReadFull(br,r.bin.Bytes())
@@ -128,6 +330,10 @@ func (r *resp) ReadResponse(br *bufio.Reader) error {
 var reqs = sync.Pool{ New:func() interface{} { return new(req) } }
 var resps = sync.Pool{ New:func() interface{} { return new(resp) } }
 func (r *req) free() {
+	for i := range r.items { r.items[i] = item{} }
+	r.items = r.items[:0]
+	r.chunk = nil
+	r.scan = nil
 	reqs.Put(r)
 }
 func (r *resp) pullBin() (bin bufferex.Binary) {
@@ -138,6 +344,8 @@ func (r *resp) pullBin() (bin bufferex.Binary) {
 func (r *resp) free() {
 	r.bin.Free()
 	r.bin = bufferex.Binary{}
+	for i := range r.items { r.items[i].reset() }
+	r.items = r.items[:0]
+	r.page.reset()
 	resps.Put(r)
 }
-
@@ -0,0 +1,198 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package kvrpc
+
+import "io"
+import "bufio"
+import "github.com/vmihailenco/msgpack"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+
+/*
+The maximum number of keys per page of a scan. If values are requested, the
+pages are smaller.
+
+In addition, a page is cut, once its keys and values exceed ScanPageBytes.
+A page holds at least one key, so a single larger value is sent on its own.
+*/
+const (
+	ScanPage = 1024
+	ScanPageValues = 64
+	ScanPageBytes = ChunkSize
+)
+
+func writeScan(enc *msgpack.Encoder, op uint, bucket []byte, r *bucketstore.ScanRange) error {
+	return enc.EncodeMulti(op,bucket,r.Prefix,r.Start,r.End,r.Limit,r.Values)
+}
+func readScan(dec *msgpack.Decoder, bucket *[]byte, r *bucketstore.ScanRange) error {
+	return dec.DecodeMulti(bucket,&r.Prefix,&r.Start,&r.End,&r.Limit,&r.Values)
+}
+
+/* One page of a scan. The keys and values are stored back to back in buf. */
+type scanPage struct{
+	code uint
+	msg string
+	more bool
+	cursor []byte
+	buf []byte
+	ofs []int
+	tmp []byte
+}
+func (s *scanPage) reset() {
+	s.code = cOk
+	s.msg = ""
+	s.more = false
+	s.cursor = s.cursor[:0]
+	s.buf = s.buf[:0]
+	s.ofs = s.ofs[:0]
+}
+func (s *scanPage) add(key, value []byte) {
+	s.buf = append(s.buf,key...)
+	s.ofs = append(s.ofs,len(s.buf))
+	s.buf = append(s.buf,value...)
+	s.ofs = append(s.ofs,len(s.buf))
+}
+func (s *scanPage) each(targ func(key, value []byte)) (n int) {
+	prev := 0
+	for i := 0; i+1<len(s.ofs); i += 2 {
+		targ(s.buf[prev:s.ofs[i]],s.buf[s.ofs[i]:s.ofs[i+1]])
+		prev = s.ofs[i+1]
+		n++
+	}
+	return
+}
+func (s *scanPage) write(enc *msgpack.Encoder, bw *bufio.Writer) (err error) {
+	defer s.reset()
+	err = enc.EncodeMulti(s.code,s.msg,s.more,s.cursor,uint(len(s.ofs)/2))
+	if err!=nil { return }
+	prev := 0
+	for i := 0; i+1<len(s.ofs); i += 2 {
+		value := s.buf[s.ofs[i]:s.ofs[i+1]]
+		err = enc.EncodeMulti(s.buf[prev:s.ofs[i]],uint(len(value)))
+		if err!=nil { return }
+		_,err = bw.Write(value)
+		if err!=nil { return }
+		prev = s.ofs[i+1]
+	}
+	return
+}
+func (s *scanPage) read(dec *msgpack.Decoder, br *bufio.Reader) error {
+	var n,vl uint
+	s.reset()
+	err := dec.DecodeMulti(&s.code,&s.msg,&s.more,&s.cursor,&n)
+	if err!=nil { return err }
+	if n>ScanPage { return EProtocol }
+	for ; n>0; n-- {
+		err = dec.DecodeMulti(&s.tmp,&vl)
+		if err!=nil { return err }
+		s.buf = append(s.buf,s.tmp...)
+		s.ofs = append(s.ofs,len(s.buf))
+		l := len(s.buf)
+		if cap(s.buf)-l < int(vl) {
+			nb := make([]byte,l,(l+int(vl))*2)
+			copy(nb,s.buf)
+			s.buf = nb
+		}
+		s.buf = s.buf[:l+int(vl)]
+		_,err = io.ReadFull(br,s.buf[l:])
+		if err!=nil { return err }
+		s.ofs = append(s.ofs,len(s.buf))
+	}
+	return nil
+}
+
+func scan(b GBucket, rr *reqCtx) {
+	p := &rr.page
+	p.reset()
+	sc,ok := b.(bucketstore.BucketScanner)
+	if !ok { p.code = cUnsupported; return }
+	page := ScanPage
+	if rr.rng.Values { page = ScanPageValues }
+	if rr.rng.Limit<=0 || rr.rng.Limit>page { rr.rng.Limit = page }
+	full := false
+	cursor,e := sc.BucketScan(rr.bucket,&rr.rng,func(key, value []byte) {
+		if full { return }
+		p.add(key,value)
+		if len(p.buf)<ScanPageBytes { return }
+		/* Resume right after this key. */
+		full = true
+		p.cursor = append(append(p.cursor[:0],key...),0)
+	})
+	p.code,p.msg = e2c(e)
+	if e!=nil {
+		p.buf = p.buf[:0]
+		p.ofs = p.ofs[:0]
+		p.cursor = p.cursor[:0]
+		return
+	}
+	if full { p.more = true; return }
+	p.more = cursor!=nil
+	p.cursor = append(p.cursor[:0],cursor...)
+}
+
+/* ------------------------------------------------------------ */
+
+func (c *Client) scanPage(bucket []byte, r *bucketstore.ScanRange, targ func(key, value []byte)) (cursor []byte, n int, err error) {
+	o := reqs.Get().(*req)
+	defer o.free()
+	i := resps.Get().(*resp)
+	defer i.free()
+	
+	o.single(uScan,bucket,nil,nil,0)
+	o.scan = r
+	i.mode = mScan
+	
+	err = c.Cli.DoDeadline(o,i,deadline())
+	if err!=nil { c.renegotiate(); return }
+	err = c2e(i.page.code,i.page.msg)
+	if err!=nil { return }
+	n = i.page.each(targ)
+	if i.page.more { cursor = append([]byte{},i.page.cursor...) }
+	return
+}
+
+/*
+Scans a remote bucket. The scan is transferred in pages of up to ScanPage keys
+(or ScanPageValues, if values are requested) and about ScanPageBytes.
+Requires protocol version 2.
+*/
+func (c *Client) BucketScan(bucket []byte, r *bucketstore.ScanRange, targ func(key, value []byte)) ([]byte, error) {
+	v,err := c.Version()
+	if err!=nil { return nil,err }
+	if v<Version2 { return nil,EUnsupported }
+	
+	rng := *r
+	left := r.Limit
+	for {
+		if r.Limit>0 { rng.Limit = left }
+		cursor,n,err := c.scanPage(bucket,&rng,targ)
+		if err!=nil || cursor==nil { return nil,err }
+		if r.Limit>0 {
+			left -= n
+			if left<=0 { return cursor,nil }
+		}
+		rng.Start = cursor
+	}
+}
+
+var _ bucketstore.BucketScanner = (*Client)(nil)
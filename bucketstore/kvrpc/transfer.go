@@ -0,0 +1,81 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package kvrpc
+
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "sync"
+import "time"
+
+/* Transfers, that have not been touched for this duration, are dropped. */
+const TransferTimeout = time.Minute
+
+/*
+The state of a chunked transfer. A get-transfer holds the value read from the
+bucket, a put-transfer collects the chunks until the final one arrived.
+*/
+type transfer struct{
+	bin bufferex.Binary
+	buf []byte
+	touched time.Time
+}
+func (t *transfer) release() {
+	t.bin.Free()
+	t.bin = bufferex.Binary{}
+	t.buf = nil
+}
+
+type transfers struct{
+	mu  sync.Mutex
+	seq uint64
+	m   map[uint64]*transfer
+}
+func newTransfers() *transfers {
+	return &transfers{m:make(map[uint64]*transfer)}
+}
+func (t *transfers) add(x *transfer) uint64 {
+	t.mu.Lock(); defer t.mu.Unlock()
+	now := time.Now()
+	for id,o := range t.m {
+		if now.Sub(o.touched) > TransferTimeout {
+			delete(t.m,id)
+			o.release()
+		}
+	}
+	t.seq++
+	x.touched = now
+	t.m[t.seq] = x
+	return t.seq
+}
+func (t *transfers) get(id uint64) *transfer {
+	t.mu.Lock(); defer t.mu.Unlock()
+	x := t.m[id]
+	if x!=nil { x.touched = time.Now() }
+	return x
+}
+func (t *transfers) remove(id uint64) *transfer {
+	t.mu.Lock(); defer t.mu.Unlock()
+	x := t.m[id]
+	delete(t.m,id)
+	return x
+}
@@ -49,6 +49,11 @@ func (p Provider) RegisterAs(pname string) {
 }
 
 type NetKVMap struct {
+	// If set, it is called for sessions with a Writer but without WriterEx,
+	// so that it can fill in an emulation (see package expiry).
+	// Set it before any store is offered.
+	EmulateExpiry func(bucket []byte, sess *Session) error
+	
 	mtx sync.RWMutex
 	nwk map[string]*Session
 	glo map[string]bool
@@ -114,6 +119,10 @@ func (n *NetKVMap) Offer(provider string, bucket, meta []byte,global bool) bool
 	if !ok { return false }
 	conn,err := p(bucket,meta)
 	if err!=nil { return false }
+	if conn.WriterEx==nil && conn.Writer!=nil && conn.Reader!=nil && n.EmulateExpiry!=nil {
+		err = n.EmulateExpiry(bucket,conn)
+		if err!=nil { conn.Close(); return false }
+	}
 	
 	rej,_ := n.put(bucket,conn,global)
 	if rej!=nil { rej.Close() }
@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+A netkv provider, that stores the bucket in a directory (usually a network
+filesystem, shared by the nodes of a location).
+
+Every key is stored as a file, written to a temporary file first and then
+renamed, so that readers never see partial values. The expiration time is
+stored in a sidecar file next to the value (with the suffix ".exp").
+Expired keys are hidden by BucketGet and removed by a background sweeper.
+*/
+package netdir
+
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netkv"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv/health"
+import "github.com/vmihailenco/msgpack"
+import "crypto/sha256"
+import "encoding/binary"
+import "encoding/hex"
+import "path/filepath"
+import "io/ioutil"
+import "strings"
+import "bytes"
+import "time"
+import "sync"
+import "os"
+
+const PROVIDER = "dir"
+
+const expSuffix = ".exp"
+
+/* The interval of the sweeper. */
+var SweepInterval = time.Minute*10
+
+type Bucket struct {
+	Path string
+	Sync bool
+	
+	stop chan struct{}
+	once sync.Once
+}
+
+func Open(path string, fsync bool) (*Bucket,error) {
+	err := os.MkdirAll(filepath.Join(path,"tmp"),0755)
+	if err!=nil { return nil,err }
+	return &Bucket{Path:path,Sync:fsync,stop:make(chan struct{})},nil
+}
+
+/*
+Returns the filename of the key. Short keys are hex-encoded, longer keys are
+hashed to stay within the limits of the filesystem. The files are spread over
+256 directories by the first byte of the hash, as the keys themselves often
+share their first or last bytes.
+*/
+func (b *Bucket) file(key []byte) string {
+	h := sha256.Sum256(key)
+	var name string
+	if len(key)<=64 {
+		name = "k"+hex.EncodeToString(key)
+	} else {
+		name = "h"+hex.EncodeToString(h[:])
+	}
+	return filepath.Join(b.Path,hex.EncodeToString(h[:1]),name)
+}
+
+func (b *Bucket) write(name string, data []byte) error {
+	f,err := ioutil.TempFile(filepath.Join(b.Path,"tmp"),"put")
+	if err!=nil { return err }
+	_,err = f.Write(data)
+	if err==nil && b.Sync { err = f.Sync() }
+	if e := f.Close(); err==nil { err = e }
+	if err==nil {
+		err = os.Rename(f.Name(),name)
+		if os.IsNotExist(err) {
+			err = os.MkdirAll(filepath.Dir(name),0755)
+			if err==nil { err = os.Rename(f.Name(),name) }
+		}
+	}
+	if err!=nil { os.Remove(f.Name()) }
+	return err
+}
+func removeIfExists(name string) error {
+	err := os.Remove(name)
+	if os.IsNotExist(err) { return nil }
+	return err
+}
+
+func readExpire(name string) (uint64,bool) {
+	data,err := ioutil.ReadFile(name+expSuffix)
+	if err!=nil || len(data)!=8 { return 0,false }
+	return binary.BigEndian.Uint64(data),true
+}
+func expired(exp uint64) bool {
+	return exp<=uint64(time.Now().Unix())
+}
+
+func (b *Bucket) BucketGet(bucket, key []byte) (bufferex.Binary, error) {
+	name := b.file(key)
+	if exp,ok := readExpire(name); ok && expired(exp) { return bufferex.Binary{},bucketstore.ENotFound }
+	f,err := os.Open(name)
+	if os.IsNotExist(err) { return bufferex.Binary{},bucketstore.ENotFound }
+	if err!=nil { return bufferex.Binary{},err }
+	defer f.Close()
+	st,err := f.Stat()
+	if err!=nil { return bufferex.Binary{},err }
+	buf := bufferex.AllocBinary(int(st.Size()))
+	_,err = f.ReadAt(buf.Bytes(),0)
+	if err!=nil { buf.Free(); return bufferex.Binary{},err }
+	return buf,nil
+}
+func (b *Bucket) BucketPut(bucket, key, value []byte) error {
+	name := b.file(key)
+	/* Remove the sidecar first. Otherwise the new value could be hidden. */
+	err := removeIfExists(name+expSuffix)
+	if err!=nil { return err }
+	return b.write(name,value)
+}
+func (b *Bucket) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) error {
+	name := b.file(key)
+	var exp [8]byte
+	binary.BigEndian.PutUint64(exp[:],expiresAt)
+	err := b.write(name+expSuffix,exp[:])
+	if err!=nil { return err }
+	return b.write(name,value)
+}
+func (b *Bucket) BucketDelete(bucket, key []byte) error {
+	name := b.file(key)
+	err := removeIfExists(name)
+	if err!=nil { return err }
+	return removeIfExists(name+expSuffix)
+}
+
+var _ bucketstore.Bucket = (*Bucket)(nil)
+var _ bucketstore.BucketWEx = (*Bucket)(nil)
+
+/* Removes all expired keys (and stale temporary files). */
+func (b *Bucket) Sweep() error {
+	return filepath.Walk(b.Path,func(path string, info os.FileInfo, err error) error {
+		if err!=nil { return nil }
+		if info.IsDir() { return nil }
+		if filepath.Base(filepath.Dir(path))=="tmp" {
+			if time.Since(info.ModTime())>time.Hour { os.Remove(path) }
+			return nil
+		}
+		if !strings.HasSuffix(path,expSuffix) { return nil }
+		name := strings.TrimSuffix(path,expSuffix)
+		if exp,ok := readExpire(name); ok && expired(exp) {
+			os.Remove(name)
+			os.Remove(path)
+		}
+		return nil
+	})
+}
+func (b *Bucket) sweeper() {
+	t := time.NewTicker(SweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C: b.Sweep()
+		case <-b.stop: return
+		}
+	}
+}
+func (b *Bucket) Close() error {
+	b.once.Do(func() { close(b.stop) })
+	return nil
+}
+
+func Generate(path string, fsync bool) []byte {
+	var buf bytes.Buffer
+	err := msgpack.NewEncoder(&buf).EncodeMulti(path,fsync)
+	if err!=nil { panic(err) }
+	return buf.Bytes()
+}
+func loader(bucket, meta []byte) (*netkv.Session, error) {
+	var path string
+	var fsync bool
+	err := msgpack.NewDecoder(bytes.NewReader(meta)).DecodeMulti(&path,&fsync)
+	if err!=nil { return nil,err }
+	b,err := Open(path,fsync)
+	if err!=nil { return nil,err }
+	go b.sweeper()
+	hc := &health.HealthChecker{Path:path,Bucket:append([]byte(nil),bucket...)}
+	hc.Start()
+	return &netkv.Session{
+		Closer: closer{b,hc},
+		Reader: b,
+		Writer: b,
+		WriterEx: b,
+	},nil
+}
+
+type closer struct{
+	b *Bucket
+	hc *health.HealthChecker
+}
+func (c closer) Close() error {
+	c.hc.Stop()
+	return c.b.Close()
+}
+
+func init() {
+	netkv.Provider(loader).RegisterAs(PROVIDER)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package netdir
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/storetest"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+import "fmt"
+import "bytes"
+import "time"
+import "os"
+
+func tempBucket(t *testing.T) (*Bucket,func()) {
+	dir,err := ioutil.TempDir("","netdir")
+	if err!=nil { t.Fatal(err) }
+	b,err := Open(dir,false)
+	if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+	return b,func() {
+		b.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func exists(name string) bool {
+	_,err := os.Stat(name)
+	return err==nil
+}
+
+func expectGet(t *testing.T, b *Bucket, key []byte, value string) {
+	bin,err := b.BucketGet(nil,key)
+	defer bin.Free()
+	if value=="" {
+		if err!=bucketstore.ENotFound { t.Errorf("BucketGet(%q) -> %q %v, expected ENotFound",key,bin.Bytes(),err) }
+		return
+	}
+	if err!=nil || string(bin.Bytes())!=value { t.Errorf("BucketGet(%q) -> %q %v, expected %q",key,bin.Bytes(),err,value) }
+}
+
+func TestPutExpire(t *testing.T) {
+	b,done := tempBucket(t)
+	defer done()
+	now := uint64(time.Now().Unix())
+	key := []byte("key")
+	name := b.file(key)
+	
+	if err := b.BucketPutExpire(nil,key,[]byte("v1"),now+3600); err!=nil { t.Fatal(err) }
+	if !exists(name+expSuffix) { t.Errorf("BucketPutExpire did not write the sidecar") }
+	expectGet(t,b,key,"v1")
+	
+	/* A plain put must not be hidden by the old sidecar. */
+	if err := b.BucketPut(nil,key,[]byte("v2")); err!=nil { t.Fatal(err) }
+	if exists(name+expSuffix) { t.Errorf("BucketPut did not remove the sidecar") }
+	expectGet(t,b,key,"v2")
+	
+	if err := b.BucketPutExpire(nil,key,[]byte("v3"),now-1); err!=nil { t.Fatal(err) }
+	expectGet(t,b,key,"")
+}
+
+func TestSweep(t *testing.T) {
+	b,done := tempBucket(t)
+	defer done()
+	now := uint64(time.Now().Unix())
+	live,dead,plain := []byte("live"),[]byte("dead"),[]byte("plain")
+	b.BucketPutExpire(nil,live,[]byte("x"),now+3600)
+	b.BucketPutExpire(nil,dead,[]byte("x"),now-1)
+	b.BucketPut(nil,plain,[]byte("x"))
+	
+	if !exists(b.file(dead)) { t.Fatalf("the expired value is gone before the sweep") }
+	if err := b.Sweep(); err!=nil { t.Fatal(err) }
+	if exists(b.file(dead)) || exists(b.file(dead)+expSuffix) { t.Errorf("Sweep did not remove the expired key") }
+	if !exists(b.file(live)) || !exists(b.file(live)+expSuffix) { t.Errorf("Sweep removed a live key") }
+	if !exists(b.file(plain)) { t.Errorf("Sweep removed a key without expiration") }
+}
+
+func TestDelete(t *testing.T) {
+	b,done := tempBucket(t)
+	defer done()
+	long := bytes.Repeat([]byte("long"),40)
+	for _,key := range [][]byte{[]byte("short"),long} {
+		b.BucketPutExpire(nil,key,[]byte("x"),uint64(time.Now().Unix())+3600)
+		if err := b.BucketDelete(nil,key); err!=nil { t.Errorf("BucketDelete(%q): %v",key,err) }
+		if exists(b.file(key)) || exists(b.file(key)+expSuffix) { t.Errorf("BucketDelete(%q) left files behind",key) }
+		expectGet(t,b,key,"")
+		if err := b.BucketDelete(nil,key); err!=nil { t.Errorf("BucketDelete(%q) of a missing key: %v",key,err) }
+	}
+}
+
+func TestConformance(t *testing.T) {
+	s := &storetest.BucketSuite{New:func(t *testing.T) (bucketstore.Bucket,func()) {
+		return tempBucket(t)
+	}}
+	s.Run(t)
+}
+
+/* Keys, that share their last byte (like the chybrid sections), are spread nevertheless. */
+func TestShards(t *testing.T) {
+	b := &Bucket{Path:"base"}
+	dirs := make(map[string]bool)
+	for i := 0; i<64; i++ {
+		dirs[filepath.Dir(b.file([]byte(fmt.Sprintf("<%d@example.com>h",i))))] = true
+	}
+	if len(dirs)<32 {
+		t.Errorf("64 keys in %d directories",len(dirs))
+	}
+}
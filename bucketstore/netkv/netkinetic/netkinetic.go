@@ -92,7 +92,7 @@ func (b *ReportingBucket) checkHealth() (cls bool){
 	if err!=nil || stat.Code!=kinetic.OK{ return }
 	cap := log.Capacity
 	pf := int64(float64(cap.CapacityInBytes)*(1.0-float64(cap.PortionFull)))
-	healthmap.IssueHealth(healthmap.Health{b.Name,pf>=(1<<25)})
+	healthmap.IssueHealth(healthmap.Health{Name:b.Name,Writable:pf>=(1<<25),Free:uint64(pf),Total:uint64(cap.CapacityInBytes)})
 	return
 }
 func (b *ReportingBucket) CheckLoop() {
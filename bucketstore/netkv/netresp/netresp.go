@@ -0,0 +1,261 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+A netkv provider, that talks to any server speaking the Redis protocol (RESP).
+
+The keys of the bucket are prefixed with Options.Prefix, so that several
+buckets can share one server. BucketPutExpire uses "SET key value EXAT t",
+which requires Redis 6.2 or a compatible server.
+*/
+package netresp
+
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netkv"
+import "github.com/vmihailenco/msgpack"
+import "strconv"
+import "errors"
+import "bufio"
+import "bytes"
+import "time"
+import "net"
+import "io"
+
+const PROVIDER = "resp"
+
+var EProtocol = errors.New("netresp: protocol error")
+
+/* An error reply of the server. */
+type ServerError string
+func (s ServerError) Error() string { return string(s) }
+
+type Options struct {
+	Network, Addr string
+	Password string
+	DB int
+	Prefix string
+	
+	Timeout time.Duration
+	MaxIdle int
+}
+
+type conn struct{
+	c  net.Conn
+	br *bufio.Reader
+	bw *bufio.Writer
+}
+
+func (c *conn) writeArgs(args ...[]byte) error {
+	c.bw.WriteByte('*')
+	c.bw.WriteString(strconv.Itoa(len(args)))
+	c.bw.WriteString("\r\n")
+	for _,a := range args {
+		c.bw.WriteByte('$')
+		c.bw.WriteString(strconv.Itoa(len(a)))
+		c.bw.WriteString("\r\n")
+		c.bw.Write(a)
+		c.bw.WriteString("\r\n")
+	}
+	return c.bw.Flush()
+}
+
+func (c *conn) line() ([]byte,error) {
+	l,err := c.br.ReadSlice('\n')
+	if err!=nil { return nil,err }
+	if len(l)<3 || l[len(l)-2]!='\r' { return nil,EProtocol }
+	return l[:len(l)-2],nil
+}
+
+/*
+Reads a reply. Bulk strings are returned as Binary, a nil bulk string
+as ENotFound. Array replies are skipped.
+*/
+func (c *conn) reply() (bin bufferex.Binary, err error) {
+	l,err := c.line()
+	if err!=nil { return }
+	switch l[0] {
+	case '+',':': return
+	case '-': err = ServerError(l[1:]); return
+	case '$':
+		var n int
+		n,err = strconv.Atoi(string(l[1:]))
+		if err!=nil { err = EProtocol; return }
+		if n<0 { err = bucketstore.ENotFound; return }
+		bin = bufferex.AllocBinary(n)
+		_,err = io.ReadFull(c.br,bin.Bytes())
+		if err==nil { _,err = c.br.Discard(2) }
+		if err!=nil { bin.Free(); bin = bufferex.Binary{} }
+		return
+	case '*':
+		var n int
+		n,err = strconv.Atoi(string(l[1:]))
+		if err!=nil { err = EProtocol; return }
+		for i := 0; i<n; i++ {
+			var b bufferex.Binary
+			b,err = c.reply()
+			b.Free()
+			if err!=nil && err!=bucketstore.ENotFound { return }
+		}
+		err = nil
+		return
+	}
+	err = EProtocol
+	return
+}
+
+type Bucket struct {
+	Opts Options
+	idle chan *conn
+}
+
+func New(o Options) *Bucket {
+	if o.Network=="" { o.Network = "tcp" }
+	if o.MaxIdle<=0 { o.MaxIdle = 16 }
+	return &Bucket{Opts:o,idle:make(chan *conn,o.MaxIdle)}
+}
+
+func (b *Bucket) dial() (*conn,error) {
+	nc,err := net.DialTimeout(b.Opts.Network,b.Opts.Addr,b.timeout())
+	if err!=nil { return nil,err }
+	c := &conn{nc,bufio.NewReader(nc),bufio.NewWriter(nc)}
+	if b.Opts.Password!="" {
+		err = b.do(c,[]byte("AUTH"),[]byte(b.Opts.Password))
+	}
+	if err==nil && b.Opts.DB!=0 {
+		err = b.do(c,[]byte("SELECT"),[]byte(strconv.Itoa(b.Opts.DB)))
+	}
+	if err!=nil { nc.Close(); return nil,err }
+	return c,nil
+}
+func (b *Bucket) timeout() time.Duration {
+	if b.Opts.Timeout<=0 { return time.Second*10 }
+	return b.Opts.Timeout
+}
+func (b *Bucket) get() (*conn,error) {
+	select {
+	case c := <-b.idle: return c,nil
+	default: return b.dial()
+	}
+}
+func (b *Bucket) put(c *conn) {
+	select {
+	case b.idle <- c:
+	default: c.c.Close()
+	}
+}
+
+func (b *Bucket) do(c *conn, args ...[]byte) error {
+	bin,err := b.doBin(c,args...)
+	bin.Free()
+	return err
+}
+func (b *Bucket) doBin(c *conn, args ...[]byte) (bufferex.Binary,error) {
+	c.c.SetDeadline(time.Now().Add(b.timeout()))
+	err := c.writeArgs(args...)
+	if err!=nil { return bufferex.Binary{},err }
+	return c.reply()
+}
+
+/* Performs a command on a pooled connection. */
+func (b *Bucket) command(args ...[]byte) (bufferex.Binary,error) {
+	c,err := b.get()
+	if err!=nil { return bufferex.Binary{},err }
+	bin,err := b.doBin(c,args...)
+	switch err.(type) {
+	case nil,ServerError: b.put(c)
+	default:
+		if err==bucketstore.ENotFound { b.put(c) } else { c.c.Close() }
+	}
+	return bin,err
+}
+
+func (b *Bucket) key(key []byte) []byte {
+	return append([]byte(b.Opts.Prefix),key...)
+}
+
+func (b *Bucket) BucketGet(bucket, key []byte) (bufferex.Binary, error) {
+	return b.command([]byte("GET"),b.key(key))
+}
+func (b *Bucket) BucketPut(bucket, key, value []byte) error {
+	_,err := b.command([]byte("SET"),b.key(key),value)
+	return err
+}
+func (b *Bucket) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) error {
+	_,err := b.command([]byte("SET"),b.key(key),value,[]byte("EXAT"),[]byte(strconv.FormatUint(expiresAt,10)))
+	return err
+}
+func (b *Bucket) BucketDelete(bucket, key []byte) error {
+	_,err := b.command([]byte("DEL"),b.key(key))
+	return err
+}
+
+var _ bucketstore.Bucket = (*Bucket)(nil)
+var _ bucketstore.BucketWEx = (*Bucket)(nil)
+
+func (b *Bucket) Close() error {
+	for {
+		select {
+		case c := <-b.idle: c.c.Close()
+		default: return nil
+		}
+	}
+}
+
+func Generate(o Options) []byte {
+	var buf bytes.Buffer
+	err := msgpack.NewEncoder(&buf).EncodeMulti(
+		o.Network,
+		o.Addr,
+		o.Password,
+		o.DB,
+		o.Prefix,
+		o.Timeout,
+		o.MaxIdle,
+	)
+	if err!=nil { panic(err) }
+	return buf.Bytes()
+}
+func loader(bucket, meta []byte) (*netkv.Session, error) {
+	var o Options
+	err := msgpack.NewDecoder(bytes.NewReader(meta)).DecodeMulti(
+		&o.Network,
+		&o.Addr,
+		&o.Password,
+		&o.DB,
+		&o.Prefix,
+		&o.Timeout,
+		&o.MaxIdle,
+	)
+	if err!=nil { return nil,err }
+	b := New(o)
+	return &netkv.Session{
+		Closer: b,
+		Reader: b,
+		Writer: b,
+		WriterEx: b,
+	},nil
+}
+func init() {
+	netkv.Provider(loader).RegisterAs(PROVIDER)
+}
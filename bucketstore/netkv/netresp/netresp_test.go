@@ -0,0 +1,165 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package netresp
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "testing"
+import "strconv"
+import "strings"
+import "bufio"
+import "sync"
+import "net"
+import "fmt"
+import "io"
+
+/* A minimal in-process server, speaking RESP. */
+type fakeServer struct{
+	ln    net.Listener
+	mu    sync.Mutex
+	cmds  []string
+	data  map[string]string
+	conns int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { t.Fatal(err) }
+	f := &fakeServer{ln:ln,data:make(map[string]string)}
+	go f.accept()
+	return f
+}
+func (f *fakeServer) accept() {
+	for {
+		c,err := f.ln.Accept()
+		if err!=nil { return }
+		f.mu.Lock(); f.conns++; f.mu.Unlock()
+		go f.serve(c)
+	}
+}
+func readArgs(br *bufio.Reader) ([]string,error) {
+	var n int
+	if _,err := fmt.Fscanf(br,"*%d\r\n",&n); err!=nil { return nil,err }
+	args := make([]string,n)
+	for i := range args {
+		var l int
+		if _,err := fmt.Fscanf(br,"$%d\r\n",&l); err!=nil { return nil,err }
+		b := make([]byte,l+2)
+		if _,err := io.ReadFull(br,b); err!=nil { return nil,err }
+		args[i] = string(b[:l])
+	}
+	return args,nil
+}
+func (f *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for {
+		args,err := readArgs(br)
+		if err!=nil { return }
+		f.mu.Lock()
+		f.cmds = append(f.cmds,strings.Join(args," "))
+		reply := f.handle(args)
+		f.mu.Unlock()
+		io.WriteString(c,reply)
+	}
+}
+func (f *fakeServer) handle(args []string) string {
+	switch args[0] {
+	case "AUTH","SELECT": return "+OK\r\n"
+	case "GET":
+		v,ok := f.data[args[1]]
+		if !ok { return "$-1\r\n" }
+		return "$"+strconv.Itoa(len(v))+"\r\n"+v+"\r\n"
+	case "SET":
+		if strings.HasSuffix(args[1],"readonly") { return "-READONLY You can't write against a read only replica.\r\n" }
+		f.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		_,ok := f.data[args[1]]
+		delete(f.data,args[1])
+		if ok { return ":1\r\n" }
+		return ":0\r\n"
+	}
+	return "-ERR unknown command '"+args[0]+"'\r\n"
+}
+func (f *fakeServer) last() string {
+	f.mu.Lock(); defer f.mu.Unlock()
+	if len(f.cmds)==0 { return "" }
+	return f.cmds[len(f.cmds)-1]
+}
+
+func TestCommands(t *testing.T) {
+	f := newFakeServer(t)
+	defer f.ln.Close()
+	b := New(Options{Addr:f.ln.Addr().String(),Prefix:"p:",Password:"secret",DB:3})
+	defer b.Close()
+	
+	if err := b.BucketPutExpire(nil,[]byte("k"),[]byte("v"),1700000000); err!=nil { t.Fatal(err) }
+	if c := f.last(); c!="SET p:k v EXAT 1700000000" { t.Errorf("BucketPutExpire sent %q",c) }
+	f.mu.Lock()
+	if len(f.cmds)<3 || f.cmds[0]!="AUTH secret" || f.cmds[1]!="SELECT 3" { t.Errorf("the connection was set up with %q",f.cmds) }
+	f.mu.Unlock()
+	
+	bin,err := b.BucketGet(nil,[]byte("k"))
+	if err!=nil || string(bin.Bytes())!="v" { t.Errorf("BucketGet -> %q %v",bin.Bytes(),err) }
+	bin.Free()
+	
+	if err := b.BucketDelete(nil,[]byte("k")); err!=nil { t.Errorf("BucketDelete: %v",err) }
+	if c := f.last(); c!="DEL p:k" { t.Errorf("BucketDelete sent %q",c) }
+}
+
+func TestNilReply(t *testing.T) {
+	f := newFakeServer(t)
+	defer f.ln.Close()
+	b := New(Options{Addr:f.ln.Addr().String()})
+	defer b.Close()
+	
+	bin,err := b.BucketGet(nil,[]byte("missing"))
+	if err!=bucketstore.ENotFound || bin.Bytes()!=nil { t.Errorf("BucketGet -> %q %v, expected ENotFound",bin.Bytes(),err) }
+	
+	/* An empty value is not a nil reply. */
+	b.BucketPut(nil,[]byte("empty"),nil)
+	bin,err = b.BucketGet(nil,[]byte("empty"))
+	if err!=nil || len(bin.Bytes())!=0 { t.Errorf("BucketGet -> %q %v, expected an empty value",bin.Bytes(),err) }
+	bin.Free()
+}
+
+func TestServerError(t *testing.T) {
+	f := newFakeServer(t)
+	defer f.ln.Close()
+	b := New(Options{Addr:f.ln.Addr().String()})
+	defer b.Close()
+	
+	err := b.BucketPut(nil,[]byte("readonly"),[]byte("v"))
+	if se,ok := err.(ServerError); !ok || !strings.HasPrefix(string(se),"READONLY") {
+		t.Errorf("BucketPut -> %v, expected a READONLY ServerError",err)
+	}
+	
+	/* The connection is still in sync and is reused. */
+	if err := b.BucketPut(nil,[]byte("k"),[]byte("v")); err!=nil { t.Errorf("BucketPut: %v",err) }
+	bin,err := b.BucketGet(nil,[]byte("k"))
+	if err!=nil || string(bin.Bytes())!="v" { t.Errorf("BucketGet -> %q %v",bin.Bytes(),err) }
+	bin.Free()
+	f.mu.Lock(); defer f.mu.Unlock()
+	if f.conns!=1 { t.Errorf("%d connections were opened, expected 1",f.conns) }
+}
@@ -24,9 +24,11 @@ package netmodel
 
 
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/selerr"
 import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
 
 var notImpl = bucketstore.EFail
+var notBucket = selerr.ENoSuchBucket
 
 type Adapter struct {
 	Holder
@@ -51,4 +53,11 @@ func (s Adapter) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) er
 	if b.WriterEx==nil { return notImpl }
 	return b.WriterEx.BucketPutExpire(bucket,key,value,expiresAt)
 }
+func (s Adapter) BucketScan(bucket []byte, r *bucketstore.ScanRange, targ func(key, value []byte)) ([]byte, error) {
+	b,t := s.GetHolder(bucket)
+	if t==None { return nil,notBucket }
+	sc,ok := b.Reader.(bucketstore.BucketScanner)
+	if !ok { return nil,notImpl }
+	return sc.BucketScan(bucket,r,targ)
+}
 
@@ -24,9 +24,11 @@ package netsel
 
 
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/selerr"
 import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
 
 var notImpl = bucketstore.EFail
+var notBucket = selerr.ENoSuchBucket
 
 
 func (n *NodeSelector) BucketGet(bucket, key []byte) (bufferex.Binary, error) {
@@ -49,4 +51,11 @@ func (n *NodeSelector) BucketPutExpire(bucket, key, value []byte, expiresAt uint
 	if b.WriterEx==nil { return notImpl }
 	return b.WriterEx.BucketPutExpire(bucket,key,value,expiresAt)
 }
+func (n *NodeSelector) BucketScan(bucket []byte, r *bucketstore.ScanRange, targ func(key, value []byte)) ([]byte, error) {
+	b,ok := n.FastLookup(bucket)
+	if !ok { return nil,notBucket }
+	sc,ok := b.Reader.(bucketstore.BucketScanner)
+	if !ok { return nil,notImpl }
+	return sc.BucketScan(bucket,r,targ)
+}
 
@@ -0,0 +1,165 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package netsel
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/kvrpc"
+import "errors"
+import "sync"
+import "time"
+
+var EBreakerOpen = errors.New("EBreakerOpen")
+
+type BreakerState uint
+
+const (
+	Closed BreakerState = iota
+	Open
+	HalfOpen
+)
+func (s BreakerState) String() string {
+	switch s {
+	case Closed: return "closed"
+	case Open: return "open"
+	case HalfOpen: return "half-open"
+	}
+	return "?"
+}
+
+/*
+Configures the circuit breakers of the nodes. Zero values select the defaults.
+
+A breaker opens after Failures consecutive failures. A call, that takes longer
+than SlowCall, counts as a failure, even if it succeeds. While the breaker is
+open, calls to the node fail immediately with EBreakerOpen, so that the
+holders after it are used. After OpenFor, a single probe call is let through
+(half-open): if it succeeds, the breaker closes, otherwise it opens again.
+*/
+type BreakerConfig struct{
+	Failures int           // default 5
+	SlowCall time.Duration // default 1 second
+	OpenFor  time.Duration // default 10 seconds
+}
+func (c *BreakerConfig) failures() int {
+	if c.Failures<=0 { return 5 }
+	return c.Failures
+}
+func (c *BreakerConfig) slowCall() time.Duration {
+	if c.SlowCall<=0 { return time.Second }
+	return c.SlowCall
+}
+func (c *BreakerConfig) openFor() time.Duration {
+	if c.OpenFor<=0 { return time.Second*10 }
+	return c.OpenFor
+}
+
+/* The state of a breaker, as reported by NodeSelector.Breakers. */
+type BreakerStatus struct{
+	State    BreakerState
+	Failures int       // consecutive failures
+	Trips    uint64    // how often the breaker opened
+	Since    time.Time // when the state was entered
+}
+
+type breaker struct{
+	cfg *BreakerConfig
+	
+	mu      sync.Mutex
+	status  BreakerStatus
+	probing bool
+}
+func newBreaker(cfg *BreakerConfig) *breaker {
+	return &breaker{cfg:cfg,status:BreakerStatus{Since:time.Now()}}
+}
+func (b *breaker) set(s BreakerState) {
+	b.status.State = s
+	b.status.Since = time.Now()
+}
+
+/* Returns true, if the node should not be used right now. */
+func (b *breaker) blocked() bool {
+	b.mu.Lock(); defer b.mu.Unlock()
+	switch b.status.State {
+	case Open: return time.Since(b.status.Since)<b.cfg.openFor()
+	case HalfOpen: return b.probing
+	}
+	return false
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock(); defer b.mu.Unlock()
+	switch b.status.State {
+	case Open:
+		if time.Since(b.status.Since)<b.cfg.openFor() { return false }
+		b.set(HalfOpen)
+		b.probing = true
+		return true
+	case HalfOpen:
+		if b.probing { return false }
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+/*
+Errors, that have been returned by the remote bucket, show that the node is
+alive. Everything else (timeouts, connection errors, overload) is a failure.
+*/
+func isFailure(err error) bool {
+	switch err {
+	case nil,bucketstore.ENotFound,kvrpc.EUnsupported,kvrpc.ENoTransfer: return false
+	}
+	_,remote := err.(kvrpc.RemoteError)
+	return !remote
+}
+
+func (b *breaker) done(err error, d time.Duration) {
+	failed := isFailure(err) || d>b.cfg.slowCall()
+	b.mu.Lock(); defer b.mu.Unlock()
+	if b.status.State==HalfOpen {
+		b.probing = false
+		if failed {
+			b.status.Trips++
+			b.set(Open)
+		} else {
+			b.status.Failures = 0
+			b.set(Closed)
+		}
+		return
+	}
+	if !failed {
+		b.status.Failures = 0
+		return
+	}
+	b.status.Failures++
+	if b.status.State==Closed && b.status.Failures>=b.cfg.failures() {
+		b.status.Trips++
+		b.set(Open)
+	}
+}
+func (b *breaker) get() BreakerStatus {
+	b.mu.Lock(); defer b.mu.Unlock()
+	return b.status
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package netsel
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/kvrpc"
+import "errors"
+import "testing"
+import "time"
+
+var errConn = errors.New("connection refused")
+
+func expectState(t *testing.T, b *breaker, s BreakerState, failures int, trips uint64) {
+	t.Helper()
+	st := b.get()
+	if st.State!=s || st.Failures!=failures || st.Trips!=trips {
+		t.Fatalf("breaker is %v (failures=%d trips=%d), expected %v (failures=%d trips=%d)",
+			st.State,st.Failures,st.Trips,s,failures,trips)
+	}
+}
+
+func TestBreakerDefaults(t *testing.T) {
+	c := new(BreakerConfig)
+	if c.failures()!=5 || c.slowCall()!=time.Second || c.openFor()!=10*time.Second {
+		t.Errorf("defaults: %d %v %v",c.failures(),c.slowCall(),c.openFor())
+	}
+	c = &BreakerConfig{Failures:2,SlowCall:time.Millisecond,OpenFor:time.Minute}
+	if c.failures()!=2 || c.slowCall()!=time.Millisecond || c.openFor()!=time.Minute {
+		t.Errorf("configured: %d %v %v",c.failures(),c.slowCall(),c.openFor())
+	}
+	if Closed.String()!="closed" || Open.String()!="open" || HalfOpen.String()!="half-open" || BreakerState(9).String()!="?" {
+		t.Error("BreakerState.String")
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	for _,err := range []error{nil,bucketstore.ENotFound,kvrpc.EUnsupported,kvrpc.ENoTransfer,kvrpc.RemoteError("disk full")} {
+		if isFailure(err) { t.Errorf("isFailure(%v) = true",err) }
+	}
+	for _,err := range []error{errConn,EBreakerOpen,ErrNoHost} {
+		if !isFailure(err) { t.Errorf("isFailure(%v) = false",err) }
+	}
+}
+
+func TestBreakerOpens(t *testing.T) {
+	b := newBreaker(&BreakerConfig{Failures:3,OpenFor:time.Hour})
+	b.done(errConn,0)
+	b.done(errConn,0)
+	expectState(t,b,Closed,2,0)
+	
+	/* A success resets the consecutive failures. */
+	b.done(nil,0)
+	expectState(t,b,Closed,0,0)
+	
+	/* Errors of the remote bucket don't count. */
+	b.done(errConn,0)
+	b.done(bucketstore.ENotFound,0)
+	b.done(kvrpc.RemoteError("x"),0)
+	expectState(t,b,Closed,0,0)
+	
+	for i := 0; i<3; i++ {
+		if !b.allow() || b.blocked() { t.Fatal("a closed breaker blocks") }
+		b.done(errConn,0)
+	}
+	expectState(t,b,Open,3,1)
+	if b.allow() || !b.blocked() { t.Fatal("an open breaker lets calls through") }
+	
+	/* Calls, that were in flight, when it opened, don't trip it again. */
+	b.done(errConn,0)
+	expectState(t,b,Open,4,1)
+}
+
+func TestBreakerSlowCall(t *testing.T) {
+	b := newBreaker(&BreakerConfig{Failures:2,SlowCall:10*time.Millisecond,OpenFor:time.Hour})
+	b.done(nil,20*time.Millisecond)
+	expectState(t,b,Closed,1,0)
+	b.done(nil,5*time.Millisecond)
+	expectState(t,b,Closed,0,0)
+	b.done(nil,20*time.Millisecond)
+	b.done(bucketstore.ENotFound,20*time.Millisecond)
+	expectState(t,b,Open,2,1)
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	const openFor = 20*time.Millisecond
+	b := newBreaker(&BreakerConfig{Failures:1,OpenFor:openFor})
+	b.done(errConn,0)
+	expectState(t,b,Open,1,1)
+	if b.allow() { t.Fatal("allowed a call before OpenFor") }
+	time.Sleep(2*openFor)
+	
+	/* After OpenFor, the breaker is no longer blocked, and a single probe is let through. */
+	if b.blocked() { t.Fatal("blocked after OpenFor") }
+	if !b.allow() { t.Fatal("no probe after OpenFor") }
+	expectState(t,b,HalfOpen,1,1)
+	if b.allow() || !b.blocked() { t.Fatal("a second probe was let through") }
+	
+	/* A failed probe opens the breaker again. */
+	b.done(errConn,0)
+	expectState(t,b,Open,1,2)
+	if b.allow() { t.Fatal("allowed a call after the failed probe") }
+	time.Sleep(2*openFor)
+	
+	/* A successful probe closes it. */
+	if !b.allow() { t.Fatal("no second probe") }
+	b.done(nil,0)
+	expectState(t,b,Closed,0,2)
+	if !b.allow() || !b.allow() || b.blocked() { t.Fatal("a closed breaker blocks") }
+}
+
+func TestBreakerSlowProbe(t *testing.T) {
+	const openFor = 20*time.Millisecond
+	b := newBreaker(&BreakerConfig{Failures:1,SlowCall:time.Millisecond,OpenFor:openFor})
+	b.done(errConn,0)
+	time.Sleep(2*openFor)
+	if !b.allow() { t.Fatal("no probe after OpenFor") }
+	b.done(nil,time.Second)
+	expectState(t,b,Open,1,2)
+	
+	/* Since is reset, whenever the state changes. */
+	if since := time.Since(b.get().Since); since>openFor { t.Errorf("Since is %v old",since) }
+}
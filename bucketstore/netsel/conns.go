@@ -0,0 +1,330 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package netsel
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/kvrpc"
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "errors"
+import "net"
+import "sync"
+import "sync/atomic"
+import "time"
+
+var eParked = errors.New("connection parked")
+
+/*
+Configures the connections to the remote nodes. Zero values select the defaults.
+
+Every connection is a kvrpc.Client, that multiplexes the requests over one TCP
+connection. Another connection to a node is opened, if all connections to it
+have at least Busy pending requests, up to MaxConns. Connections, that have not
+been used for IdleTimeout, are closed. A negative IdleTimeout disables this.
+*/
+type PoolConfig struct{
+	MaxConns    int           // default 1
+	Busy        int           // default 16
+	IdleTimeout time.Duration // default 5 minutes
+}
+func (c *PoolConfig) maxConns() int {
+	if c.MaxConns<=0 { return 1 }
+	return c.MaxConns
+}
+func (c *PoolConfig) busy() int {
+	if c.Busy<=0 { return 16 }
+	return c.Busy
+}
+func (c *PoolConfig) idleTimeout() time.Duration {
+	if c.IdleTimeout==0 { return time.Minute*5 }
+	return c.IdleTimeout
+}
+
+/* The connection statistics of a node, as reported by NodeSelector.Stats. */
+type NodeStats struct{
+	Conns      int       // connections (clients) in use
+	Open       int       // open TCP connections
+	Dials      uint64
+	DialErrors uint64
+	Requests   uint64
+	Failures   uint64    // failed requests, see isFailure
+	Evictions  uint64    // connections closed, because they were idle
+	Reconnects uint64    // connections closed, because the address of the node changed
+	LastUsed   time.Time
+}
+
+/*
+A fastrpc.Client can't be closed, it reconnects for ever. So, if a connection
+is no longer needed, it is parked instead: The TCP connection is closed, and the
+client blocks in dial(), until it is assigned to a node again.
+*/
+type conn struct{
+	*kvrpc.Client
+	
+	mu    sync.Mutex
+	nd    *node
+	nc    net.Conn
+	ready chan struct{}
+	
+	used  int64 // UnixNano, atomic
+}
+func newConn() *conn {
+	c := &conn{Client:new(kvrpc.Client)}
+	c.Init()
+	c.Cli.Dial = c.dial
+	return c
+}
+func (c *conn) dial(addr string) (net.Conn, error) {
+	c.mu.Lock()
+	for c.nd==nil {
+		ch := c.ready
+		c.mu.Unlock()
+		<- ch
+		c.mu.Lock()
+	}
+	nd := c.nd
+	c.mu.Unlock()
+	
+	nc,err := nd.dial()
+	if err!=nil { return nil,err }
+	
+	c.mu.Lock(); defer c.mu.Unlock()
+	if c.nd!=nd { nc.Close(); return nil,eParked }
+	c.nc = nc
+	return nc,nil
+}
+func (c *conn) assign(nd *node) {
+	c.mu.Lock(); defer c.mu.Unlock()
+	c.Cli.Addr = nd.name
+	c.nd = nd
+	atomic.StoreInt64(&c.used,time.Now().UnixNano())
+	if c.ready!=nil { close(c.ready); c.ready = nil }
+}
+func (c *conn) park() {
+	c.mu.Lock()
+	nc := c.nc
+	c.nd,c.nc = nil,nil
+	c.ready = make(chan struct{})
+	c.mu.Unlock()
+	if nc!=nil { nc.Close() }
+}
+/* Closes the TCP connection, if it isn't connected to addr. */
+func (c *conn) reconnect(addr string) bool {
+	c.mu.Lock()
+	nc := c.nc
+	if nc==nil || nc.RemoteAddr().String()==addr { c.mu.Unlock(); return false }
+	c.nc = nil
+	c.mu.Unlock()
+	nc.Close()
+	return true
+}
+
+/* Counts the open TCP connections of a node. */
+type tracked struct{
+	net.Conn
+	nd   *node
+	once sync.Once
+}
+func (t *tracked) Close() error {
+	t.once.Do(func(){
+		t.nd.mu.Lock(); defer t.nd.mu.Unlock()
+		t.nd.stats.Open--
+	})
+	return t.Conn.Close()
+}
+
+/*
+A remote node: its connections and its circuit breaker.
+*/
+type node struct{
+	name string
+	n    *NodeSelector
+	b    *breaker
+	
+	mu     sync.Mutex
+	conns  []*conn
+	stats  NodeStats
+	closed bool
+}
+func (nd *node) dial() (net.Conn, error) {
+	var nc net.Conn
+	err := ErrNoHost
+	if m := nd.n.de.GetOne(nd.name); m!=nil {
+		var tc *net.TCPConn
+		tc,err = net.DialTCP("tcp",nil,&net.TCPAddr{IP:m.IP,Port:m.Port})
+		if err==nil { nc = tc }
+	}
+	nd.mu.Lock(); defer nd.mu.Unlock()
+	nd.stats.Dials++
+	if err!=nil {
+		nd.stats.DialErrors++
+		return nil,err
+	}
+	nd.stats.Open++
+	return &tracked{Conn:nc,nd:nd},nil
+}
+
+/*
+Returns the connection with the least pending requests, or a new one, if they
+are all busy. Returns nil, if the node has left the cluster.
+*/
+func (nd *node) client() *conn {
+	cfg := &nd.n.Pool
+	nd.mu.Lock(); defer nd.mu.Unlock()
+	if nd.closed { return nil }
+	var best *conn
+	min := 0
+	for _,c := range nd.conns {
+		p := c.Cli.PendingRequests()
+		if best==nil || p<min { best,min = c,p }
+	}
+	if best==nil || (min>=cfg.busy() && len(nd.conns)<cfg.maxConns()) {
+		best = nd.n.getConn()
+		best.assign(nd)
+		nd.conns = append(nd.conns,best)
+	}
+	return best
+}
+func (nd *node) take(f func(c *conn) bool) (r []*conn) {
+	nd.mu.Lock(); defer nd.mu.Unlock()
+	j := 0
+	for _,c := range nd.conns {
+		if f(c) {
+			r = append(r,c)
+			continue
+		}
+		nd.conns[j] = c
+		j++
+	}
+	for i := j; i<len(nd.conns); i++ { nd.conns[i] = nil }
+	nd.conns = nd.conns[:j]
+	return
+}
+/* Removes the connections, that have not been used since limit. */
+func (nd *node) idle(limit int64) []*conn {
+	r := nd.take(func(c *conn) bool {
+		return atomic.LoadInt64(&c.used)<limit && c.Cli.PendingRequests()==0
+	})
+	nd.mu.Lock(); defer nd.mu.Unlock()
+	nd.stats.Evictions += uint64(len(r))
+	return r
+}
+/* Removes all connections. The node can no longer be used. */
+func (nd *node) close() []*conn {
+	nd.mu.Lock()
+	nd.closed = true
+	nd.mu.Unlock()
+	return nd.take(func(c *conn) bool { return true })
+}
+/* Closes the connections, that are not connected to the current address of the node. */
+func (nd *node) update() {
+	m := nd.n.de.GetOne(nd.name)
+	if m==nil { return }
+	addr := (&net.TCPAddr{IP:m.IP,Port:m.Port}).String()
+	nd.mu.Lock()
+	conns := append([]*conn(nil),nd.conns...)
+	nd.mu.Unlock()
+	k := 0
+	for _,c := range conns {
+		if c.reconnect(addr) { k++ }
+	}
+	nd.mu.Lock(); defer nd.mu.Unlock()
+	nd.stats.Reconnects += uint64(k)
+}
+func (nd *node) getStats() NodeStats {
+	nd.mu.Lock(); defer nd.mu.Unlock()
+	s := nd.stats
+	s.Conns = len(nd.conns)
+	return s
+}
+
+/*
+Performs a request to the node, guarded by its circuit breaker.
+*/
+func (nd *node) call(f func(c *kvrpc.Client) error) error {
+	c := nd.client()
+	if c==nil { return ErrNoHost }
+	if !nd.b.allow() { return EBreakerOpen }
+	begin := time.Now()
+	err := f(c.Client)
+	end := time.Now()
+	nd.b.done(err,end.Sub(begin))
+	atomic.StoreInt64(&c.used,end.UnixNano())
+	
+	nd.mu.Lock(); defer nd.mu.Unlock()
+	nd.stats.Requests++
+	if isFailure(err) { nd.stats.Failures++ }
+	nd.stats.LastUsed = end
+	return err
+}
+func (nd *node) BucketGet(bucket, key []byte) (bin bufferex.Binary, err error) {
+	err = nd.call(func(c *kvrpc.Client) (e error) { bin,e = c.BucketGet(bucket,key); return })
+	return
+}
+func (nd *node) BucketPut(bucket, key, value []byte) error {
+	return nd.call(func(c *kvrpc.Client) error { return c.BucketPut(bucket,key,value) })
+}
+func (nd *node) BucketDelete(bucket, key []byte) error {
+	return nd.call(func(c *kvrpc.Client) error { return c.BucketDelete(bucket,key) })
+}
+func (nd *node) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) error {
+	return nd.call(func(c *kvrpc.Client) error { return c.BucketPutExpire(bucket,key,value,expiresAt) })
+}
+func (nd *node) BucketScan(bucket []byte, r *bucketstore.ScanRange, targ func(key, value []byte)) (cursor []byte, err error) {
+	err = nd.call(func(c *kvrpc.Client) (e error) { cursor,e = c.BucketScan(bucket,r,targ); return })
+	return
+}
+
+var _ kvrpc.GBucket = (*node)(nil)
+var _ bucketstore.BucketScanner = (*node)(nil)
+
+func (n *NodeSelector) getConn() *conn {
+	c,_ := n.p.get().(*conn)
+	if c==nil { c = newConn() }
+	return c
+}
+func (n *NodeSelector) park(conns []*conn) {
+	for _,c := range conns {
+		c.park()
+		n.p.put(c)
+	}
+}
+func (n *NodeSelector) janitor() {
+	for {
+		t := n.Pool.idleTimeout()
+		if t<0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		if t<time.Second*2 { time.Sleep(time.Second) } else { time.Sleep(t/2) }
+		limit := time.Now().Add(-t).UnixNano()
+		for _,nd := range n.nodes() { n.park(nd.idle(limit)) }
+	}
+}
+
+/* Returns the connection statistics of the remote nodes. */
+func (n *NodeSelector) Stats() map[string]NodeStats {
+	r := make(map[string]NodeStats)
+	for _,nd := range n.nodes() { r[nd.name] = nd.getStats() }
+	return r
+}
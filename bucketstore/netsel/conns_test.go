@@ -0,0 +1,224 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package netsel
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/kvrpc"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/memstore"
+import "github.com/hashicorp/memberlist"
+import "github.com/valyala/fastrpc"
+import "testing"
+import "time"
+import "net"
+
+/* A kvrpc server on a loopback port. Requests block, while gate is set. */
+type testServer struct{
+	b    *memstore.Bucket
+	ln   net.Listener
+	gate chan struct{}
+}
+func newTestServer(t *testing.T, gate chan struct{}) *testServer {
+	s := &testServer{b:new(memstore.Bucket),gate:gate}
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { t.Fatal(err) }
+	s.ln = ln
+	h := kvrpc.Makehandler(s.b)
+	go (&fastrpc.Server{NewHandlerCtx:kvrpc.NewHandler,Handler:func(ctx fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+		if s.gate!=nil { <-s.gate }
+		return h(ctx)
+	},Concurrency:64}).Serve(ln)
+	return s
+}
+func (s *testServer) port() int { return s.ln.Addr().(*net.TCPAddr).Port }
+
+/* Announces the node name, served by s, as if it had joined the cluster. */
+func announce(de *cluster.Deleg, name string, s *testServer, join bool) {
+	meta := (&cluster.Deleg{Meta:&cluster.Metadata{Port:s.port()}}).NodeMeta(512)
+	mn := &memberlist.Node{Name:name,Addr:net.IPv4(127,0,0,1),Meta:meta}
+	if join { de.NotifyJoin(mn) } else { de.NotifyUpdate(mn) }
+}
+
+func newTestSelector(cfg PoolConfig) (*NodeSelector,*cluster.Deleg) {
+	de := &cluster.Deleg{Self:"self"}
+	de.Init()
+	if cfg.IdleTimeout==0 { cfg.IdleTimeout = -1 } // no janitor
+	n := &NodeSelector{Pool:cfg}
+	return n.Init(de),de
+}
+
+func expectGet(t *testing.T, nd *node, key, value string) {
+	t.Helper()
+	bin,err := nd.BucketGet([]byte("b"),[]byte(key))
+	if err!=nil { t.Fatalf("BucketGet(%q): %v",key,err) }
+	if string(bin.Bytes())!=value { t.Fatalf("BucketGet(%q) = %q, expected %q",key,bin.Bytes(),value) }
+}
+
+func TestPoolDefaults(t *testing.T) {
+	c := new(PoolConfig)
+	if c.maxConns()!=1 || c.busy()!=16 || c.idleTimeout()!=5*time.Minute {
+		t.Errorf("defaults: %d %d %v",c.maxConns(),c.busy(),c.idleTimeout())
+	}
+	c = &PoolConfig{MaxConns:3,Busy:2,IdleTimeout:-1}
+	if c.maxConns()!=3 || c.busy()!=2 || c.idleTimeout()>=0 {
+		t.Errorf("configured: %d %d %v",c.maxConns(),c.busy(),c.idleTimeout())
+	}
+}
+
+func TestNodeCall(t *testing.T) {
+	s := newTestServer(t,nil)
+	defer s.ln.Close()
+	n,de := newTestSelector(PoolConfig{})
+	announce(de,"a",s,true)
+	nd := n.getNode("a")
+	if err := nd.BucketPut([]byte("b"),[]byte("k"),[]byte("v")); err!=nil { t.Fatal(err) }
+	expectGet(t,nd,"k","v")
+	
+	st := n.Stats()["a"]
+	if st.Conns!=1 || st.Open!=1 || st.Dials!=1 || st.DialErrors!=0 || st.Requests!=2 || st.Failures!=0 {
+		t.Errorf("unexpected stats %+v",st)
+	}
+	if st.LastUsed.IsZero() { t.Error("LastUsed is not set") }
+	
+	/* A node, that is not known to the cluster, can't be dialed. */
+	if _,err := n.getNode("unknown").BucketGet([]byte("b"),[]byte("k")); err==nil { t.Error("BucketGet on an unknown node succeeded") }
+	if st := n.Stats()["unknown"]; st.DialErrors==0 || st.Failures==0 { t.Errorf("unknown node: %+v",st) }
+}
+
+func TestPoolEviction(t *testing.T) {
+	s := newTestServer(t,nil)
+	defer s.ln.Close()
+	n,de := newTestSelector(PoolConfig{})
+	announce(de,"a",s,true)
+	nd := n.getNode("a")
+	if err := nd.BucketPut([]byte("b"),[]byte("k"),[]byte("v")); err!=nil { t.Fatal(err) }
+	
+	/* Recently used connections are kept. */
+	if r := nd.idle(time.Now().Add(-time.Hour).UnixNano()); len(r)!=0 { t.Fatalf("evicted %d recently used connections",len(r)) }
+	
+	r := nd.idle(time.Now().Add(time.Hour).UnixNano())
+	if len(r)!=1 { t.Fatalf("evicted %d connections, expected 1",len(r)) }
+	n.park(r)
+	if n.p.Len()!=1 { t.Fatalf("%d parked connections, expected 1",n.p.Len()) }
+	st := n.Stats()["a"]
+	if st.Conns!=0 || st.Open!=0 || st.Evictions!=1 { t.Errorf("after eviction: %+v",st) }
+	
+	/* The parked connection is reused, and dials again. */
+	expectGet(t,nd,"k","v")
+	if n.p.Len()!=0 { t.Errorf("the parked connection was not reused") }
+	st = n.Stats()["a"]
+	if st.Conns!=1 || st.Open!=1 || st.Dials!=2 { t.Errorf("after reuse: %+v",st) }
+}
+
+func TestPoolReconnect(t *testing.T) {
+	s1,s2 := newTestServer(t,nil),newTestServer(t,nil)
+	defer s1.ln.Close()
+	defer s2.ln.Close()
+	s1.b.BucketPut([]byte("b"),[]byte("k"),[]byte("one"))
+	s2.b.BucketPut([]byte("b"),[]byte("k"),[]byte("two"))
+	n,de := newTestSelector(PoolConfig{})
+	announce(de,"a",s1,true)
+	nd := n.getNode("a")
+	expectGet(t,nd,"k","one")
+	
+	/* An update without a new address leaves the connection alone. */
+	announce(de,"a",s1,false)
+	if st := n.Stats()["a"]; st.Reconnects!=0 { t.Fatalf("reconnected without a new address: %+v",st) }
+	
+	/* The node moved to s2. */
+	announce(de,"a",s2,false)
+	if st := n.Stats()["a"]; st.Reconnects!=1 || st.Conns!=1 { t.Fatalf("after the update: %+v",st) }
+	for i := 0; ; i++ {
+		bin,err := nd.BucketGet([]byte("b"),[]byte("k"))
+		if err==nil && string(bin.Bytes())=="two" { break }
+		if i>=50 { t.Fatalf("never reached the new address: %q %v",bin.Bytes(),err) }
+		time.Sleep(10*time.Millisecond)
+	}
+}
+
+func TestPoolLeave(t *testing.T) {
+	s := newTestServer(t,nil)
+	defer s.ln.Close()
+	n,de := newTestSelector(PoolConfig{})
+	announce(de,"a",s,true)
+	nd := n.getNode("a")
+	if err := nd.BucketPut([]byte("b"),[]byte("k"),[]byte("v")); err!=nil { t.Fatal(err) }
+	
+	de.NotifyLeave(&memberlist.Node{Name:"a"})
+	if n.peekn("a")!=nil { t.Fatal("the node is still known") }
+	if n.p.Len()!=1 { t.Fatalf("%d parked connections, expected 1",n.p.Len()) }
+	if err := nd.BucketPut([]byte("b"),[]byte("k"),nil); err!=ErrNoHost { t.Fatalf("BucketPut on a node, that left: got %v, expected %v",err,ErrNoHost) }
+	
+	/* The node can join again, using the parked connection. */
+	announce(de,"a",s,true)
+	expectGet(t,n.getNode("a"),"k","v")
+	if n.p.Len()!=0 { t.Errorf("the parked connection was not reused") }
+}
+
+/* Another connection is opened, if all of them are busy, up to MaxConns. */
+func TestPoolBusy(t *testing.T) {
+	gate := make(chan struct{})
+	s := newTestServer(t,gate)
+	defer s.ln.Close()
+	n,de := newTestSelector(PoolConfig{MaxConns:2,Busy:1})
+	announce(de,"a",s,true)
+	nd := n.getNode("a")
+	
+	/* The version negotiation would hold up the requests behind the gate. */
+	for i := 0; i<2; i++ {
+		c := newConn()
+		c.MaxVersion = kvrpc.Version1
+		n.p.put(c)
+	}
+	
+	c1 := nd.client()
+	done := make(chan error,4)
+	go func() { done <- nd.BucketPut([]byte("b"),[]byte("k1"),nil) }()
+	waitPending(t,c1,1)
+	c2 := nd.client()
+	if c2==c1 { t.Fatal("a busy connection was chosen") }
+	go func() { done <- nd.BucketPut([]byte("b"),[]byte("k2"),nil) }()
+	waitPending(t,c2,1)
+	
+	/* At MaxConns, the least busy connection is chosen. */
+	go func() { done <- nd.BucketPut([]byte("b"),[]byte("k3"),nil) }()
+	for i := 0; c1.Cli.PendingRequests()+c2.Cli.PendingRequests()<3; i++ {
+		if i>5000 { t.Fatalf("%d+%d pending requests, expected 3",c1.Cli.PendingRequests(),c2.Cli.PendingRequests()) }
+		time.Sleep(time.Millisecond)
+	}
+	if c := nd.client(); c!=c1 && c!=c2 { t.Fatal("a third connection was opened") }
+	if st := n.Stats()["a"]; st.Conns!=2 { t.Fatalf("%d connections, expected 2",st.Conns) }
+	
+	close(gate)
+	for i := 0; i<3; i++ {
+		if err := <-done; err!=nil { t.Error(err) }
+	}
+}
+func waitPending(t *testing.T, c *conn, p int) {
+	for i := 0; c.Cli.PendingRequests()<p; i++ {
+		if i>5000 { t.Fatalf("%d pending requests, expected %d",c.Cli.PendingRequests(),p) }
+		time.Sleep(time.Millisecond)
+	}
+}
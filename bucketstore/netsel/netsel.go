@@ -23,7 +23,6 @@ SOFTWARE.
 
 package netsel
 
-import "net"
 import "errors"
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netmodel"
 
@@ -35,6 +34,7 @@ import "sync"
 
 var ErrNoHost = errors.New("No such host")
 
+/* Parked connections (see conn). */
 type pool struct{
 	sync.Mutex
 	*list.List
@@ -52,70 +52,116 @@ func (p *pool) put(v interface{}) {
 }
 
 type NodeSelector struct{
+	late uint64 // first, for 64-bit alignment of atomic operations
+	
+	/*
+	The number of holders, that must acknowledge a write to a bucket, that
+	is served from more than one node. Defaults to QuorumMajority.
+	*/
+	Quorum Quorum
+	
+	/* Configures the circuit breakers of the remote nodes. */
+	Breaker BreakerConfig
+	
+	/* Configures the connections to the remote nodes. */
+	Pool PoolConfig
+	
 	de *cluster.Deleg
 	sel *selector.Selector
 	
 	p pool
 	
 	ml sync.RWMutex
-	m map[string]*kvrpc.Client
+	m map[string]*node
 }
-func (n *NodeSelector) peekn(name string) *kvrpc.Client {
+func (n *NodeSelector) peekn(name string) *node {
 	n.ml.RLock(); defer n.ml.RUnlock()
 	return n.m[name]
 }
-func (n *NodeSelector) putn(name string,c *kvrpc.Client) bool {
+func (n *NodeSelector) putn(name string) *node {
 	n.ml.Lock(); defer n.ml.Unlock()
-	if _,ok := n.m[name]; ok { return false }
-	n.m[name] = c
-	return true
+	if nd,ok := n.m[name]; ok { return nd }
+	nd := &node{name:name,n:n,b:newBreaker(&n.Breaker)}
+	n.m[name] = nd
+	return nd
 }
 func (n *NodeSelector) kickn(name string) {
-	n.ml.Lock(); defer n.ml.Unlock()
-	c,ok := n.m[name]
-	if !ok { return }
+	n.ml.Lock()
+	nd,ok := n.m[name]
 	delete(n.m,name)
-	n.p.put(c)
+	n.ml.Unlock()
+	if ok { n.park(nd.close()) }
 }
-func (n *NodeSelector) newClient(name string) *kvrpc.Client {
-	c,_ := n.p.get().(*kvrpc.Client)
-	if c==nil {
-		c = new(kvrpc.Client)
-		c.Init()
-		c.Cli.Dial = n.dial
-	}
-	c.Cli.Addr = name
-	return c
+func (n *NodeSelector) updaten(name string) {
+	if nd := n.peekn(name); nd!=nil { nd.update() }
 }
-func (n *NodeSelector) getClient(name string) *kvrpc.Client {
-	for {
-		if c := n.peekn(name); c!=nil { return c }
-		c := n.newClient(name)
-		if n.putn(name,c) { return c }
-		n.p.put(c)
-		continue
-	}
-	panic("unreachable")
+func (n *NodeSelector) nodes() []*node {
+	n.ml.RLock(); defer n.ml.RUnlock()
+	r := make([]*node,0,len(n.m))
+	for _,nd := range n.m { r = append(r,nd) }
+	return r
 }
-func (n *NodeSelector) dial(name string) (net.Conn, error) {
-	m := n.de.GetOne(name)
-	if m==nil { return nil,ErrNoHost }
-	return net.DialTCP("tcp",nil,&net.TCPAddr{IP:m.IP,Port:m.Port})
+func (n *NodeSelector) getNode(name string) *node {
+	if nd := n.peekn(name); nd!=nil { return nd }
+	return n.putn(name)
 }
 
 func (n *NodeSelector) Init(de *cluster.Deleg) *NodeSelector {
 	n.de = de
 	n.sel = &selector.Selector{&de.BM,&de.NKV}
 	n.p.List = list.New()
-	n.m = make(map[string]*kvrpc.Client)
+	n.m = make(map[string]*node)
 	de.AddLeaveListener(n.kickn)
+	de.AddUpdateListener(n.updaten)
+	go n.janitor()
 	return n
 }
+/*
+Returns the holders of a bucket: this node first (if local is true),
+then the other nodes, nearest first. Nodes with an open circuit breaker
+are moved to the end.
+*/
+func (n *NodeSelector) holders(bucket []byte, local bool) (h []kvrpc.GBucket) {
+	if local { h = append(h,n.sel) }
+	nodes := n.de.NM.NodesB(bucket)
+	elems := n.de.GetAll(nodes,make([]*cluster.NodeMetadata,0,len(nodes)))
+	j := 0
+	for _,e := range elems {
+		if e==nil || e.Name==n.de.Self { continue }
+		elems[j] = e
+		j++
+	}
+	elems = elems[:j]
+	n.de.SortNodesDistance(elems)
+	var ejected []kvrpc.GBucket
+	for _,e := range elems {
+		c := n.getNode(e.Name)
+		if c.b.blocked() {
+			ejected = append(ejected,c)
+		} else {
+			h = append(h,c)
+		}
+	}
+	h = append(h,ejected...)
+	return
+}
+
+/* Returns the state of the circuit breakers of the remote nodes. */
+func (n *NodeSelector) Breakers() map[string]BreakerStatus {
+	n.ml.RLock(); defer n.ml.RUnlock()
+	r := make(map[string]BreakerStatus,len(n.m))
+	for name,nd := range n.m { r[name] = nd.b.get() }
+	return r
+}
+
 func (n *NodeSelector) FastLookup(bucket []byte) (srv netmodel.Server, rok bool) {
 	/*
 	We generally consult our local maps first (NKV and BM).
 	
 	Step 1: consult NKV
+	
+	A netkv bucket is one store, that is shared by all its holders,
+	so there is nothing to replicate.
 	*/
 	if sess := n.de.NKV.Get(bucket); sess!=nil {
 		srv.Reader = sess.Reader
@@ -128,6 +174,9 @@ func (n *NodeSelector) FastLookup(bucket []byte) (srv netmodel.Server, rok bool)
 	Step 2: consult BM
 	*/
 	if bkt,ok := n.de.BM.Obtain(bucket); ok {
+		if h := n.holders(bucket,true); len(h)>1 {
+			return newReplica(h,n.Quorum,&n.late).server(),true
+		}
 		srv.Reader = bkt.Reader
 		srv.Writer = bkt.Writer
 		srv.WriterEx = bkt.WriterEx
@@ -138,17 +187,17 @@ func (n *NodeSelector) FastLookup(bucket []byte) (srv netmodel.Server, rok bool)
 	If we don't have the bucket, it is almost guaranteed, that we won't be
 	one of the nodes, sharing this bucket.
 	*/
-	nodes := n.de.NM.NodesB(bucket)
-	if  len(nodes)==0 { return }
-	elems := n.de.GetAll(nodes,make([]*cluster.NodeMetadata,0,len(nodes)))
-	n.de.SortNodesDistance(elems)
-	{
-		cli := n.getClient(elems[0].Name)
-		srv.Reader = cli
-		srv.Writer = cli
-		srv.WriterEx = cli
-		rok = true
+	h := n.holders(bucket,false)
+	switch len(h) {
+	case 0: return
+	case 1:
+		srv.Reader = h[0]
+		srv.Writer = h[0]
+		srv.WriterEx = h[0]
+	default:
+		srv = newReplica(h,n.Quorum,&n.late).server()
 	}
+	rok = true
 	return
 }
 
@@ -176,33 +225,33 @@ func (n *NodeSelector) GetHolder(bucket []byte) (srv netmodel.Server, t netmodel
 	Step 2: consult BM
 	*/
 	if n.de.BM.Contains(bucket) {
+		h := n.holders(bucket,true)
+		if len(h)>1 {
+			srv = newReplica(h,n.Quorum,&n.late).server()
+			t = netmodel.Some
+			return
+		}
 		srv.Reader = n.sel
 		srv.Writer = n.sel
 		srv.WriterEx = n.sel
-		switch n.de.NM.CountNodes(bucket) {
-		case 0,1: t = netmodel.One
-		default: t = netmodel.Some
-		}
+		t = netmodel.One
 		return
 	}
 	/*
 	If we don't have the bucket, it is almost guaranteed, that we won't be
 	one of the nodes, sharing this bucket.
 	*/
-	nodes := n.de.NM.NodesB(bucket)
-	switch len(nodes) {
+	h := n.holders(bucket,false)
+	switch len(h) {
 	case 0: t = netmodel.None; return
-	case 1: t = netmodel.One
-	default: t = netmodel.Some
-	}
-	elems := n.de.GetAll(nodes,make([]*cluster.NodeMetadata,0,len(nodes)))
-	n.de.SortNodesDistance(elems)
-	{
-		cli := n.getClient(elems[0].Name)
-		srv.Reader = cli
-		srv.Writer = cli
-		srv.WriterEx = cli
+	case 1:
+		t = netmodel.One
+		srv.Reader = h[0]
+		srv.Writer = h[0]
+		srv.WriterEx = h[0]
+	default:
+		t = netmodel.Some
+		srv = newReplica(h,n.Quorum,&n.late).server()
 	}
 	return
 }
-
@@ -0,0 +1,184 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package netsel
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/kvrpc"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netmodel"
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "strconv"
+import "fmt"
+import "sync/atomic"
+
+/*
+A Quorum determines, how many holders of a replicated bucket must acknowledge
+a write, given the number of holders.
+*/
+type Quorum func(holders int) int
+
+func QuorumAll(n int) int { return n }
+func QuorumMajority(n int) int { return n/2+1 }
+func QuorumOne(n int) int { return 1 }
+
+/* Requires n holders, or all of them, if there are less than n. */
+func QuorumN(n int) Quorum {
+	return func(h int) int {
+		if n<h { return n }
+		return h
+	}
+}
+
+/*
+Parses a quorum specification. Valid are "all", "majority", "one" and a positive
+number. The empty string means "majority".
+*/
+func ParseQuorum(s string) (Quorum,error) {
+	switch s {
+	case "","majority": return QuorumMajority,nil
+	case "all": return QuorumAll,nil
+	case "one": return QuorumOne,nil
+	}
+	n,err := strconv.Atoi(s)
+	if err!=nil || n<1 { return nil,fmt.Errorf("invalid quorum %q",s) }
+	return QuorumN(n),nil
+}
+
+func clone(b []byte) []byte {
+	if b==nil { return nil }
+	return append(make([]byte,0,len(b)),b...)
+}
+
+/*
+Replicates a bucket, that is served from more than one node.
+
+Writes are sent to every holder in parallel. A write succeeds, once quorum holders
+acknowledged it, the remaining writes complete in the background. Those, that
+fail, are counted in late (see NodeSelector.LateFailures). Reads and scans
+go to the first holder (this node, if it is one, otherwise the nearest one) and
+fall back to the next one, if it fails.
+*/
+type replica struct{
+	holders []kvrpc.GBucket
+	quorum int
+	late *uint64
+}
+func newReplica(holders []kvrpc.GBucket, q Quorum, late *uint64) *replica {
+	if q==nil { q = QuorumMajority }
+	n := q(len(holders))
+	if n<1 { n = 1 }
+	if n>len(holders) { n = len(holders) }
+	return &replica{holders,n,late}
+}
+func (r *replica) server() (srv netmodel.Server) {
+	srv.Reader = r
+	srv.Writer = r
+	srv.WriterEx = r
+	return
+}
+
+/* If a holder does not know a key, a holder, that missed no writes, might. */
+func better(err, last error) error {
+	if last==bucketstore.ENotFound { return last }
+	return err
+}
+func (r *replica) BucketGet(bucket, key []byte) (bin bufferex.Binary, err error) {
+	var last error
+	for _,h := range r.holders {
+		bin,err = h.BucketGet(bucket,key)
+		if err==nil { return }
+		last = better(err,last)
+	}
+	err = last
+	return
+}
+func (r *replica) BucketScan(bucket []byte, rng *bucketstore.ScanRange, targ func(key, value []byte)) (cursor []byte, err error) {
+	err = notImpl
+	for _,h := range r.holders {
+		sc,ok := h.(bucketstore.BucketScanner)
+		if !ok { continue }
+		/*
+		Once a holder delivered keys, we can't switch over to another one,
+		without delivering them twice.
+		*/
+		n := 0
+		cursor,err = sc.BucketScan(bucket,rng,func(k, v []byte) { n++; targ(k,v) })
+		if err==nil || n>0 { return }
+	}
+	return
+}
+func (r *replica) write(f func(h kvrpc.GBucket) error) error {
+	errs := make(chan error,len(r.holders))
+	for _,h := range r.holders {
+		go func(h kvrpc.GBucket) { errs <- f(h) }(h)
+	}
+	var first error
+	ok,failed := 0,0
+	for i := range r.holders {
+		err := <-errs
+		if err==nil {
+			ok++
+			if ok>=r.quorum {
+				go r.drain(errs,len(r.holders)-i-1)
+				return nil
+			}
+			continue
+		}
+		if first==nil { first = err }
+		failed++
+		if failed>len(r.holders)-r.quorum { break }
+	}
+	return first
+}
+
+/* Counts the failures among the n writes, that completed after the quorum. */
+func (r *replica) drain(errs chan error, n int) {
+	for ; n>0; n-- {
+		if <-errs!=nil && r.late!=nil { atomic.AddUint64(r.late,1) }
+	}
+}
+
+/*
+Returns the number of replicated writes, that failed on a holder after the quorum
+had already acknowledged them. Those holders missed the write.
+*/
+func (n *NodeSelector) LateFailures() uint64 { return atomic.LoadUint64(&n.late) }
+
+/*
+The buffers are copied, because writes beyond the quorum outlive the call.
+*/
+func (r *replica) BucketPut(bucket, key, value []byte) error {
+	bucket,key,value = clone(bucket),clone(key),clone(value)
+	return r.write(func(h kvrpc.GBucket) error { return h.BucketPut(bucket,key,value) })
+}
+func (r *replica) BucketDelete(bucket, key []byte) error {
+	bucket,key = clone(bucket),clone(key)
+	return r.write(func(h kvrpc.GBucket) error { return h.BucketDelete(bucket,key) })
+}
+func (r *replica) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) error {
+	bucket,key,value = clone(bucket),clone(key),clone(value)
+	return r.write(func(h kvrpc.GBucket) error { return h.BucketPutExpire(bucket,key,value,expiresAt) })
+}
+
+var _ kvrpc.GBucket = (*replica)(nil)
+var _ bucketstore.BucketScanner = (*replica)(nil)
@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package netsel
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/kvrpc"
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "errors"
+import "sync/atomic"
+import "testing"
+import "time"
+
+var errFake = errors.New("fake failure")
+
+/* A holder, that fails, if err is set, and blocks until wait is closed. */
+type fakeHolder struct{
+	err   error
+	wait  chan struct{}
+	done  chan struct{}
+	calls int32
+}
+func newFake(err error, wait chan struct{}) *fakeHolder {
+	return &fakeHolder{err:err,wait:wait,done:make(chan struct{},4)}
+}
+func (f *fakeHolder) write() error {
+	if f.wait!=nil { <-f.wait }
+	atomic.AddInt32(&f.calls,1)
+	f.done <- struct{}{}
+	return f.err
+}
+func (f *fakeHolder) BucketGet(bucket, key []byte) (bufferex.Binary, error) { return bufferex.Binary{},bucketstore.ENotFound }
+func (f *fakeHolder) BucketPut(bucket, key, value []byte) error { return f.write() }
+func (f *fakeHolder) BucketDelete(bucket, key []byte) error { return f.write() }
+func (f *fakeHolder) BucketPutExpire(bucket, key, value []byte, expiresAt uint64) error { return f.write() }
+
+func holders(fs ...*fakeHolder) (h []kvrpc.GBucket) {
+	for _,f := range fs { h = append(h,f) }
+	return
+}
+func waitDone(t *testing.T, fs ...*fakeHolder) {
+	for i,f := range fs {
+		select {
+		case <-f.done:
+		case <-time.After(5*time.Second): t.Fatalf("holder %d: write never happened",i)
+		}
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	cases := []struct{
+		name  string
+		q     Quorum
+		errs  []error
+		fails bool
+	}{
+		{"all ok",QuorumAll,[]error{nil,nil,nil},false},
+		{"all one failed",QuorumAll,[]error{nil,errFake,nil},true},
+		{"majority ok",QuorumMajority,[]error{nil,errFake,nil},false},
+		{"majority failed",QuorumMajority,[]error{errFake,nil,errFake},true},
+		{"one ok",QuorumOne,[]error{errFake,errFake,nil},false},
+		{"one failed",QuorumOne,[]error{errFake,errFake,errFake},true},
+		{"n ok",QuorumN(2),[]error{nil,errFake,nil},false},
+		{"n capped",QuorumN(5),[]error{nil,nil},false},
+	}
+	for _,c := range cases {
+		var fs []*fakeHolder
+		for _,e := range c.errs { fs = append(fs,newFake(e,nil)) }
+		var late uint64
+		r := newReplica(holders(fs...),c.q,&late)
+		err := r.BucketPut([]byte("b"),[]byte("k"),[]byte("v"))
+		if c.fails && err!=errFake { t.Errorf("%s: got %v, want %v",c.name,err,errFake) }
+		if !c.fails && err!=nil { t.Errorf("%s: got %v",c.name,err) }
+		waitDone(t,fs...)
+	}
+}
+
+func TestLateFailure(t *testing.T) {
+	wait := make(chan struct{})
+	fast1,fast2 := newFake(nil,nil),newFake(nil,nil)
+	slow := newFake(errFake,wait)
+	var late uint64
+	r := newReplica(holders(fast1,slow,fast2),QuorumMajority,&late)
+	if err := r.BucketDelete([]byte("b"),[]byte("k")); err!=nil { t.Fatal(err) }
+	if atomic.LoadInt32(&slow.calls)!=0 { t.Fatal("returned after the slow holder") }
+	close(wait)
+	waitDone(t,slow)
+	for i := 0; atomic.LoadUint64(&late)!=1; i++ {
+		if i>500 { t.Fatalf("late failures = %d, want 1",atomic.LoadUint64(&late)) }
+		time.Sleep(10*time.Millisecond)
+	}
+	
+	/* Late successes are not counted. */
+	wait = make(chan struct{})
+	slow = newFake(nil,wait)
+	r = newReplica(holders(fast1,slow,fast2),QuorumMajority,&late)
+	if err := r.BucketPutExpire([]byte("b"),[]byte("k"),[]byte("v"),1); err!=nil { t.Fatal(err) }
+	close(wait)
+	waitDone(t,fast1,fast2,slow)
+	time.Sleep(50*time.Millisecond)
+	if n := atomic.LoadUint64(&late); n!=1 { t.Fatalf("late failures = %d, want 1",n) }
+}
+
+func TestLateFailuresCounter(t *testing.T) {
+	n := new(NodeSelector)
+	wait := make(chan struct{})
+	r := newReplica(holders(newFake(nil,nil),newFake(errFake,wait)),QuorumOne,&n.late)
+	if err := r.BucketPut([]byte("b"),[]byte("k"),nil); err!=nil { t.Fatal(err) }
+	close(wait)
+	for i := 0; n.LateFailures()!=1; i++ {
+		if i>500 { t.Fatalf("LateFailures() = %d, want 1",n.LateFailures()) }
+		time.Sleep(10*time.Millisecond)
+	}
+}
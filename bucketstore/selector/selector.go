@@ -23,7 +23,7 @@ SOFTWARE.
 
 package selector
 
-//import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/bucketmap"
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netkv"
 import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
@@ -77,4 +77,23 @@ func (s *Selector) BucketPutExpire(bucket, key, value []byte, expiresAt uint64)
 	return b.WriterEx.BucketPutExpire(bucket,key,value,expiresAt)
 }
 
+func scanner(r bucketstore.BucketR) bucketstore.BucketScanner {
+	s,_ := r.(bucketstore.BucketScanner)
+	return s
+}
+func (s *Selector) BucketScan(bucket []byte, r *bucketstore.ScanRange, targ func(key, value []byte)) ([]byte, error) {
+	if b := s.NKV.Get(bucket); b!=nil {
+		sc := scanner(b.Reader)
+		if sc==nil { return nil,notImpl }
+		return sc.BucketScan(bucket,r,targ)
+	}
+	b,ok := s.BM.Obtain(bucket)
+	if !ok { return nil,notBucket }
+	sc := scanner(b.Reader)
+	if sc==nil { return nil,notImpl }
+	return sc.BucketScan(bucket,r,targ)
+}
+
+var _ bucketstore.BucketScanner = (*Selector)(nil)
+
 //
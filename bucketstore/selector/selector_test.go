@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package selector_test
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/bucketmap"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netkv"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/selector"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/selerr"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/memstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/storetest"
+import "testing"
+
+var bucketName = []byte("storetest")
+
+func init() {
+	netkv.Provider(func(bucket, meta []byte) (*netkv.Session,error) {
+		b := new(memstore.Bucket)
+		return &netkv.Session{Reader:b,Writer:b,WriterEx:b},nil
+	}).RegisterAs("selector-test")
+}
+
+func newSelector() *selector.Selector {
+	s := &selector.Selector{BM:new(bucketmap.BucketMap),NKV:new(netkv.NetKVMap)}
+	s.BM.Init()
+	s.NKV.Init()
+	return s
+}
+
+func TestLocal(t *testing.T) {
+	s := &storetest.BucketSuite{New:func(t *testing.T) (bucketstore.Bucket,func()) {
+		sel := newSelector()
+		b := new(memstore.Bucket)
+		sel.BM.Add(bucketName,bucketmap.Bucket{Reader:b,Writer:b,WriterEx:b})
+		return sel,nil
+	}}
+	s.Run(t)
+	s.OverKvrpc().Run(t)
+}
+
+func TestNetKV(t *testing.T) {
+	s := &storetest.BucketSuite{New:func(t *testing.T) (bucketstore.Bucket,func()) {
+		sel := newSelector()
+		if !sel.NKV.Offer("selector-test",bucketName,nil,false) { t.Fatal("Offer failed") }
+		return sel,nil
+	}}
+	s.Run(t)
+	s.OverKvrpc().Run(t)
+}
+
+func TestErrors(t *testing.T) {
+	sel := newSelector()
+	sel.BM.Add([]byte("ro"),bucketmap.Bucket{Reader:new(memstore.Bucket)})
+	if _,err := sel.BucketGet([]byte("missing"),[]byte("k")); err!=selerr.ENoSuchBucket {
+		t.Errorf("BucketGet on a missing bucket -> %v",err)
+	}
+	if err := sel.BucketPut([]byte("ro"),[]byte("k"),[]byte("v")); err!=selerr.ENotImplemented {
+		t.Errorf("BucketPut on a read-only bucket -> %v",err)
+	}
+	if err := sel.BucketPutExpire([]byte("ro"),[]byte("k"),[]byte("v"),1); err!=selerr.ENotImplemented {
+		t.Errorf("BucketPutExpire on a read-only bucket -> %v",err)
+	}
+}
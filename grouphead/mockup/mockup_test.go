@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package mockup_test
+
+import bolt "github.com/coreos/bbolt"
+import "github.com/maxymania/fastnntp-polyglot-labs2/grouphead"
+import "github.com/maxymania/fastnntp-polyglot-labs2/grouphead/mockup"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/groupdb2"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/groupdb3"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/gitest"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+import "os"
+
+func run(t *testing.T, open func(dir string) (grouphead.GroupHeadDB,func(),error)) {
+	s := &gitest.Suite{
+		New:func(t *testing.T) (groupidx.GroupIndex,func()) {
+			dir,err := ioutil.TempDir("","mockup")
+			if err!=nil { t.Fatal(err) }
+			db,done,err := open(dir)
+			if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+			return mockup.Wrapper{db},func() {
+				done()
+				os.RemoveAll(dir)
+			}
+		},
+		OnlyGroupHead:true,
+	}
+	s.Run(t)
+	s.OverWire2().Run(t)
+	s.OverWire1().Run(t)
+}
+
+func TestGroupdb2(t *testing.T) {
+	run(t,func(dir string) (grouphead.GroupHeadDB,func(),error) {
+		b,err := bolt.Open(filepath.Join(dir,"groups.db"),0600,nil)
+		if err!=nil { return nil,nil,err }
+		return groupdb2.NewDB(b,[]byte("groups")),func() { b.Close() },nil
+	})
+}
+
+func TestGroupdb3(t *testing.T) {
+	run(t,func(dir string) (grouphead.GroupHeadDB,func(),error) {
+		l,err := groupdb3.OpenLogDB(dir)
+		if err!=nil { return nil,nil,err }
+		return l,func() { l.Close() },nil
+	})
+}
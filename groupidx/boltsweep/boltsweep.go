@@ -0,0 +1,209 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+Expiry sweeping for the bolt based group indexes (groupdb2 and groupdb3).
+
+Both keep every group in a sub-bucket of one bolt bucket, laid out as follows:
+
+	table   Encode(num) → Encode(exp)+id
+	index   a nubrin.TSIndex: Encode(exp-exp%Mod) → Encode(first)+Encode(last)
+	count   Encode(the number of entries)
+	high    Encode(the highest number, that has been deleted by the sweeper)
+*/
+package boltsweep
+
+import bolt "github.com/coreos/bbolt"
+import "github.com/maxymania/gonbase/nubrin"
+import "sync"
+import "time"
+
+var (
+	Table = []byte("table")
+	Index = []byte("index")
+	Count = []byte("count")
+	High  = []byte("high")
+)
+
+/*
+Trims secondary indexes of a group, after its expired entries have been
+deleted, looking at no more than limit entries.
+*/
+type Trimmer func(grp *bolt.Bucket, now uint64, limit int) error
+
+func clone(b []byte) []byte { return append(make([]byte,0,len(b)),b...) }
+
+/*
+Deletes the expired entries of a group, looking at no more than limit entries.
+Returns more=true, if there are more expired entries to delete.
+
+The time buckets of the index are used to find them: Only buckets, that lie
+completely in the past, are visited. Entries within their range, that did not
+expire (yet), are kept.
+*/
+func SweepGroup(grp *bolt.Bucket, now uint64, limit int, trim Trimmer) (deleted int, more bool, err error) {
+	index,table := grp.Bucket(Index),grp.Bucket(Table)
+	if index==nil || table==nil { return }
+	const mod = 60*60*24
+	
+	var dels,done [][]byte
+	var resume,rng []byte
+	scanned := 0
+	
+	ic := index.Cursor()
+	for k,v := ic.First(); len(k)!=0; k,v = ic.Next() {
+		if nubrin.Decode(k)+mod > now { break }
+		first,last := nubrin.SplitOffSecond(v)
+		hi := nubrin.Decode(last)
+		tc := table.Cursor()
+		for tk,tv := tc.Seek(first); len(tk)!=0; tk,tv = tc.Next() {
+			num := nubrin.Decode(tk)
+			if num>hi { break }
+			if scanned>=limit {
+				resume = clone(k)
+				rng = append(nubrin.Encode(num),last...)
+				break
+			}
+			scanned++
+			ee,_ := nubrin.SplitOffSecond(tv)
+			if nubrin.Decode(ee) < now { dels = append(dels,clone(tk)) }
+		}
+		if resume!=nil { more = true; break }
+		done = append(done,clone(k))
+	}
+	
+	var high uint64
+	if v := grp.Get(High); len(v)!=0 { high = nubrin.Decode(v) }
+	for _,k := range dels {
+		if err = table.Delete(k); err!=nil { return }
+		if n := nubrin.Decode(k); n>high { high = n }
+	}
+	for _,k := range done {
+		if err = index.Delete(k); err!=nil { return }
+	}
+	if resume!=nil {
+		if err = index.Put(resume,rng); err!=nil { return }
+	}
+	if trim!=nil {
+		if err = trim(grp,now,limit); err!=nil { return }
+	}
+	deleted = len(dels)
+	if deleted==0 { return }
+	
+	if err = grp.Put(High,nubrin.Encode(high)); err!=nil { return }
+	count := nubrin.Decode(grp.Get(Count))
+	if uint64(deleted)<count { count -= uint64(deleted) } else { count = 0 }
+	err = grp.Put(Count,nubrin.Encode(count))
+	return
+}
+
+/* Returns the names of all groups. */
+func Groups(bkt *bolt.Bucket) (groups [][]byte) {
+	bkt.ForEach(func(k, v []byte) error {
+		if v==nil { groups = append(groups,clone(k)) }
+		return nil
+	})
+	return
+}
+
+/*
+Deletes expired entries in the background.
+*/
+type Sweeper struct{
+	DB *bolt.DB
+	
+	// The bolt bucket, holding the groups.
+	Name []byte
+	
+	// Optional.
+	Trim Trimmer
+	
+	// Returns the current time (in seconds since the Unix epoch).
+	// Defaults to the wall clock.
+	Clock func() uint64
+	
+	// The number of entries, that are looked at per transaction. Defaults to 1024.
+	Batch int
+	
+	// Defaults to one minute.
+	Interval time.Duration
+	
+	once sync.Once
+	stop chan struct{}
+}
+func (s *Sweeper) now() uint64 {
+	if s.Clock!=nil { return s.Clock() }
+	return uint64(time.Now().Unix())
+}
+
+/* Performs one pass over all groups. Returns the number of deleted entries. */
+func (s *Sweeper) Sweep() (n int, err error) {
+	batch := s.Batch
+	if batch<=0 { batch = 1024 }
+	now := s.now()
+	var groups [][]byte
+	err = s.DB.View(func(t *bolt.Tx) error {
+		if bkt := t.Bucket(s.Name); bkt!=nil { groups = Groups(bkt) }
+		return nil
+	})
+	if err!=nil { return }
+	for _,group := range groups {
+		for more := true; more; {
+			var d int
+			err = s.DB.Update(func(t *bolt.Tx) (err error) {
+				more = false
+				bkt := t.Bucket(s.Name)
+				if bkt==nil { return }
+				grp := bkt.Bucket(group)
+				if grp==nil { return }
+				d,more,err = SweepGroup(grp,now,batch,s.Trim)
+				return
+			})
+			if err!=nil { return }
+			n += d
+		}
+	}
+	return
+}
+
+func (s *Sweeper) Start() {
+	s.once.Do(func(){ s.stop = make(chan struct{}) })
+	go s.loop()
+}
+func (s *Sweeper) Stop() {
+	s.once.Do(func(){ s.stop = make(chan struct{}) })
+	close(s.stop)
+}
+func (s *Sweeper) loop() {
+	iv := s.Interval
+	if iv<=0 { iv = time.Minute }
+	t := time.NewTicker(iv)
+	defer t.Stop()
+	for {
+		select {
+		case <- s.stop: return
+		case <- t.C:
+		}
+		s.Sweep()
+	}
+}
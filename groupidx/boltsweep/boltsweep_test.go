@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package boltsweep_test
+
+import bolt "github.com/coreos/bbolt"
+import "github.com/maxymania/gonbase/nubrin"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/boltsweep"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/groupdb2"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/groupdb3"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+import "os"
+
+const day = 60*60*24
+
+var (
+	table = []byte("groups")
+	group = []byte("g")
+)
+
+func tempBolt(t *testing.T) (*bolt.DB,func()) {
+	dir,err := ioutil.TempDir("","boltsweep")
+	if err!=nil { t.Fatal(err) }
+	b,err := bolt.Open(filepath.Join(dir,"groups.db"),0600,nil)
+	if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+	return b,func() {
+		b.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+/* Returns the numbers of the rows in the table of the group, and the value of count. */
+func rows(t *testing.T, b *bolt.DB) (nums []uint64, count uint64) {
+	err := b.View(func(tx *bolt.Tx) error {
+		grp := tx.Bucket(table).Bucket(group)
+		grp.Bucket(boltsweep.Table).ForEach(func(k, v []byte) error {
+			nums = append(nums,nubrin.Decode(k))
+			return nil
+		})
+		count = nubrin.Decode(grp.Get(boltsweep.Count))
+		return nil
+	})
+	if err!=nil { t.Fatal(err) }
+	return
+}
+
+func expect(t *testing.T, b *bolt.DB, gi groupidx.GroupIndex, nums []uint64, low, high int64) {
+	got,count := rows(t,b)
+	if len(got)!=len(nums) {
+		t.Errorf("rows %v, expected %v",got,nums)
+	} else {
+		for i := range got {
+			if got[i]!=nums[i] { t.Errorf("rows %v, expected %v",got,nums); break }
+		}
+	}
+	if count!=uint64(len(nums)) {
+		t.Errorf("count %d, expected %d",count,len(nums))
+	}
+	n,l,h,ok := gi.GroupRealtimeQuery(group)
+	if !ok || n!=int64(len(nums)) || l!=low || h!=high {
+		t.Errorf("GroupRealtimeQuery() -> %d %d %d %v, expected %d %d %d true",n,l,h,ok,len(nums),low,high)
+	}
+}
+
+func testSweep(t *testing.T, b *bolt.DB, gi groupidx.GroupIndex, sw *boltsweep.Sweeper) {
+	now := uint64(1000*day)
+	sw.Clock = func() uint64 { return now }
+	sw.Batch = 2
+	
+	/* 1-5 and 11 expired days ago, 6-8 yesterday, 9 and 10 expire tomorrow. */
+	for i := uint64(1); i<=5; i++ {
+		if err := gi.AssignArticleToGroup(group,i,now-3*day,[]byte("old")); err!=nil { t.Fatal(err) }
+	}
+	for i := uint64(6); i<=8; i++ {
+		if err := gi.AssignArticleToGroup(group,i,now-day-10,[]byte("yesterday")); err!=nil { t.Fatal(err) }
+	}
+	for i := uint64(9); i<=10; i++ {
+		if err := gi.AssignArticleToGroup(group,i,now+day,[]byte("live")); err!=nil { t.Fatal(err) }
+	}
+	if err := gi.AssignArticleToGroup(group,11,now-3*day+5,[]byte("old")); err!=nil { t.Fatal(err) }
+	expect(t,b,gi,[]uint64{1,2,3,4,5,6,7,8,9,10,11},1,11)
+	
+	n,err := sw.Sweep()
+	if err!=nil || n!=9 { t.Fatalf("Sweep() -> %d %v, expected 9",n,err) }
+	expect(t,b,gi,[]uint64{9,10},9,11)
+	
+	n,err = sw.Sweep()
+	if err!=nil || n!=0 { t.Fatalf("Sweep() again -> %d %v, expected 0",n,err) }
+	
+	now += 3*day
+	n,err = sw.Sweep()
+	if err!=nil || n!=2 { t.Fatalf("Sweep() later -> %d %v, expected 2",n,err) }
+	expect(t,b,gi,nil,12,11)
+}
+
+func TestGroupdb2(t *testing.T) {
+	b,cleanup := tempBolt(t)
+	defer cleanup()
+	db := groupdb2.NewDB(b,table)
+	testSweep(t,b,db,db.Sweeper())
+}
+
+func TestGroupdb3(t *testing.T) {
+	b,cleanup := tempBolt(t)
+	defer cleanup()
+	db := groupdb3.NewDB(b,table)
+	testSweep(t,b,db,db.Sweeper())
+}
@@ -45,6 +45,7 @@ func initGen(session *gocql.Session) {
 		PRIMARY KEY(identifier,livesuntil)
 	)
 	`).Exec()
+	initSince(session)
 }
 func Initialize(session *gocql.Session) {
 	initGen(session)
@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package cassm
+
+import "github.com/gocql/gocql"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "time"
+
+/*
+The arrival index. It is partitioned by group and day of arrival. A seperate table
+keeps track of the days, so that only those partitions are visited, that exist.
+
+Like in agrpcnt, a day of arrival has one row for every day, on which some of its
+articles expire (livesuntil), each with its own TTL. So the day stays listed, as
+long as any of its articles lives, no matter in which order they arrive.
+*/
+func initSince(session *gocql.Session) {
+	session.Query(`
+	CREATE TABLE IF NOT EXISTS agsince1 (
+		identifier uuid,
+		arrivalday bigint,
+		livesuntil bigint,
+		PRIMARY KEY(identifier,arrivalday,livesuntil)
+	)
+	`).Exec()
+	session.Query(`
+	CREATE TABLE IF NOT EXISTS agsince2 (
+		identifier uuid,
+		arrivalday bigint,
+		arrival bigint,
+		articlenum bigint,
+		messageid blob,
+		PRIMARY KEY((identifier,arrivalday),arrival,articlenum)
+	)
+	`).Exec()
+}
+
+const (
+	qArrival1 = `INSERT INTO agsince1 (identifier,arrivalday,livesuntil) VALUES (?,?,?) USING TTL ?`
+	qArrival2 = `INSERT INTO agsince2 (identifier,arrivalday,arrival,articlenum,messageid) VALUES (?,?,?,?,?) USING TTL ?`
+)
+
+/* lives is the end of the day, the article expires on, ttl is the TTL of the day row. */
+func arrivalNow(secs int64) (day, now, lives, ttl int64) {
+	now = time.Now().UTC().Unix()
+	day = now-(now%DAY)
+	lives = now+secs+DAY-1
+	lives -= lives%DAY
+	ttl = lives-now
+	return
+}
+
+/* Records the arrival of an article. The entries live as long as the article. */
+func recordArrival(session *gocql.Session, cons gocql.Consistency, gid gocql.UUID, num interface{}, id []byte, secs int64) error {
+	day,now,lives,ttl := arrivalNow(secs)
+	err := qExec(session.Query(qArrival2,gid,day,now,num,id,secs).Consistency(cons))
+	if err!=nil { return err }
+	return qExec(session.Query(qArrival1,gid,day,lives,ttl).Consistency(cons))
+}
+func batchArrival(batch *gocql.Batch, gid gocql.UUID, num interface{}, id []byte, secs int64) {
+	day,now,lives,ttl := arrivalNow(secs)
+	batch.Query(qArrival2,gid,day,now,num,id,secs)
+	batch.Query(qArrival1,gid,day,lives,ttl)
+}
+
+func listSince(session *gocql.Session, wildmat []byte, since int64, targ func(group []byte, num int64, id []byte)) {
+	type grp struct{
+		name []byte
+		gid  gocql.UUID
+	}
+	if since<0 { since = 0 }
+	var groups []grp
+	var name []byte
+	var gid gocql.UUID
+	
+	iter1 := qIter(session.Query(`SELECT groupname,identifier FROM newsgroups`).PageSize(1<<12))
+	for iter1.Scan(&name,&gid) {
+		if groupidx.MatchWildmat(wildmat,name) { groups = append(groups,grp{append([]byte(nil),name...),gid}) }
+	}
+	iter1.Close()
+	
+	var day,last,num int64
+	var id []byte
+	var iter iter
+	defer iter.sClose()
+	for _,g := range groups {
+		var days []int64
+		iter.place(qIter(session.Query(`
+			SELECT arrivalday FROM agsince1 WHERE identifier = ? AND arrivalday >= ?
+		`,g.gid,since-(since%DAY))))
+		last = -1
+		for iter.Scan(&day) {
+			if day!=last { days = append(days,day) }
+			last = day
+		}
+		for _,d := range days {
+			iter.place(qIter(session.Query(`
+				SELECT articlenum,messageid
+				FROM agsince2
+				WHERE identifier = ? AND arrivalday = ? AND arrival >= ?
+			`,g.gid,d,since).PageSize(1<<16).Prefetch(.25)))
+			for iter.Scan(&num,&id) { targ(g.name,num,id) }
+		}
+	}
+}
+
+func (g *SimpleGroupDB) ListArticlesSince(wildmat []byte, since int64, targ func(group []byte, num int64, id []byte)) {
+	listSince(g.Session,wildmat,since,targ)
+}
+func (g *N2LayerGroupDB) ListArticlesSince(wildmat []byte, since int64, targ func(group []byte, num int64, id []byte)) {
+	listSince(g.Session,wildmat,since,targ)
+}
+
+var _ groupidx.GroupIndexSince = (*SimpleGroupDB)(nil)
+var _ groupidx.GroupIndexSince = (*N2LayerGroupDB)(nil)
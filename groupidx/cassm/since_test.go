@@ -0,0 +1,54 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package cassm
+
+import "testing"
+
+/*
+The day row must outlive every article of its day, whatever their lifetimes and
+the order of their arrival.
+*/
+func TestArrivalNow(t *testing.T) {
+	var prev int64
+	for i,secs := range []int64{0,1,59,3600,DAY-1,DAY,DAY+1,30*DAY} {
+		day,now,lives,ttl := arrivalNow(secs)
+		if day%DAY!=0 || day>now || now>=day+DAY { t.Errorf("arrivalNow(%d): day %d doesn't contain %d",secs,day,now) }
+		if lives%DAY!=0 { t.Errorf("arrivalNow(%d): lives=%d is not the end of a day",secs,lives) }
+		if lives<now+secs { t.Errorf("arrivalNow(%d): the day row expires at %d, before the article at %d",secs,lives,now+secs) }
+		if lives>=now+secs+DAY { t.Errorf("arrivalNow(%d): the day row lives until %d, a day too long",secs,lives) }
+		if ttl!=lives-now || ttl<=0 { t.Errorf("arrivalNow(%d): ttl=%d, lives-now=%d",secs,ttl,lives-now) }
+		
+		/*
+		A longer lived article gets its own row, so a later, shorter lived one
+		can't shorten the life of the day.
+		*/
+		if i>0 && lives<prev { t.Errorf("arrivalNow(%d): lives=%d < %d",secs,lives,prev) }
+		prev = lives
+	}
+	_,_,short,_ := arrivalNow(60)
+	_,_,long,_ := arrivalNow(10*DAY)
+	if short==long { t.Errorf("articles living 60s and 10 days share the row livesuntil=%d",short) }
+}
@@ -78,6 +78,8 @@ func (g *N2LayerGroupDB) AssignArticleToGroup(group []byte, num, exp uint64, id
 		UPDATE agstat1l2 USING TTL ? SET expiresat = ? WHERE identifier = ? AND articlepart = ? IF expiresat < ?
 	`,secs,exp,gid,nxs,exp).Consistency(g.OnAssign))
 	if err!=nil { return err }
+	err = recordArrival(g.Session,g.OnAssign,gid,num,id,secs)
+	if err!=nil { return err }
 	
 	err = qExec(g.Session.Query(`
 		UPDATE agrpcnt SET number = number + 1 WHERE identifier = ? AND livesuntil = ?
@@ -108,6 +110,7 @@ func (g *N2LayerGroupDB) AssignArticleToGroups(groups [][]byte, nums []int64, ex
 		batch.Query(`
 			INSERT INTO agstat2l2 (identifier,articlepart,articlenum,messageid) VALUES (?,?,?,?) USING TTL ?
 		`,gid,nxs,nums[i],id,secs)
+		batchArrival(batch,gid,nums[i],id,secs)
 		insbt.Query(`
 			INSERT INTO agstat1l2 (identifier,articlepart,expiresat) VALUES (?,?,?) IF NOT EXISTS USING TTL ?
 		`,gid,nxs,exp,secs)
@@ -65,6 +65,8 @@ func (g *SimpleGroupDB) AssignArticleToGroup(group []byte, num, exp uint64, id [
 		INSERT INTO agstat (identifier,articlenum,messageid) VALUES (?,?,?) USING TTL ?
 	`,gid,num,id,secs).Consistency(g.OnAssign).Exec()
 	if err!=nil { return err }
+	err = recordArrival(g.Session,g.OnAssign,gid,num,id,secs)
+	if err!=nil { return err }
 	err = g.Session.Query(`
 		UPDATE agrpcnt SET number = number + 1 WHERE identifier = ? AND livesuntil = ?
 	`,gid,coarse).Consistency(g.OnIncrement).Exec()
@@ -88,6 +90,7 @@ func (g *SimpleGroupDB) AssignArticleToGroups(groups [][]byte, nums []int64, exp
 		batch.Query(`
 			INSERT INTO agstat (identifier,articlenum,messageid) VALUES (?,?,?) USING TTL ?
 		`,gid,nums[i],id,secs)
+		batchArrival(batch,gid,nums[i],id,secs)
 		ctrbt.Query(`
 			UPDATE agrpcnt SET number = number + 1 WHERE identifier = ? AND livesuntil = ?
 		`,gid,coarse)
@@ -38,7 +38,7 @@ func (a *Range) Match(b *Range) bool {
 }
 func (a *Range) Contains(i int64) bool {
 	if i < a.Beg { return false }
-	if a.Beg < i { return false }
+	if a.End < i { return false }
 	return true
 }
 func (a *Range) UnionInPlace(b *Range) {
@@ -51,8 +51,13 @@ func (a *Range) String() string {
 	return fmt.Sprintf("%d",a.Beg)
 }
 
+/*
+UnixTime is the expiration time. Arrival is the time, the article was assigned
+to the group. Entries, written before Arrival existed, have Arrival==nil.
+*/
 type ArticleKey struct{
 	Article, UnixTime *Range
+	Arrival *Range
 }
 
 func ArticleKeyLiteral(article int64) *ArticleKey {
@@ -63,6 +68,10 @@ func (a *ArticleKey) WithUnixTime(ut int64) *ArticleKey {
 	a.UnixTime = &Range{ut,ut}
 	return a
 }
+func (a *ArticleKey) WithArrival(ut int64) *ArticleKey {
+	a.Arrival = &Range{ut,ut}
+	return a
+}
 func (a *ArticleKey) lowAN() int64 {
 	if a.Article==nil { return 0 }
 	return a.Article.Beg
@@ -78,6 +87,10 @@ func (a *ArticleKey) Clone() (b *ArticleKey) {
 		b.UnixTime = new(Range)
 		*(b.UnixTime) = *(a.UnixTime)
 	}
+	if a.Arrival!=nil {
+		b.Arrival = new(Range)
+		*(b.Arrival) = *(a.Arrival)
+	}
 	return
 }
 
@@ -93,6 +106,9 @@ func (a *ArticleKey) Match(b *ArticleKey) bool {
 	if a.UnixTime!=nil && b.UnixTime!=nil {
 		if !a.UnixTime.Match(b.UnixTime) { return false }
 	}
+	if a.Arrival!=nil && b.Arrival!=nil {
+		if !a.Arrival.Match(b.Arrival) { return false }
+	}
 	return true
 }
 
@@ -107,12 +123,18 @@ func (a *ArticleKey) UnionInPlace(b *ArticleKey) {
 	} else if a.UnixTime!=nil {
 		a.UnixTime.UnionInPlace(b.UnixTime)
 	}
+	if b.Arrival == nil {
+		a.Arrival = nil
+	} else if a.Arrival!=nil {
+		a.Arrival.UnionInPlace(b.Arrival)
+	}
 }
 
 func (a *ArticleKey) EncodeMsgpack(dst *msgpack.Encoder) error {
 	u := uint64(0)
 	if a.Article!=nil { u |= 1 }
 	if a.UnixTime!=nil { u |= 2 }
+	if a.Arrival!=nil { u |= 4 }
 	err := dst.EncodeUint(u)
 	if err!=nil { return err }
 	if r := a.Article; r!=nil {
@@ -123,6 +145,10 @@ func (a *ArticleKey) EncodeMsgpack(dst *msgpack.Encoder) error {
 		err = dst.Encode(r.Beg,r.End)
 		if err!=nil { return err }
 	}
+	if r := a.Arrival; r!=nil {
+		err = dst.Encode(r.Beg,r.End)
+		if err!=nil { return err }
+	}
 	return nil
 }
 
@@ -141,6 +167,12 @@ func (a *ArticleKey) DecodeMsgpack(src *msgpack.Decoder) error {
 		if err!=nil { return err }
 		a.UnixTime = r
 	}
+	if (u&4)!=0 {
+		r := new(Range)
+		err = src.Decode(&(r.Beg),&(r.End))
+		if err!=nil { return err }
+		a.Arrival = r
+	}
 	return nil
 }
 
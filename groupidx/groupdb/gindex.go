@@ -0,0 +1,234 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package groupdb
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "context"
+import "math"
+import "sort"
+import "time"
+
+func (tx *Tx) GroupHeadInsert(groups [][]byte, buf []int64) ([]int64, error) {
+	ctx := context.Background()
+	if cap(buf)<len(groups) { buf = make([]int64,len(groups)) } else { buf = buf[:len(groups)] }
+	for i,group := range groups {
+		gep,ge,err := tx.createGroup(ctx,group)
+		if err!=nil { return nil,err }
+		if ge.Next<ge.High { ge.Next = ge.High }
+		ge.Next++
+		err = tx.putGroup(gep,&ge)
+		if err!=nil { return nil,err }
+		buf[i] = ge.Next
+	}
+	return buf,nil
+}
+
+/*
+Only the highest number can be given back. Other numbers are left as gaps.
+*/
+func (tx *Tx) GroupHeadRevert(groups [][]byte, nums []int64) error {
+	ctx := context.Background()
+	for i,group := range groups {
+		gep,ge,err := tx.lookupGroup(ctx,group)
+		if err==ENoSuchGroup { continue }
+		if err!=nil { return err }
+		if nums[i]<=0 || ge.Next!=nums[i] { continue }
+		ge.Next--
+		err = tx.putGroup(gep,&ge)
+		if err!=nil { return err }
+	}
+	return nil
+}
+
+func (tx *Tx) ArticleGroupStat(group []byte, num int64, id_buf []byte) (id []byte, ok bool) {
+	ctx := context.Background()
+	_,ge,err := tx.lookupGroup(ctx,group)
+	if err!=nil { return }
+	tx.search(ctx,&ge,alive(&Range{num,num}),func(k *ArticleKey, v []byte) bool {
+		id = append(id_buf[:0],v...)
+		ok = true
+		return false
+	})
+	return
+}
+
+/*
+Searches windows of growing size next to i, until an article is found or the
+end of the group is reached.
+*/
+func (tx *Tx) ArticleGroupMove(group []byte, i int64, backward bool, id_buf []byte) (ni int64, id []byte, ok bool) {
+	ctx := context.Background()
+	_,ge,err := tx.lookupGroup(ctx,group)
+	if err!=nil { return }
+	for w := int64(64); ; w<<=1 {
+		var r Range
+		if backward {
+			if i<=ge.Low { return }
+			r = Range{i-w,i-1}
+			if r.Beg<ge.Low { r.Beg = ge.Low }
+			i = r.Beg
+		} else {
+			if i>=ge.High { return }
+			r = Range{i+1,i+w}
+			if r.End>ge.High || r.End<i { r.End = ge.High }
+			i = r.End
+		}
+		list := tx.collect(ctx,&ge,alive(&r))
+		if len(list)==0 { continue }
+		a := list[0]
+		if backward { a = list[len(list)-1] }
+		return a.num,append(id_buf[:0],a.id...),true
+	}
+}
+
+func (tx *Tx) GroupRealtimeQuery(group []byte) (number int64, low int64, high int64, ok bool) {
+	_,ge,err := tx.lookupGroup(context.Background(),group)
+	if err!=nil { return }
+	return ge.Count,ge.Low,ge.High,true
+}
+
+func (tx *Tx) AssignArticleToGroup(group []byte, num, exp uint64, id []byte) error {
+	ctx := context.Background()
+	gep,ge,err := tx.createGroup(ctx,group)
+	if err!=nil { return err }
+	return tx.assign(ctx,gep,&ge,int64(num),id,int64(exp))
+}
+func (tx *Tx) AssignArticleToGroups(groups [][]byte, nums []int64, exp uint64, id []byte) error {
+	for i,group := range groups {
+		err := tx.AssignArticleToGroup(group,uint64(nums[i]),exp,id)
+		if err!=nil { return err }
+	}
+	return nil
+}
+
+func (tx *Tx) ListArticleGroupRaw(group []byte, first, last int64, targ func(int64, []byte)) {
+	ctx := context.Background()
+	_,ge,err := tx.lookupGroup(ctx,group)
+	if err!=nil { return }
+	for _,a := range tx.collect(ctx,&ge,alive(&Range{first,last})) { targ(a.num,a.id) }
+}
+
+/*
+Lists the articles of a group, that expire within from and to (Unix seconds),
+using the expiration time, as passed to AssignArticleToGroup. For the time of
+arrival, see ListArticleGroupArrived. Expired articles are hidden, so from is raised to the current time,
+and nothing is listed, if to lies in the past.
+
+This is a range query on the second dimension of the GiST tree, so it does not
+need to scan the whole group.
+*/
+func (tx *Tx) ListArticleGroupExpiring(group []byte, from, to int64, targ func(int64, []byte)) {
+	ctx := context.Background()
+	_,ge,err := tx.lookupGroup(ctx,group)
+	if err!=nil { return }
+	if now := time.Now().UTC().Unix(); from<now { from = now }
+	if from>to { return }
+	q := &ArticleKey{Article:&Range{math.MinInt64,math.MaxInt64},UnixTime:&Range{from,to}}
+	for _,a := range tx.collect(ctx,&ge,q) { targ(a.num,a.id) }
+}
+
+/*
+Lists the articles of a group, that arrived within from and to (Unix seconds).
+Expired articles are hidden. Articles, that were assigned before the arrival
+time was recorded, are never listed.
+
+This is a range query on the third dimension of the GiST tree.
+*/
+func (tx *Tx) ListArticleGroupArrived(group []byte, from, to int64, targ func(int64, []byte)) {
+	ctx := context.Background()
+	_,ge,err := tx.lookupGroup(ctx,group)
+	if err!=nil { return }
+	if from>to { return }
+	q := alive(&Range{math.MinInt64,math.MaxInt64})
+	q.Arrival = &Range{from,to}
+	var list []article
+	tx.search(ctx,&ge,q,func(k *ArticleKey, id []byte) bool {
+		if k.Arrival!=nil { list = append(list,article{k.lowAN(),append([]byte(nil),id...)}) }
+		return true
+	})
+	sort.Slice(list,func(i,j int) bool { return list[i].num<list[j].num })
+	for _,a := range list { targ(a.num,a.id) }
+}
+
+var _ groupidx.GroupIndex = (*Tx)(nil)
+
+func (db *DB) GroupHeadInsert(groups [][]byte, buf []int64) (nums []int64, err error) {
+	err = db.update(func(tx *Tx) (err error) {
+		nums,err = tx.GroupHeadInsert(groups,buf)
+		return
+	})
+	return
+}
+func (db *DB) GroupHeadRevert(groups [][]byte, nums []int64) error {
+	return db.update(func(tx *Tx) error { return tx.GroupHeadRevert(groups,nums) })
+}
+func (db *DB) ArticleGroupStat(group []byte, num int64, id_buf []byte) (id []byte, ok bool) {
+	db.view(func(tx *Tx) error {
+		id,ok = tx.ArticleGroupStat(group,num,id_buf)
+		return nil
+	})
+	return
+}
+func (db *DB) ArticleGroupMove(group []byte, i int64, backward bool, id_buf []byte) (ni int64, id []byte, ok bool) {
+	db.view(func(tx *Tx) error {
+		ni,id,ok = tx.ArticleGroupMove(group,i,backward,id_buf)
+		return nil
+	})
+	return
+}
+func (db *DB) GroupRealtimeQuery(group []byte) (number int64, low int64, high int64, ok bool) {
+	db.view(func(tx *Tx) error {
+		number,low,high,ok = tx.GroupRealtimeQuery(group)
+		return nil
+	})
+	return
+}
+func (db *DB) AssignArticleToGroup(group []byte, num, exp uint64, id []byte) error {
+	return db.update(func(tx *Tx) error { return tx.AssignArticleToGroup(group,num,exp,id) })
+}
+func (db *DB) AssignArticleToGroups(groups [][]byte, nums []int64, exp uint64, id []byte) error {
+	return db.update(func(tx *Tx) error { return tx.AssignArticleToGroups(groups,nums,exp,id) })
+}
+func (db *DB) ListArticleGroupRaw(group []byte, first, last int64, targ func(int64, []byte)) {
+	db.view(func(tx *Tx) error {
+		tx.ListArticleGroupRaw(group,first,last,targ)
+		return nil
+	})
+}
+/* See Tx.ListArticleGroupExpiring. */
+func (db *DB) ListArticleGroupExpiring(group []byte, from, to int64, targ func(int64, []byte)) {
+	db.view(func(tx *Tx) error {
+		tx.ListArticleGroupExpiring(group,from,to,targ)
+		return nil
+	})
+}
+/* See Tx.ListArticleGroupArrived. */
+func (db *DB) ListArticleGroupArrived(group []byte, from, to int64, targ func(int64, []byte)) {
+	db.view(func(tx *Tx) error {
+		tx.ListArticleGroupArrived(group,from,to,targ)
+		return nil
+	})
+}
+
+var _ groupidx.GroupIndex = (*DB)(nil)
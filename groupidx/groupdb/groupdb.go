@@ -27,33 +27,52 @@ import "github.com/maxymania/storage-engines/anytree"
 import "github.com/maxymania/storage-engines/anytree/gistops"
 import "context"
 import "errors"
+import "math"
+import "sort"
+import "time"
 
 var ENoSuchGroup = errors.New("ENoSuchGroup")
 
 var groupTree   = anytree.GiST{Ops: gistops.StrkeyOps(),TreeM:24}
 var articleTree = anytree.GiST{Ops:     ArticleKeyOps(),TreeM:85}
 
+/* The fields must be exported for encoding/binary. */
 type groupEntry struct{
-	root  int64
-	count int64
-	low   int64
-	high  int64
+	Root  int64
+	Count int64
+	Low   int64
+	High  int64
+	Next  int64 // the highest number, handed out by GroupHeadInsert
 }
 
 type DB struct{
 	inner *anytree.DB
 }
+func NewDB(db *anytree.DB) *DB {
+	return &DB{db}
+}
+func (db *DB) update(f func(tx *Tx) error) error {
+	t,err := db.inner.Begin(true)
+	if err!=nil { return err }
+	err = f(&Tx{db,t})
+	if err!=nil { t.Rollback(); return err }
+	return t.Commit()
+}
+func (db *DB) view(f func(tx *Tx) error) error {
+	t,err := db.inner.Begin(false)
+	if err!=nil { return err }
+	defer t.Rollback()
+	return f(&Tx{db,t})
+}
 
 type Tx struct{
 	parent *DB
 	inner *anytree.Tx
 }
-func (tx *Tx) AssignArticle(ctx context.Context,group []byte, num int64, id []byte, expire int64) error {
-	var gep int64
-	var ge groupEntry
-	
+
+func (tx *Tx) lookupGroup(ctx context.Context,group []byte) (gep int64, ge groupEntry, err error) {
 	root,err := tx.inner.GetRoot()
-	if err!=nil { return err }
+	if err!=nil { return }
 	ch := make(chan anytree.Pair,1)
 	tree := groupTree.WithTransaction(tx.inner)
 	tree.Root = root
@@ -61,38 +80,107 @@ func (tx *Tx) AssignArticle(ctx context.Context,group []byte, num int64, id []by
 	go tree.SearchGiST(cc,gistops.StrkeyLiteral(group),ch)
 	p := <- ch
 	cancel()
-	if p.K==nil { return ENoSuchGroup }
+	if p.K==nil { err = ENoSuchGroup; return }
 	err = Unmarshal(p.V.Ptr,&gep)
 	p.V.Free()
-	if err!=nil { return err }
+	if err!=nil { return }
 	
 	b,err := tx.inner.Read(gep)
 	
-	if err!=nil { b.Free(); return err }
+	if err!=nil { b.Free(); return }
 	
 	err = Unmarshal(b.Ptr,&ge)
 	b.Free()
+	return
+}
+func (tx *Tx) putGroup(gep int64, ge *groupEntry) error {
+	gedata,err := Marshal(ge)
 	if err!=nil { return err }
+	return tx.inner.Update(gep,gedata)
+}
+
+/* Creates the group, if it doesn't exist. */
+func (tx *Tx) createGroup(ctx context.Context,group []byte) (gep int64, ge groupEntry, err error) {
+	gep,ge,err = tx.lookupGroup(ctx,group)
+	if err!=ENoSuchGroup { return }
 	
+	gedata,err := Marshal(ge)
+	if err!=nil { return }
+	gep,err = tx.inner.Insert(gedata)
+	if err!=nil { return }
+	gepdata,err := Marshal(gep)
+	if err!=nil { return }
+	
+	root,err := tx.inner.GetRoot()
+	if err!=nil { return }
+	tree := groupTree.WithTransaction(tx.inner)
+	tree.Root = root
+	err = tree.InsertGiST(ctx,gistops.StrkeyLiteral(group),gepdata)
+	if err!=nil { return }
+	err = tx.inner.SetRoot(tree.Root)
+	return
+}
+func (tx *Tx) CreateGroup(ctx context.Context,group []byte) error {
+	_,_,err := tx.createGroup(ctx,group)
+	return err
+}
+
+func (tx *Tx) assign(ctx context.Context,gep int64, ge *groupEntry, num int64, id []byte, expire int64) error {
 	artree := articleTree.WithTransaction(tx.inner)
-	artree.Root = ge.root
+	artree.Root = ge.Root
 	
-	if ge.low  > num || ge.low==0 { ge.low = num }
-	if ge.high < num { ge.high = num }
+	if ge.Low  > num || ge.Low==0 { ge.Low = num }
+	if ge.High < num { ge.High = num }
 	
-	ge.count++
+	ge.Count++
 	
-	err = artree.InsertGiST(ctx,ArticleKeyLiteral(num).WithUnixTime(expire),id)
+	arrival := time.Now().UTC().Unix()
+	err := artree.InsertGiST(ctx,ArticleKeyLiteral(num).WithUnixTime(expire).WithArrival(arrival),id)
 	
 	if err!=nil { return err }
 	
-	ge.root = artree.Root
+	ge.Root = artree.Root
 	
-	gedata,err := Marshal(ge)
+	return tx.putGroup(gep,ge)
+}
+func (tx *Tx) AssignArticle(ctx context.Context,group []byte, num int64, id []byte, expire int64) error {
+	gep,ge,err := tx.lookupGroup(ctx,group)
 	if err!=nil { return err }
-	
-	err = tx.inner.Update(gep,gedata)
-	
-	return err
+	return tx.assign(ctx,gep,&ge,num,id,expire)
 }
 
+/* Calls f for every article matching q, until f returns false. id is only valid during the call. */
+func (tx *Tx) search(ctx context.Context,ge *groupEntry, q *ArticleKey, f func(k *ArticleKey, id []byte) bool) {
+	if ge.Root==0 { return }
+	artree := articleTree.WithTransaction(tx.inner)
+	artree.Root = ge.Root
+	ch := make(chan anytree.Pair,16)
+	cc,cancel := context.WithCancel(ctx)
+	defer cancel()
+	go artree.SearchGiST(cc,q,ch)
+	for p := range ch {
+		ok := f(p.K.(*ArticleKey),p.V.Ptr)
+		p.V.Free()
+		if !ok { return }
+	}
+}
+
+type article struct{
+	num int64
+	id  []byte
+}
+
+/* Returns the articles matching q, ordered by number. */
+func (tx *Tx) collect(ctx context.Context,ge *groupEntry, q *ArticleKey) (list []article) {
+	tx.search(ctx,ge,q,func(k *ArticleKey, id []byte) bool {
+		list = append(list,article{k.lowAN(),append([]byte(nil),id...)})
+		return true
+	})
+	sort.Slice(list,func(i,j int) bool { return list[i].num<list[j].num })
+	return
+}
+
+/* Matches the articles within r, that are not expired. */
+func alive(r *Range) *ArticleKey {
+	return &ArticleKey{Article:r,UnixTime:&Range{time.Now().UTC().Unix(),math.MaxInt64}}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package groupdb
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/gitest"
+import "github.com/maxymania/storage-engines/anytree"
+import "context"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+import "os"
+import "time"
+
+func tempDB(t *testing.T) (*DB,func()) {
+	dir,err := ioutil.TempDir("","groupdb")
+	if err!=nil { t.Fatal(err) }
+	inner,err := anytree.OpenDB(filepath.Join(dir,"groups.db"))
+	if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+	return NewDB(inner),func() {
+		inner.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	s := &gitest.Suite{New:func(t *testing.T) (groupidx.GroupIndex,func()) {
+		return tempDB(t)
+	}}
+	s.Run(t)
+	s.OverWire2().Run(t)
+}
+
+func arrived(db *DB, group []byte, from, to int64) (nums []int64) {
+	db.ListArticleGroupArrived(group,from,to,func(n int64, id []byte) { nums = append(nums,n) })
+	return
+}
+
+func TestArrived(t *testing.T) {
+	db,cleanup := tempDB(t)
+	defer cleanup()
+	group := []byte("a.b")
+	exp := uint64(time.Now().Unix())+3600
+	t0 := time.Now().UTC().Unix()
+	db.AssignArticleToGroup(group,1,exp,[]byte("<1>"))
+	db.AssignArticleToGroup(group,2,exp,[]byte("<2>"))
+	db.AssignArticleToGroup(group,3,uint64(t0)-1,[]byte("<expired>"))
+	
+	/* Wait for the next second, so the second batch arrives later. */
+	time.Sleep(time.Until(time.Unix(time.Now().Unix()+1,0)))
+	t1 := time.Now().UTC().Unix()
+	db.AssignArticleToGroup(group,5,exp,[]byte("<5>"))
+	db.AssignArticleToGroup(group,4,exp,[]byte("<4>"))
+	t2 := time.Now().UTC().Unix()
+	
+	check := func(from, to int64, expect ...int64) {
+		nums := arrived(db,group,from,to)
+		if len(nums)!=len(expect) {
+			t.Errorf("ListArticleGroupArrived(%d,%d) -> %v, expected %v",from,to,nums,expect)
+			return
+		}
+		for i := range nums {
+			if nums[i]!=expect[i] {
+				t.Errorf("ListArticleGroupArrived(%d,%d) -> %v, expected %v",from,to,nums,expect)
+				return
+			}
+		}
+	}
+	check(t0,t2,1,2,4,5)
+	check(t0,t1-1,1,2)
+	check(t1,t2,4,5)
+	check(t0-100,t0-1)
+	check(t2+1,t2+100)
+	check(t2,t0)
+	if nums := arrived(db,[]byte("unknown"),t0,t2); len(nums)!=0 {
+		t.Errorf("ListArticleGroupArrived on unknown group -> %v",nums)
+	}
+	
+	/* An entry without an arrival time, as written by older versions. */
+	err := db.update(func(tx *Tx) error {
+		gep,ge,err := tx.lookupGroup(context.Background(),group)
+		if err!=nil { return err }
+		artree := articleTree.WithTransaction(tx.inner)
+		artree.Root = ge.Root
+		err = artree.InsertGiST(context.Background(),ArticleKeyLiteral(6).WithUnixTime(int64(exp)),[]byte("<6>"))
+		if err!=nil { return err }
+		ge.Root = artree.Root
+		return tx.putGroup(gep,&ge)
+	})
+	if err!=nil { t.Fatal(err) }
+	if id,ok := db.ArticleGroupStat(group,6,nil); !ok || string(id)!="<6>" {
+		t.Errorf("ArticleGroupStat(6) -> %q %v",id,ok)
+	}
+	check(t0,t2,1,2,4,5)
+}
@@ -100,6 +100,9 @@ func (t Tx) AssignArticleToGroup(group []byte, num, exp uint64, id []byte) error
 	err = tsi.Insert(num,exp,id)
 	if err!=nil { return err }
 	
+	err = t.arrived(bkt,num,now())
+	if err!=nil { return err }
+	
 	return bkt.Put(iCount,nubrin.Encode(nubrin.Decode(  bkt.Get(iCount)  )+1))
 }
 
@@ -121,7 +124,7 @@ func (t Tx) ArticleGroupStat(group []byte, num int64, id_buf []byte) ([]byte, bo
 	}
 	
 	e,val := tsi.Lookup(uint64(num))
-	if e < current { return nil,false }
+	if e < now() { return nil,false }
 	if len(val)==0 { return nil,false }
 	
 	return append(id_buf[:0],val...),true
@@ -164,24 +167,27 @@ func (t Tx) ArticleGroupMove(group []byte, i int64, backward bool, id_buf []byte
 	
 	k,v := c.Seek(nubrin.Encode(uint64(i)))
 	
-	ni = int64(nubrin.Decode(k))
-	
 	if backward {
 		k,v = c.Prev()
-		ni = int64(nubrin.Decode(k))
-	} else if ni==i {
+	} else if len(k)!=0 && int64(nubrin.Decode(k))==i {
 		k,v = c.Next()
-		ni = int64(nubrin.Decode(k))
 	}
 	
-	if len(k)==0 { return }
-	if ni==0 { return }
-	
-	ee,mid := nubrin.SplitOffSecond(v)
-	if len(mid)==0 { return }
-	if nubrin.Decode(ee) < current { return }
-	
-	id = append(id_buf[:0],mid...)
+	/* Skip expired entries. */
+	for len(k)!=0 {
+		ni = int64(nubrin.Decode(k))
+		if ni==0 { break }
+		
+		ee,mid := nubrin.SplitOffSecond(v)
+		if len(mid)!=0 && nubrin.Decode(ee) >= now() {
+			id = append(id_buf[:0],mid...)
+			ok = true
+			return
+		}
+		
+		if backward { k,v = c.Prev() } else { k,v = c.Next() }
+	}
+	ni = 0
 	return
 }
 
@@ -194,7 +200,7 @@ func (t Tx) ListArticleGroupRaw(group []byte, first, last int64, targ func(int64
 		i := int64(nubrin.Decode(k))
 		if i>last { break }
 		ee,id := nubrin.SplitOffSecond(v)
-		if nubrin.Decode(ee) >= current { targ(i,id) }
+		if nubrin.Decode(ee) >= now() { targ(i,id) }
 	}
 }
 func (t Tx) GroupRealtimeQuery(group []byte) (number int64, low int64, high int64, ok bool) {
@@ -210,6 +216,14 @@ func (t Tx) GroupRealtimeQuery(group []byte) (number int64, low int64, high int6
 	low = int64(nubrin.Decode(k))
 	k,_ = c.Last()
 	high = int64(nubrin.Decode(k))
+	
+	/* The high mark stays, even if the Sweeper deleted the highest entries. */
+	if v := bkt.Get(iHigh); len(v)!=0 {
+		if h := int64(nubrin.Decode(v)); h>high {
+			high = h
+			if low==0 { low = high+1 }
+		}
+	}
 	return
 }
 
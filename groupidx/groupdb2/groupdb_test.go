@@ -0,0 +1,52 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package groupdb2
+
+import bolt "github.com/coreos/bbolt"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/gitest"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+import "os"
+
+func tempDB(t *testing.T) (*DB,func()) {
+	dir,err := ioutil.TempDir("","groupdb2")
+	if err!=nil { t.Fatal(err) }
+	b,err := bolt.Open(filepath.Join(dir,"groups.db"),0600,nil)
+	if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+	return NewDB(b,[]byte("groups")),func() {
+		b.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	s := &gitest.Suite{New:func(t *testing.T) (groupidx.GroupIndex,func()) {
+		return tempDB(t)
+	}}
+	s.Run(t)
+	s.OverWire2().Run(t)
+	s.OverWire1().Run(t)
+}
@@ -110,7 +110,14 @@ func (db *DB) GroupRealtimeQuery(group []byte) (number int64, low int64, high in
 	})
 	return
 }
+func (db *DB) ListArticlesSince(wildmat []byte, since int64, targ func(group []byte, num int64, id []byte)) {
+	db.view(func(t *bolt.Bucket) (err error) {
+		Tx{t}.ListArticlesSince(wildmat,since,targ)
+		return
+	})
+}
 
 
 var _ groupidx.GroupIndex = (*DB)(nil)
+var _ groupidx.GroupIndexSince = (*DB)(nil)
 
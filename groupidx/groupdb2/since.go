@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package groupdb2
+
+import bolt "github.com/coreos/bbolt"
+import "github.com/maxymania/gonbase/nubrin"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/boltsweep"
+
+func clone(b []byte) []byte { return append(make([]byte,0,len(b)),b...) }
+
+/* The arrival index of a group: Encode(arrival)+Encode(num) → nothing. */
+var iArrival = []byte("arrival")
+
+func (t Tx) arrived(bkt *bolt.Bucket, num, at uint64) error {
+	arr,err := bkt.CreateBucketIfNotExists(iArrival)
+	if err!=nil { return err }
+	return arr.Put(append(nubrin.Encode(at),nubrin.Encode(num)...),iArrival)
+}
+
+/* Looks up an entry of the arrival index. Returns nil, if the article is gone or expired. */
+func lookupArrival(table *bolt.Bucket, k []byte, now uint64) (num uint64, id []byte) {
+	_,nk := nubrin.SplitOffSecond(k)
+	tv := table.Get(nk)
+	if len(tv)<8 { return }
+	ee,id := nubrin.SplitOffSecond(tv)
+	if nubrin.Decode(ee) < now { return 0,nil }
+	return nubrin.Decode(nk),id
+}
+
+func (t Tx) listSince(group []byte, since uint64, targ func(group []byte, num int64, id []byte)) {
+	bkt := t.inner.Bucket(group)
+	if bkt==nil { return }
+	arr,table := bkt.Bucket(iArrival),bkt.Bucket(iTable)
+	if arr==nil || table==nil { return }
+	c := arr.Cursor()
+	for k,_ := c.Seek(nubrin.Encode(since)); len(k)!=0; k,_ = c.Next() {
+		num,id := lookupArrival(table,k,now())
+		if len(id)!=0 { targ(group,int64(num),id) }
+	}
+}
+
+/*
+Lists the articles, that arrived since, ordered by arrival within each group.
+*/
+func (t Tx) ListArticlesSince(wildmat []byte, since int64, targ func(group []byte, num int64, id []byte)) {
+	if since<0 { since = 0 }
+	for _,group := range boltsweep.Groups(t.inner) {
+		if !groupidx.MatchWildmat(wildmat,group) { continue }
+		t.listSince(group,uint64(since),targ)
+	}
+}
+
+/*
+Deletes entries from the start of the arrival index, whose articles are gone or
+expired, looking at no more than limit entries. As articles usually expire in the
+order of their arrival, this keeps the index small.
+*/
+func trimArrival(bkt *bolt.Bucket, now uint64, limit int) error {
+	arr,table := bkt.Bucket(iArrival),bkt.Bucket(iTable)
+	if arr==nil || table==nil { return nil }
+	var dels [][]byte
+	c := arr.Cursor()
+	for k,_ := c.First(); len(k)!=0 && len(dels)<limit; k,_ = c.Next() {
+		if _,id := lookupArrival(table,k,now); len(id)!=0 { break }
+		dels = append(dels,clone(k))
+	}
+	for _,k := range dels {
+		if err := arr.Delete(k); err!=nil { return err }
+	}
+	return nil
+}
+
+var _ groupidx.GroupIndexSince = (*Tx)(nil)
@@ -37,6 +37,9 @@ var (
 	iTable = []byte("table")
 	iIndex = []byte("index")
 	iCount = []byte("count")
+	
+	/* The highest number, that has been handed out by GroupHeadInsert. */
+	iNext  = []byte("next")
 )
 
 type Tx struct{
@@ -76,6 +79,9 @@ func (t Tx) AssignArticleToGroup(group []byte, num, exp uint64, id []byte) error
 	err = tsi.Insert(num,exp,id)
 	if err!=nil { return err }
 	
+	err = t.arrived(bkt,num,now())
+	if err!=nil { return err }
+	
 	return bkt.Put(iCount,nubrin.Encode(nubrin.Decode(  bkt.Get(iCount)  )+1))
 }
 
@@ -115,12 +121,23 @@ func (t Tx) insertAllRecords(s *slog) error {
 		if len(tsi.Table.Get(nubrin.Encode(row.Number)))!=0 { continue }
 		err = tsi.Insert(row.Number,row.Expires,row.MessageId)
 		if err!=nil { return err }
+		if row.Arrival!=0 {
+			err = t.arrived(bkt,row.Number,row.Arrival)
+			if err!=nil { return err }
+		}
 		count++
 	}
 	if len(cgrp)!=0 {
 		err = bkt.Put(iCount,nubrin.Encode(count))
 		if err!=nil { return err }
 	}
+	/* The heads of the slog are newer, than the ones in the database. */
+	for group,h := range s.Heads {
+		bkt,err = t.createGroup([]byte(group))
+		if err!=nil { return err }
+		err = bkt.Put(iNext,nubrin.Encode(h))
+		if err!=nil { return err }
+	}
 	return err
 }
 
@@ -136,15 +153,63 @@ func (t Tx) ArticleGroupStat(group []byte, num int64, id_buf []byte) ([]byte, bo
 	}
 	
 	e,val := tsi.Lookup(uint64(num))
-	if e < current { return nil,false }
+	if e < now() { return nil,false }
 	if len(val)==0 { return nil,false }
 	
 	return append(id_buf[:0],val...),true
 }
 
-func (t Tx) GroupHeadInsert(groups [][]byte, buf []int64) ([]int64, error) { return nil,EUnimplemented }
+/*
+Returns the highest number, that is in use. Besides the numbers, handed out by
+GroupHeadInsert, this includes numbers, that have been assigned otherwise, and
+numbers of entries, deleted by the Sweeper.
+*/
+func (t Tx) head(group []byte) (h uint64) {
+	bkt := t.inner.Bucket(group)
+	if bkt==nil { return }
+	if v := bkt.Get(iNext); len(v)!=0 { h = nubrin.Decode(v) }
+	if v := bkt.Get(iHigh); len(v)!=0 {
+		if n := nubrin.Decode(v); n>h { h = n }
+	}
+	if tab := bkt.Bucket(iTable); tab!=nil {
+		if k,_ := tab.Cursor().Last(); len(k)!=0 {
+			if n := nubrin.Decode(k); n>h { h = n }
+		}
+	}
+	return
+}
+
+func (t Tx) GroupHeadInsert(groups [][]byte, buf []int64) ([]int64, error) {
+	
+	if cap(buf)<len(groups) { buf = make([]int64,len(groups)) } else { buf = buf[:len(groups)] }
+	
+	for i,group := range groups {
+		bkt,err := t.createGroup(group)
+		if err!=nil { return nil,err }
+		
+		n := t.head(group)+1
+		err = bkt.Put(iNext,nubrin.Encode(n))
+		if err!=nil { return nil,err }
+		
+		buf[i] = int64(n)
+	}
+	
+	return buf,nil
+}
 
-func (t Tx) GroupHeadRevert(groups [][]byte, nums []int64) error { return EUnimplemented }
+/*
+Only the highest number can be given back. Other numbers are left as gaps.
+*/
+func (t Tx) GroupHeadRevert(groups [][]byte, nums []int64) error {
+	for i,group := range groups {
+		bkt := t.inner.Bucket(group)
+		if bkt==nil || nums[i]<=0 { continue }
+		if nubrin.Decode(bkt.Get(iNext))!=uint64(nums[i]) { continue }
+		err := bkt.Put(iNext,nubrin.Encode(uint64(nums[i])-1))
+		if err!=nil { return err }
+	}
+	return nil
+}
 
 func (t Tx) ArticleGroupMove(group []byte, i int64, backward bool, id_buf []byte) (ni int64, id []byte, ok bool) {
 	bkt := t.inner.Bucket(group)
@@ -153,24 +218,27 @@ func (t Tx) ArticleGroupMove(group []byte, i int64, backward bool, id_buf []byte
 	
 	k,v := c.Seek(nubrin.Encode(uint64(i)))
 	
-	ni = int64(nubrin.Decode(k))
-	
 	if backward {
 		k,v = c.Prev()
-		ni = int64(nubrin.Decode(k))
-	} else if ni==i {
+	} else if len(k)!=0 && int64(nubrin.Decode(k))==i {
 		k,v = c.Next()
-		ni = int64(nubrin.Decode(k))
 	}
 	
-	if len(k)==0 { return }
-	if ni==0 { return }
-	
-	ee,mid := nubrin.SplitOffSecond(v)
-	if len(mid)==0 { return }
-	if nubrin.Decode(ee) < current { return }
-	
-	id = append(id_buf[:0],mid...)
+	/* Skip expired entries. */
+	for len(k)!=0 {
+		ni = int64(nubrin.Decode(k))
+		if ni==0 { break }
+		
+		ee,mid := nubrin.SplitOffSecond(v)
+		if len(mid)!=0 && nubrin.Decode(ee) >= now() {
+			id = append(id_buf[:0],mid...)
+			ok = true
+			return
+		}
+		
+		if backward { k,v = c.Prev() } else { k,v = c.Next() }
+	}
+	ni = 0
 	return
 }
 
@@ -183,7 +251,7 @@ func (t Tx) ListArticleGroupRaw(group []byte, first, last int64, targ func(int64
 		i := int64(nubrin.Decode(k))
 		if i>last { break }
 		ee,id := nubrin.SplitOffSecond(v)
-		if nubrin.Decode(ee) >= current { targ(i,id) }
+		if nubrin.Decode(ee) >= now() { targ(i,id) }
 	}
 }
 func (t Tx) GroupRealtimeQuery(group []byte) (number int64, low int64, high int64, ok bool) {
@@ -199,6 +267,14 @@ func (t Tx) GroupRealtimeQuery(group []byte) (number int64, low int64, high int6
 	low = int64(nubrin.Decode(k))
 	k,_ = c.Last()
 	high = int64(nubrin.Decode(k))
+	
+	/* The high mark stays, even if the Sweeper deleted the highest entries. */
+	if v := bkt.Get(iHigh); len(v)!=0 {
+		if h := int64(nubrin.Decode(v)); h>high {
+			high = h
+			if low==0 { low = high+1 }
+		}
+	}
 	return
 }
 
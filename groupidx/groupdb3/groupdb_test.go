@@ -0,0 +1,140 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package groupdb3
+
+import bolt "github.com/coreos/bbolt"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/gitest"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+import "os"
+import "time"
+
+func tempDir(t *testing.T) string {
+	dir,err := ioutil.TempDir("","groupdb3")
+	if err!=nil { t.Fatal(err) }
+	return dir
+}
+
+func tempDB(t *testing.T) (*DB,func()) {
+	dir := tempDir(t)
+	b,err := bolt.Open(filepath.Join(dir,"groups.db"),0600,nil)
+	if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+	return NewDB(b,[]byte("groups")),func() {
+		b.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func tempLogDB(t *testing.T) (*LogDB,func()) {
+	dir := tempDir(t)
+	l,err := OpenLogDB(dir)
+	if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+	return l,func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	s := &gitest.Suite{New:func(t *testing.T) (groupidx.GroupIndex,func()) {
+		return tempDB(t)
+	}}
+	s.Run(t)
+	s.OverWire2().Run(t)
+	s.OverWire1().Run(t)
+}
+
+/* Entries, that are still in the log, must be visible just like flushed ones. */
+func TestConformanceLog(t *testing.T) {
+	s := &gitest.Suite{New:func(t *testing.T) (groupidx.GroupIndex,func()) {
+		return tempLogDB(t)
+	}}
+	s.Run(t)
+}
+
+func TestConformanceFlushed(t *testing.T) {
+	s := &gitest.Suite{
+		New:func(t *testing.T) (groupidx.GroupIndex,func()) {
+			return tempLogDB(t)
+		},
+		Settle:func(gi groupidx.GroupIndex) { gi.(*LogDB).FlushLog() },
+	}
+	s.Run(t)
+}
+
+func headInsert(t *testing.T, l *LogDB, group []byte) int64 {
+	nums,err := l.GroupHeadInsert([][]byte{group},nil)
+	if err!=nil { t.Fatal(err) }
+	return nums[0]
+}
+
+/* Numbers must not be handed out twice, even across restarts, flushes and reverts. */
+func TestHeadRestart(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	group := []byte("a.b")
+	l,err := OpenLogDB(dir)
+	if err!=nil { t.Fatal(err) }
+	reopen := func() {
+		if err := l.Close(); err!=nil { t.Fatal(err) }
+		l,err = OpenLogDB(dir)
+		if err!=nil { t.Fatal(err) }
+	}
+	defer func() { l.Close() }()
+	expect := func(what string, n int64) {
+		if m := headInsert(t,l,group); m!=n { t.Errorf("%s: GroupHeadInsert -> %d, expected %d",what,m,n) }
+	}
+	
+	expect("fresh",1)
+	expect("fresh",2)
+	reopen()
+	expect("from the log",3)
+	
+	if err = l.FlushLog(); err!=nil { t.Fatal(err) }
+	expect("after FlushLog",4)
+	if err = l.FlushLog(); err!=nil { t.Fatal(err) }
+	reopen()
+	expect("from the database",5)
+	
+	if err = l.GroupHeadRevert([][]byte{group},[]int64{5}); err!=nil { t.Fatal(err) }
+	reopen()
+	expect("reverted, from the log",5)
+	
+	if err = l.GroupHeadRevert([][]byte{group},[]int64{5}); err!=nil { t.Fatal(err) }
+	if err = l.FlushLog(); err!=nil { t.Fatal(err) }
+	reopen()
+	expect("reverted, from the database",5)
+	
+	/* Only the highest number can be reverted. */
+	if err = l.GroupHeadRevert([][]byte{group},[]int64{3}); err!=nil { t.Fatal(err) }
+	reopen()
+	expect("reverted a gap",6)
+	
+	/* Assigned articles raise the head. */
+	if err = l.AssignArticleToGroup(group,10,uint64(time.Now().Unix())+3600,[]byte("<10>")); err!=nil { t.Fatal(err) }
+	reopen()
+	expect("above an article",11)
+}
@@ -25,6 +25,7 @@ package groupdb3
 
 import bolt "github.com/coreos/bbolt"
 import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/gonbase/nubrin"
 import "errors"
 
 var EMissingTable = errors.New("Missing Table")
@@ -60,14 +61,24 @@ func NewDB(b *bolt.DB,name []byte) *DB {
 }
 
 
-func (db *DB) GroupHeadInsert(groups [][]byte, buf []int64) (nums []int64, err error) { return nil,EUnimplemented }
-func (db *DB) GroupHeadRevert(groups [][]byte, nums []int64) error { return EUnimplemented }
+func (db *DB) GroupHeadInsert(groups [][]byte, buf []int64) (nums []int64, err error) {
+	err = db.batch(func(t *bolt.Bucket) (err error) {
+		nums,err = Tx{t}.GroupHeadInsert(groups,buf)
+		return
+	})
+	return
+}
+func (db *DB) GroupHeadRevert(groups [][]byte, nums []int64) error {
+	return db.batch(func(t *bolt.Bucket) (err error) {
+		return Tx{t}.GroupHeadRevert(groups,nums)
+	})
+}
 
 
 func (db *DB) ArticleGroupStat(group []byte, num int64, id_buf []byte) (id []byte, ok bool) {
 	row := db.slogs.lookup(TableKey{Group:group,Number:uint64(num)})
 	if row!=nil {
-		if row.Expires < current { return }
+		if row.Expires < now() { return }
 		id = append(id_buf[:0],row.MessageId...)
 		ok = true
 		return
@@ -80,6 +91,10 @@ func (db *DB) ArticleGroupStat(group []byte, num int64, id_buf []byte) (id []byt
 }
 func (db *DB) ArticleGroupMove(group []byte, i int64, backward bool, id_buf []byte) (ni int64, id []byte, ok bool) {
 	row := db.slogs.move(group,uint64(i),backward)
+	
+	/* Skip expired entries. */
+	for row!=nil && row.Expires < now() { row = db.slogs.move(group,row.Number,backward) }
+	
 	if row!=nil {
 		ui := uint64(i)
 		if backward { ui-- } else { ui++ }
@@ -95,7 +110,6 @@ func (db *DB) ArticleGroupMove(group []byte, i int64, backward bool, id_buf []by
 		return
 	})
 	if row!=nil {
-		if row.Expires < current { return }
 		if ok {
 			if backward && row.Number<=uint64(ni) { return }
 			if (!backward) && row.Number>=uint64(ni) { return }
@@ -134,13 +148,32 @@ func (db *DB) GroupRealtimeQuery(group []byte) (number int64, low int64, high in
 	})
 	for _,stat := range stats {
 		ok = true
-		if low==0 || low<stat.Low { low = stat.Low }
-		if high==0 || high>stat.High { high = stat.High }
+		if low==0 || low>stat.Low { low = stat.Low }
+		if high==0 || high<stat.High { high = stat.High }
 		number += stat.Count
 	}
 	return
 }
 
+/* The rows in the slogs come first, followed by those in the database. */
+func (db *DB) ListArticlesSince(wildmat []byte, since int64, targ func(group []byte, num int64, id []byte)) {
+	if since<0 { since = 0 }
+	rows := db.slogs.since(wildmat,uint64(since),now())
+	seen := make(map[string]bool,len(rows))
+	for _,row := range rows {
+		seen[string(append(nubrin.Encode(row.Number),row.Group...))] = true
+		targ(row.Group,int64(row.Number),row.MessageId)
+	}
+	db.view(func(t *bolt.Bucket) (err error) {
+		Tx{t}.ListArticlesSince(wildmat,since,func(group []byte, num int64, id []byte) {
+			if len(seen)!=0 && seen[string(append(nubrin.Encode(uint64(num)),group...))] { return }
+			targ(group,num,id)
+		})
+		return
+	})
+}
+
 
 var _ groupidx.GroupIndex = (*DB)(nil)
+var _ groupidx.GroupIndexSince = (*DB)(nil)
 
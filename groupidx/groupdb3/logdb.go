@@ -24,7 +24,6 @@ SOFTWARE.
 package groupdb3
 
 import bolt "github.com/coreos/bbolt"
-import "github.com/vmihailenco/msgpack"
 import "os"
 import "path/filepath"
 import "io/ioutil"
@@ -32,21 +31,35 @@ import "strings"
 import "sort"
 import "fmt"
 import "time"
+import "sync"
 
 type LogDB struct{
 	DB
 	path string
 	signal chan int
+	
+	/* Closed by Close. Stops the syncer and the Worker. */
+	stop  chan struct{}
+	sonce sync.Once
+	
+	/* Serializes GroupHeadInsert and GroupHeadRevert. */
+	hmu sync.Mutex
+	
+	opts Options
+	recovery RecoveryStats
 }
-func openLogDB(path string) (*LogDB,error) {
+func openLogDB(path string, o *Options) (*LogDB,error) {
 	j := filepath.Join(path,"master.db")
 	bdb,err := bolt.Open(j,0600,nil)
 	if err!=nil { return nil,err }
-	return &LogDB{
-		DB{bdb,[]byte("groups"),new(slogs)},
-		path,
-		make(chan int,1),
-	},nil
+	l := &LogDB{
+		DB:DB{bdb,[]byte("groups"),new(slogs)},
+		path:path,
+		signal:make(chan int,1),
+		stop:make(chan struct{}),
+	}
+	if o!=nil { l.opts = *o }
+	return l,nil
 }
 func (l *LogDB) getLogs() []string {
 	fis,_ := ioutil.ReadDir(l.path)
@@ -61,19 +74,13 @@ func (l *LogDB) getLogs() []string {
 	return s
 }
 func (l *LogDB) startup() error {
+	defer func() {
+		if l.recovery.Logs>0 { fmt.Println("groupdb3: recovered",l.recovery) }
+	}()
 	for _,s := range l.getLogs() {
 		j := filepath.Join(l.path,s)
-		f,err := os.Open(j)
+		slg,err := recoverLog(j,&l.recovery)
 		if err!=nil { return err }
-		dec := msgpack.NewDecoder(f)
-		slg := mkslog2()
-		for {
-			row := new(TableRow)
-			if dec.Decode(row)!=nil { break }
-			slg.dedupeString(&row.Group)
-			slg.insertMem(row)
-		}
-		f.Close()
 		err = l.batch(func(t *bolt.Bucket) error { return Tx{t}.insertAllRecords(slg) })
 		if err!=nil { return err }
 		err = os.Remove(j)
@@ -85,19 +92,47 @@ func (l *LogDB) allocSlog() error {
 	j := filepath.Join(l.path,fmt.Sprintf("%016x.log",time.Now().UnixNano()))
 	f,err := os.OpenFile(j,os.O_CREATE|os.O_EXCL|os.O_RDWR,0644)
 	if err!=nil { return err }
-	l.slogs.push(mkslog(f,j))
+	_,err = f.Write(walMagic)
+	if err==nil { err = f.Sync() }
+	if err!=nil { f.Close(); os.Remove(j); return err }
+	l.slogs.push(mkslog(f,j,&l.opts))
 	return nil
 }
 func OpenLogDB(path string) (*LogDB,error) {
-	l,e := openLogDB(path)
+	return OpenLogDBOptions(path,nil)
+}
+func OpenLogDBOptions(path string, o *Options) (*LogDB,error) {
+	l,e := openLogDB(path,o)
 	if e!=nil { return nil,e }
 	e = l.startup()
 	if e!=nil { l.inner.Close(); return nil,e }
 	e = l.allocSlog()
 	if e!=nil { l.inner.Close(); return nil,e }
+	if l.opts.Sync==SyncInterval { go l.syncer() }
 	return l,nil
 }
 
+/*
+Stops the background goroutines and closes the logs and the database. The logs
+are not folded into the database. They are recovered by the next OpenLogDB.
+*/
+func (l *LogDB) Close() error {
+	l.sonce.Do(func() { close(l.stop) })
+	l.slogs.WB.Lock(); defer l.slogs.WB.Unlock()
+	l.slogs.Lock.RLock()
+	for _,s := range l.slogs.Stack {
+		s.WB.Lock()
+		s.File.Sync()
+		s.File.Close()
+		s.WB.Unlock()
+	}
+	l.slogs.Lock.RUnlock()
+	return l.inner.Close()
+}
+
+/* Statistics of the log recovery at startup. */
+func (l *LogDB) Recovery() RecoveryStats { return l.recovery }
+
 func (l *LogDB) FlushLog() error {
 	l.slogs.WB.Lock(); defer l.slogs.WB.Unlock()
 	err := l.allocSlog()
@@ -120,9 +155,13 @@ func (l *LogDB) getSize0() (int64,error) {
 	if err!=nil { return 0,err }
 	return fi.Size(),nil
 }
+/* Flushes the log, once it has grown beyond treshold bytes. Returns after Close. */
 func (l *LogDB) Worker(treshold int64) {
 	for {
-		<- l.signal
+		select {
+		case <- l.signal:
+		case <- l.stop: return
+		}
 		s,err := l.getSize0()
 		if err!=nil {
 			fmt.Println(err)
@@ -144,18 +183,75 @@ func (l *LogDB) wakeup() {
 func (l *LogDB) AssignArticleToGroup(group []byte, num, exp uint64, id []byte) error {
 	defer l.wakeup()
 	id = append([]byte(nil),id...)
-	row := &TableRow{TableKey:TableKey{Group:group,Number:num},Expires:exp,MessageId:id}
+	row := &TableRow{TableKey:TableKey{Group:group,Number:num},Expires:exp,MessageId:id,Arrival:now()}
 	return l.slogs.insert(row)
 }
 func (l *LogDB) AssignArticleToGroups(groups [][]byte, nums []int64, exp uint64, id []byte) error {
 	defer l.wakeup()
 	id = append([]byte(nil),id...)
 	for i := range groups {
-		row := &TableRow{TableKey:TableKey{Group: groups[i],Number: uint64(nums[i])},Expires: exp,MessageId: id}
+		row := &TableRow{TableKey:TableKey{Group: groups[i],Number: uint64(nums[i])},Expires: exp,MessageId: id,Arrival: now()}
 		err := l.slogs.insert(row)
 		if err!=nil && i==0 { return err }
 	}
 	return nil
 }
 
+/*
+Returns the highest number of the group, that is in use: the head from the newest
+slog, or from the database, and the numbers, that have been assigned since.
+*/
+func (l *LogDB) head(group []byte) (h uint64, err error) {
+	h,ok := l.slogs.getHead(group)
+	if !ok {
+		err = l.view(func(t *bolt.Bucket) error {
+			h = Tx{t}.head(group)
+			return nil
+		})
+		if err==EMissingTable { err = nil }
+		if err!=nil { return }
+	}
+	for _,stat := range l.slogs.getStats(group) {
+		if stat.High>0 && uint64(stat.High)>h { h = uint64(stat.High) }
+	}
+	return
+}
+
+/*
+Hands out numbers, that are recorded in the current slog and folded into the
+database by FlushLog, so they survive a restart.
+*/
+func (l *LogDB) GroupHeadInsert(groups [][]byte, buf []int64) ([]int64, error) {
+	defer l.wakeup()
+	l.hmu.Lock(); defer l.hmu.Unlock()
+	
+	if cap(buf)<len(groups) { buf = make([]int64,len(groups)) } else { buf = buf[:len(groups)] }
+	
+	for i,group := range groups {
+		h,err := l.head(group)
+		if err!=nil { return nil,err }
+		h++
+		err = l.slogs.insertHead(&HeadRow{Group:group,Head:h})
+		if err!=nil { return nil,err }
+		buf[i] = int64(h)
+	}
+	return buf,nil
+}
 
+/*
+Only the highest number can be given back. Other numbers are left as gaps.
+*/
+func (l *LogDB) GroupHeadRevert(groups [][]byte, nums []int64) error {
+	defer l.wakeup()
+	l.hmu.Lock(); defer l.hmu.Unlock()
+	
+	for i,group := range groups {
+		if nums[i]<=0 { continue }
+		h,err := l.head(group)
+		if err!=nil { return err }
+		if h!=uint64(nums[i]) { continue }
+		err = l.slogs.insertHead(&HeadRow{Group:group,Head:h-1})
+		if err!=nil { return err }
+	}
+	return nil
+}
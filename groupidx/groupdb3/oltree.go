@@ -26,10 +26,12 @@ package groupdb3
 import avl "github.com/emirpasic/gods/trees/avltree"
 //import "github.com/emirpasic/gods/utils"
 import "github.com/vmihailenco/msgpack"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
 
 import "bytes"
 import "bufio"
 import "sync"
+import "sync/atomic"
 import "os"
 import "errors"
 
@@ -72,6 +74,18 @@ type TableRow struct{
 	TableKey
 	Expires   uint64
 	MessageId []byte
+	Arrival   uint64 // zero in logs of earlier versions
+}
+
+/*
+Records the highest number, that has been handed out by GroupHeadInsert. The newest
+record of a group wins. It is encoded as an array of two elements, unlike TableRow,
+so both can be stored in the same slog.
+*/
+type HeadRow struct{
+	_msgpack struct{} `msgpack:",asArray"`
+	Group []byte
+	Head  uint64
 }
 
 type GroupStats struct{
@@ -105,30 +119,42 @@ func treeLookup(t *avl.Tree,key interface{}) *avl.Node {
 }
 
 type slog struct{
+	written uint64 // records written, atomic
+	dirty   uint32 // written since the last sync, atomic
+	folded  uint32 // folded into the database, atomic
+	
 	WB   sync.Mutex
 	Lock sync.RWMutex
-	Enc  *msgpack.Encoder
+	Enc  *msgpack.Encoder // encodes into Scr
+	Scr  bytes.Buffer
 	Buf  *bufio.Writer
+	Opts *Options
+	gc   gcommit
 	Tree *avl.Tree
 	Gsix *avl.Tree
+	Heads map[string]uint64
 	File *os.File
 	Path string
 }
-func mkslog(f *os.File,p string) *slog {
-	buf := bufio.NewWriter(f)
-	return &slog{
-		Enc: msgpack.NewEncoder(buf),
-		Buf: buf,
+func mkslog(f *os.File,p string,o *Options) *slog {
+	s := &slog{
+		Buf: bufio.NewWriter(f),
+		Opts: o,
 		Tree: avl.NewWith(TKComparator),
 		Gsix: avl.NewWith(bytesComparator),
+		Heads: make(map[string]uint64),
 		File: f,
 		Path: p,
 	}
+	s.Enc = msgpack.NewEncoder(&s.Scr)
+	s.gc.cond.L = &s.gc.mu
+	return s
 }
 func mkslog2() *slog {
 	return &slog{
 		Tree: avl.NewWith(TKComparator),
 		Gsix: avl.NewWith(bytesComparator),
+		Heads: make(map[string]uint64),
 	}
 }
 
@@ -163,21 +189,28 @@ func (s *slog) insertMem(row *TableRow) {
 	s.Tree.Put(&row.TableKey,row)
 }
 func (s *slog) insert(row *TableRow) error {
-	s.WB.Lock(); defer s.WB.Unlock()
-	if s.Enc==nil { return errors.New("No encoder.") }
-	err := s.Enc.Encode(row)
-	if err!=nil { return err }
-	err = s.Buf.Flush()
-	if err!=nil { return err }
-	s.insertMem(row)
-	return nil
+	return s.append(row,func(){ s.insertMem(row) })
+}
+func (s *slog) setHead(row *HeadRow) {
+	s.Lock.Lock(); defer s.Lock.Unlock()
+	s.Heads[string(row.Group)] = row.Head
+}
+func (s *slog) insertHead(row *HeadRow) error {
+	return s.append(row,func(){ s.setHead(row) })
+}
+func (s *slog) getHead(group []byte) (h uint64, ok bool) {
+	s.Lock.RLock(); defer s.Lock.RUnlock()
+	h,ok = s.Heads[string(group)]
+	return
 }
 func (s *slog) deleteIt() {
+	atomic.StoreUint32(&s.folded,1)
 	s.File.Close()
 	os.Remove(s.Path)
 	s.Lock.Lock(); defer s.Lock.Unlock()
 	s.Tree.Clear()
 	s.Gsix.Clear()
+	s.Heads = make(map[string]uint64)
 }
 func (s *slog) lookup(k interface{}) *TableRow {
 	s.Lock.RLock(); defer s.Lock.RUnlock()
@@ -217,6 +250,21 @@ func (s *slogs) insert(row *TableRow) error {
 	s.dedupeString(&row.Group)
 	return s.Stack[len(s.Stack)-1].insert(row)
 }
+func (s *slogs) insertHead(row *HeadRow) error {
+	s.Lock.RLock(); defer s.Lock.RUnlock()
+	if len(s.Stack)==0 { return errors.New("stack empty") }
+	s.dedupeString(&row.Group)
+	return s.Stack[len(s.Stack)-1].insertHead(row)
+}
+/* Returns the head of the group from the newest slog, that has one. */
+func (s *slogs) getHead(group []byte) (h uint64, ok bool) {
+	s.Lock.RLock(); defer s.Lock.RUnlock()
+	for i := len(s.Stack)-1; i>=0; i-- {
+		h,ok = s.Stack[i].getHead(group)
+		if ok { return }
+	}
+	return
+}
 func (s *slogs) lookup(k interface{}) (v *TableRow) {
 	s.Lock.RLock(); defer s.Lock.RUnlock()
 	for _,slog := range s.Stack {
@@ -230,6 +278,7 @@ func (s *slogs) floor(k interface{}) (v *TableRow) {
 	s.Lock.RLock(); defer s.Lock.RUnlock()
 	for _,slog := range s.Stack {
 		nv = slog.floor(k)
+		if nv==nil { continue }
 		if v==nil {
 			v = nv
 		} else if tkCompare(v.TableKey,nv.TableKey)<0 {
@@ -243,6 +292,7 @@ func (s *slogs) ceiling(k interface{}) (v *TableRow) {
 	s.Lock.RLock(); defer s.Lock.RUnlock()
 	for _,slog := range s.Stack {
 		nv = slog.ceiling(k)
+		if nv==nil { continue }
 		if v==nil {
 			v = nv
 		} else if tkCompare(nv.TableKey,v.TableKey)<0 {
@@ -251,14 +301,17 @@ func (s *slogs) ceiling(k interface{}) (v *TableRow) {
 	}
 	return
 }
-func (s *slogs) move(group []byte,i uint64,backward bool) *TableRow {
+func (s *slogs) move(group []byte,i uint64,backward bool) (row *TableRow) {
 	if backward {
 		if i==0 { return nil }
-		return s.floor(TableKey{Group:group,Number:i-1})
+		row = s.floor(TableKey{Group:group,Number:i-1})
 	} else {
 		if i== (^uint64(0)) { return nil }
-		return s.ceiling(TableKey{Group:group,Number:i+1})
+		row = s.ceiling(TableKey{Group:group,Number:i+1})
 	}
+	/* Floor and Ceiling may cross group boundaries. */
+	if row!=nil && !bytes.Equal(row.Group,group) { row = nil }
+	return
 }
 func (s *slogs) shift() {
 	s.Lock.Lock(); defer s.Lock.Unlock()
@@ -279,6 +332,27 @@ func (s *slogs) getStats(group []byte) (gs ListGroupStats) {
 	return
 }
 
+/* Returns the rows of the groups matching the wildmat, that arrived since and did not expire. */
+func (s *slogs) since(wildmat []byte, since, now uint64) (rows []*TableRow) {
+	s.Lock.RLock(); defer s.Lock.RUnlock()
+	for _,slog := range s.Stack {
+		slog.Lock.RLock()
+		var cgrp []byte
+		var match bool
+		for cur := slog.Tree.Left(); cur!=nil; cur = cur.Next() {
+			row := cur.Value.(*TableRow)
+			if !bytes.Equal(row.Group,cgrp) {
+				cgrp = row.Group
+				match = groupidx.MatchWildmat(wildmat,cgrp)
+			}
+			if !match || row.Arrival<since || row.Expires<now { continue }
+			rows = append(rows,row)
+		}
+		slog.Lock.RUnlock()
+	}
+	return
+}
+
 func (s *slogs) wrap(group []byte, first, last int64, targ func(int64, []byte)) (func(int64, []byte),func()) {
 	stats := s.getStats(group)
 	kb := &TableKey{Group:group}
@@ -287,12 +361,11 @@ func (s *slogs) wrap(group []byte, first, last int64, targ func(int64, []byte))
 		if next >= i { return }
 		if !stats.Cuts(next,i) { return }
 		kb.Number = uint64(next)
-		row := s.ceiling(kb)
-		if row==nil { return }
-		for row.Number<uint64(i) {
-			targ(int64(row.Number),row.MessageId)
+		now := now()
+		/* Ceiling may cross group boundaries. */
+		for row := s.ceiling(kb); row!=nil && bytes.Equal(row.Group,group) && row.Number<uint64(i); row = s.ceiling(kb) {
+			if row.Expires >= now { targ(int64(row.Number),row.MessageId) }
 			kb.Number = row.Number+1
-			row = s.ceiling(kb)
 		}
 	}
 	return func(i int64, id []byte){
@@ -24,16 +24,19 @@ SOFTWARE.
 package groupdb3
 
 import "time"
+import "sync/atomic"
 
 var current uint64
 
 func ticker() {
 	tt := time.Tick(time.Second)
 	for {
-		current = uint64((<- tt).Unix())
+		atomic.StoreUint64(&current,uint64((<- tt).Unix()))
 	}
 }
 
+func now() uint64 { return atomic.LoadUint64(&current) }
+
 func init() {
 	current = uint64(time.Now().Unix())
 	go ticker()
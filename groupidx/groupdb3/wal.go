@@ -0,0 +1,257 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package groupdb3
+
+import "github.com/vmihailenco/msgpack"
+import "github.com/vmihailenco/msgpack/codes"
+import "bytes"
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "hash/crc32"
+import "io/ioutil"
+import "os"
+import "sync"
+import "sync/atomic"
+import "time"
+
+/*
+A log file starts with walMagic. Every record is framed as
+
+	length  uint32 (big endian)
+	crc32c  uint32 (big endian, of the payload)
+	payload (msgpack: a TableRow or a HeadRow)
+
+Log files, written by earlier versions, lack the magic. They consist of the
+msgpack payloads only, and are still read.
+*/
+var walMagic = []byte("gdb3wal\x01")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var ECorrupt = errors.New("corrupt log record")
+
+/* Determines, when the log is synced to disk. */
+type SyncPolicy int
+
+const (
+	/* The log is synced every Options.Interval. Writes, that happened since, may be lost. */
+	SyncInterval SyncPolicy = iota
+	
+	/* Every write is synced, before it returns. */
+	SyncAlways
+	
+	/* Every write is synced, before it returns. Concurrent writes share a sync (group commit). */
+	SyncGroup
+)
+
+/* Parses "interval", "always" or "group". The empty string means SyncInterval. */
+func ParseSyncPolicy(s string) (SyncPolicy,error) {
+	switch s {
+	case "","interval": return SyncInterval,nil
+	case "always": return SyncAlways,nil
+	case "group": return SyncGroup,nil
+	}
+	return 0,fmt.Errorf("invalid sync policy %q",s)
+}
+
+type Options struct{
+	Sync SyncPolicy
+	
+	/* For SyncInterval. Defaults to one second. */
+	Interval time.Duration
+}
+func (o *Options) interval() time.Duration {
+	if o.Interval<=0 { return time.Second }
+	return o.Interval
+}
+
+/* What has been found in the logs on startup. */
+type RecoveryStats struct{
+	Logs      int   // log files
+	Legacy    int   // log files without checksums
+	Records   int   // TableRows
+	Heads     int   // HeadRows
+	Corrupt   int   // log files with a corrupt tail
+	Truncated int64 // bytes, cut off from the corrupt tails
+}
+func (r RecoveryStats) String() string {
+	return fmt.Sprintf("%d logs (%d legacy), %d records, %d heads, %d corrupt tails, %d bytes truncated",
+		r.Logs,r.Legacy,r.Records,r.Heads,r.Corrupt,r.Truncated)
+}
+
+/* Group commit state of a slog. */
+type gcommit struct{
+	mu     sync.Mutex
+	cond   sync.Cond
+	synced uint64
+	busy   bool
+}
+
+/* Decodes a TableRow or a HeadRow into slg. */
+func decodeRecord(dec *msgpack.Decoder, slg *slog, rs *RecoveryStats) error {
+	c,err := dec.PeekCode()
+	if err!=nil { return err }
+	if c==codes.FixedArrayLow|2 {
+		head := new(HeadRow)
+		if err = dec.Decode(head); err!=nil { return err }
+		slg.setHead(head)
+		rs.Heads++
+		return nil
+	}
+	row := new(TableRow)
+	if err = dec.Decode(row); err!=nil { return err }
+	slg.dedupeString(&row.Group)
+	slg.insertMem(row)
+	rs.Records++
+	return nil
+}
+
+/*
+Returns the length of the valid prefix of a log.
+*/
+func parseLog(data []byte, slg *slog, rs *RecoveryStats) int {
+	if !bytes.HasPrefix(data,walMagic) {
+		rs.Legacy++
+		r := bytes.NewReader(data)
+		dec := msgpack.NewDecoder(r)
+		good := 0
+		for r.Len()>0 {
+			if decodeRecord(dec,slg,rs)!=nil { break }
+			good = len(data)-r.Len()
+		}
+		return good
+	}
+	good := len(walMagic)
+	for rest := data[good:]; len(rest)>0; rest = data[good:] {
+		if len(rest)<8 { break }
+		n := int(binary.BigEndian.Uint32(rest))
+		sum := binary.BigEndian.Uint32(rest[4:])
+		if n>len(rest)-8 { break }
+		payload := rest[8:8+n]
+		if crc32.Checksum(payload,castagnoli)!=sum { break }
+		if decodeRecord(msgpack.NewDecoder(bytes.NewReader(payload)),slg,rs)!=nil { break }
+		good += 8+n
+	}
+	return good
+}
+
+/*
+Reads a log file into a slog. A corrupt tail (for example a torn write) is cut off.
+*/
+func recoverLog(path string, rs *RecoveryStats) (*slog,error) {
+	f,err := os.OpenFile(path,os.O_RDWR,0644)
+	if err!=nil { return nil,err }
+	defer f.Close()
+	data,err := ioutil.ReadAll(f)
+	if err!=nil { return nil,err }
+	slg := mkslog2()
+	good := parseLog(data,slg,rs)
+	rs.Logs++
+	if good<len(data) {
+		rs.Corrupt++
+		rs.Truncated += int64(len(data)-good)
+		if err = f.Truncate(int64(good)); err!=nil { return nil,err }
+		if err = f.Sync(); err!=nil { return nil,err }
+	}
+	return slg,nil
+}
+
+func (s *slog) frame(v interface{}) (seq uint64, err error) {
+	if s.Enc==nil { return 0,errors.New("No encoder.") }
+	s.Scr.Reset()
+	if err = s.Enc.Encode(v); err!=nil { return }
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:],uint32(s.Scr.Len()))
+	binary.BigEndian.PutUint32(hdr[4:],crc32.Checksum(s.Scr.Bytes(),castagnoli))
+	s.Buf.Write(hdr[:])
+	s.Buf.Write(s.Scr.Bytes())
+	if err = s.Buf.Flush(); err!=nil { return }
+	seq = atomic.AddUint64(&s.written,1)
+	return
+}
+
+/*
+Appends a record to the log, applies it to the memory and syncs it
+according to the SyncPolicy.
+*/
+func (s *slog) append(v interface{}, apply func()) error {
+	s.WB.Lock()
+	seq,err := s.frame(v)
+	if err==nil && s.Opts.Sync==SyncAlways { err = s.File.Sync() }
+	/* Under the WB lock, so FlushLog won't miss it. */
+	if err==nil { apply() }
+	s.WB.Unlock()
+	if err!=nil { return err }
+	switch s.Opts.Sync {
+	case SyncGroup: return s.groupCommit(seq)
+	case SyncInterval: atomic.StoreUint32(&s.dirty,1)
+	}
+	return nil
+}
+
+/* Waits, until the record seq is synced. Only one sync is running at a time. */
+func (s *slog) groupCommit(seq uint64) error {
+	g := &s.gc
+	g.mu.Lock(); defer g.mu.Unlock()
+	for g.synced<seq {
+		if g.busy { g.cond.Wait(); continue }
+		g.busy = true
+		g.mu.Unlock()
+		target := atomic.LoadUint64(&s.written)
+		err := s.File.Sync()
+		g.mu.Lock()
+		g.busy = false
+		g.cond.Broadcast()
+		/* If the slog has been folded into the database meanwhile, the record is safe. */
+		if err!=nil && atomic.LoadUint32(&s.folded)!=0 { return nil }
+		if err!=nil { return err }
+		if target>g.synced { g.synced = target }
+	}
+	return nil
+}
+
+/* Syncs the slog, if it has been written to since the last call. */
+func (s *slog) syncDirty() {
+	if atomic.SwapUint32(&s.dirty,0)==0 { return }
+	s.File.Sync()
+}
+
+func (s *slogs) syncDirty() {
+	s.Lock.RLock()
+	stack := append([]*slog(nil),s.Stack...)
+	s.Lock.RUnlock()
+	for _,slog := range stack { slog.syncDirty() }
+}
+
+func (l *LogDB) syncer() {
+	t := time.NewTicker(l.opts.interval())
+	defer t.Stop()
+	for {
+		select {
+		case <- t.C: l.slogs.syncDirty()
+		case <- l.stop: return
+		}
+	}
+}
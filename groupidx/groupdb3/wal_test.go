@@ -0,0 +1,243 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package groupdb3
+
+import "github.com/vmihailenco/msgpack"
+import "testing"
+import "bytes"
+import "encoding/binary"
+import "fmt"
+import "io/ioutil"
+import "path/filepath"
+import "os"
+import "sync"
+import "sync/atomic"
+import "time"
+
+var walGroup = []byte("wal.test")
+
+func walExpires() uint64 { return uint64(time.Now().Unix())+3600 }
+
+/*
+Assigns the numbers 1 to n and one head through a LogDB, and closes it without
+flushing. Returns the log file.
+*/
+func writeLog(t *testing.T, dir string, o *Options, n int) string {
+	l,err := OpenLogDBOptions(dir,o)
+	if err!=nil { t.Fatal(err) }
+	for i := 1; i<=n; i++ {
+		err = l.AssignArticleToGroup(walGroup,uint64(i),walExpires(),[]byte(fmt.Sprint(i)))
+		if err!=nil { t.Fatal(err) }
+	}
+	if _,err = l.GroupHeadInsert([][]byte{walGroup},nil); err!=nil { t.Fatal(err) }
+	if err = l.Close(); err!=nil { t.Fatal(err) }
+	logs,_ := filepath.Glob(filepath.Join(dir,"*.log"))
+	if len(logs)!=1 { t.Fatalf("%d logs, expected 1",len(logs)) }
+	return logs[0]
+}
+
+/* Returns the offsets of the frames in a log. */
+func frames(data []byte) (offs []int) {
+	for i := len(walMagic); i+8<=len(data); i += 8+int(binary.BigEndian.Uint32(data[i:])) {
+		offs = append(offs,i)
+	}
+	return
+}
+
+/* Runs recoverLog on a copy of the log, and returns the stats and the size of the copy afterwards. */
+func recoverCopy(t *testing.T, path string) (RecoveryStats,*slog,int64) {
+	data,err := ioutil.ReadFile(path)
+	if err!=nil { t.Fatal(err) }
+	cp := path+".copy"
+	defer os.Remove(cp)
+	if err = ioutil.WriteFile(cp,data,0644); err!=nil { t.Fatal(err) }
+	var rs RecoveryStats
+	slg,err := recoverLog(cp,&rs)
+	if err!=nil { t.Fatal(err) }
+	fi,err := os.Stat(cp)
+	if err!=nil { t.Fatal(err) }
+	return rs,slg,fi.Size()
+}
+
+/* Reopens the LogDB and checks, that exactly the numbers 1 to n are there. */
+func expectRecovered(t *testing.T, dir string, n int) RecoveryStats {
+	l,err := OpenLogDB(dir)
+	if err!=nil { t.Fatal(err) }
+	defer l.Close()
+	for i := 1; i<=n+1; i++ {
+		id,ok := l.ArticleGroupStat(walGroup,int64(i),nil)
+		if i<=n && (!ok || string(id)!=fmt.Sprint(i)) { t.Errorf("ArticleGroupStat(%d) -> %q %v",i,id,ok) }
+		if i>n && ok { t.Errorf("ArticleGroupStat(%d) -> %q, expected nothing",i,id) }
+	}
+	return l.Recovery()
+}
+
+func TestRecoverClean(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeLog(t,dir,nil,5)
+	rs := expectRecovered(t,dir,5)
+	if rs!=(RecoveryStats{Logs:1,Records:5,Heads:1}) { t.Errorf("Recovery() -> %v",rs) }
+}
+
+func TestRecoverTornTail(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := writeLog(t,dir,nil,5)
+	fi,err := os.Stat(path)
+	if err!=nil { t.Fatal(err) }
+	
+	/* A frame header, that announces 100 bytes, followed by 10 of them. */
+	torn := make([]byte,18)
+	binary.BigEndian.PutUint32(torn,100)
+	f,err := os.OpenFile(path,os.O_APPEND|os.O_WRONLY,0644)
+	if err!=nil { t.Fatal(err) }
+	f.Write(torn)
+	f.Close()
+	
+	rs,_,size := recoverCopy(t,path)
+	if size!=fi.Size() { t.Errorf("truncated to %d bytes, expected %d",size,fi.Size()) }
+	if rs.Corrupt!=1 || rs.Truncated!=18 || rs.Records!=5 { t.Errorf("recoverLog -> %v",rs) }
+	
+	rs = expectRecovered(t,dir,5)
+	if rs.Corrupt!=1 || rs.Truncated!=18 { t.Errorf("Recovery() -> %v",rs) }
+}
+
+func TestRecoverShortHeader(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := writeLog(t,dir,nil,3)
+	f,err := os.OpenFile(path,os.O_APPEND|os.O_WRONLY,0644)
+	if err!=nil { t.Fatal(err) }
+	f.Write([]byte{0,0,1})
+	f.Close()
+	rs := expectRecovered(t,dir,3)
+	if rs.Corrupt!=1 || rs.Truncated!=3 { t.Errorf("Recovery() -> %v",rs) }
+}
+
+func TestRecoverChecksum(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := writeLog(t,dir,nil,5)
+	data,err := ioutil.ReadFile(path)
+	if err!=nil { t.Fatal(err) }
+	offs := frames(data)
+	if len(offs)!=6 { t.Fatalf("%d frames, expected 6",len(offs)) }
+	
+	/* Breaks the fourth record. It and everything after it is lost. */
+	data[offs[3]+8] ^= 0xff
+	if err = ioutil.WriteFile(path,data,0644); err!=nil { t.Fatal(err) }
+	
+	rs,slg,size := recoverCopy(t,path)
+	if size!=int64(offs[3]) { t.Errorf("truncated to %d bytes, expected %d",size,offs[3]) }
+	if rs.Corrupt!=1 || rs.Truncated!=int64(len(data)-offs[3]) || rs.Records!=3 || rs.Heads!=0 {
+		t.Errorf("recoverLog -> %v",rs)
+	}
+	if slg.Tree.Size()!=3 { t.Errorf("%d records recovered, expected 3",slg.Tree.Size()) }
+	
+	expectRecovered(t,dir,3)
+}
+
+func TestRecoverLegacy(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	buf := new(bytes.Buffer)
+	enc := msgpack.NewEncoder(buf)
+	for i := 1; i<=4; i++ {
+		row := &TableRow{TableKey:TableKey{Group:walGroup,Number:uint64(i)},Expires:walExpires(),MessageId:[]byte(fmt.Sprint(i))}
+		if err := enc.Encode(row); err!=nil { t.Fatal(err) }
+	}
+	if err := enc.Encode(&HeadRow{Group:walGroup,Head:4}); err!=nil { t.Fatal(err) }
+	good := buf.Len()
+	buf.WriteByte(0xc1) // never used
+	path := filepath.Join(dir,"0000000000000001.log")
+	if err := ioutil.WriteFile(path,buf.Bytes(),0644); err!=nil { t.Fatal(err) }
+	
+	rs,_,size := recoverCopy(t,path)
+	if size!=int64(good) { t.Errorf("truncated to %d bytes, expected %d",size,good) }
+	if rs!=(RecoveryStats{Logs:1,Legacy:1,Records:4,Heads:1,Corrupt:1,Truncated:1}) { t.Errorf("recoverLog -> %v",rs) }
+	
+	rs = expectRecovered(t,dir,4)
+	if rs.Legacy!=1 || rs.Records!=4 { t.Errorf("Recovery() -> %v",rs) }
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for s,e := range map[string]SyncPolicy{"":SyncInterval,"interval":SyncInterval,"always":SyncAlways,"group":SyncGroup} {
+		p,err := ParseSyncPolicy(s)
+		if err!=nil || p!=e { t.Errorf("ParseSyncPolicy(%q) -> %v %v, expected %v",s,p,err,e) }
+	}
+	if _,err := ParseSyncPolicy("never"); err==nil { t.Errorf("ParseSyncPolicy(\"never\") -> no error") }
+}
+
+/* Concurrent writers, with every policy. All records must be there after a restart. */
+func TestSyncPolicies(t *testing.T) {
+	for _,p := range []SyncPolicy{SyncInterval,SyncAlways,SyncGroup} {
+		t.Run(fmt.Sprint(p),func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			l,err := OpenLogDBOptions(dir,&Options{Sync:p,Interval:10*time.Millisecond})
+			if err!=nil { t.Fatal(err) }
+			var wg sync.WaitGroup
+			for w := 0; w<4; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 1; i<=10; i++ {
+						n := w*10+i
+						err := l.AssignArticleToGroup(walGroup,uint64(n),walExpires(),[]byte(fmt.Sprint(n)))
+						if err!=nil { t.Error(err) }
+					}
+				}(w)
+			}
+			wg.Wait()
+			s := l.slogs.Stack[len(l.slogs.Stack)-1]
+			if p==SyncGroup && s.gc.synced!=atomic.LoadUint64(&s.written) {
+				t.Errorf("group commit synced %d of %d records",s.gc.synced,s.written)
+			}
+			if p==SyncInterval {
+				time.Sleep(50*time.Millisecond)
+				if atomic.LoadUint32(&s.dirty)!=0 { t.Errorf("log not synced after the interval") }
+			}
+			if err = l.Close(); err!=nil { t.Fatal(err) }
+			rs := expectRecovered(t,dir,40)
+			if rs.Records!=40 || rs.Corrupt!=0 { t.Errorf("Recovery() -> %v",rs) }
+		})
+	}
+}
+
+/* Close makes the Worker return. Closing twice is harmless. */
+func TestCloseStopsSyncer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l,err := OpenLogDBOptions(dir,&Options{Interval:time.Millisecond})
+	if err!=nil { t.Fatal(err) }
+	done := make(chan struct{})
+	go func() { l.Worker(1); close(done) }()
+	if err = l.Close(); err!=nil { t.Fatal(err) }
+	select {
+	case <- done:
+	case <- time.After(time.Second): t.Errorf("Worker did not return after Close")
+	}
+	l.Close()
+}
@@ -45,3 +45,12 @@ type P_GIPrototype GroupIndex
 	ArticleGroupList(group []byte, first, last int64, targ func(int64))
 }*/
 
+
+/*
+Optional. Lists the articles, that arrived at or after since (in seconds since the
+Unix epoch), in all groups, that match the wildmat (see MatchWildmat). The arrival
+time is recorded by AssignArticleToGroup(s). Used for NEWNEWS.
+*/
+type GroupIndexSince interface{
+	ListArticlesSince(wildmat []byte, since int64, targ func(group []byte, num int64, id []byte))
+}
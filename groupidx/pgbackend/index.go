@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package pgbackend
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/grouphead"
+import "database/sql"
+import "time"
+
+func now() int64 { return time.Now().UTC().Unix() }
+
+/* The next number is one above the numbers, handed out, and the highest assigned number. */
+const qHeadInsert = `
+	INSERT INTO grphead (ngrp,head,high) VALUES (?,1,0)
+	ON CONFLICT (ngrp) DO UPDATE SET head = (CASE WHEN grphead.high > grphead.head THEN grphead.high ELSE grphead.head END)+1
+`
+const qHeadGet = `SELECT head FROM grphead WHERE ngrp = ?`
+
+func (b *Base) GroupHeadInsert(groups [][]byte, buf []int64) ([]int64, error) {
+	if cap(buf)<len(groups) { buf = make([]int64,len(groups)) } else { buf = buf[:len(groups)] }
+	err := b.tx(func(t *sql.Tx) error {
+		for i,group := range groups {
+			_,err := t.Exec(b.q(qHeadInsert),group)
+			if err!=nil { return err }
+			err = t.QueryRow(b.q(qHeadGet),group).Scan(&buf[i])
+			if err!=nil { return err }
+		}
+		return nil
+	})
+	if err!=nil { return nil,err }
+	return buf,nil
+}
+
+/*
+Only the highest number can be given back. Other numbers are left as gaps.
+*/
+func (b *Base) GroupHeadRevert(groups [][]byte, nums []int64) error {
+	return b.tx(func(t *sql.Tx) error {
+		for i,group := range groups {
+			if nums[i]<=0 { continue }
+			_,err := t.Exec(b.q(`UPDATE grphead SET head = head-1 WHERE ngrp = ? AND head = ?`),group,nums[i])
+			if err!=nil { return err }
+		}
+		return nil
+	})
+}
+
+func (b *Base) ArticleGroupStat(group []byte, num int64, id_buf []byte) ([]byte, bool) {
+	var id []byte
+	err := b.DB.QueryRow(b.q(`
+		SELECT msgid FROM grp2mid WHERE ngrp = ? AND mnum = ? AND expir >= ?
+	`),group,num,now()).Scan(&id)
+	if err!=nil || len(id)==0 { return nil,false }
+	return append(id_buf[:0],id...),true
+}
+
+func (b *Base) ArticleGroupMove(group []byte, i int64, backward bool, id_buf []byte) (ni int64, id []byte, ok bool) {
+	query := `
+		SELECT mnum,msgid FROM grp2mid WHERE ngrp = ? AND mnum > ? AND expir >= ? ORDER BY mnum ASC LIMIT 1
+	`
+	if backward {
+		query = `
+		SELECT mnum,msgid FROM grp2mid WHERE ngrp = ? AND mnum < ? AND expir >= ? ORDER BY mnum DESC LIMIT 1
+		`
+	}
+	var mid []byte
+	err := b.DB.QueryRow(b.q(query),group,i,now()).Scan(&ni,&mid)
+	if err!=nil { return 0,nil,false }
+	return ni,append(id_buf[:0],mid...),true
+}
+
+/*
+The high mark stays, even if the highest entries are expired. If the group has no
+entries, low is high+1.
+*/
+func (b *Base) GroupRealtimeQuery(group []byte) (number int64, low int64, high int64, ok bool) {
+	var mark int64
+	err := b.DB.QueryRow(b.q(`SELECT high FROM grphead WHERE ngrp = ?`),group).Scan(&mark)
+	if err!=nil { return }
+	var nlow,nhigh sql.NullInt64
+	err = b.DB.QueryRow(b.q(`
+		SELECT COUNT(*),MIN(mnum),MAX(mnum) FROM grp2mid WHERE ngrp = ? AND expir >= ?
+	`),group,now()).Scan(&number,&nlow,&nhigh)
+	if err!=nil { return }
+	ok = true
+	low,high = nlow.Int64,nhigh.Int64
+	if mark>high {
+		high = mark
+		if number==0 { low = high+1 }
+	}
+	return
+}
+
+/* A number, that has already been assigned, keeps its first article. */
+const qAssign = `
+	INSERT INTO grp2mid (ngrp,mnum,msgid,expir) VALUES (?,?,?,?)
+	ON CONFLICT (ngrp,mnum) DO NOTHING
+`
+const qAssignHigh = `
+	INSERT INTO grphead (ngrp,head,high) VALUES (?,0,?)
+	ON CONFLICT (ngrp) DO UPDATE SET high = CASE WHEN grphead.high < excluded.high THEN excluded.high ELSE grphead.high END
+`
+
+func (b *Base) assign(t execer, group []byte, num int64, exp uint64, id []byte) error {
+	_,err := t.Exec(b.q(qAssign),group,num,id,int64(exp))
+	if err!=nil { return err }
+	_,err = t.Exec(b.q(qAssignHigh),group,num)
+	return err
+}
+
+func (b *Base) AssignArticleToGroup(group []byte, num, exp uint64, id []byte) error {
+	return b.tx(func(t *sql.Tx) error { return b.assign(t,group,int64(num),exp,id) })
+}
+func (b *Base) AssignArticleToGroups(groups [][]byte, nums []int64, exp uint64, id []byte) error {
+	return b.tx(func(t *sql.Tx) error {
+		for i,group := range groups {
+			err := b.assign(t,group,nums[i],exp,id)
+			if err!=nil { return err }
+		}
+		return nil
+	})
+}
+
+func (b *Base) ListArticleGroupRaw(group []byte, first, last int64, targ func(int64, []byte)) {
+	rows,err := b.DB.Query(b.q(`
+		SELECT mnum,msgid FROM grp2mid WHERE ngrp = ? AND mnum >= ? AND mnum <= ? AND expir >= ? ORDER BY mnum
+	`),group,first,last,now())
+	if err!=nil { return }
+	defer rows.Close()
+	var num int64
+	var id []byte
+	for rows.Next() {
+		if rows.Scan(&num,&id)!=nil { return }
+		targ(num,id)
+	}
+}
+
+/* Deletes the entries, that expired before now (in Unix seconds). */
+func (b *Base) Expire(now int64) (int64,error) {
+	res,err := b.DB.Exec(b.q(`DELETE FROM grp2mid WHERE expir < ?`),now)
+	if err!=nil { return 0,err }
+	return res.RowsAffected()
+}
+
+var _ groupidx.GroupIndex = (*Base)(nil)
+var _ grouphead.GroupHeadDB = (*Base)(nil)
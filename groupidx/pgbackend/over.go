@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package pgbackend
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupover"
+import "database/sql"
+
+type overRow struct{
+	group  []byte
+	status int64
+	descr  []byte
+}
+
+/*
+The rows are read first, so that targ may use the database.
+*/
+func (b *Base) GroupOverviewList(targ func(group []byte, statusAndDescr []byte)) bool {
+	rows,err := b.DB.Query(`SELECT ngrp,status,descr FROM grpover ORDER BY ngrp`)
+	if err!=nil { return false }
+	var list []overRow
+	for rows.Next() {
+		var r overRow
+		if rows.Scan(&r.group,&r.status,&r.descr)!=nil { break }
+		list = append(list,r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err!=nil { return false }
+	var buf []byte
+	for _,r := range list {
+		buf = append(append(buf[:0],byte(r.status)),r.descr...)
+		targ(r.group,buf)
+	}
+	return true
+}
+
+func (b *Base) GroupOverviewGet(group []byte, buffer []byte) (statusAndDescr []byte) {
+	var status int64
+	var descr []byte
+	err := b.DB.QueryRow(b.q(`SELECT status,descr FROM grpover WHERE ngrp = ?`),group).Scan(&status,&descr)
+	if err!=nil { return nil }
+	return append(append(buffer[:0],byte(status)),descr...)
+}
+
+type overUpdater struct{
+	b *Base
+	t execer
+}
+func (u overUpdater) GroupOverviewSetStatus(group []byte, status byte) error {
+	_,err := u.t.Exec(u.b.q(`
+		INSERT INTO grpover (ngrp,status) VALUES (?,?)
+		ON CONFLICT (ngrp) DO UPDATE SET status = excluded.status
+	`),group,int64(status))
+	return err
+}
+func (u overUpdater) GroupOverviewSetDescr(group []byte, descr []byte) error {
+	_,err := u.t.Exec(u.b.q(`
+		INSERT INTO grpover (ngrp,status,descr) VALUES (?,0,?)
+		ON CONFLICT (ngrp) DO UPDATE SET descr = excluded.descr
+	`),group,descr)
+	return err
+}
+
+func (b *Base) GroupOverviewSetStatus(group []byte, status byte) error {
+	return overUpdater{b,b.DB}.GroupOverviewSetStatus(group,status)
+}
+func (b *Base) GroupOverviewSetDescr(group []byte, descr []byte) error {
+	return overUpdater{b,b.DB}.GroupOverviewSetDescr(group,descr)
+}
+func (b *Base) GroupOverviewBatchUpdate(targ func(groupover.GroupOverviewUpdater)error) error {
+	return b.tx(func(t *sql.Tx) error { return targ(overUpdater{b,t}) })
+}
+
+var _ groupover.GroupOverview = (*Base)(nil)
+var _ groupover.GroupOverviewUpdater = (*Base)(nil)
+var _ groupover.GroupOverviewBatchUpdater = (*Base)(nil)
+var _ groupover.GroupOverviewUpdater = overUpdater{}
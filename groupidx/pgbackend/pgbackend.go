@@ -21,37 +21,101 @@ SOFTWARE.
 */
 
 
+/*
+A database/sql backend for the group index, the group head and the group
+overview. It is written for PostgreSQL, with a SQLite dialect for local use.
+*/
 package pgbackend
 
 
 import "database/sql"
+import "strconv"
+import "strings"
+
+
+/* The differences between the SQL databases. */
+type Dialect struct{
+	Name     string
+	Blob     string // type of binary columns
+	Numbered bool   // placeholders are $1,$2... instead of ?
+}
 
+var Postgres = &Dialect{Name:"postgres",Blob:"bytea",Numbered:true}
+var SQLite = &Dialect{Name:"sqlite3",Blob:"BLOB"}
 
+/* Implemented by *sql.DB and *sql.Tx. */
+type execer interface{
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+/*
+Tables:
+	grp2mid: article number → message-id, per group
+	grphead: the numbers, handed out by GroupHeadInsert, and the highest assigned one
+	grpover: status and description of groups
+
+If Dialect is nil, Postgres is assumed.
+*/
 type Base struct{
 	DB *sql.DB
+	Dialect *Dialect
 }
 
-func (b *Base) CreateTables() {
-	b.DB.Exec(`
-	CREATE TABLE grp2mid (
-		ngrp bytea,
-		mnum bigint,
-		msgid bytea,
-		expir date,
-		PRIMARY KEY(ngrp,num)
-	)
-	`)
+func (b *Base) dialect() *Dialect {
+	if b.Dialect==nil { return Postgres }
+	return b.Dialect
 }
 
-func (b *Base) GroupHeadInsert(groups [][]byte, buf []int64) ([]int64, error) {
-	
-	return nil,nil
+/* Rewrites ? into $1,$2... if the dialect requires it. */
+func (b *Base) q(query string) string {
+	if !b.dialect().Numbered { return query }
+	parts := strings.Split(query,"?")
+	buf := make([]byte,0,len(query)+len(parts)*2)
+	for i,p := range parts {
+		if i!=0 { buf = strconv.AppendInt(append(buf,'$'),int64(i),10) }
+		buf = append(buf,p...)
+	}
+	return string(buf)
 }
-func (b *Base) GroupHeadRevert(groups [][]byte, nums []int64) error {
+
+func (b *Base) CreateTables() error {
+	blob := b.dialect().Blob
+	for _,s := range []string{`
+	CREATE TABLE IF NOT EXISTS grp2mid (
+		ngrp `+blob+`,
+		mnum bigint,
+		msgid `+blob+`,
+		expir bigint,
+		PRIMARY KEY(ngrp,mnum)
+	)
+	`,`
+	CREATE INDEX IF NOT EXISTS grp2mid_expir ON grp2mid (expir)
+	`,`
+	CREATE TABLE IF NOT EXISTS grphead (
+		ngrp `+blob+` PRIMARY KEY,
+		head bigint NOT NULL,
+		high bigint NOT NULL
+	)
+	`,`
+	CREATE TABLE IF NOT EXISTS grpover (
+		ngrp `+blob+` PRIMARY KEY,
+		status smallint NOT NULL,
+		descr `+blob+`
+	)
+	`} {
+		_,err := b.DB.Exec(s)
+		if err!=nil { return err }
+	}
 	return nil
 }
-func (b *Base) ArticleGroupStat(group []byte, num, exp int64, id_buf []byte) ([]byte, bool) {
-	return nil,nil
-}
-
 
+/* Runs fn in a transaction. */
+func (b *Base) tx(fn func(t *sql.Tx) error) error {
+	t,err := b.DB.Begin()
+	if err!=nil { return err }
+	err = fn(t)
+	if err!=nil { t.Rollback(); return err }
+	return t.Commit()
+}
@@ -0,0 +1,73 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package pgbackend
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/gitest"
+import _ "github.com/mattn/go-sqlite3"
+import "database/sql"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+import "os"
+import "time"
+
+func tempBase(t *testing.T) (*Base,func()) {
+	dir,err := ioutil.TempDir("","pgbackend")
+	if err!=nil { t.Fatal(err) }
+	db,err := sql.Open("sqlite3",filepath.Join(dir,"groups.db"))
+	if err!=nil { os.RemoveAll(dir); t.Fatal(err) }
+	/* SQLite allows one writer at a time. */
+	db.SetMaxOpenConns(1)
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+	b := &Base{DB:db,Dialect:SQLite}
+	if err = b.CreateTables(); err!=nil { cleanup(); t.Fatal(err) }
+	return b,cleanup
+}
+
+func TestConformance(t *testing.T) {
+	s := &gitest.Suite{New:func(t *testing.T) (groupidx.GroupIndex,func()) {
+		return tempBase(t)
+	}}
+	s.Run(t)
+	s.OverWire2().Run(t)
+}
+
+func TestAssignKeepsFirst(t *testing.T) {
+	b,cleanup := tempBase(t)
+	defer cleanup()
+	group := []byte("a.b")
+	exp := uint64(time.Now().Unix())+3600
+	if err := b.AssignArticleToGroup(group,1,exp,[]byte("<first>")); err!=nil { t.Fatal(err) }
+	if err := b.AssignArticleToGroup(group,1,exp,[]byte("<second>")); err!=nil { t.Fatal(err) }
+	if id,ok := b.ArticleGroupStat(group,1,nil); !ok || string(id)!="<first>" {
+		t.Errorf("ArticleGroupStat(1) -> %q %v, expected <first>",id,ok)
+	}
+	if n,_,_,_ := b.GroupRealtimeQuery(group); n!=1 {
+		t.Errorf("%d articles, expected 1",n)
+	}
+}
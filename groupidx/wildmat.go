@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package groupidx
+
+import "bytes"
+import "unicode/utf8"
+
+/*
+Matches a group name against a wildmat, as defined in RFC 3977 section 4:
+A comma-separated list of patterns, each of which may be negated by a leading '!'.
+The last pattern, that matches, decides. '*' matches any sequence of characters,
+'?' matches a single character.
+*/
+func MatchWildmat(wildmat, group []byte) bool {
+	m := false
+	for _,pat := range bytes.Split(wildmat,[]byte(",")) {
+		neg := len(pat)!=0 && pat[0]=='!'
+		if neg { pat = pat[1:] }
+		if matchPattern(pat,group) { m = !neg }
+	}
+	return m
+}
+
+func matchPattern(pat, s []byte) bool {
+	/* Position after the last '*' and where its match ends, for backtracking. */
+	star,back := -1,0
+	p,i := 0,0
+	for i<len(s) {
+		if p<len(pat) {
+			switch pat[p] {
+			case '*':
+				p++
+				star,back = p,i
+				continue
+			case '?':
+				_,n := utf8.DecodeRune(s[i:])
+				p++; i += n
+				continue
+			default:
+				if pat[p]==s[i] { p++; i++; continue }
+			}
+		}
+		if star<0 { return false }
+		_,n := utf8.DecodeRune(s[back:])
+		back += n
+		p,i = star,back
+	}
+	for p<len(pat) && pat[p]=='*' { p++ }
+	return p==len(pat)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package groupidx
+
+import "testing"
+
+func TestMatchWildmat(t *testing.T) {
+	cases := []struct{
+		wildmat, group string
+		match bool
+	}{
+		{"comp.lang.go","comp.lang.go",true},
+		{"comp.lang.go","comp.lang.gopher",false},
+		{"","comp.lang.go",false},
+		{"*","comp.lang.go",true},
+		{"comp.*","comp.lang.go",true},
+		{"comp.*","comp",false},
+		{"*.go","comp.lang.go",true},
+		{"comp.*.go","comp.lang.go",true},
+		{"comp.*.go","comp.lang.go.misc",false},
+		{"c*g*o","comp.lang.go",true},
+		{"comp.lang.g?","comp.lang.go",true},
+		{"comp.lang.g?","comp.lang.g",false},
+		{"comp.lang.?","comp.lang.go",false},
+
+		/* Negation: a negated pattern alone matches nothing. */
+		{"!comp.*","comp.lang.go",false},
+		{"!comp.*","alt.test",false},
+		{"comp.*,!comp.lang.*","comp.lang.go",false},
+		{"comp.*,!comp.lang.*","comp.os.linux",true},
+
+		/* The last pattern, that matches, wins. */
+		{"comp.*,!comp.lang.*,comp.lang.go","comp.lang.go",true},
+		{"comp.*,!comp.lang.*,comp.lang.go","comp.lang.c",false},
+		{"!comp.lang.*,comp.*","comp.lang.go",true},
+		{"comp.lang.go,!comp.*","comp.lang.go",false},
+		{"alt.*,comp.*","comp.lang.go",true},
+
+		/* '?' matches a whole UTF-8 character, not a byte. */
+		{"de.?bersicht","de.übersicht",true},
+		{"de.??bersicht","de.übersicht",false},
+		{"de.*sicht","de.übersicht",true},
+		{"?","ü",true},
+		{"?","日",true},
+		{"??","日",false},
+		{"de.?","de.日本",false},
+		{"de.??","de.日本",true},
+		{"de.*本","de.日本",true},
+	}
+	for _,c := range cases {
+		if m := MatchWildmat([]byte(c.wildmat),[]byte(c.group)); m!=c.match {
+			t.Errorf("MatchWildmat(%q,%q) = %v, expected %v",c.wildmat,c.group,m,c.match)
+		}
+	}
+}
@@ -139,7 +139,7 @@ func (wh *WireHandler) handleRequest(r *ssh.Request) {
 	case "wire1://AssignArticleToGroup":
 		if !r.WantReply { break }
 		err := msgpackx.Unmarshal(r.Payload,&group,&unum,&exp,&id)
-		if err!=nil {
+		if err==nil {
 			err = wh.Inner.AssignArticleToGroup(group,unum,exp,id)
 		}
 		data,err2 := msgpackx.Marshal(err==nil,fmt.Sprint(err))
@@ -149,7 +149,7 @@ func (wh *WireHandler) handleRequest(r *ssh.Request) {
 	case "wire1://AssignArticleToGroups":
 		if !r.WantReply { break }
 		err := msgpackx.Unmarshal(r.Payload,&groups,&nums,&exp,&id)
-		if err!=nil {
+		if err==nil {
 			err = wh.Inner.AssignArticleToGroups(groups,nums,exp,id)
 		}
 		data,err2 := msgpackx.Marshal(err==nil,fmt.Sprint(err))
@@ -173,7 +173,8 @@ func (wh *WireHandler) HandleConnection(conn *ssh.ServerConn, chs <-chan ssh.New
 	//go blackHole_Chan_do(chs)
 	for {
 		select {
-		case nch := <- chs:
+		case nch,ok := <- chs:
+			if !ok { return }
 			switch nch.ChannelType() {
 			case "wire1://Binary":
 				sch,srqs,err := nch.Accept()
@@ -181,7 +182,8 @@ func (wh *WireHandler) HandleConnection(conn *ssh.ServerConn, chs <-chan ssh.New
 			default:
 				nch.Reject(ssh.UnknownChannelType,"unknown")
 			}
-		case req := <- reqs:
+		case req,ok := <- reqs:
+			if !ok { return }
 			wh.handleRequest(req)
 		}
 	}
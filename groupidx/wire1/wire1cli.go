@@ -111,7 +111,7 @@ func (c *Client) ArticleGroupMove(group []byte, i int64, backward bool, id_buf [
 	ok,data,err := c.Inner.SendRequest("wire1://ArticleGroupMove",true,data)
 	if err!=nil { return 0,nil,false }
 	if !ok { return 0,nil,false }
-	err = msgpackx.Unmarshal(data,&b,&id_buf,&i)
+	err = msgpackx.Unmarshal(data,&b,&i,&id_buf)
 	if err!=nil { return 0,nil,false }
 	return i,id_buf,b
 }
@@ -146,7 +146,6 @@ func (c *Client) AssignArticleToGroups(groups [][]byte, nums []int64, exp uint64
 func (c *Client) borrowBinary() (ssh.Channel,error) {
 	ch,reqs,err := c.Inner.OpenChannel("wire1://Binary",nil)
 	if err!=nil { return nil,err }
-	defer ch.Close()
 	go blackHole_Req_do(reqs)
 	return ch,nil
 }
@@ -164,13 +163,14 @@ func (c *Client) ListArticleGroupRaw(group []byte, first, last int64, targ func(
 	enc := msgpack.NewEncoder(w)
 	dec := msgpack.NewDecoder(ch)
 	
-	if err := enc.EncodeMulti("ListArticleGroupRaw",first,last,group); err==nil { return }
+	if err := enc.EncodeMulti("ListArticleGroupRaw",first,last,group); err!=nil { return }
 	if err := w.Flush(); err!=nil { return }
 	
+	var more bool
 	var num int64
 	var id []byte
 	for {
-		if err := dec.DecodeMulti(&num,&id); err!=nil { break }
+		if err := dec.DecodeMulti(&more,&num,&id); err!=nil || !more { break }
 		targ(num,id)
 	}
 }
@@ -184,12 +184,13 @@ func (c *Client) ArticleGroupList(group []byte, first, last int64, targ func(int
 	enc := msgpack.NewEncoder(w)
 	dec := msgpack.NewDecoder(ch)
 	
-	if err := enc.EncodeMulti("ArticleGroupList",first,last,group); err==nil { return }
+	if err := enc.EncodeMulti("ArticleGroupList",first,last,group); err!=nil { return }
 	if err := w.Flush(); err!=nil { return }
 	
+	var more bool
 	var num int64
 	for {
-		if err := dec.Decode(&num); err!=nil { break }
+		if err := dec.DecodeMulti(&more,&num); err!=nil || !more { break }
 		targ(num)
 	}
 }
@@ -122,7 +122,6 @@ func (cS *clientStream) checkClosed() bool{
 	case <- cS.cls: return true
 	default: return false
 	}
-	return false
 }
 func (cS *clientStream) keepalive() {
 	req := reqPool.Get().(*request)
@@ -136,7 +135,7 @@ func (cS *clientStream) keepalive() {
 		case <- tickr.C:
 			cS.cli.SendNowait(req,emptyfree)
 		case <- cS.cls:
-			break
+			return
 		}
 	}
 }
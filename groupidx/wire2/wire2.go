@@ -72,6 +72,17 @@ func createHandler(ginr groupidx.GroupIndex) func(ctx fastrpc.HandlerCtx) fastrp
 		}
 	}
 	
+	var LAS func(wildmat []byte, since int64, enc *msgpack.Encoder, cls io.Closer)
+	if x,ok := ginr.(groupidx.GroupIndexSince); ok {
+		LAS = func(wildmat []byte, since int64, enc *msgpack.Encoder, cls io.Closer) {
+			x.ListArticlesSince(wildmat,since,func(group []byte, num int64, id []byte){
+				enc.EncodeMulti(true,group,num,id)
+			})
+			enc.EncodeMulti(false,[]byte(nil),int64(0),[]byte(nil))
+			cls.Close()
+		}
+	}
+	
 	/* Well the indentation is totally screwed up, but this is because this routine is copied from wire1. */
 	handleRequest := func(r *iRequest) {
 		var group  []byte
@@ -100,6 +111,19 @@ func createHandler(ginr groupidx.GroupIndex) func(ctx fastrpc.HandlerCtx) fastrp
 			case "stream://AGL" : go AGL (group,first,last,msgpack.NewEncoder(str),str)
 			}
 			return
+		case "stream://LAS":
+			if !r.WantReply || LAS==nil { break }
+			var since int64
+			err := msgpackx.Unmarshal(r.Payload,&group,&since)
+			if err!=nil { break }
+			
+			n,str  := strmap.allocate(strall,time.Now().Add(tmout))
+			
+			data,_ := msgpack.Marshal(n)
+			r.Reply(true,data)
+			
+			go LAS(group,since,msgpack.NewEncoder(str),str)
+			return
 		case "stream://Pull":
 			err := msgpack.Unmarshal(r.Payload,&unum)
 			if err!=nil { break }
@@ -112,7 +112,7 @@ func (c *Client) ArticleGroupMove(group []byte, i int64, backward bool, id_buf [
 	ok,data,err := c.Inner.SendRequest("wire1://ArticleGroupMove",true,data)
 	if err!=nil { return 0,nil,false }
 	if !ok { return 0,nil,false }
-	err = msgpackx.Unmarshal(data,&b,&id_buf,&i)
+	err = msgpackx.Unmarshal(data,&b,&i,&id_buf)
 	if err!=nil { return 0,nil,false }
 	return i,id_buf,b
 }
@@ -179,6 +179,27 @@ func (c *Client) ArticleGroupList(group []byte, first, last int64, targ func(int
 	}
 }
 
+/*
+If the server's backend doesn't implement groupidx.GroupIndexSince, nothing is listed.
+*/
+func (c *Client) ListArticlesSince(wildmat []byte, since int64, targ func(group []byte, num int64, id []byte)) {
+	data,err := msgpackx.Marshal(wildmat,since)
+	if err!=nil { return }
+	ch,err := c.Inner.openStream("stream://LAS",true,data)
+	if err!=nil { return }
+	defer ch.consume()
+	
+	dec := msgpack.NewDecoder(ch)
+	
+	var group,id []byte
+	var num int64
+	var ok bool
+	for {
+		if err := dec.DecodeMulti(&ok,&group,&num,&id); err!=nil || !ok { break }
+		targ(group,num,id)
+	}
+}
+
 func (c *Client) GroupRealtimeQuery(group []byte) (number int64, low int64, high int64, ok bool) {
 	rok,data,err := c.Inner.SendRequest("wire1://GroupRealtimeQuery",true,group)
 	if err!=nil { return }
@@ -190,5 +211,6 @@ func (c *Client) GroupRealtimeQuery(group []byte) (number int64, low int64, high
 
 var _ groupidx.GroupIndex = (*Client)(nil)
 var _ idxExt1 = (*Client)(nil)
+var _ groupidx.GroupIndexSince = (*Client)(nil)
 
 
@@ -33,11 +33,17 @@ import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster/runner"
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster/bucketsched"
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/healthmap"
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netsel"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/expiry"
+
+/* NetKV providers, that can be offered at runtime (see bucketstore/cluster/admin). */
+import _ "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netkv/netdir"
+import _ "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/netkv/netresp"
 
 import "github.com/lytics/confl"
 import "github.com/gocql/gocql"
 import "net"
 import "io"
+import "time"
 
 type Cassa struct{
 	Keyspace string
@@ -59,10 +65,48 @@ Config data structure. To be parsed with confl.
 	rpc {
 		port 63282
 	}
+	# Replicated buckets: all, majority, one or a number.
+	quorum majority
+	# Circuit breakers of the remote nodes: a node is avoided after
+	# failures consecutive failures or calls slower than slowcall
+	# milliseconds, for openfor seconds.
+	breaker {
+		failures 5
+		slowcall 1000
+		openfor 10
+	}
+	# Connections to the remote nodes: up to maxconns per node, another
+	# one is opened, if all have busy pending requests. Connections idle
+	# for more than idle seconds are closed (-1 disables this).
+	pool {
+		maxconns 4
+		busy 16
+		idle 300
+	}
+	# Gossip encryption keys (base64) and the nodes, that may join.
+	keys [ "cg8StVXbQJ0gPvMd9o7yrg==" ]
+	allow [ 10.1.0.0/16 ]
+	# Distance between locations, see runner.Configuration.
+	topology {
+		type hierarchical
+		rttweight 10
+	}
+	# Admin service, see runner.Configuration.
+	admin {
+		addr 127.0.0.1
+		port 63283
+	}
 	# Articlestore-service.
 	service {
 		port 63300
 	}
+	# Erasure coding: bodies of at least minsize bytes are split into
+	# data+parity shards on distinct buckets (see chybrid.Erasure).
+	erasure {
+		data 4
+		parity 2
+		minsize 65536
+	}
 	# Cassandra-cluster
 	cassandra {
 		keyspace mydb
@@ -81,12 +125,71 @@ Config data structure. To be parsed with confl.
 		'E:\bucket'
 		"F:\bucket"
 	]
+	# Databases hosting many buckets. The bucket names are prefixed
+	# with the UID of the path, e.g. "<uid>.a".
+	multi [
+		{
+			path /path/to/database
+			buckets [ a b c ]
+		}
+	]
+	# Health thresholds of the local buckets, see runner.Configuration.
+	health {
+		minfree 268435456
+		minfreeratio 0.001
+		interval 30
+		probe true
+	}
+	# Bucket scheduling: buckets are chosen by their free space. Buckets
+	# in the same location are preferred by the factor localbias, buckets
+	# with less than minfree bytes are avoided.
+	sched {
+		localbias 4
+		minfree 1073741824
+	}
+	# Side index for buckets, that can't expire keys on their own
+	# (like the kinetic netkv provider); see bucketstore/expiry.
+	expiryindex /path/to/expiry-index
+	# Buckets, that have been moved to this node (see bucketstore/cluster/drain),
+	# and the local bucket, they are served from.
+	aliases {
+		drained-bucket-uid local-bucket-uid
+	}
 */
+type MultiBucket struct{
+	Path string
+	Buckets []string
+}
+
+type SchedConfig struct{
+	LocalBias float64
+	MinFree uint64
+}
+
+type BreakerConfig struct{
+	Failures int
+	SlowCall int
+	OpenFor int
+}
+
+type PoolConfig struct{
+	MaxConns int
+	Busy int
+	Idle int
+}
+
 type Config struct{
 	runner.Configuration
 	Service runner.Bind
 	Cassandra Cassa
 	Buckets []string
+	Multi []MultiBucket
+	Aliases map[string]string
+	Sched SchedConfig
+	Breaker BreakerConfig
+	Pool PoolConfig
+	Erasure chybrid.Erasure
+	ExpiryIndex string
 }
 func (bcfg *Config) LoadBytes(b []byte) error {
 	return confl.Unmarshal(b,bcfg)
@@ -98,8 +201,19 @@ func (bcfg *Config) NCluster() (*cluster.Deleg,error) {
 	
 	d,e := bcfg.Configuration.NCluster()
 	if e!=nil { return nil,e }
+	if bcfg.ExpiryIndex!="" {
+		idx,e := expiry.Open(bcfg.ExpiryIndex)
+		if e!=nil { return nil,e }
+		d.NKV.EmulateExpiry = idx.Emulate
+	}
 	for _,buk := range bcfg.Buckets {
-		openBucket(buk,d)
+		openBucket(buk,d,&bcfg.Health)
+	}
+	for _,mb := range bcfg.Multi {
+		openMulti(mb.Path,mb.Buckets,d,&bcfg.Health)
+	}
+	for name,local := range bcfg.Aliases {
+		d.AliasBucket(d.Self,[]byte(name),[]byte(local))
 	}
 	
 	if bcfg.Service.Port!=0 {
@@ -112,12 +226,26 @@ func (bcfg *Config) NCluster() (*cluster.Deleg,error) {
 		chybrid.Initialize(session)
 		
 		sel := new(netsel.NodeSelector).Init(d)
+		sel.Quorum,e = netsel.ParseQuorum(bcfg.Quorum)
+		if e!=nil { return nil,e }
+		sel.Breaker.Failures = bcfg.Breaker.Failures
+		sel.Breaker.SlowCall = time.Duration(bcfg.Breaker.SlowCall)*time.Millisecond
+		sel.Breaker.OpenFor = time.Duration(bcfg.Breaker.OpenFor)*time.Second
+		sel.Pool.MaxConns = bcfg.Pool.MaxConns
+		sel.Pool.Busy = bcfg.Pool.Busy
+		sel.Pool.IdleTimeout = time.Duration(bcfg.Pool.Idle)*time.Second
 		
 		sched := new(bucketsched.BucketScheduler)
 		sched.D = d
+		sched.LocalBias = bcfg.Sched.LocalBias
+		sched.MinFree = bcfg.Sched.MinFree
 		sched.Start()
 		
 		sw := &chybrid.StoreWriter{Sched:sched,Flook:sel,Session:session,UseFastOver:true}
+		if bcfg.Erasure.Data>0 {
+			ec := bcfg.Erasure
+			sw.Erasure = &ec
+		}
 		sr := &chybrid.StoreReader{Bucket:sel,Session:session}
 		
 		addr := bcfg.Bind.Addr
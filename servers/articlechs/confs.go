@@ -26,14 +26,14 @@ package articlechs
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster"
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/bucketmap"
 import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv"
-import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/dkv/health"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/cluster/runner"
 import "github.com/maxymania/fastnntp-polyglot-labs/guido"
 import "os"
 //import "errors"
 
 //var EPathMismatch = errors.New("EPathMismatch")
 
-func openBucket(path string,d *cluster.Deleg) {
+func openBucket(path string,d *cluster.Deleg,h *runner.Health) {
 	s,err := os.Stat(path)
 	if err!=nil { return }
 	if !s.IsDir() { return }
@@ -43,6 +43,23 @@ func openBucket(path string,d *cluster.Deleg) {
 	if err!=nil { return }
 	bkt := []byte(u.String())
 	d.AddBucket(bkt,bucketmap.Bucket{Reader:db,Writer:db,WriterEx:db})
-	(&health.HealthChecker{Path:path,Bucket:bkt}).Start()
+	h.Checker(path,bkt).Start()
 }
 
+
+func openMulti(path string,names []string,d *cluster.Deleg,h *runner.Health) {
+	s,err := os.Stat(path)
+	if err!=nil { return }
+	if !s.IsDir() { return }
+	u,err := guido.GetUID(path)
+	if err!=nil { return }
+	m,err := dkv.OpenMulti(path)
+	if err!=nil { return }
+	for _,name := range names {
+		if m.Create([]byte(name))!=nil { continue }
+		db := m.Bucket([]byte(name))
+		bkt := []byte(u.String()+"."+name)
+		d.AddBucket(bkt,bucketmap.Bucket{Reader:db,Writer:db,WriterEx:db})
+		h.Checker(path,bkt).Start()
+	}
+}
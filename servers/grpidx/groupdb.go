@@ -26,6 +26,7 @@ import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
 import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/groupdb2"
 import bolt "github.com/coreos/bbolt"
 import "os"
+import "time"
 
 func storageGroupdb (s *storage) (groupidx.GroupIndex,error) {
 	opts := new(bolt.Options)
@@ -33,7 +34,14 @@ func storageGroupdb (s *storage) (groupidx.GroupIndex,error) {
 	opts.PageSize        = int(s.PageSize.Int64())
 	db,err := bolt.Open(s.Location,os.FileMode(s.Mode.Uint64()),opts)
 	if err!=nil { return nil,err }
-	return groupdb2.NewDB(db,[]byte(s.Table)),nil
+	gdb := groupdb2.NewDB(db,[]byte(s.Table))
+	/* Expired entries are deleted every sweep-interval seconds, if set. */
+	if iv := s.SweepInterval.Int64(); iv>0 {
+		sw := gdb.Sweeper()
+		sw.Interval = time.Duration(iv)*time.Second
+		sw.Start()
+	}
+	return gdb,nil
 }
 
 func init() {
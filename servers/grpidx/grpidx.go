@@ -30,6 +30,7 @@ storage groupdb {
 	page-size: 1<<14
 	table: groupdb
 	location: 'F:/data/'
+	sweep-interval: 60
 }
 network {
 	net: tcp
@@ -45,6 +46,7 @@ type storage struct {
 	Mode            datatypes.Number `inn:"$mode"`
 	Table           string           `inn:"$table"`
 	Location        string           `inn:"$location"`
+	SweepInterval   datatypes.Number `inn:"$sweep-interval"`
 }
 
 type network struct {
@@ -43,6 +43,7 @@ Parses a config file (or blob), loads the storage and runs.
 		page-size: 1<<14
 		table: groupdb
 		location: 'F:/data/'
+		sweep-interval: 60
 	}
 	network {
 		net: tcp
@@ -27,13 +27,23 @@ import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
 import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/groupdb3"
 import "os"
 import "strings"
+import "time"
 
 var osPS = string([]rune{os.PathSeparator})
 
 func storageGroupdb (s *Storage) (groupidx.GroupIndex,error) {
-	db,err := groupdb3.OpenLogDB(strings.Replace(s.Location,"/",osPS,-1))
+	sp,err := groupdb3.ParseSyncPolicy(s.Sync)
+	if err!=nil { return nil,err }
+	opts := &groupdb3.Options{Sync:sp,Interval:time.Duration(s.SyncInterval.Int64())*time.Millisecond}
+	db,err := groupdb3.OpenLogDBOptions(strings.Replace(s.Location,"/",osPS,-1),opts)
 	if err!=nil { return nil,err }
 	go db.Worker(s.LogSizeFlush.Int64())
+	/* Expired entries are deleted every sweep-interval seconds, if set. */
+	if iv := s.SweepInterval.Int64(); iv>0 {
+		sw := db.DB.Sweeper()
+		sw.Interval = time.Duration(iv)*time.Second
+		sw.Start()
+	}
 	return db,nil
 }
 
@@ -28,6 +28,9 @@ import "github.com/byte-mug/goconfig/datatypes"
 storage groupdb {
 	log-size-flush: 1<<20
 	location: 'F:/data/'
+	sweep-interval: 60
+	sync: interval
+	sync-interval: 1000
 }
 network {
 	net: tcp
@@ -40,6 +43,9 @@ type Storage struct {
 	Type            string           `inn:"$storage"`
 	LogSizeFlush    datatypes.Number `inn:"$log-size-flush"`
 	Location        string           `inn:"$location"`
+	SweepInterval   datatypes.Number `inn:"$sweep-interval"`
+	Sync            string           `inn:"$sync"`          // interval, always or group
+	SyncInterval    datatypes.Number `inn:"$sync-interval"` // milliseconds
 }
 
 type network struct {
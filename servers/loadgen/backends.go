@@ -0,0 +1,85 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package loadgen
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore/netwire"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/wire2"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/memstore"
+import "fmt"
+
+type f_articles func(c *ArticleBackend) (articlestore.StorageR,articlestore.StorageW,error)
+var m_articles = make(map[string]f_articles)
+
+type f_groups func(c *GroupBackend) (groupidx.GroupIndex,error)
+var m_groups = make(map[string]f_groups)
+
+/*
+Registers an additional article storage backend, eg. to benchmark
+a local timefile or badger storage.
+*/
+func RegisterArticles(s string,f func(c *ArticleBackend) (articlestore.StorageR,articlestore.StorageW,error)) {
+	_,ok := m_articles[s]
+	if ok { panic("Name conflict: backend "+s+" already exists") }
+	m_articles[s] = f
+}
+
+/*
+Registers an additional group index backend, eg. to benchmark
+a local groupdb2 or groupdb3 database.
+*/
+func RegisterGroups(s string,f func(c *GroupBackend) (groupidx.GroupIndex,error)) {
+	_,ok := m_groups[s]
+	if ok { panic("Name conflict: backend "+s+" already exists") }
+	m_groups[s] = f
+}
+
+func create_articles(c *ArticleBackend) (articlestore.StorageR,articlestore.StorageW,error) {
+	f := m_articles[c.Type]
+	if f==nil { return nil,nil,fmt.Errorf("No such article storage %q",c.Type) }
+	return f(c)
+}
+func create_groups(c *GroupBackend) (groupidx.GroupIndex,error) {
+	f := m_groups[c.Type]
+	if f==nil { return nil,fmt.Errorf("No such group index %q",c.Type) }
+	return f(c)
+}
+
+func init() {
+	m_articles["memory"] = func(c *ArticleBackend) (articlestore.StorageR,articlestore.StorageW,error) {
+		s := new(memstore.Storage)
+		return s,s,nil
+	}
+	m_articles["netwire"] = func(c *ArticleBackend) (articlestore.StorageR,articlestore.StorageW,error) {
+		cli := netwire.NewClient(c.Net,c.Addr)
+		return netwire.ClientR(cli),netwire.ClientW(cli),nil
+	}
+	m_groups["memory"] = func(c *GroupBackend) (groupidx.GroupIndex,error) {
+		return new(memstore.GroupIndex),nil
+	}
+	m_groups["wire2"] = func(c *GroupBackend) (groupidx.GroupIndex,error) {
+		return wire2.NewClient(c.Net,c.Addr),nil
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package loadgen
+
+import "github.com/byte-mug/goconfig/datatypes"
+
+/*
+articles netwire {
+	net: tcp
+	addr: '127.0.0.1:9999'
+}
+groups wire2 {
+	net: tcp
+	addr: '127.0.0.1:9998'
+}
+load {
+	groups: 200
+	articles: 100000
+	workers: 32
+	body-median: 2048
+	body-max: 1<<20
+	crosspost-percent: 20
+	crosspost-max: 5
+	retention-days: 7
+	reads: 200000
+	mix-group: 30
+	mix-article: 60
+	mix-xover: 10
+	xover-range: 100
+}
+*/
+
+type ArticleBackend struct {
+	Type string `inn:"$articles"`
+	Net  string `inn:"$net"`
+	Addr string `inn:"$addr"`
+}
+
+type GroupBackend struct {
+	Type string `inn:"$groups"`
+	Net  string `inn:"$net"`
+	Addr string `inn:"$addr"`
+}
+
+type load struct {
+	Groups           datatypes.Number `inn:"$groups"`
+	Articles         datatypes.Number `inn:"$articles"`
+	Workers          datatypes.Number `inn:"$workers"`
+	BodyMedian       datatypes.Number `inn:"$body-median"`
+	BodyMax          datatypes.Number `inn:"$body-max"`
+	CrosspostPercent datatypes.Number `inn:"$crosspost-percent"`
+	CrosspostMax     datatypes.Number `inn:"$crosspost-max"`
+	RetentionDays    datatypes.Number `inn:"$retention-days"`
+	Reads            datatypes.Number `inn:"$reads"`
+	MixGroup         datatypes.Number `inn:"$mix-group"`
+	MixArticle       datatypes.Number `inn:"$mix-article"`
+	MixXover         datatypes.Number `inn:"$mix-xover"`
+	XoverRange       datatypes.Number `inn:"$xover-range"`
+}
+
+type config struct {
+	Articles ArticleBackend `inn:"$articles"`
+	Groups   GroupBackend   `inn:"$groups"`
+	Load     load           `inn:"$load"`
+}
+
+func def(n datatypes.Number, d int64) int64 {
+	if v := n.Int64(); v>0 { return v }
+	return d
+}
+
+/* The load parameters, with defaults applied. */
+type params struct {
+	groups, articles, workers int
+	bodyMedian, bodyMax int
+	crosspostPercent, crosspostMax int
+	retentionDays int
+	reads int
+	mixGroup, mixArticle, mixXover int
+	xoverRange int64
+}
+
+func (l *load) params() (p params) {
+	p.groups           = int(def(l.Groups,200))
+	p.articles         = int(def(l.Articles,100000))
+	p.workers          = int(def(l.Workers,32))
+	p.bodyMedian       = int(def(l.BodyMedian,2048))
+	p.bodyMax          = int(def(l.BodyMax,1<<20))
+	p.crosspostPercent = int(def(l.CrosspostPercent,20))
+	p.crosspostMax     = int(def(l.CrosspostMax,5))
+	p.retentionDays    = int(def(l.RetentionDays,7))
+	p.reads            = int(def(l.Reads,p.articles*2))
+	p.mixGroup         = int(def(l.MixGroup,30))
+	p.mixArticle       = int(def(l.MixArticle,60))
+	p.mixXover         = int(def(l.MixXover,10))
+	p.xoverRange       = def(l.XoverRange,100)
+	if p.crosspostMax<1 { p.crosspostMax = 1 }
+	if p.crosspostMax>p.groups { p.crosspostMax = p.groups }
+	return
+}
@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+Loadgen is a load generator for the whole posting and reading path.
+
+It builds a caps.ArticleDB and a caps.GroupDB from the configured backends,
+posts synthetic articles through ArticlePostingPost and then replays a mix of
+GROUP, ARTICLE and XOVER commands against them. For both phases, the throughput
+and the latency percentiles are reported for the NNTP commands ("nntp") as well
+as for the article storage ("articles") and the group index ("groups").
+*/
+package loadgen
+
+import "github.com/byte-mug/goconfig"
+import "github.com/maxymania/fastnntp-polyglot"
+import "github.com/maxymania/fastnntp-polyglot/policies"
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/caps"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/memstore"
+import "sync/atomic"
+import "sync"
+import "time"
+import "fmt"
+import "io"
+
+type retention struct{
+	days int
+}
+func (r retention) Decide(groups [][]byte, lines, length int64) (d policies.PostingDecision) {
+	d.ExpireAt = time.Now().AddDate(0,0,r.days)
+	return
+}
+
+type bench struct{
+	p      params
+	groups [][]byte
+
+	gi     groupidx.GroupIndex
+	sr     articlestore.StorageR
+	sw     articlestore.StorageW
+	over   *memstore.GroupOverview
+}
+
+/* Creates the instrumented ArticleDB and GroupDB. */
+func (b *bench) dbs(r *recorder) (*caps.ArticleDB,*caps.GroupDB) {
+	gi := timedIndex{b.gi,r}
+	adb := &caps.ArticleDB{
+		GroupIndex: gi,
+		ArticleGL:  caps.NewArticleGL(gi),
+		StorageR:   timedStorageR{b.sr,r},
+		StorageW:   timedStorageW{b.sw,r},
+		Policy:     retention{b.p.retentionDays},
+	}
+	gdb := &caps.GroupDB{
+		GroupIndex:    gi,
+		GroupOverview: b.over,
+	}
+	return adb,gdb
+}
+
+/* Runs f in the configured number of workers, until n jobs are done. */
+func (b *bench) parallel(n int, f func(s *synth)) time.Duration {
+	var wg sync.WaitGroup
+	var jobs int64
+	begin := time.Now()
+	for w := 0; w<b.p.workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			s := newSynth(&b.p,b.groups,w)
+			for atomic.AddInt64(&jobs,1)<=int64(n) { f(s) }
+		}(w)
+	}
+	wg.Wait()
+	return time.Since(begin)
+}
+
+func (b *bench) post(w io.Writer) {
+	r := newRecorder()
+	adb,gdb := b.dbs(r)
+	elapsed := b.parallel(b.p.articles,func(s *synth) {
+		h,body,ngs := s.article()
+		nums,err := gdb.GroupHeadInsert(ngs,nil)
+		if err!=nil { return }
+		begin := time.Now()
+		_,failed,_ := adb.ArticlePostingPost(h,body,ngs,nums)
+		r.record("nntp","POST",begin,failed)
+		if failed { gdb.GroupHeadRevert(ngs,nums) }
+	})
+	fmt.Fprintf(w,"Posting phase: %d articles in %v\n",b.p.articles,elapsed)
+	r.report(w,elapsed)
+}
+
+func (b *bench) read(w io.Writer) {
+	r := newRecorder()
+	adb,gdb := b.dbs(r)
+	total := b.p.mixGroup+b.p.mixArticle+b.p.mixXover
+	elapsed := b.parallel(b.p.reads,func(s *synth) {
+		g := s.group()
+		begin := time.Now()
+		_,low,high,ok := gdb.GroupRealtimeQuery(g)
+		r.record("nntp","GROUP",begin,!ok)
+		if !ok || high<low || high<=0 { return }
+
+		switch x := s.rnd.Intn(total); {
+		case x<b.p.mixGroup:
+		case x<b.p.mixGroup+b.p.mixArticle:
+			num := low+s.rnd.Int63n(1+high-low)
+			begin = time.Now()
+			_,ao := adb.ArticleGroupGet(g,num,true,true,nil)
+			r.record("nntp","ARTICLE",begin,ao==nil)
+		default:
+			first := high-b.p.xoverRange+1
+			if first<low { first = low }
+			n := 0
+			begin = time.Now()
+			adb.ArticleGroupOverview(g,first,high,func(int64, *newspolyglot.ArticleOverview) { n++ })
+			r.record("nntp","XOVER",begin,n==0)
+		}
+	})
+	fmt.Fprintf(w,"Reading phase: %d sessions in %v\n",b.p.reads,elapsed)
+	r.report(w,elapsed)
+}
+
+/*
+Parses a config file (or blob), creates the backends and runs the benchmark.
+The results are written to w.
+	articles netwire {
+		net: tcp
+		addr: '127.0.0.1:9999'
+	}
+	groups wire2 {
+		net: tcp
+		addr: '127.0.0.1:9998'
+	}
+	load {
+		groups: 200
+		articles: 100000
+		workers: 32
+		reads: 200000
+	}
+*/
+func Run(cfg []byte, w io.Writer) error {
+	obj := new(config)
+	err := goconfig.Parse(cfg,goconfig.CreateReflectHandler(obj))
+	if err!=nil { return err }
+
+	b := &bench{p:obj.Load.params()}
+	b.sr,b.sw,err = create_articles(&obj.Articles)
+	if err!=nil { return err }
+	b.gi,err = create_groups(&obj.Groups)
+	if err!=nil { return err }
+
+	b.groups = groupNames(b.p.groups)
+	b.over = new(memstore.GroupOverview)
+	for _,g := range b.groups {
+		b.over.GroupOverviewSetStatus(g,'y')
+	}
+
+	b.post(w)
+	b.read(w)
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package loadgen
+
+import "github.com/byte-mug/fastnntp/posting"
+import "math/rand"
+import "math"
+import "fmt"
+import "time"
+
+/*
+Generates synthetic articles.
+
+Body sizes follow a log-normal distribution around the median, which fits the
+mix of short text postings and few large ones. The number of groups per article
+follows a geometric distribution and the groups themselves are picked according
+to Zipf's law, so that few groups get most of the traffic.
+*/
+type synth struct{
+	p      *params
+	rnd    *rand.Rand
+	zipf   *rand.Zipf
+	groups [][]byte
+	seq    int
+	worker int
+}
+
+func groupNames(n int) [][]byte {
+	g := make([][]byte,n)
+	for i := range g { g[i] = []byte(fmt.Sprintf("loadgen.test.g%d",i)) }
+	return g
+}
+
+func newSynth(p *params, groups [][]byte, worker int) *synth {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()+int64(worker)))
+	return &synth{
+		p:      p,
+		rnd:    rnd,
+		zipf:   rand.NewZipf(rnd,1.1,1,uint64(len(groups)-1)),
+		groups: groups,
+		worker: worker,
+	}
+}
+
+func (s *synth) bodySize() int {
+	n := int(math.Exp(s.rnd.NormFloat64()*1.2)*float64(s.p.bodyMedian))
+	if n<16 { n = 16 }
+	if n>s.p.bodyMax { n = s.p.bodyMax }
+	return n
+}
+
+/* Picks a group using Zipf's law. */
+func (s *synth) group() []byte { return s.groups[s.zipf.Uint64()] }
+
+/* Picks the newsgroups of an article. */
+func (s *synth) newsgroups() [][]byte {
+	n := 1
+	for n<s.p.crosspostMax && s.rnd.Intn(100)<s.p.crosspostPercent { n++ }
+	ngs := make([][]byte,0,n)
+	outer:
+	for len(ngs)<n {
+		g := s.group()
+		for _,o := range ngs {
+			if string(o)==string(g) { continue outer }
+		}
+		ngs = append(ngs,g)
+	}
+	return ngs
+}
+
+const lineChars = "abcdefghijklmnopqrstuvwxyz ABCDEFGHIJKLMNOPQRSTUVWXYZ 0123456789 .,"
+
+func (s *synth) body() []byte {
+	b := make([]byte,s.bodySize())
+	col := 0
+	for i := range b {
+		if col==72 {
+			b[i] = '\n'
+			col = 0
+			continue
+		}
+		b[i] = lineChars[s.rnd.Intn(len(lineChars))]
+		col++
+	}
+	return b
+}
+
+/* Generates the next article. */
+func (s *synth) article() (*posting.HeadInfo,[]byte,[][]byte) {
+	s.seq++
+	ngs := s.newsgroups()
+	h := new(posting.HeadInfo)
+	h.MessageId  = []byte(fmt.Sprintf("<%d.%d.%d@loadgen.invalid>",time.Now().UnixNano(),s.worker,s.seq))
+	h.Subject    = []byte(fmt.Sprintf("Synthetic article %d of worker %d",s.seq,s.worker))
+	h.From       = []byte("loadgen <loadgen@loadgen.invalid>")
+	h.Date       = []byte(time.Now().UTC().Format(time.RFC1123Z))
+	ngl := make([]byte,0,64)
+	for i,g := range ngs {
+		if i>0 { ngl = append(ngl,',') }
+		ngl = append(ngl,g...)
+	}
+	h.RAW = []byte(fmt.Sprintf("Message-ID: %s\r\nSubject: %s\r\nFrom: %s\r\nDate: %s\r\nNewsgroups: %s\r\n",
+		h.MessageId,h.Subject,h.From,h.Date,ngl))
+	return h,s.body(),ngs
+}
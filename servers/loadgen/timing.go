@@ -0,0 +1,163 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package loadgen
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "sort"
+import "sync"
+import "time"
+import "fmt"
+import "io"
+
+type series struct{
+	layer, op string
+	mu     sync.Mutex
+	lat    []time.Duration
+	errs   int
+}
+func (s *series) add(d time.Duration, failed bool) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	s.lat = append(s.lat,d)
+	if failed { s.errs++ }
+}
+
+/* Collects latencies per layer and operation. */
+type recorder struct{
+	mu   sync.Mutex
+	m    map[string]*series
+	keys []string
+}
+func newRecorder() *recorder { return &recorder{m:make(map[string]*series)} }
+
+func (r *recorder) get(layer, op string) *series {
+	k := layer+"/"+op
+	r.mu.Lock(); defer r.mu.Unlock()
+	s := r.m[k]
+	if s==nil {
+		s = &series{layer:layer,op:op}
+		r.m[k] = s
+		r.keys = append(r.keys,k)
+	}
+	return s
+}
+func (r *recorder) record(layer, op string, begin time.Time, failed bool) {
+	r.get(layer,op).add(time.Since(begin),failed)
+}
+
+/*
+Returns the p-th percentile of the sorted latencies, using the nearest rank: the
+smallest value, that is not less than p percent of the values.
+*/
+func percentile(lat []time.Duration, p int) time.Duration {
+	if len(lat)==0 { return 0 }
+	i := (len(lat)*p+99)/100-1
+	if i<0 { i = 0 }
+	if i>=len(lat) { i = len(lat)-1 }
+	return lat[i]
+}
+
+/* Prints the throughput and the latency percentiles of every series. */
+func (r *recorder) report(w io.Writer, elapsed time.Duration) {
+	r.mu.Lock(); defer r.mu.Unlock()
+	keys := append([]string(nil),r.keys...)
+	sort.Strings(keys)
+	fmt.Fprintf(w,"%-10s %-22s %9s %7s %11s %10s %10s %10s %10s\n","layer","op","count","errors","ops/s","p50","p90","p99","max")
+	for _,k := range keys {
+		s := r.m[k]
+		s.mu.Lock()
+		lat := append([]time.Duration(nil),s.lat...)
+		errs := s.errs
+		s.mu.Unlock()
+		sort.Slice(lat,func(i,j int) bool { return lat[i]<lat[j] })
+		tput := float64(len(lat))/elapsed.Seconds()
+		fmt.Fprintf(w,"%-10s %-22s %9d %7d %11.1f %10v %10v %10v %10v\n",s.layer,s.op,len(lat),errs,tput,
+			percentile(lat,50),percentile(lat,90),percentile(lat,99),percentile(lat,100))
+	}
+}
+
+/* ------------------------------------------------------------ */
+
+type timedStorageR struct{
+	articlestore.StorageR
+	r *recorder
+}
+func (t timedStorageR) StoreReadMessage(id []byte, over,head,body bool) (bufferex.Binary,error) {
+	begin := time.Now()
+	b,err := t.StorageR.StoreReadMessage(id,over,head,body)
+	t.r.record("articles","StoreReadMessage",begin,err!=nil)
+	return b,err
+}
+
+type timedStorageW struct{
+	articlestore.StorageW
+	r *recorder
+}
+func (t timedStorageW) StoreWriteMessage(id, msg []byte, expire uint64) error {
+	begin := time.Now()
+	err := t.StorageW.StoreWriteMessage(id,msg,expire)
+	t.r.record("articles","StoreWriteMessage",begin,err!=nil)
+	return err
+}
+
+type timedIndex struct{
+	groupidx.GroupIndex
+	r *recorder
+}
+func (t timedIndex) GroupHeadInsert(groups [][]byte, buf []int64) ([]int64, error) {
+	begin := time.Now()
+	nums,err := t.GroupIndex.GroupHeadInsert(groups,buf)
+	t.r.record("groups","GroupHeadInsert",begin,err!=nil)
+	return nums,err
+}
+func (t timedIndex) ArticleGroupStat(group []byte, num int64, id_buf []byte) ([]byte, bool) {
+	begin := time.Now()
+	id,ok := t.GroupIndex.ArticleGroupStat(group,num,id_buf)
+	t.r.record("groups","ArticleGroupStat",begin,!ok)
+	return id,ok
+}
+func (t timedIndex) GroupRealtimeQuery(group []byte) (number int64, low int64, high int64, ok bool) {
+	begin := time.Now()
+	number,low,high,ok = t.GroupIndex.GroupRealtimeQuery(group)
+	t.r.record("groups","GroupRealtimeQuery",begin,!ok)
+	return
+}
+func (t timedIndex) AssignArticleToGroups(groups [][]byte, nums []int64, exp uint64, id []byte) error {
+	begin := time.Now()
+	err := t.GroupIndex.AssignArticleToGroups(groups,nums,exp,id)
+	t.r.record("groups","AssignArticleToGroups",begin,err!=nil)
+	return err
+}
+func (t timedIndex) ListArticleGroupRaw(group []byte, first, last int64, targ func(int64, []byte)) {
+	/* The time spent in targ is not accounted to this layer. */
+	var inner time.Duration
+	begin := time.Now()
+	t.GroupIndex.ListArticleGroupRaw(group,first,last,func(num int64, id []byte) {
+		b := time.Now()
+		targ(num,id)
+		inner += time.Since(b)
+	})
+	t.r.get("groups","ListArticleGroupRaw").add(time.Since(begin)-inner,false)
+}
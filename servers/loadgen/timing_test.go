@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package loadgen
+
+import "bytes"
+import "strings"
+import "testing"
+import "time"
+
+func millis(ms ...int) (lat []time.Duration) {
+	for _,m := range ms { lat = append(lat,time.Duration(m)*time.Millisecond) }
+	return
+}
+
+func TestPercentile(t *testing.T) {
+	one := millis(7)
+	ten := millis(1,2,3,4,5,6,7,8,9,10)
+	var hundred []time.Duration
+	for i := 1; i<=100; i++ { hundred = append(hundred,millis(i)...) }
+	cases := []struct{
+		lat    []time.Duration
+		p      int
+		expect time.Duration
+	}{
+		{nil,50,0},
+		{one,0,7*time.Millisecond},
+		{one,50,7*time.Millisecond},
+		{one,100,7*time.Millisecond},
+		{ten,0,1*time.Millisecond},
+		{ten,10,1*time.Millisecond},
+		{ten,11,2*time.Millisecond},
+		{ten,50,5*time.Millisecond},
+		{ten,90,9*time.Millisecond},
+		{ten,99,10*time.Millisecond},
+		{ten,100,10*time.Millisecond},
+		{hundred,50,50*time.Millisecond},
+		{hundred,90,90*time.Millisecond},
+		{hundred,99,99*time.Millisecond},
+		{hundred,100,100*time.Millisecond},
+		{millis(1,2,3),50,2*time.Millisecond},
+		{millis(1,2,3,4),50,2*time.Millisecond},
+		{millis(1,2,3,4),51,3*time.Millisecond},
+	}
+	for _,c := range cases {
+		if d := percentile(c.lat,c.p); d!=c.expect {
+			t.Errorf("percentile(%d values,%d) = %v, expected %v",len(c.lat),c.p,d,c.expect)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := newRecorder()
+	s := r.get("nntp","ARTICLE")
+	for _,d := range []int{10,2,8,4,6,9,1,7,3,5} { s.add(time.Duration(d)*time.Millisecond,d==3) }
+	r.get("articles","StoreReadMessage")
+	
+	var buf bytes.Buffer
+	r.report(&buf,2*time.Second)
+	lines := strings.Split(strings.TrimSpace(buf.String()),"\n")
+	if len(lines)!=3 { t.Fatalf("expected a header and 2 lines, got:\n%s",buf.String()) }
+	
+	/* Sorted by layer and op. */
+	if f := strings.Fields(lines[1]); f[0]!="articles" || f[2]!="0" { t.Errorf("unexpected line %q",lines[1]) }
+	f := strings.Fields(lines[2])
+	expect := []string{"nntp","ARTICLE","10","1","5.0","5ms","9ms","10ms","10ms"}
+	if strings.Join(f," ")!=strings.Join(expect," ") { t.Errorf("got %q, expected %q",f,expect) }
+}
@@ -0,0 +1,158 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+Gossip encryption and admission control for memberlist based clusters.
+
+Keys are AES keys (16, 24 or 32 bytes), written in base64. The first key is the
+primary key, that is used for encryption, all keys are accepted for decryption.
+To rotate a key without downtime:
+
+	1. Add the new key as a secondary key to all nodes.
+	2. Make it the primary key on all nodes.
+	3. Remove the old key from all nodes.
+
+The allow-list contains node names and CIDRs (like "10.1.0.0/16"). A node is
+admitted, if its name or its address matches any entry. An empty allow-list
+admits every node.
+*/
+package mlsec
+
+import "github.com/hashicorp/memberlist"
+import "encoding/base64"
+import "strings"
+import "errors"
+import "sync"
+import "net"
+import "fmt"
+
+var ENotAdmitted = errors.New("ENotAdmitted")
+
+func DecodeKeys(keys []string) ([][]byte,error) {
+	bkeys := make([][]byte,0,len(keys))
+	for _,k := range keys {
+		b,err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+		if err!=nil { return nil,err }
+		switch len(b) {
+		case 16,24,32:
+		default: return nil,fmt.Errorf("mlsec: key must be 16, 24 or 32 bytes, not %d",len(b))
+		}
+		bkeys = append(bkeys,b)
+	}
+	return bkeys,nil
+}
+
+type Security struct{
+	Keyring *memberlist.Keyring
+	
+	// The name of the local node, which is always admitted.
+	Self string
+	
+	mu    sync.RWMutex
+	names map[string]bool
+	nets  []*net.IPNet
+}
+
+/*
+Creates the keyring (if there are keys) and the allow-list, and installs the
+keyring into cfg. The admission control must be hooked into the Alive and
+Merge delegates by the caller, using Admit. cfg.Name must be set before.
+*/
+func Configure(cfg *memberlist.Config, keys, allow []string) (*Security,error) {
+	s := &Security{Self:cfg.Name}
+	err := s.SetAllow(allow)
+	if err!=nil { return nil,err }
+	if len(keys)==0 { return s,nil }
+	bkeys,err := DecodeKeys(keys)
+	if err!=nil { return nil,err }
+	s.Keyring,err = memberlist.NewKeyring(bkeys,bkeys[0])
+	if err!=nil { return nil,err }
+	cfg.Keyring = s.Keyring
+	return s,nil
+}
+
+/* Replaces the allow-list. */
+func (s *Security) SetAllow(allow []string) error {
+	names := make(map[string]bool)
+	var nets []*net.IPNet
+	for _,a := range allow {
+		if strings.Contains(a,"/") {
+			_,n,err := net.ParseCIDR(a)
+			if err!=nil { return err }
+			nets = append(nets,n)
+		} else if ip := net.ParseIP(a); ip!=nil {
+			bits := 8*len(ip)
+			if ip4 := ip.To4(); ip4!=nil { ip,bits = ip4,32 }
+			nets = append(nets,&net.IPNet{IP:ip,Mask:net.CIDRMask(bits,bits)})
+		} else {
+			names[a] = true
+		}
+	}
+	s.mu.Lock(); defer s.mu.Unlock()
+	s.names = names
+	s.nets = nets
+	return nil
+}
+
+func (s *Security) Allowed(name string, ip net.IP) bool {
+	s.mu.RLock(); defer s.mu.RUnlock()
+	if len(s.names)==0 && len(s.nets)==0 { return true }
+	if s.names[name] { return true }
+	for _,n := range s.nets {
+		if n.Contains(ip) { return true }
+	}
+	return false
+}
+
+/* Returns ENotAdmitted, if the node is not allowed to join. */
+func (s *Security) Admit(n *memberlist.Node) error {
+	if s==nil || n.Name==s.Self || s.Allowed(n.Name,n.Addr) { return nil }
+	return ENotAdmitted
+}
+
+/*
+Installs the given keys, the first one being the primary key. Keys, that are
+not listed, are removed from the keyring. See the package documentation on
+how to rotate keys.
+*/
+func (s *Security) Rotate(keys []string) error {
+	if s.Keyring==nil { return errors.New("mlsec: encryption is not enabled") }
+	bkeys,err := DecodeKeys(keys)
+	if err!=nil { return err }
+	if len(bkeys)==0 { return errors.New("mlsec: no keys") }
+	for _,k := range bkeys {
+		err = s.Keyring.AddKey(k)
+		if err!=nil { return err }
+	}
+	err = s.Keyring.UseKey(bkeys[0])
+	if err!=nil { return err }
+	outer:
+	for _,o := range s.Keyring.GetKeys() {
+		for _,k := range bkeys {
+			if string(o)==string(k) { continue outer }
+		}
+		err = s.Keyring.RemoveKey(o)
+		if err!=nil { return err }
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+
+package mlsec
+
+import "github.com/hashicorp/memberlist"
+import "encoding/base64"
+import "bytes"
+import "net"
+import "testing"
+
+func key(n int, b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b},n))
+}
+
+func TestDecodeKeys(t *testing.T) {
+	keys,err := DecodeKeys([]string{key(16,1)," "+key(24,2)+"\n",key(32,3)})
+	if err!=nil { t.Fatal(err) }
+	if len(keys)!=3 || len(keys[0])!=16 || len(keys[1])!=24 || len(keys[2])!=32 || keys[1][0]!=2 {
+		t.Errorf("unexpected keys %v",keys)
+	}
+	for _,bad := range []string{key(15,1),key(33,1),"",".not base64."} {
+		if _,err := DecodeKeys([]string{key(16,1),bad}); err==nil { t.Errorf("DecodeKeys accepted %q",bad) }
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	s := new(Security)
+	if !s.Allowed("any",net.ParseIP("192.0.2.1")) { t.Error("an empty allow-list must admit every node") }
+	err := s.SetAllow([]string{"node-a","10.1.0.0/16","192.0.2.7","2001:db8::1","2001:db8:1::/48"})
+	if err!=nil { t.Fatal(err) }
+	cases := []struct{
+		name  string
+		ip    string
+		allow bool
+	}{
+		{"node-a","198.51.100.1",true},
+		{"node-b","198.51.100.1",false},
+		{"node-b","10.1.200.3",true},
+		{"node-b","10.2.0.1",false},
+		{"node-b","192.0.2.7",true},
+		{"node-b","192.0.2.8",false},
+		{"node-b","::ffff:192.0.2.7",true},
+		{"node-b","2001:db8::1",true},
+		{"node-b","2001:db8::2",false},
+		{"node-b","2001:db8:1:ff::9",true},
+	}
+	for _,c := range cases {
+		if a := s.Allowed(c.name,net.ParseIP(c.ip)); a!=c.allow {
+			t.Errorf("Allowed(%q,%s) = %v, expected %v",c.name,c.ip,a,c.allow)
+		}
+	}
+	if err := s.SetAllow([]string{"10.1.0.0/33"}); err==nil { t.Error("SetAllow accepted an invalid CIDR") }
+	if !s.Allowed("node-a",nil) { t.Error("a failed SetAllow replaced the allow-list") }
+	
+	if err := s.SetAllow(nil); err!=nil { t.Fatal(err) }
+	if !s.Allowed("node-b",net.ParseIP("10.2.0.1")) { t.Error("clearing the allow-list must admit every node") }
+}
+
+func TestAdmit(t *testing.T) {
+	var none *Security
+	if none.Admit(&memberlist.Node{Name:"x"})!=nil { t.Error("a nil Security must admit every node") }
+	s := &Security{Self:"self"}
+	if err := s.SetAllow([]string{"friend"}); err!=nil { t.Fatal(err) }
+	ip := net.ParseIP("192.0.2.1")
+	if err := s.Admit(&memberlist.Node{Name:"self",Addr:ip}); err!=nil { t.Errorf("self: %v",err) }
+	if err := s.Admit(&memberlist.Node{Name:"friend",Addr:ip}); err!=nil { t.Errorf("friend: %v",err) }
+	if err := s.Admit(&memberlist.Node{Name:"stranger",Addr:ip}); err!=ENotAdmitted { t.Errorf("stranger: got %v, expected %v",err,ENotAdmitted) }
+}
+
+func TestConfigure(t *testing.T) {
+	cfg := memberlist.DefaultLANConfig()
+	cfg.Name = "self"
+	s,err := Configure(cfg,nil,[]string{"other"})
+	if err!=nil { t.Fatal(err) }
+	if s.Keyring!=nil || cfg.Keyring!=nil { t.Error("a keyring was installed without keys") }
+	if s.Self!="self" || s.Admit(&memberlist.Node{Name:"self"})!=nil { t.Error("the local node is not admitted") }
+	if err := s.Rotate([]string{key(16,1)}); err==nil { t.Error("Rotate without a keyring succeeded") }
+	
+	cfg = memberlist.DefaultLANConfig()
+	s,err = Configure(cfg,[]string{key(16,1),key(32,2)},nil)
+	if err!=nil { t.Fatal(err) }
+	if cfg.Keyring==nil || cfg.Keyring!=s.Keyring { t.Fatal("the keyring was not installed") }
+	if p := s.Keyring.GetPrimaryKey(); len(p)!=16 || p[0]!=1 { t.Errorf("primary key %v",p) }
+	if n := len(s.Keyring.GetKeys()); n!=2 { t.Errorf("%d keys, expected 2",n) }
+	
+	for _,bad := range [][]string{{"x"},{key(8,1)}} {
+		if _,err := Configure(memberlist.DefaultLANConfig(),bad,nil); err==nil { t.Errorf("Configure accepted %q",bad) }
+	}
+	if _,err := Configure(memberlist.DefaultLANConfig(),nil,[]string{"1.2.3.4/99"}); err==nil { t.Error("Configure accepted an invalid CIDR") }
+}
+
+func keyset(kr *memberlist.Keyring) map[byte]bool {
+	m := make(map[byte]bool)
+	for _,k := range kr.GetKeys() { m[k[0]] = true }
+	return m
+}
+
+/* Follows the rotation steps of the package documentation. */
+func TestRotate(t *testing.T) {
+	s,err := Configure(memberlist.DefaultLANConfig(),[]string{key(16,1)},nil)
+	if err!=nil { t.Fatal(err) }
+	
+	/* 1. Add the new key as a secondary key. */
+	if err := s.Rotate([]string{key(16,1),key(16,2)}); err!=nil { t.Fatal(err) }
+	if s.Keyring.GetPrimaryKey()[0]!=1 { t.Error("step 1 changed the primary key") }
+	if ks := keyset(s.Keyring); len(ks)!=2 || !ks[2] { t.Errorf("step 1: keys %v",ks) }
+	
+	/* 2. Make it the primary key. */
+	if err := s.Rotate([]string{key(16,2),key(16,1)}); err!=nil { t.Fatal(err) }
+	if s.Keyring.GetPrimaryKey()[0]!=2 { t.Error("step 2 did not change the primary key") }
+	if ks := keyset(s.Keyring); len(ks)!=2 { t.Errorf("step 2: keys %v",ks) }
+	
+	/* 3. Remove the old key. */
+	if err := s.Rotate([]string{key(16,2)}); err!=nil { t.Fatal(err) }
+	if ks := keyset(s.Keyring); len(ks)!=1 || !ks[2] { t.Errorf("step 3: keys %v",ks) }
+	
+	if err := s.Rotate(nil); err==nil { t.Error("Rotate accepted no keys") }
+	if err := s.Rotate([]string{"x"}); err==nil { t.Error("Rotate accepted an invalid key") }
+	if ks := keyset(s.Keyring); len(ks)!=1 || !ks[2] { t.Errorf("a failed Rotate changed the keys: %v",ks) }
+}
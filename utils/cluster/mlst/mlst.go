@@ -25,6 +25,7 @@ package mlst
 
 import "github.com/hashicorp/memberlist" 
 import "github.com/maxymania/fastnntp-polyglot-labs2/utils/cluster"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/cluster/mlsec"
 import "net"
 import "errors"
 
@@ -48,6 +49,7 @@ func Convert2(m *memberlist.Node) cluster.Node {
 type Delegate struct{
 	cluster.StateHandler
 	H cluster.Handler
+	Sec *mlsec.Security
 }
 func (d *Delegate) NodeMeta(limit int) []byte {
 	return d.H.Metadata(limit)
@@ -64,12 +66,16 @@ func (d *Delegate) NotifyUpdate(n *memberlist.Node) { d.H.Delete(Convert(n)) }
 var _ memberlist.EventDelegate = (*Delegate)(nil)
 
 func (d *Delegate) NotifyAlive(peer *memberlist.Node) error {
+	if err := d.Sec.Admit(peer); err!=nil { return err }
 	if !d.H.ValidateAll([]cluster.Node{Convert2(peer)}) { return EBadMerge }
 	return nil
 }
 func (d *Delegate) NotifyMerge(peers []*memberlist.Node) error {
 	c := make([]cluster.Node,len(peers))
-	for i,peer := range peers { c[i] = Convert2(peer) }
+	for i,peer := range peers {
+		if err := d.Sec.Admit(peer); err!=nil { return err }
+		c[i] = Convert2(peer)
+	}
 	if !d.H.ValidateAll(c) { return EBadMerge }
 	return nil
 }
@@ -80,9 +86,14 @@ func (shdef) MergeRemoteState(buf []byte, join bool) {}
 
 func Configure(cfg *memberlist.Config,h cluster.Handler,sh cluster.StateHandler) {
 	if sh==nil { sh = shdef{} }
-	d := &Delegate{sh,h}
+	d := &Delegate{StateHandler:sh,H:h}
 	cfg.Delegate = d
 	cfg.Events = d
 	cfg.Alive = d
 	cfg.Merge = d
 }
+
+/* Enables admission control on a memberlist configured by Configure. */
+func Secure(cfg *memberlist.Config,sec *mlsec.Security) {
+	if d,ok := cfg.Alive.(*Delegate); ok { d.Sec = sec }
+}
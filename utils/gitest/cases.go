@@ -0,0 +1,264 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package gitest
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "bytes"
+import "sort"
+import "fmt"
+import "time"
+
+func (c *ctx) assign(group []byte, num int64, exp uint64, id string) {
+	err := c.gi.AssignArticleToGroup(group,uint64(num),exp,[]byte(id))
+	if err!=nil { c.Fatalf("AssignArticleToGroup(%q,%d): %v",group,num,err) }
+}
+func (c *ctx) expectMove(group []byte, i int64, backward bool, eni int64, eid string) {
+	ni,id,ok := c.gi.ArticleGroupMove(group,i,backward,nil)
+	if eid=="" {
+		if ok { c.Errorf("ArticleGroupMove(%d,%v) -> %d %q, expected nothing",i,backward,ni,id) }
+		return
+	}
+	if !ok {
+		c.Errorf("ArticleGroupMove(%d,%v) -> nothing, expected %d %q",i,backward,eni,eid)
+		return
+	}
+	if ni!=eni || string(id)!=eid {
+		c.Errorf("ArticleGroupMove(%d,%v) -> %d %q, expected %d %q",i,backward,ni,id,eni,eid)
+	}
+}
+func (c *ctx) list(group []byte, first, last int64) (nums []int64, ids []string) {
+	c.gi.ListArticleGroupRaw(group,first,last,func(n int64, id []byte){
+		nums = append(nums,n)
+		ids = append(ids,string(id))
+	})
+	return
+}
+func (c *ctx) expectList(group []byte, first, last int64, enums ...int64) {
+	nums,_ := c.list(group,first,last)
+	if len(nums)!=len(enums) {
+		c.Errorf("ListArticleGroupRaw(%d,%d) -> %v, expected %v",first,last,nums,enums)
+		return
+	}
+	for i := range nums {
+		if nums[i]!=enums[i] {
+			c.Errorf("ListArticleGroupRaw(%d,%d) -> %v, expected %v",first,last,nums,enums)
+			return
+		}
+	}
+}
+
+/* ------------------------------------------------------------ */
+
+func testNumbering(c *ctx) {
+	if c.s.NoGroupHead { c.Skip("GroupHeadInsert not supported") }
+	ga,gb := c.group("a"),c.group("b")
+	seen := make(map[[2]int64]bool)
+	for i := 0; i<8; i++ {
+		nums,err := c.gi.GroupHeadInsert([][]byte{ga,gb},make([]int64,0,8))
+		if err!=nil { c.Fatalf("GroupHeadInsert: %v",err) }
+		if len(nums)!=2 { c.Fatalf("GroupHeadInsert returned %d numbers for 2 groups",len(nums)) }
+		for j,n := range nums {
+			if n<=0 { c.Errorf("GroupHeadInsert returned non-positive number %d",n) }
+			k := [2]int64{int64(j),n}
+			if seen[k] { c.Errorf("GroupHeadInsert returned number %d twice",n) }
+			seen[k] = true
+		}
+	}
+}
+
+func testRevert(c *ctx) {
+	if c.s.NoGroupHead { c.Skip("GroupHeadInsert not supported") }
+	g := c.group("a")
+	live := make(map[int64]bool)
+	var nums []int64
+	for i := 0; i<4; i++ {
+		r,err := c.gi.GroupHeadInsert([][]byte{g},nil)
+		if err!=nil { c.Fatalf("GroupHeadInsert: %v",err) }
+		live[r[0]] = true
+		nums = append(nums,r[0])
+	}
+	err := c.gi.GroupHeadRevert([][]byte{g},nums[1:2])
+	if err!=nil { c.Fatalf("GroupHeadRevert: %v",err) }
+	delete(live,nums[1])
+	for i := 0; i<4; i++ {
+		r,err := c.gi.GroupHeadInsert([][]byte{g},nil)
+		if err!=nil { c.Fatalf("GroupHeadInsert: %v",err) }
+		if r[0]<=0 || live[r[0]] { c.Errorf("GroupHeadInsert returned %d, which is still in use",r[0]) }
+		live[r[0]] = true
+	}
+}
+
+func testStat(c *ctx) {
+	g := c.group("a")
+	c.assign(g,1,future(),"<1@gitest>")
+	c.assign(g,2,future(),"<2@gitest>")
+	c.settle()
+
+	buf := []byte("garbage, that must be overwritten")
+	id,ok := c.gi.ArticleGroupStat(g,2,buf)
+	if !ok || string(id)!="<2@gitest>" { c.Errorf("ArticleGroupStat(2) -> %q %v",id,ok) }
+	id,ok = c.gi.ArticleGroupStat(g,1,nil)
+	if !ok || string(id)!="<1@gitest>" { c.Errorf("ArticleGroupStat(1) -> %q %v",id,ok) }
+	id,ok = c.gi.ArticleGroupStat(g,3,nil)
+	if ok { c.Errorf("ArticleGroupStat(3) -> %q, expected nothing",id) }
+	id,ok = c.gi.ArticleGroupStat(c.group("unknown"),1,nil)
+	if ok { c.Errorf("ArticleGroupStat on unknown group -> %q, expected nothing",id) }
+}
+
+func testMoveForward(c *ctx) {
+	g := c.group("a")
+	for _,n := range []int64{1,2,3,5} { c.assign(g,n,future(),string(rune('a'+n))) }
+	c.settle()
+	c.expectMove(g,0,false,1,"b")
+	c.expectMove(g,1,false,2,"c")
+	c.expectMove(g,3,false,5,"f")
+	c.expectMove(g,4,false,5,"f")
+	c.expectMove(g,5,false,0,"")
+	c.expectMove(g,100,false,0,"")
+	c.expectMove(c.group("unknown"),0,false,0,"")
+}
+
+func testMoveBackward(c *ctx) {
+	g := c.group("a")
+	for _,n := range []int64{1,2,3,5} { c.assign(g,n,future(),string(rune('a'+n))) }
+	c.settle()
+	c.expectMove(g,100,true,5,"f")
+	c.expectMove(g,5,true,3,"d")
+	c.expectMove(g,4,true,3,"d")
+	c.expectMove(g,2,true,1,"b")
+	c.expectMove(g,1,true,0,"")
+	c.expectMove(c.group("unknown"),10,true,0,"")
+}
+
+func testExpiry(c *ctx) {
+	g := c.group("a")
+	c.assign(g,1,future(),"live1")
+	c.assign(g,2,past(),"dead2")
+	c.assign(g,3,future(),"live3")
+	c.assign(g,4,past(),"dead4")
+	c.settle()
+
+	if id,ok := c.gi.ArticleGroupStat(g,2,nil); ok { c.Errorf("ArticleGroupStat(2) -> %q, expected nothing",id) }
+	if _,ok := c.gi.ArticleGroupStat(g,3,nil); !ok { c.Errorf("ArticleGroupStat(3) -> nothing") }
+	c.expectMove(g,1,false,3,"live3")
+	c.expectMove(g,3,true,1,"live1")
+	c.expectMove(g,3,false,0,"")
+	c.expectMove(g,5,true,3,"live3")
+	c.expectList(g,1,4,1,3)
+	_,ids := c.list(g,0,10)
+	for _,id := range ids {
+		if id[0]=='d' { c.Errorf("ListArticleGroupRaw yielded expired %q",id) }
+	}
+}
+
+func testRanges(c *ctx) {
+	g := c.group("a")
+	for n := int64(1); n<=10; n++ { c.assign(g,n,future(),"x") }
+	c.settle()
+	c.expectList(g,3,7,3,4,5,6,7)
+	c.expectList(g,8,100,8,9,10)
+	c.expectList(g,0,2,1,2)
+	c.expectList(g,5,5,5)
+	c.expectList(g,20,30)
+	c.expectList(g,7,3)
+	c.expectList(c.group("unknown"),1,10)
+}
+
+func testBulkAssign(c *ctx) {
+	groups := [][]byte{c.group("a"),c.group("b"),c.group("c")}
+	nums := []int64{4,7,9}
+	err := c.gi.AssignArticleToGroups(groups,nums,future(),[]byte("<bulk@gitest>"))
+	if err!=nil { c.Fatalf("AssignArticleToGroups: %v",err) }
+	c.settle()
+	for i,g := range groups {
+		id,ok := c.gi.ArticleGroupStat(g,nums[i],nil)
+		if !ok || !bytes.Equal(id,[]byte("<bulk@gitest>")) { c.Errorf("ArticleGroupStat(%q,%d) -> %q %v",g,nums[i],id,ok) }
+		for j,n := range nums {
+			if i==j { continue }
+			if id,ok := c.gi.ArticleGroupStat(g,n,nil); ok { c.Errorf("ArticleGroupStat(%q,%d) -> %q, expected nothing",g,n,id) }
+		}
+	}
+}
+
+func testRealtimeStats(c *ctx) {
+	if _,_,_,ok := c.gi.GroupRealtimeQuery(c.group("unknown")); ok {
+		c.Errorf("GroupRealtimeQuery on unknown group -> ok")
+	}
+	g := c.group("a")
+	c.assign(g,4,future(),"x")
+	c.assign(g,8,future(),"x")
+	c.assign(g,3,future(),"x")
+	c.settle()
+	number,low,high,ok := c.gi.GroupRealtimeQuery(g)
+	if !ok { c.Fatalf("GroupRealtimeQuery -> not ok") }
+	if low!=3 || high!=8 { c.Errorf("GroupRealtimeQuery -> low=%d high=%d, expected low=3 high=8",low,high) }
+	if number<3 || number>(1+high-low) { c.Errorf("GroupRealtimeQuery -> number=%d, expected 3<=number<=%d",number,1+high-low) }
+}
+
+/*
+Returns the GroupIndexSince of gi. Over a loopback, the backend must implement it
+as well, because the client can't tell.
+*/
+func sinceOf(gi groupidx.GroupIndex) (groupidx.GroupIndexSince,bool) {
+	if w,ok := gi.(*wired); ok {
+		if _,ok := sinceOf(w.backend); !ok { return nil,false }
+		gi = w.GroupIndex
+	}
+	x,ok := gi.(groupidx.GroupIndexSince)
+	return x,ok
+}
+func (c *ctx) since(x groupidx.GroupIndexSince, wildmat []byte, since int64) (got []string) {
+	x.ListArticlesSince(wildmat,since,func(group []byte, num int64, id []byte) {
+		got = append(got,fmt.Sprintf("%s %d %s",group,num,id))
+	})
+	sort.Strings(got)
+	return
+}
+func (c *ctx) expectSince(x groupidx.GroupIndexSince, wildmat []byte, since int64, expect ...string) {
+	got := c.since(x,wildmat,since)
+	sort.Strings(expect)
+	if fmt.Sprint(got)!=fmt.Sprint(expect) {
+		c.Errorf("ListArticlesSince(%q,%d) -> %q, expected %q",wildmat,since,got,expect)
+	}
+}
+
+func testSince(c *ctx) {
+	x,ok := sinceOf(c.gi)
+	if !ok { c.Skip("no groupidx.GroupIndexSince") }
+	a,b := c.group("a"),c.group("b")
+	before := time.Now().Unix()-60
+	c.assign(a,1,future(),"<1@gitest>")
+	c.assign(a,2,future(),"<2@gitest>")
+	c.assign(a,3,past(),"<3@gitest>")
+	c.assign(b,5,future(),"<5@gitest>")
+	c.settle()
+	
+	all := append(c.group(""),'*')
+	line := func(g []byte, n int64, id string) string { return fmt.Sprintf("%s %d %s",g,n,id) }
+	c.expectSince(x,all,before,line(a,1,"<1@gitest>"),line(a,2,"<2@gitest>"),line(b,5,"<5@gitest>"))
+	c.expectSince(x,append(append(all,",!"...),b...),before,line(a,1,"<1@gitest>"),line(a,2,"<2@gitest>"))
+	c.expectSince(x,b,before,line(b,5,"<5@gitest>"))
+	c.expectSince(x,c.group("none"),before)
+	c.expectSince(x,all,time.Now().Unix()+3600)
+}
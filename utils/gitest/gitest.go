@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+Gitest is a conformance harness for groupidx.GroupIndex implementations.
+
+It is meant to be called from the _test.go file of a backend:
+
+	func TestConformance(t *testing.T) {
+		s := &gitest.Suite{New:func(t *testing.T) (groupidx.GroupIndex,func()) {
+			return new(memstore.GroupIndex),nil
+		}}
+		s.Run(t)
+		s.OverWire2().Run(t)
+		s.OverWire1().Run(t)
+	}
+
+The contract, that is checked:
+
+	Numbers, returned by GroupHeadInsert are positive and unique per group,
+	until they are reverted using GroupHeadRevert.
+
+	An entry is visible to ArticleGroupStat, ArticleGroupMove and ListArticleGroupRaw,
+	as long as its expiration is not in the past.
+
+	ArticleGroupMove skips expired entries.
+
+	ListArticleGroupRaw yields the visible entries within [first,last] in ascending order.
+
+	GroupRealtimeQuery reports ok=false for unknown groups. Otherwise, low and high
+	are the lowest and highest assigned numbers, and number is between the count of
+	assigned numbers and 1+high-low.
+
+	If the backend implements groupidx.GroupIndexSince, ListArticlesSince yields the
+	visible entries, that were assigned at or after since, in the groups, that match
+	the wildmat.
+*/
+package gitest
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "testing"
+import "fmt"
+import "time"
+
+var nonce = time.Now().UnixNano()
+
+type Suite struct{
+	// Creates the GroupIndex under test. The returned function (may be nil) releases it.
+	New func(t *testing.T) (groupidx.GroupIndex,func())
+
+	// The backend does not implement GroupHeadInsert and GroupHeadRevert.
+	NoGroupHead bool
+
+	// The backend implements nothing but GroupHeadInsert and GroupHeadRevert,
+	// like mockup.Wrapper. Only those are tested.
+	OnlyGroupHead bool
+
+	// Optional. Called after writes, before they are read back.
+	Settle func(gi groupidx.GroupIndex)
+}
+
+type ctx struct{
+	*testing.T
+	s  *Suite
+	gi groupidx.GroupIndex
+}
+
+/*
+Returns a group name, that is unique to the test and the process,
+so persistent backends can be tested repeatedly.
+*/
+func (c *ctx) group(n string) []byte {
+	return []byte(fmt.Sprintf("gitest.%x.%s.%s",nonce,c.Name(),n))
+}
+func (c *ctx) settle() {
+	if c.s.Settle!=nil { c.s.Settle(c.gi) }
+}
+
+func future() uint64 { return uint64(time.Now().Unix())+3600 }
+func past() uint64 { return uint64(time.Now().Unix())-3600 }
+
+func (s *Suite) run(t *testing.T, name string, f func(c *ctx)) {
+	t.Run(name,func(t *testing.T) {
+		gi,done := s.New(t)
+		if done!=nil { defer done() }
+		f(&ctx{t,s,gi})
+	})
+}
+
+func (s *Suite) Run(t *testing.T) {
+	s.run(t,"Numbering",testNumbering)
+	s.run(t,"Revert",testRevert)
+	if s.OnlyGroupHead { return }
+	s.run(t,"Stat",testStat)
+	s.run(t,"MoveForward",testMoveForward)
+	s.run(t,"MoveBackward",testMoveBackward)
+	s.run(t,"Expiry",testExpiry)
+	s.run(t,"Ranges",testRanges)
+	s.run(t,"BulkAssign",testBulkAssign)
+	s.run(t,"RealtimeStats",testRealtimeStats)
+	s.run(t,"Since",testSince)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package gitest
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/wire1"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx/wire2"
+import "golang.org/x/crypto/ed25519"
+import "golang.org/x/crypto/ssh"
+import "crypto/rand"
+import "testing"
+import "net"
+
+/*
+Serves gi using wire2 on a loopback port and returns a client connected to it.
+The returned function stops the server.
+*/
+func Wire2Loopback(gi groupidx.GroupIndex) (groupidx.GroupIndex,func(),error) {
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { return nil,nil,err }
+	go wire2.NewServer(gi).Serve(ln)
+	return wire2.NewClient("tcp",ln.Addr().String()),func() { ln.Close() },nil
+}
+
+/*
+Serves gi using wire1 on a loopback port and returns a client connected to it.
+Any user and password is accepted. The returned function closes the client and
+stops the server.
+*/
+func Wire1Loopback(gi groupidx.GroupIndex) (groupidx.GroupIndex,func(),error) {
+	_,priv,err := ed25519.GenerateKey(rand.Reader)
+	if err!=nil { return nil,nil,err }
+	signer,err := ssh.NewSignerFromKey(priv)
+	if err!=nil { return nil,nil,err }
+	h := new(wire1.RawHandler)
+	h.Inner = gi
+	h.Config.PasswordCallback = func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil,nil }
+	h.Config.AddHostKey(signer)
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { return nil,nil,err }
+	go func() {
+		for {
+			conn,err := ln.Accept()
+			if err!=nil { return }
+			go h.Handle(conn)
+		}
+	}()
+	conn,err := net.Dial("tcp",ln.Addr().String())
+	if err!=nil { ln.Close(); return nil,nil,err }
+	cli,err := wire1.NewClient(conn,ln.Addr().String(),"gitest","gitest")
+	if err!=nil { conn.Close(); ln.Close(); return nil,nil,err }
+	return cli,func() {
+		cli.Inner.Close()
+		ln.Close()
+	},nil
+}
+
+type wired struct{
+	groupidx.GroupIndex
+	backend groupidx.GroupIndex
+}
+
+func (s *Suite) over(name string, lb func(groupidx.GroupIndex) (groupidx.GroupIndex,func(),error)) *Suite {
+	ns := new(Suite)
+	*ns = *s
+	ns.New = func(t *testing.T) (groupidx.GroupIndex,func()) {
+		gi,done := s.New(t)
+		cli,stop,err := lb(gi)
+		if err!=nil {
+			if done!=nil { done() }
+			t.Fatalf("%s: %v",name,err)
+		}
+		return &wired{cli,gi},func() {
+			stop()
+			if done!=nil { done() }
+		}
+	}
+	if s.Settle!=nil {
+		/* The Settle function must be applied to the backend, not to the client. */
+		ns.Settle = func(gi groupidx.GroupIndex) { s.Settle(gi.(*wired).backend) }
+	}
+	return ns
+}
+
+/*
+Returns a copy of the Suite, that tests the backend through a wire2 loopback.
+*/
+func (s *Suite) OverWire2() *Suite { return s.over("Wire2Loopback",Wire2Loopback) }
+
+/*
+Returns a copy of the Suite, that tests the backend through a wire1 loopback.
+*/
+func (s *Suite) OverWire1() *Suite { return s.over("Wire1Loopback",Wire1Loopback) }
@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package memstore
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "sync"
+
+type record struct{
+	value  []byte
+	expire uint64
+}
+func (r record) alive(t uint64) bool {
+	return r.expire==0 || r.expire>t
+}
+
+/* In-Memory articlestore.Storage. */
+type Storage struct{
+	mu sync.RWMutex
+	m  map[string]record
+}
+var _ articlestore.Storage = (*Storage)(nil)
+
+func (s *Storage) StoreWriteMessage(id, msg []byte, expire uint64) error {
+	s.mu.Lock(); defer s.mu.Unlock()
+	if s.m==nil { s.m = make(map[string]record) }
+	s.m[string(id)] = record{append([]byte(nil),msg...),expire}
+	return nil
+}
+func (s *Storage) StoreReadMessage(id []byte, over,head,body bool) (bufferex.Binary,error) {
+	s.mu.RLock(); defer s.mu.RUnlock()
+	r,ok := s.m[string(id)]
+	if !ok || !r.alive(now()) { return bufferex.Binary{},articlestore.VEFail }
+	return bufferex.NewBinary(r.value),nil
+}
+
+/* Removes all expired articles. */
+func (s *Storage) Expire() {
+	t := now()
+	s.mu.Lock(); defer s.mu.Unlock()
+	for k,r := range s.m {
+		if !r.alive(t) { delete(s.m,k) }
+	}
+}
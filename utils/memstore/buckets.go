@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package memstore
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs/bufferex"
+import "sync"
+import "sort"
+
+/*
+In-Memory bucketstore.Bucket, that also implements bucketstore.BucketWEx
+and bucketstore.BucketScanner.
+
+Unlike dkv.Bucket, the bucket argument is not ignored: every bucket
+has its own keyspace.
+*/
+type Bucket struct{
+	mu sync.RWMutex
+	m  map[string]map[string]record
+}
+var _ bucketstore.Bucket = (*Bucket)(nil)
+var _ bucketstore.BucketWEx = (*Bucket)(nil)
+var _ bucketstore.BucketScanner = (*Bucket)(nil)
+
+func (b *Bucket) put(bucket,key,value []byte,expiresAt uint64) {
+	b.mu.Lock(); defer b.mu.Unlock()
+	if b.m==nil { b.m = make(map[string]map[string]record) }
+	bm := b.m[string(bucket)]
+	if bm==nil {
+		bm = make(map[string]record)
+		b.m[string(bucket)] = bm
+	}
+	bm[string(key)] = record{append([]byte(nil),value...),expiresAt}
+}
+
+func (b *Bucket) BucketGet(bucket,key []byte) (bufferex.Binary,error) {
+	b.mu.RLock(); defer b.mu.RUnlock()
+	r,ok := b.m[string(bucket)][string(key)]
+	if !ok || !r.alive(now()) { return bufferex.Binary{},bucketstore.ENotFound }
+	return bufferex.NewBinary(r.value),nil
+}
+func (b *Bucket) BucketPut(bucket,key,value []byte) error {
+	b.put(bucket,key,value,0)
+	return nil
+}
+func (b *Bucket) BucketPutExpire(bucket,key,value []byte,expiresAt uint64) error {
+	b.put(bucket,key,value,expiresAt)
+	return nil
+}
+func (b *Bucket) BucketDelete(bucket,key []byte) error {
+	b.mu.Lock(); defer b.mu.Unlock()
+	delete(b.m[string(bucket)],string(key))
+	return nil
+}
+
+func (b *Bucket) BucketScan(bucket []byte, r *bucketstore.ScanRange, targ func(key, value []byte)) ([]byte, error) {
+	t := now()
+	start := string(r.Start)
+	if start<string(r.Prefix) { start = string(r.Prefix) }
+	
+	b.mu.RLock()
+	bm := b.m[string(bucket)]
+	keys := make([]string,0,len(bm))
+	for k,rec := range bm {
+		if k<start || len(k)<len(r.Prefix) || k[:len(r.Prefix)]!=string(r.Prefix) { continue }
+		if len(r.End)>0 && k>=string(r.End) { continue }
+		if !rec.alive(t) { continue }
+		keys = append(keys,k)
+	}
+	b.mu.RUnlock()
+	sort.Strings(keys)
+	
+	for i,k := range keys {
+		if r.Limit>0 && i>=r.Limit { return []byte(k),nil }
+		var v []byte
+		if r.Values {
+			b.mu.RLock()
+			v = bm[k].value
+			b.mu.RUnlock()
+		}
+		targ([]byte(k),v)
+	}
+	return nil,nil
+}
+
+/* Removes all expired entries from all buckets. */
+func (b *Bucket) Expire() {
+	t := now()
+	b.mu.Lock(); defer b.mu.Unlock()
+	for _,bm := range b.m {
+		for k,r := range bm {
+			if !r.alive(t) { delete(bm,k) }
+		}
+	}
+}
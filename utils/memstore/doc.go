@@ -0,0 +1,44 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+Memstore provides in-memory implementations of the storage interfaces of this
+repository. They are thread-safe and intended for unit tests and for prototyping.
+
+The implementations follow the semantics of the real backends:
+
+	Storage and Bucket behave like the badger-based backends: an expiration of 0
+	means "never", otherwise a record is gone, once expiresAt<=now.
+
+	GroupIndex behaves like groupdb2: an entry is visible, as long as exp>=now.
+	GroupRealtimeQuery does not filter expired entries.
+
+	GroupOverview behaves like overdb.
+
+All zero values are ready to use.
+*/
+package memstore
+
+import "time"
+
+func now() uint64 { return uint64(time.Now().Unix()) }
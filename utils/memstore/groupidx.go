@@ -0,0 +1,197 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package memstore
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/grouphead"
+import "sort"
+import "sync"
+
+type grow struct{
+	id  []byte
+	exp uint64
+}
+
+type group struct{
+	seq   int64
+	free  []int64 /* sorted */
+	nums  []int64 /* sorted */
+	rows  map[int64]grow
+	count int64
+}
+func (g *group) reserve() (n int64) {
+	if len(g.free)!=0 {
+		n,g.free = g.free[0],g.free[1:]
+		return
+	}
+	g.seq++
+	return g.seq
+}
+func insertSorted(s []int64, n int64) ([]int64,bool) {
+	i := sort.Search(len(s),func(j int) bool { return s[j]>=n })
+	if i<len(s) && s[i]==n { return s,false }
+	s = append(s,0)
+	copy(s[i+1:],s[i:])
+	s[i] = n
+	return s,true
+}
+func (g *group) revert(n int64) {
+	g.free,_ = insertSorted(g.free,n)
+}
+/* A number, that has already been assigned, keeps its first article. */
+func (g *group) assign(n int64, exp uint64, id []byte) {
+	var isnew bool
+	g.nums,isnew = insertSorted(g.nums,n)
+	if !isnew { return }
+	g.rows[n] = grow{append([]byte(nil),id...),exp}
+	g.count++
+}
+
+/*
+In-Memory groupidx.GroupIndex. It also serves as grouphead.GroupHeadDB.
+
+Numbers are allocated like in groupdb2: reverted numbers are reused first
+(lowest first), then a per-group sequence starting with 1 is used.
+
+ArticleGroupMove skips expired entries.
+*/
+type GroupIndex struct{
+	mu sync.RWMutex
+	m  map[string]*group
+}
+var _ groupidx.GroupIndex = (*GroupIndex)(nil)
+var _ grouphead.GroupHeadDB = (*GroupIndex)(nil)
+
+func (gi *GroupIndex) create(name []byte) *group {
+	if gi.m==nil { gi.m = make(map[string]*group) }
+	g := gi.m[string(name)]
+	if g==nil {
+		g = &group{rows:make(map[int64]grow)}
+		gi.m[string(name)] = g
+	}
+	return g
+}
+
+func (gi *GroupIndex) GroupHeadInsert(groups [][]byte, buf []int64) ([]int64, error) {
+	gi.mu.Lock(); defer gi.mu.Unlock()
+	if cap(buf)<len(groups) { buf = make([]int64,len(groups)) } else { buf = buf[:len(groups)] }
+	for i,name := range groups {
+		buf[i] = gi.create(name).reserve()
+	}
+	return buf,nil
+}
+func (gi *GroupIndex) GroupHeadRevert(groups [][]byte, nums []int64) error {
+	gi.mu.Lock(); defer gi.mu.Unlock()
+	for i,name := range groups {
+		gi.create(name).revert(nums[i])
+	}
+	return nil
+}
+
+func (gi *GroupIndex) AssignArticleToGroup(group []byte, num, exp uint64, id []byte) error {
+	gi.mu.Lock(); defer gi.mu.Unlock()
+	gi.create(group).assign(int64(num),exp,id)
+	return nil
+}
+func (gi *GroupIndex) AssignArticleToGroups(groups [][]byte, nums []int64, exp uint64, id []byte) error {
+	gi.mu.Lock(); defer gi.mu.Unlock()
+	for i,name := range groups {
+		gi.create(name).assign(nums[i],exp,id)
+	}
+	return nil
+}
+
+func (gi *GroupIndex) ArticleGroupStat(group []byte, num int64, id_buf []byte) ([]byte, bool) {
+	gi.mu.RLock(); defer gi.mu.RUnlock()
+	g := gi.m[string(group)]
+	if g==nil { return nil,false }
+	r,ok := g.rows[num]
+	if !ok || r.exp < now() { return nil,false }
+	return append(id_buf[:0],r.id...),true
+}
+func (gi *GroupIndex) ArticleGroupMove(group []byte, i int64, backward bool, id_buf []byte) (ni int64, id []byte, ok bool) {
+	gi.mu.RLock(); defer gi.mu.RUnlock()
+	g := gi.m[string(group)]
+	if g==nil { return }
+	t := now()
+	j := sort.Search(len(g.nums),func(j int) bool { return g.nums[j]>=i })
+	if backward {
+		for j--; j>=0; j-- {
+			r := g.rows[g.nums[j]]
+			if r.exp < t { continue }
+			return g.nums[j],append(id_buf[:0],r.id...),true
+		}
+		return
+	}
+	if j<len(g.nums) && g.nums[j]==i { j++ }
+	for ; j<len(g.nums); j++ {
+		r := g.rows[g.nums[j]]
+		if r.exp < t { continue }
+		return g.nums[j],append(id_buf[:0],r.id...),true
+	}
+	return
+}
+func (gi *GroupIndex) ListArticleGroupRaw(group []byte, first, last int64, targ func(int64, []byte)) {
+	gi.mu.RLock(); defer gi.mu.RUnlock()
+	g := gi.m[string(group)]
+	if g==nil { return }
+	t := now()
+	j := sort.Search(len(g.nums),func(j int) bool { return g.nums[j]>=first })
+	for ; j<len(g.nums); j++ {
+		n := g.nums[j]
+		if n>last { break }
+		r := g.rows[n]
+		if r.exp >= t { targ(n,r.id) }
+	}
+}
+func (gi *GroupIndex) GroupRealtimeQuery(group []byte) (number int64, low int64, high int64, ok bool) {
+	gi.mu.RLock(); defer gi.mu.RUnlock()
+	g := gi.m[string(group)]
+	if g==nil { return }
+	ok = true
+	number = g.count
+	if len(g.nums)!=0 {
+		low = g.nums[0]
+		high = g.nums[len(g.nums)-1]
+	}
+	return
+}
+
+/* Removes all expired entries. Like the groupdb2 Sweeper, it decreases the article count. */
+func (gi *GroupIndex) Expire() {
+	t := now()
+	gi.mu.Lock(); defer gi.mu.Unlock()
+	for _,g := range gi.m {
+		nums := g.nums[:0]
+		for _,n := range g.nums {
+			if g.rows[n].exp < t {
+				delete(g.rows,n)
+				if g.count>0 { g.count-- }
+			} else {
+				nums = append(nums,n)
+			}
+		}
+		g.nums = nums
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package memstore
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupover"
+import "sort"
+import "sync"
+
+/*
+In-Memory groupover.GroupOverview, that also implements the updater interfaces.
+
+Like overdb, the value of each group is the status byte followed by the description.
+*/
+type GroupOverview struct{
+	mu sync.RWMutex
+	m  map[string][]byte
+}
+var _ groupover.GroupOverview = (*GroupOverview)(nil)
+var _ groupover.GroupOverviewUpdater = (*GroupOverview)(nil)
+var _ groupover.GroupOverviewBatchUpdater = (*GroupOverview)(nil)
+
+func (g *GroupOverview) GroupOverviewList(targ func(group []byte, statusAndDescr []byte)) bool {
+	g.mu.RLock(); defer g.mu.RUnlock()
+	keys := make([]string,0,len(g.m))
+	for k := range g.m { keys = append(keys,k) }
+	sort.Strings(keys)
+	for _,k := range keys {
+		v := g.m[k]
+		if len(v)==0 { continue }
+		targ([]byte(k),v)
+	}
+	return false
+}
+func (g *GroupOverview) GroupOverviewGet(group []byte, buffer []byte) (statusAndDescr []byte) {
+	g.mu.RLock(); defer g.mu.RUnlock()
+	return append(buffer[:0],g.m[string(group)]...)
+}
+
+type goupdater struct{
+	m map[string][]byte
+}
+func (u goupdater) GroupOverviewSetStatus(group []byte, status byte) error {
+	oentry := u.m[string(group)]
+	entry := make([]byte,len(oentry))
+	if len(entry)==0 { entry = make([]byte,1) }
+	copy(entry,oentry)
+	entry[0] = status
+	u.m[string(group)] = entry
+	return nil
+}
+func (u goupdater) GroupOverviewSetDescr(group []byte, descr []byte) error {
+	var status byte
+	if oentry := u.m[string(group)]; len(oentry)>0 { status = oentry[0] }
+	entry := make([]byte,len(descr)+1)
+	copy(entry[1:],descr)
+	entry[0] = status
+	u.m[string(group)] = entry
+	return nil
+}
+
+func (g *GroupOverview) updater() goupdater {
+	if g.m==nil { g.m = make(map[string][]byte) }
+	return goupdater{g.m}
+}
+func (g *GroupOverview) GroupOverviewSetStatus(group []byte, status byte) error {
+	g.mu.Lock(); defer g.mu.Unlock()
+	return g.updater().GroupOverviewSetStatus(group,status)
+}
+func (g *GroupOverview) GroupOverviewSetDescr(group []byte, descr []byte) error {
+	g.mu.Lock(); defer g.mu.Unlock()
+	return g.updater().GroupOverviewSetDescr(group,descr)
+}
+
+/*
+The batch is applied atomically: if targ returns an error, no change is visible.
+*/
+func (g *GroupOverview) GroupOverviewBatchUpdate(targ func(groupover.GroupOverviewUpdater)error) error {
+	g.mu.Lock(); defer g.mu.Unlock()
+	nm := make(map[string][]byte,len(g.m))
+	for k,v := range g.m { nm[k] = v }
+	err := targ(goupdater{nm})
+	if err!=nil { return err }
+	g.m = nm
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package memstore_test
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/memstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/gitest"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/storetest"
+import "github.com/maxymania/fastnntp-polyglot-labs2/groupidx"
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "testing"
+import "time"
+
+func TestGroupIndex(t *testing.T) {
+	s := &gitest.Suite{New:func(t *testing.T) (groupidx.GroupIndex,func()) {
+		return new(memstore.GroupIndex),nil
+	}}
+	s.Run(t)
+	s.OverWire2().Run(t)
+	s.OverWire1().Run(t)
+}
+
+func TestAssignKeepsFirst(t *testing.T) {
+	gi := new(memstore.GroupIndex)
+	group := []byte("a.b")
+	exp := uint64(time.Now().Unix())+3600
+	gi.AssignArticleToGroup(group,1,exp,[]byte("<first>"))
+	gi.AssignArticleToGroup(group,1,exp,[]byte("<second>"))
+	gi.AssignArticleToGroups([][]byte{group},[]int64{1},exp,[]byte("<third>"))
+	if id,ok := gi.ArticleGroupStat(group,1,nil); !ok || string(id)!="<first>" {
+		t.Errorf("ArticleGroupStat(1) -> %q %v, expected <first>",id,ok)
+	}
+	if n,_,_,_ := gi.GroupRealtimeQuery(group); n!=1 {
+		t.Errorf("%d articles, expected 1",n)
+	}
+}
+
+func TestArticles(t *testing.T) {
+	s := &storetest.ArticleSuite{New:func(t *testing.T) (articlestore.Storage,func()) {
+		return new(memstore.Storage),nil
+	}}
+	s.Run(t)
+	s.OverNetwire().Run(t)
+	s.OverGnetwire().Run(t)
+}
+
+func TestBuckets(t *testing.T) {
+	s := &storetest.BucketSuite{New:func(t *testing.T) (bucketstore.Bucket,func()) {
+		return new(memstore.Bucket),nil
+	}}
+	s.Run(t)
+	s.OverKvrpc().Run(t)
+}
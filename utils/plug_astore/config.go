@@ -42,6 +42,12 @@ network {
 	n2n-port: 7002
 	srv-port: 7003
 	addr: ':9999'
+	
+	# Gossip encryption (base64 AES keys, the first one is the primary key)
+	key: 'cg8StVXbQJ0gPvMd9o7yrg=='
+	# Nodes, that may join: node names or CIDRs.
+	allow: test124
+	allow: 192.168.1.0/24
 }
 topology: 'F:/config/cluster.cfg'
 */
@@ -65,6 +71,9 @@ type Network struct {
 	Srv    int  `inn:"$srv-port"`
 	
 	Join []string `inn:"@join"`
+	
+	Keys  []string `inn:"@key"`
+	Allow []string `inn:"@allow"`
 }
 
 type Config struct {
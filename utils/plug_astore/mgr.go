@@ -25,6 +25,7 @@ package plug_astore
 import "github.com/byte-mug/goconfig"
 import "github.com/hashicorp/memberlist"
 import "github.com/maxymania/fastnntp-polyglot-labs2/utils/cluster/mlst"
+import "github.com/maxymania/fastnntp-polyglot-labs2/utils/cluster/mlsec"
 import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore/graph"
 import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore/netwire"
 import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore/gnetwire"
@@ -38,6 +39,7 @@ import "log"
 type Service struct {
 	ml *memberlist.Memberlist
 	cfg *memberlist.Config
+	sec *mlsec.Security
 	g  *graph.Cluster
 	srv *fastrpc.Server
 	gsrv *fastrpc.Server
@@ -84,6 +86,10 @@ func (s *Service) Init(n *Network,authorative string) error {
 	if n.Node!="" {
 		cfg.Name = n.Node
 	}
+	sec,err := mlsec.Configure(cfg,n.Keys,n.Allow)
+	if err!=nil { return err }
+	mlst.Secure(cfg,sec)
+	s.sec = sec
 	
 	gl,err := net.Listen("tcp",net.JoinHostPort(n.Addr,fmt.Sprint(n.N2n)))
 	if err!=nil { return err }
@@ -120,6 +126,13 @@ func (s *Service) Start(n *Network) error {
 	go s.serveGSrv(s.gl)
 	return nil
 }
+/*
+Replaces the gossip encryption keys, the first one becomes the primary key.
+See package mlsec on how to rotate keys.
+*/
+func (s *Service) RotateKeys(keys []string) error {
+	return s.sec.Rotate(keys)
+}
 func (s *Service) Wait() error {
 	e1 := <- s.err1
 	e2 := <- s.err2
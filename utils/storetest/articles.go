@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package storetest
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "testing"
+import "bytes"
+import "sync"
+import "fmt"
+
+type ArticleSuite struct{
+	// Creates the Storage under test. The returned function (may be nil) releases it.
+	New func(t *testing.T) (articlestore.Storage,func())
+
+	// The backend does not remove expired articles on its own (eg. timefbak).
+	NoExpiry bool
+}
+
+type actx struct{
+	*testing.T
+	s  *ArticleSuite
+	st articlestore.Storage
+}
+
+func (s *ArticleSuite) run(t *testing.T, name string, f func(c *actx)) {
+	t.Run(name,func(t *testing.T) {
+		st,done := s.New(t)
+		if done!=nil { defer done() }
+		f(&actx{t,s,st})
+	})
+}
+
+func (s *ArticleSuite) Run(t *testing.T) {
+	s.run(t,"RoundTrip",testArticleRoundTrip)
+	s.run(t,"Sections",testArticleSections)
+	s.run(t,"NotFound",testArticleNotFound)
+	s.run(t,"Expiry",testArticleExpiry)
+	s.run(t,"Large",testArticleLarge)
+	s.run(t,"Concurrent",testArticleConcurrent)
+}
+
+func (c *actx) write(id []byte, over,head,body []byte, exp uint64) {
+	msg,err := articlestore.PackMessage(over,head,body)
+	if err!=nil { c.Fatalf("PackMessage: %v",err) }
+	defer msg.Free()
+	err = c.st.StoreWriteMessage(id,msg.Bytes(),exp)
+	if err!=nil { c.Fatalf("StoreWriteMessage(%q): %v",id,err) }
+}
+
+/* Reads the message and checks the requested sections. */
+func (c *actx) expect(id []byte, over,head,body []byte, fover,fhead,fbody bool) error {
+	b,err := c.st.StoreReadMessage(id,fover,fhead,fbody)
+	if err!=nil { return fmt.Errorf("StoreReadMessage(%q): %v",id,err) }
+	defer b.Free()
+	if len(b.Bytes())<4 { return fmt.Errorf("StoreReadMessage(%q): short message (%d bytes)",id,len(b.Bytes())) }
+	rover,rhead,rbody := articlestore.UnpackMessage(b.Bytes())
+	if fover && !bytes.Equal(rover,over) { return fmt.Errorf("StoreReadMessage(%q): overview %q, expected %q",id,rover,over) }
+	if fhead && !bytes.Equal(rhead,head) { return fmt.Errorf("StoreReadMessage(%q): head %q, expected %q",id,rhead,head) }
+	if fbody && !bytes.Equal(rbody,body) { return fmt.Errorf("StoreReadMessage(%q): body differs (%d bytes, expected %d)",id,len(rbody),len(body)) }
+	return nil
+}
+
+/* ------------------------------------------------------------ */
+
+func testArticleRoundTrip(c *actx) {
+	for i := 0; i<4; i++ {
+		id := key(c.T,fmt.Sprint(i))
+		over := []byte(fmt.Sprintf("Subject %d\tfrom@example.com",i))
+		head := []byte(fmt.Sprintf("Subject: %d\r\nMessage-ID: %s\r\n",i,id))
+		body := pattern(100+i,byte(i))
+		c.write(id,over,head,body,future())
+		if err := c.expect(id,over,head,body,true,true,true); err!=nil { c.Error(err) }
+	}
+	/* Empty sections. */
+	id := key(c.T,"empty")
+	c.write(id,nil,nil,nil,future())
+	if err := c.expect(id,nil,nil,nil,true,true,true); err!=nil { c.Error(err) }
+}
+
+func testArticleSections(c *actx) {
+	id := key(c.T,"a")
+	over := []byte("overview")
+	head := []byte("Header: value\r\n")
+	body := pattern(4096,1)
+	c.write(id,over,head,body,future())
+	for f := 0; f<8; f++ {
+		fover,fhead,fbody := (f&1)!=0,(f&2)!=0,(f&4)!=0
+		if err := c.expect(id,over,head,body,fover,fhead,fbody); err!=nil {
+			c.Errorf("over=%v head=%v body=%v: %v",fover,fhead,fbody,err)
+		}
+	}
+}
+
+func testArticleNotFound(c *actx) {
+	b,err := c.st.StoreReadMessage(key(c.T,"missing"),true,true,true)
+	if err==nil {
+		b.Free()
+		c.Errorf("StoreReadMessage on a missing id succeeded")
+	}
+}
+
+func testArticleExpiry(c *actx) {
+	if c.s.NoExpiry { c.Skip("expiry not supported") }
+	id := key(c.T,"dead")
+	c.write(id,[]byte("o"),[]byte("h"),[]byte("b"),past())
+	b,err := c.st.StoreReadMessage(id,true,true,true)
+	if err==nil {
+		b.Free()
+		c.Errorf("StoreReadMessage on an expired article succeeded")
+	}
+	id = key(c.T,"live")
+	c.write(id,[]byte("o"),[]byte("h"),[]byte("b"),future())
+	if err := c.expect(id,[]byte("o"),[]byte("h"),[]byte("b"),true,true,true); err!=nil { c.Error(err) }
+}
+
+func testArticleLarge(c *actx) {
+	id := key(c.T,"large")
+	over := pattern(0xffff,2)
+	head := pattern(0xffff,3)
+	body := pattern(4<<20,4)
+	c.write(id,over,head,body,future())
+	if err := c.expect(id,over,head,body,true,true,true); err!=nil { c.Error(err) }
+}
+
+func testArticleConcurrent(c *actx) {
+	var wg sync.WaitGroup
+	errs := make(chan error,concurrency)
+	for w := 0; w<concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i<opsPerWorker; i++ {
+				id := key(c.T,fmt.Sprintf("%d.%d",w,i))
+				over,head,body := []byte("o"),id,pattern(512+i,byte(w))
+				msg,err := articlestore.PackMessage(over,head,body)
+				if err==nil { err = c.st.StoreWriteMessage(id,msg.Bytes(),future()) }
+				msg.Free()
+				if err==nil { err = c.expect(id,over,head,body,true,true,true) }
+				if err!=nil { errs <- err; return }
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs { c.Error(err) }
+}
@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package storetest
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "testing"
+import "bytes"
+import "sync"
+import "fmt"
+
+type BucketSuite struct{
+	// Creates the Bucket under test. The returned function (may be nil) releases it.
+	New func(t *testing.T) (bucketstore.Bucket,func())
+
+	// The bucket name to use. Defaults to "storetest".
+	Name []byte
+}
+
+type bctx struct{
+	*testing.T
+	s  *BucketSuite
+	b  bucketstore.Bucket
+}
+
+func (s *BucketSuite) run(t *testing.T, name string, f func(c *bctx)) {
+	t.Run(name,func(t *testing.T) {
+		b,done := s.New(t)
+		if done!=nil { defer done() }
+		f(&bctx{t,s,b})
+	})
+}
+
+func (s *BucketSuite) Run(t *testing.T) {
+	s.run(t,"RoundTrip",testBucketRoundTrip)
+	s.run(t,"Overwrite",testBucketOverwrite)
+	s.run(t,"NotFound",testBucketNotFound)
+	s.run(t,"Delete",testBucketDelete)
+	s.run(t,"Expiry",testBucketExpiry)
+	s.run(t,"Large",testBucketLarge)
+	s.run(t,"Concurrent",testBucketConcurrent)
+	s.run(t,"Scan",testBucketScan)
+}
+
+func (c *bctx) bucket() []byte {
+	if len(c.s.Name)==0 { return []byte("storetest") }
+	return c.s.Name
+}
+func (c *bctx) put(k,v []byte) {
+	err := c.b.BucketPut(c.bucket(),k,v)
+	if err!=nil { c.Fatalf("BucketPut(%q): %v",k,err) }
+}
+func (c *bctx) check(k,v []byte) error {
+	b,err := c.b.BucketGet(c.bucket(),k)
+	if err!=nil { return fmt.Errorf("BucketGet(%q): %v",k,err) }
+	defer b.Free()
+	if !bytes.Equal(b.Bytes(),v) { return fmt.Errorf("BucketGet(%q): value differs (%d bytes, expected %d)",k,len(b.Bytes()),len(v)) }
+	return nil
+}
+func (c *bctx) missing(k []byte) {
+	b,err := c.b.BucketGet(c.bucket(),k)
+	if err==nil {
+		b.Free()
+		c.Errorf("BucketGet(%q) succeeded, expected ENotFound",k)
+	} else if err!=bucketstore.ENotFound {
+		c.Errorf("BucketGet(%q): %v, expected ENotFound",k,err)
+	}
+}
+
+/* ------------------------------------------------------------ */
+
+func testBucketRoundTrip(c *bctx) {
+	for i := 0; i<8; i++ {
+		k := key(c.T,fmt.Sprint(i))
+		c.put(k,pattern(i*13,byte(i)))
+	}
+	for i := 0; i<8; i++ {
+		k := key(c.T,fmt.Sprint(i))
+		if err := c.check(k,pattern(i*13,byte(i))); err!=nil { c.Error(err) }
+	}
+}
+
+func testBucketOverwrite(c *bctx) {
+	k := key(c.T,"a")
+	c.put(k,[]byte("first value, that is longer"))
+	c.put(k,[]byte("second"))
+	if err := c.check(k,[]byte("second")); err!=nil { c.Error(err) }
+}
+
+func testBucketNotFound(c *bctx) {
+	c.missing(key(c.T,"missing"))
+}
+
+func testBucketDelete(c *bctx) {
+	k := key(c.T,"a")
+	c.put(k,[]byte("value"))
+	err := c.b.BucketDelete(c.bucket(),k)
+	if err!=nil { c.Fatalf("BucketDelete: %v",err) }
+	c.missing(k)
+	err = c.b.BucketDelete(c.bucket(),key(c.T,"missing"))
+	if err!=nil { c.Errorf("BucketDelete on a missing key: %v",err) }
+}
+
+func testBucketExpiry(c *bctx) {
+	bx,ok := c.b.(bucketstore.BucketWEx)
+	if !ok { c.Skip("BucketWEx not implemented") }
+	live,dead := key(c.T,"live"),key(c.T,"dead")
+	err := bx.BucketPutExpire(c.bucket(),live,[]byte("live"),future())
+	if err!=nil { c.Fatalf("BucketPutExpire: %v",err) }
+	err = bx.BucketPutExpire(c.bucket(),dead,[]byte("dead"),past())
+	if err!=nil { c.Fatalf("BucketPutExpire: %v",err) }
+	if err := c.check(live,[]byte("live")); err!=nil { c.Error(err) }
+	c.missing(dead)
+}
+
+func testBucketLarge(c *bctx) {
+	k := key(c.T,"large")
+	v := pattern(8<<20,5)
+	c.put(k,v)
+	if err := c.check(k,v); err!=nil { c.Error(err) }
+}
+
+func testBucketConcurrent(c *bctx) {
+	var wg sync.WaitGroup
+	errs := make(chan error,concurrency)
+	for w := 0; w<concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i<opsPerWorker; i++ {
+				k := key(c.T,fmt.Sprintf("%d.%d",w,i))
+				v := pattern(64+i,byte(w))
+				err := c.b.BucketPut(c.bucket(),k,v)
+				if err==nil { err = c.check(k,v) }
+				if err!=nil { errs <- err; return }
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs { c.Error(err) }
+}
+
+func testBucketScan(c *bctx) {
+	sc,ok := c.b.(bucketstore.BucketScanner)
+	if !ok { c.Skip("BucketScanner not implemented") }
+	pfx := key(c.T,"s.")
+	pfx = pfx[:len(pfx)-1]
+	for i := 0; i<10; i++ {
+		c.put(key(c.T,fmt.Sprintf("s.%02d",i)),pattern(i+1,byte(i)))
+	}
+	c.put(key(c.T,"t"),[]byte("outside"))
+	
+	/* Page through the prefix, four keys at a time. */
+	r := &bucketstore.ScanRange{Prefix:pfx,Limit:4,Values:true}
+	i := 0
+	for pages := 0; ; pages++ {
+		if pages>3 { c.Fatalf("BucketScan: cursor does not advance") }
+		cursor,err := sc.BucketScan(c.bucket(),r,func(k, v []byte) {
+			if i>=10 { c.Errorf("BucketScan: unexpected key %q",k); return }
+			if exp := key(c.T,fmt.Sprintf("s.%02d",i)); !bytes.Equal(k,exp) { c.Errorf("BucketScan: got %q, expected %q",k,exp) }
+			if !bytes.Equal(v,pattern(i+1,byte(i))) { c.Errorf("BucketScan(%q): value differs",k) }
+			i++
+		})
+		if err!=nil { c.Fatalf("BucketScan: %v",err) }
+		if cursor==nil { break }
+		r.Start = cursor
+	}
+	if i!=10 { c.Errorf("BucketScan: got %d keys, expected 10",i) }
+	
+	/* Range without values. */
+	i = 0
+	r = &bucketstore.ScanRange{Prefix:pfx,Start:key(c.T,"s.03"),End:key(c.T,"s.07")}
+	cursor,err := sc.BucketScan(c.bucket(),r,func(k, v []byte) {
+		if len(v)!=0 { c.Errorf("BucketScan(%q): got a value without asking",k) }
+		i++
+	})
+	if err!=nil { c.Fatalf("BucketScan: %v",err) }
+	if cursor!=nil { c.Errorf("BucketScan: unexpected cursor %q",cursor) }
+	if i!=4 { c.Errorf("BucketScan: got %d keys in range, expected 4",i) }
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package storetest
+
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore/netwire"
+import "github.com/maxymania/fastnntp-polyglot-labs2/articlestore/gnetwire"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore"
+import "github.com/maxymania/fastnntp-polyglot-labs2/bucketstore/kvrpc"
+import "github.com/valyala/fastrpc"
+import "testing"
+import "net"
+
+func serve(s *fastrpc.Server) (string,func(),error) {
+	ln,err := net.Listen("tcp","127.0.0.1:0")
+	if err!=nil { return "",nil,err }
+	go s.Serve(ln)
+	return ln.Addr().String(),func() { ln.Close() },nil
+}
+
+type netwireStorage struct{
+	netwire.ClientR
+	netwire.ClientW
+}
+
+/* Serves st using netwire on a loopback port and returns a client connected to it. */
+func NetwireLoopback(st articlestore.Storage) (articlestore.Storage,func(),error) {
+	addr,stop,err := serve(netwire.NewServer(st,st))
+	if err!=nil { return nil,nil,err }
+	c := netwire.NewClient("tcp",addr)
+	return netwireStorage{netwire.ClientR(c),netwire.ClientW(c)},stop,nil
+}
+
+type singleStorage struct{
+	articlestore.Storage
+}
+func (s singleStorage) Lookup(K1,K2 []byte) articlestore.Storage { return s.Storage }
+
+/* Serves st using gnetwire on a loopback port and returns a client connected to it. */
+func GnetwireLoopback(st articlestore.Storage) (articlestore.Storage,func(),error) {
+	addr,stop,err := serve(gnetwire.NewServer(singleStorage{st}))
+	if err!=nil { return nil,nil,err }
+	return gnetwire.Client{Cli:gnetwire.NewClient("tcp",addr),K1:"storetest",K2:"storetest"},stop,nil
+}
+
+/* Serves b using kvrpc on a loopback port and returns a client connected to it. */
+func KvrpcLoopback(b kvrpc.GBucket) (bucketstore.Bucket,func(),error) {
+	addr,stop,err := serve(kvrpc.NewServer(b))
+	if err!=nil { return nil,nil,err }
+	c := new(kvrpc.Client)
+	c.Cli.Addr = addr
+	c.Init()
+	return c,stop,nil
+}
+
+/* ------------------------------------------------------------ */
+
+func (s *ArticleSuite) over(lb func(articlestore.Storage) (articlestore.Storage,func(),error)) *ArticleSuite {
+	ns := new(ArticleSuite)
+	*ns = *s
+	ns.New = func(t *testing.T) (articlestore.Storage,func()) {
+		st,done := s.New(t)
+		cli,stop,err := lb(st)
+		if err!=nil {
+			if done!=nil { done() }
+			t.Fatalf("loopback: %v",err)
+		}
+		return cli,func() {
+			stop()
+			if done!=nil { done() }
+		}
+	}
+	return ns
+}
+
+/* Returns a copy of the Suite, that tests the backend through a netwire loopback. */
+func (s *ArticleSuite) OverNetwire() *ArticleSuite { return s.over(NetwireLoopback) }
+
+/* Returns a copy of the Suite, that tests the backend through a gnetwire loopback. */
+func (s *ArticleSuite) OverGnetwire() *ArticleSuite { return s.over(GnetwireLoopback) }
+
+/*
+Returns a copy of the Suite, that tests the backend through a kvrpc loopback.
+The backend must implement bucketstore.BucketWEx.
+*/
+func (s *BucketSuite) OverKvrpc() *BucketSuite {
+	ns := new(BucketSuite)
+	*ns = *s
+	ns.New = func(t *testing.T) (bucketstore.Bucket,func()) {
+		b,done := s.New(t)
+		gb,ok := b.(kvrpc.GBucket)
+		if !ok {
+			if done!=nil { done() }
+			t.Skip("BucketWEx not implemented")
+		}
+		cli,stop,err := KvrpcLoopback(gb)
+		if err!=nil {
+			if done!=nil { done() }
+			t.Fatalf("KvrpcLoopback: %v",err)
+		}
+		return cli,func() {
+			stop()
+			if done!=nil { done() }
+		}
+	}
+	return ns
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+Storetest is a conformance harness for articlestore.Storage and bucketstore.Bucket
+implementations.
+
+It is meant to be called from the _test.go file of a backend:
+
+	func TestArticles(t *testing.T) {
+		s := &storetest.ArticleSuite{New:func(t *testing.T) (articlestore.Storage,func()) {
+			return new(memstore.Storage),nil
+		}}
+		s.Run(t)
+		s.OverNetwire().Run(t)
+	}
+
+	func TestBuckets(t *testing.T) {
+		s := &storetest.BucketSuite{New:func(t *testing.T) (bucketstore.Bucket,func()) {
+			return new(memstore.Bucket),nil
+		}}
+		s.Run(t)
+		s.OverKvrpc().Run(t)
+	}
+
+Read-only wrappers, like minifier.RWrapper, can be tested by combining them with
+the writer of the wrapped storage:
+
+	struct{
+		*minifier.RWrapper
+		articlestore.StorageW
+	}{&minifier.RWrapper{StorageR:st},st}
+
+The contract, that is checked:
+
+	Every value, that has been written, can be read back unaltered, until it expires.
+
+	StoreReadMessage returns at least the requested sections. Sections, that have not
+	been requested, may be omitted.
+
+	Reading a missing key fails. Buckets report bucketstore.ENotFound.
+
+	A value written with an expiration in the past can not be read back.
+
+	Concurrent reads and writes on distinct keys do not interfere.
+
+	A bucketstore.BucketScanner yields the keys of a range in ascending order, and
+	a scan, that has been cut short by its limit, can be resumed with the cursor.
+*/
+package storetest
+
+import "testing"
+import "fmt"
+import "time"
+
+var nonce = time.Now().UnixNano()
+
+/*
+Returns a key, that is unique to the test and the process,
+so persistent backends can be tested repeatedly.
+*/
+func key(t *testing.T, n string) []byte {
+	return []byte(fmt.Sprintf("<storetest.%x.%s.%s>",nonce,t.Name(),n))
+}
+
+func future() uint64 { return uint64(time.Now().Unix())+3600 }
+func past() uint64 { return uint64(time.Now().Unix())-3600 }
+
+/* A deterministic pattern, so corruption can be spotted. */
+func pattern(n int, seed byte) []byte {
+	b := make([]byte,n)
+	for i := range b { b[i] = byte(i*7)+seed }
+	return b
+}
+
+const (
+	concurrency = 16
+	opsPerWorker = 32
+)